@@ -1,24 +1,27 @@
 package aql
 
 import (
+	"fmt"
 	"github.com/alecthomas/participle"
 	"github.com/alecthomas/participle/lexer"
 	"strings"
 )
 
 var (
-	assertionLexer = lexer.Unquote(lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
-		`|(?P<Keyword>(?i)IT\s|OUTPUTS\s|COLUMN\s|UNIQUE\s|SHOULD\s|HAVE\s|AT\sLEAST\s|AT\sMOST\s|EXACTLY\s|DISTINCT\s|SATISFIES\s|IS\s|NOT\s|NULL\s|NO\s|DUPLICATE\s|VALUES\s|ROWS\s|CONTAINS\s)`+
+	assertionLexer = lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Expression>(?i)(?:WHERE|SATISFIES)\s+.+)`+
+		`|(?P<Keyword>(?i)IT\s|OUTPUTS\s|COLUMN\s|UNIQUE\s|SHOULD\s|HAVE\s|AT\sLEAST\s|AT\sMOST\s|EXACTLY\s|DISTINCT\s|IS\s|NOT\s|NULL\s|NO\s|DUPLICATE\s|VALUES\s|ROWS\s|CONTAINS\s|BETWEEN\s|AND\s|IN\s|MATCHES\s|TYPE\s|REFERENCES\s|BLOCK\s)`+
 		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
-		`|(?P<Number>[0-9]+)`+
+		`|(?P<Number>-?[0-9]+(?:\.[0-9]+)?)`+
 		`|(?P<String>'[^']*'|"[^"]*")`+
-		`|(?P<Any>.+)`,
-	)), "Keyword"), "String")
+		`|(?P<Operators>[(),%])`,
+	)), "Keyword")
 )
 
 type GlobalAssertion struct {
-	NRows *HasN   `"OUTPUTS " @@ "ROWS"`
-	Expr  *string `| "SATISFIES " @Any`
+	NRows *HasN   `"OUTPUTS " ( @@ "ROWS"`
+	Where *string `  | "ROWS " @Expression )`
+	Expr  *string `| @Expression`
 }
 
 type HasN struct {
@@ -28,11 +31,38 @@ type HasN struct {
 	N       int  `@Number `
 }
 
+// Literal is a literal value in an assertion, either a number or a quoted string.
+type Literal struct {
+	Number *string `  @Number`
+	String *string `| @String`
+}
+
+// Text returns the value as it was written, without quotes.
+func (v *Literal) Text() string {
+	if v.Number != nil {
+		return *v.Number
+	}
+	return unquote(*v.String)
+}
+
+// Reference is the block and column referenced by a column, for referential integrity assertions.
+type Reference struct {
+	Block  string `"BLOCK " ( @Ident | @String )`
+	Column string `"COLUMN " @Ident`
+}
+
 type ColumnAssertion struct {
-	TargetColumn *string `@Ident "HAS" `
-	Distinct     *HasN   `(@@ "DISTINCT " "VALUES"`
-	NoDuplicates bool    `| @"UNIQUE " "VALUES"`
-	NoNulls      bool    `|@"NO " "NULL " "VALUES")`
+	TargetColumn *string    `@Ident`
+	Count        *HasN      `( "HAS" ( @@`
+	Distinct     bool       `         ( @"DISTINCT " "VALUES"`
+	PercentNulls bool       `         | @"%" "NULL " "VALUES" )`
+	NoDuplicates bool       `       | @"UNIQUE " "VALUES"`
+	NoNulls      bool       `       | @"NO " "NULL " "VALUES"`
+	Between      []Literal  `       | "VALUES " ( "BETWEEN " @@ "AND " @@`
+	In           []Literal  `                   | "IN " "(" @@ { "," @@ } ")" )`
+	Type         *string    `       | "TYPE " @Ident )`
+	Matches      *string    `| "MATCHES " @String`
+	References   *Reference `| "REFERENCES " @@ )`
 }
 
 type Assertion struct {
@@ -40,6 +70,24 @@ type Assertion struct {
 	Column *ColumnAssertion `| "COLUMN " @@`
 }
 
+// unquote removes the quotes around a string token, if there are any.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// expression removes the keyword that starts an expression token and checks that it is the expected one.
+func expression(s *string, keyword string) error {
+	fields := strings.SplitN(strings.TrimSpace(*s), " ", 2)
+	if strings.ToUpper(fields[0]) != keyword || len(fields) < 2 {
+		return fmt.Errorf("expected %s followed by an expression but got '%s'", keyword, *s)
+	}
+	*s = strings.TrimSpace(fields[1])
+	return nil
+}
+
 func NewAssertion(aqlBody string) (*Assertion, error) {
 	p, err := participle.Build(&Assertion{}, assertionLexer)
 
@@ -53,6 +101,21 @@ func NewAssertion(aqlBody string) (*Assertion, error) {
 		return nil, err
 	}
 
+	switch {
+	case a.Global != nil && a.Global.Where != nil:
+		err = expression(a.Global.Where, "WHERE")
+	case a.Global != nil && a.Global.Expr != nil:
+		err = expression(a.Global.Expr, "SATISFIES")
+	case a.Column != nil && a.Column.Matches != nil:
+		*a.Column.Matches = unquote(*a.Column.Matches)
+	case a.Column != nil && a.Column.References != nil:
+		a.Column.References.Block = unquote(a.Column.References.Block)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &a, nil
 }
 
@@ -12,6 +12,15 @@ func TestAssertions(t *testing.T) {
 			"IT OUTPUTS AT MOST 4321 ROWS",
 			"COLUMN B HAS UNIQUE VALUES",
 			"COLUMN A HAS NO NULL VALUES",
+			"COLUMN A HAS AT MOST 5 DISTINCT VALUES",
+			"COLUMN A HAS AT MOST 5% NULL VALUES",
+			"COLUMN A HAS VALUES BETWEEN -1.5 AND 10",
+			"COLUMN A HAS VALUES IN ('a', 'b')",
+			"COLUMN A HAS TYPE int",
+			"COLUMN A MATCHES '^[a-z]+\\d$'",
+			"COLUMN A REFERENCES BLOCK 'Parents' COLUMN Id",
+			"IT OUTPUTS ROWS WHERE A > 0",
+			"IT SATISFIES A > 0",
 		}
 		Convey("It should parse correctly and return no error", func() {
 			for i := range assertions {
@@ -21,3 +30,38 @@ func TestAssertions(t *testing.T) {
 		})
 	})
 }
+
+func TestExtendedAssertions(t *testing.T) {
+	Convey("When given extended column assertions", t, func() {
+		Convey("The values should be parsed without quotes", func() {
+			a, err := NewAssertion("COLUMN A HAS VALUES IN ('a', 1)")
+			So(err, ShouldBeNil)
+			So(a.Column.In, ShouldHaveLength, 2)
+			So(a.Column.In[0].Text(), ShouldEqual, "a")
+			So(a.Column.In[1].Text(), ShouldEqual, "1")
+			a, err = NewAssertion(`COLUMN A MATCHES '^\d+$'`)
+			So(err, ShouldBeNil)
+			So(*a.Column.Matches, ShouldEqual, `^\d+$`)
+			a, err = NewAssertion("COLUMN ParentId REFERENCES BLOCK 'Parents' COLUMN Id")
+			So(err, ShouldBeNil)
+			So(a.Column.References.Block, ShouldEqual, "Parents")
+			So(a.Column.References.Column, ShouldEqual, "Id")
+		})
+		Convey("The percentage of null values should be distinguished from the number of distinct values", func() {
+			a, err := NewAssertion("COLUMN A HAS AT MOST 5% NULL VALUES")
+			So(err, ShouldBeNil)
+			So(a.Column.PercentNulls, ShouldBeTrue)
+			So(a.Column.Count.N, ShouldEqual, 5)
+			a, err = NewAssertion("COLUMN A HAS AT MOST 5 DISTINCT VALUES")
+			So(err, ShouldBeNil)
+			So(a.Column.Distinct, ShouldBeTrue)
+		})
+		Convey("The expression should follow WHERE", func() {
+			a, err := NewAssertion("IT OUTPUTS ROWS WHERE A > 0")
+			So(err, ShouldBeNil)
+			So(*a.Global.Where, ShouldEqual, "A > 0")
+			_, err = NewAssertion("IT SATISFIES")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,291 @@
+package aql
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//MaxFormattedLineLength is the length above which the options of a block are formatted one per line.
+const MaxFormattedLineLength = 100
+
+//blockOrder is the rank of each kind of block in a formatted script. Queries and execs share a rank
+//so that they keep their order relative to each other.
+var blockOrder = map[tokenType]int{
+	DESCRIPTION: 0,
+	INCLUDE:     1,
+	SET:         2,
+	DECLARE:     3,
+	CONNECTION:  4,
+	GLOBAL:      5,
+	DATA:        6,
+	QUERY:       7,
+	EXEC:        7,
+	TRANSFORM:   8,
+	MOCK:        9,
+	TEST:        10,
+}
+
+//formatBlock is a top-level block of a script, along with the comments that precede it.
+type formatBlock struct {
+	kind  tokenType
+	items []Item
+}
+
+//formatter writes the formatted script line by line.
+type formatter struct {
+	b         strings.Builder
+	line      string
+	indent    int
+	lineBreak bool
+}
+
+//Format returns the script with canonical keyword casing, indentation and option layout. Blocks are ordered
+//by kind: description, includes, SET, DECLARE, connections, globals, data, queries and execs, transforms,
+//mocks and tests. Blocks of the same kind keep their order, so the formatted script is equivalent to the
+//original. Comments and the contents of block bodies are preserved verbatim.
+func Format(script string) (string, error) {
+	before, err := parseScript(script, "")
+	if err != nil {
+		return "", err
+	}
+	items, err := LexComments(script)
+	if err != nil {
+		return "", err
+	}
+	header, blocks, footer := splitBlocks(items)
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blockOrder[blocks[i].kind] < blockOrder[blocks[j].kind]
+	})
+
+	var f formatter
+	for _, comment := range header {
+		f.comment(comment)
+	}
+	for i, block := range blocks {
+		if i > 0 || len(header) > 0 {
+			f.blankLine()
+		}
+		f.block(block.items)
+	}
+	if len(footer) > 0 {
+		f.blankLine()
+		for _, comment := range footer {
+			f.comment(comment)
+		}
+	}
+	f.flush()
+	ret := f.b.String()
+
+	after, err := parseScript(ret, "")
+	if err != nil || !reflect.DeepEqual(before, after) {
+		return "", fmt.Errorf("could not format script: the formatted script is not equivalent to the original")
+	}
+	return ret, nil
+}
+
+//endLine returns the line that the item ends on.
+func endLine(item Item) int {
+	return item.LineNumber + strings.Count(item.Content, "\n")
+}
+
+//trailing returns true if the item is a comment on the same line as the token before it.
+func trailing(items []Item, i int) bool {
+	return items[i].ID == COMMENT && i > 0 && endLine(items[i-1]) == items[i].LineNumber
+}
+
+//startsBlock returns true if the item is the first token of a top-level block. CONNECTION, GLOBAL and DATA
+//also name sources, destinations and mocks, where they are not followed by the name of a block.
+func startsBlock(items []Item, i int) bool {
+	switch items[i].ID {
+	case QUERY, EXEC, TRANSFORM, TEST, INCLUDE, DECLARE, SET, DESCRIPTION, MOCK:
+		return true
+	case CONNECTION, GLOBAL, DATA:
+		next, prev := -1, -1
+		for j := i + 1; j < len(items); j++ {
+			if items[j].ID != COMMENT {
+				next = j
+				break
+			}
+		}
+		for j := i - 1; j >= 0; j-- {
+			if items[j].ID != COMMENT {
+				prev = j
+				break
+			}
+		}
+		if next < 0 || items[next].ID != QUOTED_STRING {
+			return false
+		}
+		return prev < 0 || (items[prev].ID != MOCK && items[prev].ID != WITH)
+	}
+	return false
+}
+
+//splitBlocks splits the items into top-level blocks. Comments on their own line before a block belong to it,
+//except for those at the start of the script that are followed by a blank line, which are its header, and
+//those at the end of the script, which are its footer.
+func splitBlocks(items []Item) (header []Item, blocks []formatBlock, footer []Item) {
+	var pending []Item
+	for i, item := range items {
+		switch {
+		case item.ID == COMMENT && !trailing(items, i):
+			pending = append(pending, item)
+		case item.ID == COMMENT || !startsBlock(items, i):
+			if len(blocks) == 0 {
+				//the parser only accepts blocks, so this cannot happen for a valid script
+				blocks = append(blocks, formatBlock{kind: item.ID})
+			}
+			last := &blocks[len(blocks)-1]
+			last.items = append(last.items, pending...)
+			last.items = append(last.items, item)
+			pending = nil
+		default:
+			if len(blocks) == 0 {
+				for j := len(pending) - 1; j >= 0; j-- {
+					next := item.LineNumber
+					if j < len(pending)-1 {
+						next = pending[j+1].LineNumber
+					}
+					if next > endLine(pending[j])+1 {
+						header, pending = pending[:j+1], pending[j+1:]
+						break
+					}
+				}
+			}
+			blocks = append(blocks, formatBlock{kind: item.ID, items: append(pending, item)})
+			pending = nil
+		}
+	}
+	return header, blocks, pending
+}
+
+//block writes a block. Clauses that follow the block's body go on their own lines, and the block's options
+//go on the same line as WITH, unless they are too long or have comments, in which case they go one per line.
+func (f *formatter) block(items []Item) {
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		switch {
+		case item.ID == COMMENT && trailing(items, i):
+			f.trailingComment(item)
+		case item.ID == COMMENT:
+			f.comment(item)
+		case item.ID == LPAREN && i > 0 && (items[i-1].ID == WITH || items[i-1].ID == PARAMETER):
+			i = f.options(items, i)
+		case item.ID == LPAREN:
+			//body, which is preserved verbatim
+			f.word("(" + items[i+1].Content + ")")
+			i += 2
+		case item.ID == INTO || item.ID == USING || item.ID == AFTER || item.ID == IF || item.ID == ON ||
+			(item.ID == WITH && i+1 < len(items) && items[i+1].ID == LPAREN):
+			f.lineBreak = true
+			f.word(item.Content)
+		case item.ID == COMMA:
+			f.line += ","
+		default:
+			f.word(token(item))
+		}
+	}
+	f.flush()
+}
+
+//options writes the options that start at the opening parenthesis at index i, and returns the index of the
+//closing parenthesis.
+func (f *formatter) options(items []Item, i int) int {
+	end := i
+	var (
+		single      []string
+		hasComments bool
+	)
+	for end = i + 1; end < len(items) && items[end].ID != RPAREN; end++ {
+		switch items[end].ID {
+		case COMMENT:
+			hasComments = true
+		case COMMA:
+			single[len(single)-1] += ","
+		default:
+			single = append(single, token(items[end]))
+		}
+	}
+	s := "(" + strings.Join(single, " ") + ")"
+	if !hasComments && len(f.line)+1+len(s) <= MaxFormattedLineLength {
+		f.word(s)
+		return end
+	}
+
+	f.word("(")
+	f.flush()
+	f.indent++
+	for j := i + 1; j < end; j++ {
+		item := items[j]
+		switch {
+		case item.ID == COMMENT && trailing(items, j):
+			f.trailingComment(item)
+		case item.ID == COMMENT:
+			f.comment(item)
+		case item.ID == COMMA:
+			f.line += ","
+			f.lineBreak = true
+		default:
+			f.word(token(item))
+		}
+	}
+	f.flush()
+	f.indent--
+	f.word(")")
+	return end
+}
+
+//token returns the canonical representation of the item.
+func token(item Item) string {
+	if item.ID == QUOTED_STRING {
+		return "'" + item.Content + "'"
+	}
+	return item.Content
+}
+
+//word writes s to the current line, after a space unless it starts the line or a list of options.
+func (f *formatter) word(s string) {
+	if f.lineBreak {
+		f.flush()
+		f.lineBreak = false
+	}
+	if len(f.line) > 0 && !strings.HasSuffix(f.line, "(") {
+		f.line += " "
+	}
+	f.line += s
+}
+
+//comment writes a comment on its own line(s).
+func (f *formatter) comment(item Item) {
+	f.flush()
+	f.line = item.Content
+	f.flush()
+}
+
+//trailingComment writes a comment at the end of the current line.
+func (f *formatter) trailingComment(item Item) {
+	if len(f.line) == 0 {
+		f.comment(item)
+		return
+	}
+	f.line += " " + item.Content
+	f.flush()
+}
+
+//flush ends the current line.
+func (f *formatter) flush() {
+	f.lineBreak = false
+	if len(f.line) == 0 {
+		return
+	}
+	f.b.WriteString(strings.Repeat("\t", f.indent) + f.line + "\n")
+	f.line = ""
+}
+
+//blankLine ends the current line and writes a blank line.
+func (f *formatter) blankLine() {
+	f.flush()
+	f.b.WriteString("\n")
+}
@@ -0,0 +1,62 @@
+package aql
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	Convey("When formatting a script", t, func() {
+		s := `/** Header comment **/
+
+-- test comes first
+test Foo with assertions (
+  COLUMN a HAS UNIQUE VALUES
+)
+set X = 'y'
+query 'Foo' from connection db, block Bar -- from
+(select 1)   into global  with (table = 'T', -- the table
+  rows = 10) after Bar
+connection 'db' (driver = 'sqlite3', connectionstring = ':memory:')
+data 'Bar' ([[1]]) into global with (columns='a')
+`
+		expected := `/** Header comment **/
+
+SET X = 'y'
+
+CONNECTION 'db' (driver = 'sqlite3', connectionstring = ':memory:')
+
+DATA 'Bar' ([[1]])
+INTO GLOBAL
+WITH (columns = 'a')
+
+QUERY 'Foo' FROM CONNECTION db, BLOCK Bar -- from
+(select 1)
+INTO GLOBAL
+WITH (
+	table = 'T', -- the table
+	rows = 10
+)
+AFTER Bar
+
+-- test comes first
+TEST Foo WITH ASSERTIONS (
+  COLUMN a HAS UNIQUE VALUES
+)
+`
+		Convey("It should order the blocks, canonicalize keywords and lay out options", func() {
+			f, err := Format(s)
+			So(err, ShouldBeNil)
+			So(f, ShouldEqual, expected)
+		})
+		Convey("Formatting it again should not change it", func() {
+			f, err := Format(expected)
+			So(err, ShouldBeNil)
+			So(f, ShouldEqual, expected)
+		})
+		Convey("It should return an error if the script is invalid", func() {
+			_, err := Format("QUERY 'a' INTO")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -15,7 +16,9 @@ type tokenType rune
 type Item struct {
 	ID         tokenType
 	LineNumber int
-	Content    string
+	//Column is the position of the token in its line, starting at 1.
+	Column  int
+	Content string
 }
 
 const (
@@ -51,6 +54,14 @@ const (
 	EXEC
 	DATA
 	ASSERTIONS
+	MOCK
+	FIXTURE
+	SNAPSHOT
+	COMMENT
+	IF
+	ON
+	SUCCESS
+	FAILURE
 )
 
 var (
@@ -59,31 +70,56 @@ var (
 		RPAREN: ")", PAREN_BODY: "PAREN_BODY", WITH: "WITH",
 		EQUALS: "=", COMMA: ",", QUOTED_STRING: "QUOTED_STRING", IDENTIFIER: "IDENT", NUMBER: "NUMBER", GLOBAL: "GLOBAL",
 		CONNECTION: "CONNECTION", BLOCK: "BLOCK", AS: "AS", AFTER: "AFTER", PLUGIN: "PLUGIN", DECLARE: "DECLARE", USING: "USING", PARAMETER: "PARAMETER",
-		CONSOLE: "CONSOLE", SET: "SET", EXEC: "EXEC", DATA: "DATA", ASSERTIONS: "ASSERTIONS"}
+		CONSOLE: "CONSOLE", SET: "SET", EXEC: "EXEC", DATA: "DATA", ASSERTIONS: "ASSERTIONS", MOCK: "MOCK", FIXTURE: "FIXTURE", SNAPSHOT: "SNAPSHOT", COMMENT: "COMMENT",
+		IF: "IF", ON: "ON", SUCCESS: "SUCCESS", FAILURE: "FAILURE"}
 	whitespace = regexp.MustCompile(`\s`)
 	keywords   = map[tokenType]bool{TEST: true, QUERY: true, DESCRIPTION: true, TRANSFORM: true, FROM: true, INTO: true, EXTERN: true,
 		INCLUDE: true, WITH: true, GLOBAL: true, CONNECTION: true, BLOCK: true, AS: true, AFTER: true, PLUGIN: true, DECLARE: true, USING: true, PARAMETER: true,
-		CONSOLE: true, SET: true, EXEC: true, DATA: true, ASSERTIONS: true}
+		CONSOLE: true, SET: true, EXEC: true, DATA: true, ASSERTIONS: true, MOCK: true, FIXTURE: true, SNAPSHOT: true,
+		IF: true, ON: true, SUCCESS: true, FAILURE: true}
 	keywordReverse = map[string]tokenType{"TEST": TEST, "QUERY": QUERY, "DESCRIPTION": DESCRIPTION, "TRANSFORM": TRANSFORM, "FROM": FROM,
 		"INTO": INTO, "EXTERN": EXTERN, "INCLUDE": INCLUDE, "WITH": WITH, "GLOBAL": GLOBAL, "CONNECTION": CONNECTION, "BLOCK": BLOCK, "AS": AS, "AFTER": AFTER, "PLUGIN": PLUGIN, "DECLARE": DECLARE, "USING": USING, "PARAMETER": PARAMETER,
-		"CONSOLE": CONSOLE, "SET": SET, "EXEC": EXEC, "DATA": DATA, "ASSERTIONS": ASSERTIONS}
+		"CONSOLE": CONSOLE, "SET": SET, "EXEC": EXEC, "DATA": DATA, "ASSERTIONS": ASSERTIONS, "MOCK": MOCK, "FIXTURE": FIXTURE, "SNAPSHOT": SNAPSHOT,
+		"IF": IF, "ON": ON, "SUCCESS": SUCCESS, "FAILURE": FAILURE}
+	//contextualKeywords are only keywords where a name cannot be, so that they can still be used as names, as in
+	//FROM CONNECTION Failure. They were added after the other keywords, which are reserved everywhere.
+	contextualKeywords = map[tokenType]bool{IF: true, ON: true, SUCCESS: true, FAILURE: true, MOCK: true, FIXTURE: true}
+	//beforeName are the tokens that are followed by a name, such as that of a connection, block, option or parameter.
+	beforeName = map[tokenType]bool{CONNECTION: true, BLOCK: true, AFTER: true, DECLARE: true, PARAMETER: true, IF: true, COMMA: true, LPAREN: true}
 )
 
+//Keywords returns the keywords of AQL, sorted.
+func Keywords() []string {
+	var ret []string
+	for k := range keywordReverse {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
 type ForwardLexer struct {
-	items []Item
-	pos   int
+	items    []Item
+	pos      int
+	filename string
 }
 
-type definition struct{}
+//definition is the lexer definition of the parser. The filename, if any, is reported in the positions of errors.
+type definition struct {
+	filename string
+}
 
 func (d *definition) Lex(r io.Reader) lexer.Lexer {
-	l := &ForwardLexer{}
+	l := &ForwardLexer{filename: d.filename}
 	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		panic(fmt.Errorf("error reading from file: %v", err))
 	}
 	i, err := Lex(string(b))
 	if err != nil {
+		if e, ok := err.(*Error); ok {
+			e.File = d.filename
+		}
 		panic(err)
 	}
 	//fmt.Println(i)
@@ -101,7 +137,9 @@ func (f *ForwardLexer) Peek() lexer.Token {
 		Type:  rune(f.items[f.pos].ID),
 		Value: f.items[f.pos].Content,
 		Pos: lexer.Position{
-			Line: f.items[f.pos].LineNumber,
+			Filename: f.filename,
+			Line:     f.items[f.pos].LineNumber,
+			Column:   f.items[f.pos].Column,
 		},
 	}
 }
@@ -124,18 +162,74 @@ func (d *definition) Symbols() map[string]rune {
 	return m
 }
 
+//Lex returns the tokens of the script, without its comments.
 func Lex(s string) ([]Item, error) {
+	return lex(s, 0, lineOffsets(s), false, EOF)
+}
+
+//LexComments is like Lex, but it also returns the comments of the script, as COMMENT tokens.
+func LexComments(s string) ([]Item, error) {
+	return lex(s, 0, lineOffsets(s), true, EOF)
+}
+
+//lineOffsets returns the offset of the start of each line of the script.
+func lineOffsets(s string) []int {
+	ret := []int{0}
+	for i := range s {
+		if s[i] == '\n' {
+			ret = append(ret, i+1)
+		}
+	}
+	return ret
+}
+
+//position returns the line and column of the offset, both starting at 1.
+func position(lines []int, offset int) (int, int) {
+	line := sort.Search(len(lines), func(i int) bool { return lines[i] > offset })
+	return line, offset - lines[line-1] + 1
+}
+
+//lex returns the tokens of s, which starts at the given offset of the script whose lines are given. The token
+//before s is given as prev, which is EOF at the start of the script.
+func lex(s string, base int, lines []int, keepComments bool, prev tokenType) ([]Item, error) {
 	var (
 		index        int
-		lineNumber   int
 		ret          []Item
 		inQuot       bool
 		parenDepth   int
 		inParen      bool
 		innerContent string
+		innerStart   int
 		identifier   string
+		identStart   int
 	)
-	lineNumber = 1
+	emit := func(t tokenType, offset int, content string) {
+		line, col := position(lines, base+offset)
+		ret = append(ret, Item{ID: t, LineNumber: line, Column: col, Content: content})
+	}
+	errAt := func(msg string, offset int) error {
+		line, col := position(lines, base+offset)
+		return Errorf(Position{Line: line, Column: col}, msg)
+	}
+	previous := func() tokenType {
+		for i := len(ret) - 1; i >= 0; i-- {
+			if ret[i].ID != COMMENT {
+				return ret[i].ID
+			}
+		}
+		return prev
+	}
+	flushIdentifier := func() {
+		if len(identifier) == 0 {
+			return
+		}
+		if _, err := strconv.ParseFloat(identifier, 64); err == nil {
+			emit(NUMBER, identStart, identifier)
+		} else {
+			emit(IDENTIFIER, identStart, identifier)
+		}
+		identifier = ""
+	}
 	for {
 		if index >= len(s) {
 			break
@@ -145,9 +239,10 @@ func Lex(s string) ([]Item, error) {
 			//start ( could mean nested parenthesis or start of block
 			parenDepth++
 			if !inParen {
-				ret = append(ret, Item{LPAREN, lineNumber, "("}) //we only care about outermost parenthesis - AQL never nests but the queries or scripts could.
+				emit(LPAREN, index, "(") //we only care about outermost parenthesis - AQL never nests but the queries or scripts could.
 				inParen = true
 				innerContent = "" //clear it out
+				innerStart = index + 1
 			} else {
 				innerContent += "("
 			}
@@ -157,7 +252,7 @@ func Lex(s string) ([]Item, error) {
 		if s[index] == ')' && !inQuot {
 			//end ) could mean nested parenthesis or end of block
 			if !inParen {
-				return nil, formatErr("Unexpected ')'", lineNumber)
+				return nil, errAt("Unexpected ')'", index)
 			}
 			if parenDepth > 1 {
 				innerContent += ")"
@@ -165,16 +260,16 @@ func Lex(s string) ([]Item, error) {
 			if parenDepth == 1 {
 				if len(ret) > 2 && lexableBlock(ret[len(ret)-2].ID) {
 					//special case - if we are in WITH/VARIABLE/etc block, lex the options
-					opts, err := Lex(innerContent)
+					opts, err := lex(innerContent, base+innerStart, lines, keepComments, LPAREN)
 					if err != nil {
 						return nil, err
 					}
 					ret = append(ret, opts...)
 				} else {
 					//not in WITH/VARIABLE/etc block - could be eg. QUERY or SCRIPT
-					ret = append(ret, Item{PAREN_BODY, lineNumber, innerContent})
+					emit(PAREN_BODY, innerStart, innerContent)
 				}
-				ret = append(ret, Item{RPAREN, lineNumber, ")"}) //we only care about outermost parenthesis - AQL never nests but the queries or scripts could.
+				emit(RPAREN, index, ")") //we only care about outermost parenthesis - AQL never nests but the queries or scripts could.
 			}
 			parenDepth--
 			if parenDepth == 0 {
@@ -187,14 +282,13 @@ func Lex(s string) ([]Item, error) {
 
 		if s[index:index+1] == "'" && !inParen {
 			if inQuot {
-				ret = append(ret, Item{QUOTED_STRING, lineNumber, innerContent})
-				//ret = append(ret, Item{QUOTE, lineNumber, "'"})
+				emit(QUOTED_STRING, innerStart, innerContent)
 				inQuot = false
 				innerContent = ""
 			} else {
 				inQuot = true
 				innerContent = "" //for good measure
-				//ret = append(ret, Item{QUOTE, lineNumber, "'"})
+				innerStart = index
 			}
 			index++
 			continue
@@ -209,110 +303,80 @@ func Lex(s string) ([]Item, error) {
 
 		//inline comment
 		if s[index] == '-' && len(s) > index+1 && s[index+1] == '-' {
-			index = scanInlineComment(s, index)
+			flushIdentifier()
+			end := scanInlineComment(s, index)
+			if keepComments {
+				emit(COMMENT, index, strings.TrimRight(s[index:end], "\r"))
+			}
+			index = end
+			continue
 		}
 
-		if len(s) > index+3 && s[index:index+3] == "/**" {
-			index = scanMultilineComment(s, index)
+		if len(s) >= index+3 && s[index:index+3] == "/**" {
+			flushIdentifier()
+			end := scanMultilineComment(s, index)
+			if keepComments {
+				emit(COMMENT, index, s[index:end])
+			}
+			index = end
+			continue
 		}
 
 		if s[index] == ',' {
-			if len(identifier) > 0 {
-				_, err := strconv.ParseFloat(identifier, 64)
-				if err == nil {
-					ret = append(ret, Item{NUMBER, lineNumber, identifier})
-				} else {
-					ret = append(ret, Item{IDENTIFIER, lineNumber, identifier})
-				}
-				identifier = ""
-			}
-			ret = append(ret, Item{COMMA, lineNumber, ","})
+			flushIdentifier()
+			emit(COMMA, index, ",")
 			index++
 			continue
 		}
 
 		if s[index] == '=' {
-			if len(identifier) > 0 {
-				_, err := strconv.ParseFloat(identifier, 64)
-				if err == nil {
-					ret = append(ret, Item{NUMBER, lineNumber, identifier})
-				} else {
-					ret = append(ret, Item{IDENTIFIER, lineNumber, identifier})
-				}
-				identifier = ""
-			}
-			ret = append(ret, Item{EQUALS, lineNumber, "="})
+			flushIdentifier()
+			emit(EQUALS, index, "=")
 			index++
 			continue
 		}
 
 		if s[index] == ';' && (index < len(s)-1 && isWhitespace(s, index+1)) {
+			flushIdentifier()
 			index++
 		}
 
-		if s[index] == '\n' {
-			if len(identifier) > 0 {
-				_, err := strconv.ParseFloat(identifier, 64)
-				if err == nil {
-					ret = append(ret, Item{NUMBER, lineNumber, identifier})
-				} else {
-					ret = append(ret, Item{IDENTIFIER, lineNumber, identifier})
-				}
-				identifier = ""
-			}
-			index++
-			lineNumber++
-			continue
-		}
-		if s[index] == '\t' || s[index] == ' ' || s[index] == '\r' || s[index] == '\f' {
+		if s[index] == '\n' || s[index] == '\t' || s[index] == ' ' || s[index] == '\r' || s[index] == '\f' {
 			//ignore whitespace except if we are in identifier mode
-			if len(identifier) > 0 {
-
-				_, err := strconv.ParseFloat(identifier, 64)
-				if err == nil {
-					ret = append(ret, Item{NUMBER, lineNumber, identifier})
-				} else {
-					ret = append(ret, Item{IDENTIFIER, lineNumber, identifier})
-				}
-				identifier = ""
-			}
+			flushIdentifier()
 			index++
 			continue
 		}
 
-		if t, ss, ok := getKeyword(s, index); ok && len(identifier) == 0 {
-			ret = append(ret, Item{t, lineNumber, ss})
+		if t, ss, ok := getKeyword(s, index); ok && len(identifier) == 0 && !(contextualKeywords[t] && beforeName[previous()]) {
+			emit(t, index, ss)
 			index = index + len(ss)
 			continue
 		}
 
+		if len(identifier) == 0 {
+			identStart = index
+		}
 		identifier += string(s[index])
 		index++
 
 	}
 	if inParen {
-		return nil, formatErr("Unclosed (", lineNumber)
+		return nil, errAt("Unclosed (", innerStart-1)
 	}
 	if inQuot {
-		return nil, formatErr("Unclosed '", lineNumber)
-	}
-	if len(identifier) > 0 {
-		//closing identifier
-		_, err := strconv.ParseFloat(identifier, 64)
-		if err == nil {
-			ret = append(ret, Item{NUMBER, lineNumber, identifier})
-		} else {
-			ret = append(ret, Item{IDENTIFIER, lineNumber, identifier})
-		}
+		return nil, errAt("Unclosed '", innerStart)
 	}
+	//closing identifier
+	flushIdentifier()
 	return ret, nil
 }
 
-//scanComment returns the index of the next character outside the inline comment
+//scanInlineComment returns the index of the next character outside the inline comment
 func scanInlineComment(s string, i int) int {
 	var j = i
 	for {
-		if j >= len(s)-1 || s[j] == '\n' {
+		if j >= len(s) || s[j] == '\n' {
 			break
 		}
 		j++
@@ -329,6 +393,9 @@ func scanMultilineComment(s string, i int) int {
 		}
 		j++
 	}
+	if j+3 > len(s) {
+		return len(s)
+	}
 	return j + 3
 }
 
@@ -367,7 +434,3 @@ func getKeyword(s string, i int) (tokenType, string, bool) {
 	}
 	return -1, "", false
 }
-
-func formatErr(msg string, line int) error {
-	return fmt.Errorf("compilation error line %v: %s", line, msg)
-}
@@ -63,6 +63,35 @@ func TestComments(t *testing.T) {
 	})
 }
 
+func TestPositions(t *testing.T) {
+	Convey("When lexing a script with comments", t, func() {
+		s := "-- first\nQUERY 'a' FROM x (\n\tSELECT 1\n) /** last **/\nWITH (A = 1)"
+		Convey("LexComments should return them as tokens", func() {
+			tt, err := LexComments(s)
+			So(err, ShouldBeNil)
+			So(tt[0].ID, ShouldEqual, COMMENT)
+			So(tt[0].Content, ShouldEqual, "-- first")
+			So(tt[8].ID, ShouldEqual, COMMENT)
+			So(tt[8].Content, ShouldEqual, "/** last **/")
+		})
+		Convey("Tokens should have the line and column that they start at", func() {
+			tt, err := Lex(s)
+			So(err, ShouldBeNil)
+			So(tt, ShouldHaveLength, 13)
+			So(tt[0].ID, ShouldEqual, QUERY)
+			So(tt[0].LineNumber, ShouldEqual, 2)
+			So(tt[0].Column, ShouldEqual, 1)
+			So(tt[3].ID, ShouldEqual, IDENTIFIER)
+			So(tt[3].Column, ShouldEqual, 16)
+			So(tt[6].ID, ShouldEqual, RPAREN)
+			So(tt[6].LineNumber, ShouldEqual, 4)
+			So(tt[9].ID, ShouldEqual, IDENTIFIER)
+			So(tt[9].LineNumber, ShouldEqual, 5)
+			So(tt[9].Column, ShouldEqual, 7)
+		})
+	})
+}
+
 func TestKeywords(t *testing.T) {
 	Convey("When lexing a script with keywords", t, func() {
 		s := "QUERY TEST FROM\n INTO  DESCRIPTION  TRANSFORM EXTERN INCLUDE   \t WITH"
@@ -78,6 +107,32 @@ func TestKeywords(t *testing.T) {
 	})
 }
 
+func TestContextualKeywords(t *testing.T) {
+	Convey("When lexing a script that uses IF, ON, SUCCESS, FAILURE, MOCK and FIXTURE as names", t, func() {
+		s := "FROM CONNECTION Failure, BLOCK on AFTER If, Mock WITH (Success = 1, fixture = 2) IF @Mode = 'x' ON FAILURE"
+		ts := []tokenType{FROM, CONNECTION, IDENTIFIER, COMMA, BLOCK, IDENTIFIER, AFTER, IDENTIFIER, COMMA, IDENTIFIER,
+			WITH, LPAREN, IDENTIFIER, EQUALS, NUMBER, COMMA, IDENTIFIER, EQUALS, NUMBER, RPAREN, IF, IDENTIFIER, EQUALS,
+			QUOTED_STRING, ON, FAILURE}
+		Convey("They should be names where a name is expected, and keywords elsewhere", func() {
+			tt, err := Lex(s)
+			So(err, ShouldBeNil)
+			So(tt, ShouldHaveLength, len(ts))
+			for i := range ts {
+				So(tt[i].ID, ShouldEqual, ts[i])
+			}
+			So(tt[2].Content, ShouldEqual, "Failure")
+			So(tt[5].Content, ShouldEqual, "on")
+		})
+	})
+	Convey("When lexing a script that uses other keywords as names", t, func() {
+		tt, err := Lex("FROM CONNECTION Global")
+		Convey("They should still be lexed as keywords", func() {
+			So(err, ShouldBeNil)
+			So(tt[2].ID, ShouldEqual, GLOBAL)
+		})
+	})
+}
+
 func TestIdentifiers(t *testing.T) {
 	Convey("When lexing a script with identifiers", t, func() {
 		s := "QUERY asdf FROM bsdf (csdf) INTO esdf"
@@ -25,11 +25,16 @@ const tagName = "aql"
 type Block interface {
 	GetName() string
 	GetOptions() []Option
+	GetPosition() Position
 }
 
+//The Position fields of blocks and options are not part of the grammar. They are set once the script is parsed,
+//and are kept when the blocks of included scripts are merged into the including script.
+
 type GlobalOption struct {
-	Key   string       `SET @IDENT '='`
-	Value *OptionValue `@@`
+	Key      string       `SET @IDENT '='`
+	Value    *OptionValue `@@`
+	Position Position
 }
 
 type OptionValue struct {
@@ -38,8 +43,23 @@ type OptionValue struct {
 }
 
 type Option struct {
-	Key   string       `@IDENT '='`
-	Value *OptionValue `@@`
+	Key      string       `@IDENT '='`
+	Value    *OptionValue `@@`
+	Position Position
+}
+
+//Guard is the IF clause of a block, which compares a global option to a value. Blocks whose guard does not
+//hold are left out of the job.
+type Guard struct {
+	Option string       `IF @IDENT '='`
+	Value  *OptionValue `@@`
+}
+
+//Outcome is the ON clause of a block, which makes it run after the job, once the job has been committed or
+//rolled back, instead of as part of it.
+type Outcome struct {
+	Success bool `ON ( @SUCCESS`
+	Failure bool `   | @FAILURE )`
 }
 
 type SourceSink struct {
@@ -60,6 +80,9 @@ type Query struct {
 	Destinations []SourceSink `[INTO @@ { "," @@ } ]`
 	Options      []Option     `[WITH '(' @@ {"," @@ } ')' ]`
 	Dependencies []string     `[AFTER @IDENT {"," @IDENT }]`
+	Guard        *Guard       `[@@]`
+	On           *Outcome     `[@@]`
+	Position     Position
 }
 
 func (q *Query) GetName() string {
@@ -70,6 +93,10 @@ func (q *Query) GetOptions() []Option {
 	return q.Options
 }
 
+func (q *Query) GetPosition() Position {
+	return q.Position
+}
+
 type Transform struct {
 	Plugin       bool          `TRANSFORM [@PLUGIN]`
 	Name         string        `@QUOTED_STRING`
@@ -79,6 +106,9 @@ type Transform struct {
 	Destinations []SourceSink  `[INTO @@ {"," @@}]`
 	Options      []Option      `[WITH '(' @@ {"," @@ } ')' ]`
 	Dependencies []string      `[AFTER @IDENT {"," @IDENT }]`
+	Guard        *Guard        `[@@]`
+	On           *Outcome      `[@@]`
+	Position     Position
 }
 
 func (q *Transform) GetName() string {
@@ -89,15 +119,37 @@ func (q *Transform) GetOptions() []Option {
 	return q.Options
 }
 
+func (q *Transform) GetPosition() Position {
+	return q.Position
+}
+
 type Declaration struct {
-	Name string `DECLARE @IDENT`
+	Name     string `DECLARE @IDENT`
+	Position Position
 }
 
+//Test is either a set of assertions about the output of a block, or a snapshot that
+//the full output of the block is compared against.
 type Test struct {
-	TargetBlock string   `TEST @IDENT WITH ASSERTIONS`
-	Extern      *string  `[EXTERN @QUOTED_STRING]`
-	Content     string   `['(' @PAREN_BODY ')']`
+	TargetBlock string   `TEST @IDENT WITH`
+	Snapshot    *string  `( SNAPSHOT @QUOTED_STRING`
+	Extern      *string  `| ASSERTIONS [EXTERN @QUOTED_STRING]`
+	Content     string   `['(' @PAREN_BODY ')'] )`
 	Options     []Option `[WITH '(' @@ {"," @@ } ')' ]`
+	Position    Position
+}
+
+//Mock replaces a connection or a query block in test mode. A mocked connection is replaced by
+//a throwaway SQLite database that is seeded by the fixture, and a mocked block by literal data.
+type Mock struct {
+	Connection *string  `MOCK ( CONNECTION ( @IDENT | @QUOTED_STRING )`
+	Block      *string  `     | BLOCK ( @IDENT | @QUOTED_STRING ) )`
+	Fixture    bool     `WITH ( @FIXTURE`
+	Data       bool     `     | @DATA )`
+	Extern     *string  `( @QUOTED_STRING`
+	Content    string   `| '(' @PAREN_BODY ')' )`
+	Options    []Option `[WITH '(' @@ {"," @@ } ')' ]`
+	Position   Position
 }
 
 type Data struct {
@@ -106,6 +158,9 @@ type Data struct {
 	Content      string       `['(' @PAREN_BODY ')']`
 	Destinations []SourceSink `[INTO @@ {"," @@}]`
 	Options      []Option     `[WITH '(' @@ {"," @@} ')' ]`
+	Guard        *Guard       `[@@]`
+	On           *Outcome     `[@@]`
+	Position     Position
 }
 
 func (d *Data) GetName() string {
@@ -116,14 +171,20 @@ func (d *Data) GetOptions() []Option {
 	return d.Options
 }
 
+func (d *Data) GetPosition() Position {
+	return d.Position
+}
+
 type Global struct {
-	Name    string   `GLOBAL @QUOTED_STRING`
-	Content string   `'(' @PAREN_BODY ')'`
-	Options []Option `[WITH '(' @@ {"," @@ } ')' ]`
+	Name     string   `GLOBAL @QUOTED_STRING`
+	Content  string   `'(' @PAREN_BODY ')'`
+	Options  []Option `[WITH '(' @@ {"," @@ } ')' ]`
+	Position Position
 }
 
 type Include struct {
-	Source string `INCLUDE @QUOTED_STRING`
+	Source   string `INCLUDE @QUOTED_STRING`
+	Position Position
 }
 
 type Description struct {
@@ -131,9 +192,10 @@ type Description struct {
 }
 
 type UnparsedConnection struct {
-	Name    string   `CONNECTION @QUOTED_STRING`
-	Content string   `'(' @PAREN_BODY ')'`
-	Options []Option `[WITH '(' @@ {"," @@ } ')' ]`
+	Name     string   `CONNECTION @QUOTED_STRING`
+	Content  string   `'(' @PAREN_BODY ')'`
+	Options  []Option `[WITH '(' @@ {"," @@ } ')' ]`
+	Position Position
 }
 
 type Connection struct {
@@ -141,6 +203,7 @@ type Connection struct {
 	Driver           string
 	ConnectionString string
 	Options          []Option
+	Position         Position
 }
 
 type JobScript struct {
@@ -154,18 +217,31 @@ type JobScript struct {
 	Execs         []Query              `|EXEC @@ `
 	Globals       []Global             `| @@ `
 	GlobalOptions []GlobalOption       `| @@ `
+	Mocks         []Mock               `| @@ `
 	Transforms    []Transform          ` | @@ }`
 }
 
-func (t *Test) Parse() ([]Assertion, error){
+//Statements returns the text of each assertion of the test. Snapshot tests have none.
+func (t *Test) Statements() []string {
+	if t.Snapshot != nil {
+		return nil
+	}
 	lines := strings.Split(t.Content, ";")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines
+}
+
+func (t *Test) Parse() ([]Assertion, error){
+	lines := t.Statements()
 	var (
 		ret []Assertion
 		err error
 	)
 	for i := range lines {
 		var a *Assertion
-		a, err = NewAssertion(strings.TrimSpace(lines[i]))
+		a, err = NewAssertion(lines[i])
 		if err != nil {
 			return nil, err
 		}
@@ -230,22 +306,22 @@ func OptionScanner(blockName, namespace string, scope ...[]Option) OptScanner {
 	return func(needle string, dest interface{}) error {
 		opt, ok := FindOverridableOption(needle, namespace, scope...)
 		if !ok {
-			return fmt.Errorf("option for block %s not found: %s", blockName, needle)
+			return missingOption(blockName, needle, scope...)
 		}
 		switch v := dest.(type) {
 		case *float64:
 			if opt.Value == nil || opt.Value.Number == nil {
-				return fmt.Errorf("expected a number for option %s in block %s", needle, blockName)
+				return Errorf(opt.Position, "expected a number for option %s in block %s", needle, blockName)
 			}
 			*v = *opt.Value.Number
 		case *int:
 			if opt.Value == nil || opt.Value.Number == nil {
-				return fmt.Errorf("expected a number for option %s in block %s", needle, blockName)
+				return Errorf(opt.Position, "expected a number for option %s in block %s", needle, blockName)
 			}
 			*v = int(*opt.Value.Number)
 		case *string:
 			if opt.Value == nil || opt.Value.Str == nil {
-				return fmt.Errorf("expected a string for option %s in block %s", needle, blockName)
+				return Errorf(opt.Position, "expected a string for option %s in block %s", needle, blockName)
 			}
 			*v = *opt.Value.Str
 		case *bool:
@@ -253,7 +329,7 @@ func OptionScanner(blockName, namespace string, scope ...[]Option) OptScanner {
 			*v = src
 		case *[]string:
 			if opt.Value == nil || opt.Value.Str == nil {
-				return fmt.Errorf("expected a string for option %s in block %s", needle, blockName)
+				return Errorf(opt.Position, "expected a string for option %s in block %s", needle, blockName)
 			}
 
 			vs := strings.Split(*opt.Value.Str, ",")
@@ -276,17 +352,17 @@ func MaybeOptionScanner(blockName, namespace string, scope ...[]Option) MaybeOpt
 		switch v := dest.(type) {
 		case *float64:
 			if opt.Value == nil || opt.Value.Number == nil {
-				return true, fmt.Errorf("expected a number for option %s in block %s", needle, blockName)
+				return true, Errorf(opt.Position, "expected a number for option %s in block %s", needle, blockName)
 			}
 			*v = *opt.Value.Number
 		case *int:
 			if opt.Value == nil || opt.Value.Number == nil {
-				return true, fmt.Errorf("expected a number for option %s in block %s", needle, blockName)
+				return true, Errorf(opt.Position, "expected a number for option %s in block %s", needle, blockName)
 			}
 			*v = int(*opt.Value.Number)
 		case *string:
 			if opt.Value == nil || opt.Value.Str == nil {
-				return true, fmt.Errorf("expected a string for option %s in block %s", needle, blockName)
+				return true, Errorf(opt.Position, "expected a string for option %s in block %s", needle, blockName)
 			}
 			*v = *opt.Value.Str
 		case *bool:
@@ -294,7 +370,7 @@ func MaybeOptionScanner(blockName, namespace string, scope ...[]Option) MaybeOpt
 			*v = src
 		case *[]string:
 			if opt.Value == nil || opt.Value.Str == nil {
-				return false, fmt.Errorf("expected a string for option %s in block %s", needle, blockName)
+				return false, Errorf(opt.Position, "expected a string for option %s in block %s", needle, blockName)
 			}
 
 			vs := strings.Split(*opt.Value.Str, ",")
@@ -308,6 +384,32 @@ func MaybeOptionScanner(blockName, namespace string, scope ...[]Option) MaybeOpt
 	}
 }
 
+//missingOption returns an error for a required option that was not found, at the position of the option that
+//it was probably misspelled as, if any.
+func missingOption(blockName, needle string, scope ...[]Option) error {
+	opt, ok := FindMisspelledOption(needle, scope...)
+	if !ok {
+		return fmt.Errorf("option for block %s not found: %s", blockName, needle)
+	}
+	return Errorf(opt.Position, "option for block %s not found: %s (is %s misspelled?)", blockName, needle, opt.Key)
+}
+
+//FindMisspelledOption returns the option of the hierarchy whose key is closest to the needle, if any is close
+//enough to be a misspelling of it. It should only be used once FindOverridableOption has not found the needle.
+func FindMisspelledOption(needle string, hierarchy ...[]Option) (*Option, bool) {
+	var keys []string
+	for _, opts := range hierarchy {
+		for _, opt := range opts {
+			keys = append(keys, opt.Key)
+		}
+	}
+	key, ok := Suggest(needle, keys)
+	if !ok {
+		return nil, false
+	}
+	return FindOverridableOption(key, "", hierarchy...)
+}
+
 //String returns the option value as a string. The boolean return parameter
 //will be true if the option was a string and false otherwise.
 func (opt Option) String() (string, bool) {
@@ -376,6 +478,36 @@ func (opt Option) Truthy() bool {
 	panic("should be unreachable")
 }
 
+//Holds returns whether the global option of the guard has the guard's value. The option can be named with or
+//without a leading @. Guards on options that are not set do not hold, although the compiler rejects them.
+func (g *Guard) Holds(globals []Option) bool {
+	opt, ok := FindOption(globals, strings.TrimPrefix(g.Option, "@"))
+	if !ok || opt.Value == nil || g.Value == nil {
+		return false
+	}
+	if g.Value.Str != nil {
+		return opt.Value.Str != nil && *opt.Value.Str == *g.Value.Str
+	}
+	return g.Value.Number != nil && opt.Value.Number != nil && *opt.Value.Number == *g.Value.Number
+}
+
+func (g *Guard) String() string {
+	if g.Value != nil && g.Value.Str != nil {
+		return fmt.Sprintf("%s = '%s'", g.Option, *g.Value.Str)
+	}
+	if g.Value != nil && g.Value.Number != nil {
+		return fmt.Sprintf("%s = %v", g.Option, *g.Value.Number)
+	}
+	return g.Option
+}
+
+func (o *Outcome) String() string {
+	if o.Failure {
+		return "ON FAILURE"
+	}
+	return "ON SUCCESS"
+}
+
 //ParseExcelRange parses a range of the form 'A1:C4' with possible wildcards
 //such as 'A1:*4'
 func ParseExcelRange(s string) (x1 int, x2 *int, y1 int, y2 *int, err error) {
@@ -629,6 +761,12 @@ func (b *JobScript) EvaluateParametrizedExtern(globals []Option) error {
 	}
 
 	for i := range b.Tests {
+		if b.Tests[i].Snapshot != nil {
+			*b.Tests[i].Snapshot, err = evaluateContent(*b.Tests[i].Snapshot, b.Tests[i].Options, globals)
+			if err != nil {
+				return err
+			}
+		}
 		if b.Tests[i].Extern == nil {
 			continue
 		}
@@ -731,7 +869,7 @@ func (b *JobScript) resolveExtern(cwd string) error {
 		if query.Extern != nil {
 			s, err := getContent(cwd, *query.Extern)
 			if err != nil {
-				return err
+				return Errorf(query.Position, "%v", err)
 			}
 			b.Queries[i].Content = s
 			b.Queries[i].Extern = nil
@@ -741,7 +879,7 @@ func (b *JobScript) resolveExtern(cwd string) error {
 		if query.Extern != nil {
 			s, err := getContent(cwd, *query.Extern)
 			if err != nil {
-				return err
+				return Errorf(query.Position, "%v", err)
 			}
 			b.Queries[i].Content = s
 			b.Queries[i].Extern = nil
@@ -752,7 +890,7 @@ func (b *JobScript) resolveExtern(cwd string) error {
 		if script.Extern != nil {
 			s, err := getContent(cwd, *script.Extern)
 			if err != nil {
-				return err
+				return Errorf(script.Position, "%v", err)
 			}
 			b.Transforms[i].Content = s
 			b.Transforms[i].Extern = nil
@@ -760,10 +898,14 @@ func (b *JobScript) resolveExtern(cwd string) error {
 	}
 
 	for i, test := range b.Tests {
+		//snapshots are relative to the script that declares them, like external content
+		if test.Snapshot != nil && !filepath.IsAbs(*test.Snapshot) {
+			*b.Tests[i].Snapshot = filepath.Join(cwd, *test.Snapshot)
+		}
 		if test.Extern != nil {
 			s, err := getContent(cwd, *test.Extern)
 			if err != nil {
-				return err
+				return Errorf(test.Position, "%v", err)
 			}
 			b.Tests[i].Content = s
 			b.Tests[i].Extern = nil
@@ -773,12 +915,22 @@ func (b *JobScript) resolveExtern(cwd string) error {
 		if data.Extern != nil {
 			s, err := getContent(cwd, *data.Extern)
 			if err != nil {
-				return err
+				return Errorf(data.Position, "%v", err)
 			}
 			b.Data[i].Content = s
 			b.Data[i].Extern = nil
 		}
 	}
+	for i, mock := range b.Mocks {
+		if mock.Extern != nil {
+			s, err := getContent(cwd, *mock.Extern)
+			if err != nil {
+				return Errorf(mock.Position, "%v", err)
+			}
+			b.Mocks[i].Content = s
+			b.Mocks[i].Extern = nil
+		}
+	}
 
 	return nil
 }
@@ -791,11 +943,14 @@ func getContent(cwd, path string) (string, error) {
 //resolve the given include, recursively if need be. Doesn't do bound checks on index.
 func (b *JobScript) resolveInclude(index, depth int, cwd string) error {
 	if depth > MaxIncludeDepth {
-		return fmt.Errorf("maximum INCLUDE depth %v reached", MaxIncludeDepth)
+		return Errorf(b.Includes[index].Position, "maximum INCLUDE depth %v reached", MaxIncludeDepth)
 	}
 	path := b.Includes[index].Source
 	bb, err := ParseFile(filepath.Join(cwd, path))
 	//bb, err := ParseFile(path)
+	if _, ok := err.(*os.PathError); ok {
+		return Errorf(b.Includes[index].Position, "%v", err)
+	}
 	if err != nil {
 		return err
 	}
@@ -822,6 +977,7 @@ func (b *JobScript) union(other *JobScript) {
 	b.Connections = append(b.Connections, other.Connections...)
 	//b.Includes = append(b.Includes, other.Includes...)
 	b.Tests = append(b.Tests, other.Tests...)
+	b.Mocks = append(b.Mocks, other.Mocks...)
 	b.Globals = append(b.Globals, other.Globals...)
 	b.Transforms = append(b.Transforms, other.Transforms...)
 }
@@ -845,14 +1001,19 @@ func parseConnections(conns []UnparsedConnection) ([]Connection, error) {
 	for i := range conns {
 		cs[i].Name = conns[i].Name
 		cs[i].Options = conns[i].Options
+		cs[i].Position = conns[i].Position
 		var opts connOpts
 		err = parser.ParseString(conns[i].Content, &opts)
 		if err != nil {
-			return nil, fmt.Errorf("invalid connection %s: %v", cs[i].Name, err)
+			return nil, Errorf(cs[i].Position, "invalid connection %s: %v", cs[i].Name, err)
+		}
+		//the options in the body of the connection are located at the connection
+		for j := range opts.Options {
+			opts.Options[j].Position = cs[i].Position
 		}
 		err = optsToConn(opts.Options, &cs[i])
 		if err != nil {
-			return nil, fmt.Errorf("invalid connection %s: %v", cs[i].Name, err)
+			return nil, Errorf(cs[i].Position, "invalid connection %s: %v", cs[i].Name, err)
 		}
 	}
 	return cs, nil
@@ -875,44 +1036,99 @@ func optsToConn(opts []Option, conn *Connection) error {
 }
 
 //ParseString parses an AQL string into a JobScript struct.
-func ParseString(s string) (b *JobScript, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("parser error: %v", r)
-			return
-		}
-	}()
+func ParseString(s string) (*JobScript, error) {
+	return parse(s, "")
+}
 
-	parser, err := participle.Build(&JobScript{}, &definition{})
+//ParseFile parses an AQL file into a JobScript struct. The positions of its blocks and options, and of its
+//errors, include the path of the file.
+func ParseFile(path string) (*JobScript, error) {
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-
-	b = &JobScript{}
-	err = parser.ParseString(s, b)
-	return
+	return parse(string(b), path)
 }
 
-//ParseFile parses an AQL file into a JobScript struct.
-func ParseFile(path string) (b *JobScript, err error) {
-	f, err := os.Open(path)
+//parse parses the script and sets the position of its blocks and options.
+func parse(s, file string) (*JobScript, error) {
+	b, err := parseScript(s, file)
 	if err != nil {
-		return nil, err
+		return b, err
 	}
-	defer f.Close()
+	return b, b.locate(s, file)
+}
+
+//parseScript parses the script without setting the position of its blocks and options, so that scripts that
+//only differ by layout parse to the same JobScript.
+func parseScript(s, file string) (b *JobScript, err error) {
 	defer func() {
 		if r := recover(); r != nil {
+			if e, ok := r.(*Error); ok {
+				err = e
+				return
+			}
 			err = fmt.Errorf("parser error: %v", r)
 			return
 		}
 	}()
 
-	parser, err := participle.Build(&JobScript{}, &definition{})
+	parser, err := participle.Build(&JobScript{}, &definition{filename: file})
 	if err != nil {
 		panic(err)
 	}
 
 	b = &JobScript{}
-	err = parser.Parse(f, b)
+	err = parser.ParseString(s, b)
 	return
 }
+
+//locate sets the position of each block of the script, and of each option of their WITH clauses.
+func (b *JobScript) locate(script, file string) error {
+	positions, err := Positions(script)
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, p := range positions {
+		p.File = file
+		for i := range p.Options {
+			p.Options[i].File = file
+		}
+		i := counts[p.Kind]
+		counts[p.Kind]++
+		set := func(pos *Position, options []Option) {
+			*pos = p.Position
+			for j := range options {
+				if j < len(p.Options) {
+					options[j].Position = p.Options[j]
+				}
+			}
+		}
+		switch {
+		case p.Kind == "QUERY" && i < len(b.Queries):
+			set(&b.Queries[i].Position, b.Queries[i].Options)
+		case p.Kind == "EXEC" && i < len(b.Execs):
+			set(&b.Execs[i].Position, b.Execs[i].Options)
+		case p.Kind == "TRANSFORM" && i < len(b.Transforms):
+			set(&b.Transforms[i].Position, b.Transforms[i].Options)
+		case p.Kind == "DATA" && i < len(b.Data):
+			set(&b.Data[i].Position, b.Data[i].Options)
+		case p.Kind == "DECLARE" && i < len(b.Declarations):
+			set(&b.Declarations[i].Position, nil)
+		case p.Kind == "CONNECTION" && i < len(b.Connections):
+			set(&b.Connections[i].Position, b.Connections[i].Options)
+		case p.Kind == "INCLUDE" && i < len(b.Includes):
+			set(&b.Includes[i].Position, nil)
+		case p.Kind == "TEST" && i < len(b.Tests):
+			set(&b.Tests[i].Position, b.Tests[i].Options)
+		case p.Kind == "GLOBAL" && i < len(b.Globals):
+			set(&b.Globals[i].Position, b.Globals[i].Options)
+		case p.Kind == "SET" && i < len(b.GlobalOptions):
+			set(&b.GlobalOptions[i].Position, nil)
+		case p.Kind == "MOCK" && i < len(b.Mocks):
+			set(&b.Mocks[i].Position, b.Mocks[i].Options)
+		}
+	}
+	return nil
+}
@@ -511,6 +511,81 @@ func TestTest(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(b.TargetBlock, ShouldEqual, "block_name")
 		So(strings.TrimSpace(b.Content), ShouldEqual, "query_source()")
+		So(b.Snapshot, ShouldBeNil)
+		//2
+		s2 := `TEST block_name WITH SNAPSHOT 'snapshots/block_name.json' WITH (KEYS = 'Id')`
+		b = &Test{}
+		err = parser.ParseString(s2, b)
+		So(err, ShouldBeNil)
+		So(b.TargetBlock, ShouldEqual, "block_name")
+		So(*b.Snapshot, ShouldEqual, "snapshots/block_name.json")
+		So(b.Statements(), ShouldBeEmpty)
+		So(b.Options, ShouldHaveLength, 1)
+	})
+}
+
+func TestMock(t *testing.T) {
+	parser, err := participle.Build(&Mock{}, &definition{})
+	if err != nil {
+		panic(err)
+	}
+	Convey("It should parse mock blocks successfully", t, func() {
+		//1
+		s1 := `MOCK CONNECTION warehouse WITH FIXTURE 'fixtures/warehouse.sql'`
+		b := &Mock{}
+		err = parser.ParseString(s1, b)
+		So(err, ShouldBeNil)
+		So(*b.Connection, ShouldEqual, "warehouse")
+		So(b.Fixture, ShouldBeTrue)
+		So(*b.Extern, ShouldEqual, "fixtures/warehouse.sql")
+		//2
+		s2 := `MOCK BLOCK 'GetRows' WITH DATA (
+			[[1, "a"]]
+		) WITH (COLUMNS = 'Id, Name')`
+		b = &Mock{}
+		err = parser.ParseString(s2, b)
+		So(err, ShouldBeNil)
+		So(*b.Block, ShouldEqual, "GetRows")
+		So(b.Data, ShouldBeTrue)
+		So(strings.TrimSpace(b.Content), ShouldEqual, `[[1, "a"]]`)
+		So(b.Options, ShouldHaveLength, 1)
+	})
+}
+
+func TestControlFlow(t *testing.T) {
+	Convey("It should parse IF and ON clauses successfully", t, func() {
+		js, err := ParseString(`EXEC 'Cleanup' FROM CONNECTION Warehouse (
+			DELETE FROM Staging
+		) AFTER Load IF @Mode = 'Prod' ON FAILURE
+		DATA 'Values' ([[1]]) INTO CONSOLE WITH (COLUMNS = 'a') IF @Batch = 2
+		TRANSFORM 'Total' FROM BLOCK Values (
+			AGGREGATE SUM(a) AS Total
+		) INTO CONSOLE ON SUCCESS`)
+		So(err, ShouldBeNil)
+		So(js.Execs, ShouldHaveLength, 1)
+		So(js.Execs[0].Dependencies, ShouldResemble, []string{"Load"})
+		So(js.Execs[0].Guard.String(), ShouldEqual, "@Mode = 'Prod'")
+		So(js.Execs[0].On.Failure, ShouldBeTrue)
+		So(js.Data[0].Guard.String(), ShouldEqual, "@Batch = 2")
+		So(js.Data[0].On, ShouldBeNil)
+		So(js.Transforms[0].Guard, ShouldBeNil)
+		So(js.Transforms[0].On.String(), ShouldEqual, "ON SUCCESS")
+		Convey("Guards should hold when the global option has their value", func() {
+			opts, err := StrToOpts(`{"Mode": "Prod", "Batch": 3}`)
+			So(err, ShouldBeNil)
+			So(js.Execs[0].Guard.Holds(opts), ShouldBeTrue)
+			So(js.Data[0].Guard.Holds(opts), ShouldBeFalse)
+			So(js.Data[0].Guard.Holds(nil), ShouldBeFalse)
+		})
+	})
+	Convey("It should parse scripts that use IF, ON, SUCCESS, FAILURE, MOCK and FIXTURE as names", t, func() {
+		js, err := ParseString(`QUERY 'Load' FROM CONNECTION Failure (
+			SELECT 1
+		) INTO BLOCK Success AFTER Mock`)
+		So(err, ShouldBeNil)
+		So(*js.Queries[0].Sources[0].Database, ShouldEqual, "Failure")
+		So(*js.Queries[0].Destinations[0].Block, ShouldEqual, "Success")
+		So(js.Queries[0].Dependencies, ShouldResemble, []string{"Mock"})
 	})
 }
 
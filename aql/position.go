@@ -0,0 +1,186 @@
+package aql
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Position is a position in a script. Lines and columns start at 1.
+type Position struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%v:%v", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%v:%v", p.File, p.Line, p.Column)
+}
+
+//Error is an error about the block or option at a position of a script.
+type Error struct {
+	Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	//blocks and options that are not parsed from a script, such as command-line options, have no position
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Position, e.Message)
+}
+
+//Errorf returns an error at the position, with a message formatted like fmt.Errorf.
+func Errorf(pos Position, format string, args ...interface{}) error {
+	return &Error{Position: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+//Suggest returns the candidate that is closest to the name, which is probably what the name was meant to be.
+//The comparison is case-insensitive, and candidates that are more than a third of the name's length away from it,
+//in number of edits, are not suggested.
+func Suggest(name string, candidates []string) (string, bool) {
+	var (
+		best     string
+		bestDist = len(name)/3 + 1
+		lower    = strings.ToLower(name)
+	)
+	if bestDist < 2 {
+		bestDist = 2
+	}
+	for _, c := range candidates {
+		d := editDistance(lower, strings.ToLower(c))
+		if d > 0 && d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best, best != ""
+}
+
+//DidYouMean returns a suggestion to append to the message of an error about a name that was not found, such
+//as " (did you mean Orders?)", or an empty string if none of the candidates is close to it.
+func DidYouMean(name string, candidates []string) string {
+	if s, ok := Suggest(name, candidates); ok {
+		return fmt.Sprintf(" (did you mean %s?)", s)
+	}
+	return ""
+}
+
+//editDistance returns the number of insertions, deletions, substitutions and transpositions of adjacent
+//characters that turn a into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = smallest(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = smallest(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func smallest(values ...int) int {
+	ret := values[0]
+	for _, v := range values[1:] {
+		if v < ret {
+			ret = v
+		}
+	}
+	return ret
+}
+
+//BlockPosition is the position of a top-level block of a script, along with the position of each option
+//of its WITH clause, in order.
+type BlockPosition struct {
+	Position
+	//Kind is the keyword that starts the block, such as QUERY.
+	Kind string
+	//Name is the first name after the keyword, such as the name of a query or the target of a test.
+	Name string
+	//Comment is the text of the comments on the lines right before the block, which document it.
+	Comment string
+	Options []Position
+}
+
+//Positions returns the position of each top-level block of the script, in order. The blocks of each
+//kind are in the same order as in the JobScript that the script parses to.
+func Positions(script string) ([]BlockPosition, error) {
+	items, err := LexComments(script)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ret      []BlockPosition
+		comments []string
+		lastLine int
+	)
+	for i, item := range items {
+		if item.ID == COMMENT {
+			if trailing(items, i) || (len(comments) > 0 && item.LineNumber != lastLine+1) {
+				comments = nil
+			}
+			if !trailing(items, i) {
+				comments = append(comments, commentText(item.Content))
+			}
+			lastLine = endLine(item)
+			continue
+		}
+		if len(comments) > 0 && item.LineNumber != lastLine+1 {
+			comments = nil
+		}
+		if startsBlock(items, i) {
+			ret = append(ret, BlockPosition{
+				Position: Position{Line: item.LineNumber, Column: item.Column},
+				Kind:     item.Content,
+				Comment:  strings.Join(comments, "\n"),
+			})
+			comments = nil
+			continue
+		}
+		comments = nil
+		if len(ret) == 0 {
+			continue
+		}
+		block := &ret[len(ret)-1]
+		switch {
+		case block.Name == "" && (item.ID == QUOTED_STRING || item.ID == IDENTIFIER):
+			block.Name = item.Content
+		case item.ID == LPAREN && i > 0 && items[i-1].ID == WITH:
+			//options are keys followed by '='
+			for j := i + 1; j+1 < len(items) && items[j].ID != RPAREN; j++ {
+				if items[j+1].ID == EQUALS {
+					block.Options = append(block.Options, Position{Line: items[j].LineNumber, Column: items[j].Column})
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+//commentText returns the text of a comment, without its delimiters.
+func commentText(comment string) string {
+	if strings.HasPrefix(comment, "--") {
+		return strings.TrimSpace(comment[2:])
+	}
+	comment = strings.TrimSuffix(strings.TrimPrefix(comment, "/**"), "**/")
+	lines := strings.Split(strings.TrimSpace(comment), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.Join(lines, "\n")
+}
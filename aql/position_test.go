@@ -0,0 +1,53 @@
+package aql
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	Convey("When suggesting a name", t, func() {
+		Convey("It should suggest the closest candidate", func() {
+			s, ok := Suggest("Warehose", []string{"Archive", "Warehouse"})
+			So(ok, ShouldBeTrue)
+			So(s, ShouldEqual, "Warehouse")
+		})
+		Convey("It should count transpositions as one edit", func() {
+			s, ok := Suggest("TABEL", []string{"ROWS", "TABLE"})
+			So(ok, ShouldBeTrue)
+			So(s, ShouldEqual, "TABLE")
+		})
+		Convey("It should not suggest candidates that are too different", func() {
+			_, ok := Suggest("xyz", []string{"Orders"})
+			So(ok, ShouldBeFalse)
+			So(DidYouMean("xyz", []string{"Orders"}), ShouldEqual, "")
+			So(DidYouMean("Totl", []string{"Orders", "Total"}), ShouldEqual, " (did you mean Total?)")
+		})
+	})
+}
+
+func TestParsePositions(t *testing.T) {
+	Convey("When parsing a script", t, func() {
+		s := "DECLARE @Since\n\nQUERY 'a' FROM GLOBAL (\n\tSELECT 1\n) INTO CONSOLE\nWITH (A = 1,\n  B = 'x')\nTEST a WITH ASSERTIONS (\n  COLUMN x HAS NO NULL VALUES\n)"
+		js, err := ParseString(s)
+		So(err, ShouldBeNil)
+		Convey("Blocks should have the position of their keyword", func() {
+			So(js.Declarations[0].Position, ShouldResemble, Position{Line: 1, Column: 1})
+			So(js.Queries[0].Position, ShouldResemble, Position{Line: 3, Column: 1})
+			So(js.Tests[0].Position, ShouldResemble, Position{Line: 8, Column: 1})
+		})
+		Convey("Options should have the position of their key", func() {
+			So(js.Queries[0].Options[0].Position, ShouldResemble, Position{Line: 6, Column: 7})
+			So(js.Queries[0].Options[1].Position, ShouldResemble, Position{Line: 7, Column: 3})
+		})
+		Convey("Errors should have the position that they are at", func() {
+			_, err := ParseString("QUERY 'a' FROM GLOBAL (\n\tSELECT 1\n")
+			So(err, ShouldNotBeNil)
+			e, ok := err.(*Error)
+			So(ok, ShouldBeTrue)
+			So(e.Line, ShouldEqual, 1)
+			So(e.Column, ShouldEqual, 23)
+			So(e.Error(), ShouldEqual, "1:23: Unclosed (")
+		})
+	})
+}
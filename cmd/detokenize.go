@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/michaelbironneau/analyst/transforms"
+	"github.com/urfave/cli"
+	"os"
+	"strings"
+)
+
+//Detokenize reverses the tokens output by MASK ... USING TOKENIZE, given the key file. The tokens are
+//either passed as arguments or read from STDIN, one per line.
+func Detokenize(c *cli.Context) error {
+	keyFile := c.String("key-file")
+
+	if len(keyFile) == 0 {
+		fmt.Println("Error - key file not set")
+		return fmt.Errorf("key file not set")
+	}
+
+	key, err := transforms.ReadMaskKey(keyFile)
+
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return err
+	}
+
+	tokens := []string(c.Args())
+
+	if len(tokens) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if token := strings.TrimSpace(scanner.Text()); len(token) > 0 {
+				tokens = append(tokens, token)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	for _, token := range tokens {
+		s, err := transforms.Detokenize(key, token)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return err
+		}
+		fmt.Println(s)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/michaelbironneau/analyst"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func Explain(c *cli.Context) error {
+	var (
+		opts []aql.Option
+		err  error
+	)
+	oString := c.String("params")
+	if len(oString) > 0 {
+		opts, err = aql.StrToOpts(oString)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	scriptFile := c.String("script")
+
+	if len(scriptFile) == 0 {
+		return fmt.Errorf("script file not set")
+	}
+
+	var lev engine.LogLevel
+
+	lev = engine.Warning
+
+	if c.Bool("v") {
+		lev = engine.Info
+	}
+
+	if c.Bool("vv") {
+		lev = engine.Trace
+	}
+
+	l := engine.NewConsoleLogger(lev)
+
+	plan, err := analyst.ExplainFile(scriptFile, &analyst.RuntimeOptions{Options: opts, Logger: l, ScriptDirectory: filepath.Dir(scriptFile)})
+
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return err
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+	printPlan(os.Stdout, plan)
+	return nil
+}
+
+func printPlan(w io.Writer, plan *analyst.Plan) {
+	for i, wave := range plan.Waves {
+		fmt.Fprintf(w, "Wave %d: %s\n", i+1, strings.Join(wave, ", "))
+	}
+	for _, b := range plan.Blocks {
+		fmt.Fprintf(w, "\n%s '%s' (wave %d)\n", b.Kind, b.Name, b.Wave)
+		if len(b.After) > 0 {
+			fmt.Fprintf(w, "  After: %s\n", strings.Join(b.After, ", "))
+		}
+		for _, conn := range b.Connections {
+			fmt.Fprintf(w, "  Connection %s: %s %s\n", conn.Name, conn.Driver, conn.ConnectionString)
+			printOptions(w, "    ", conn.Options)
+		}
+		if len(b.Options) > 0 {
+			fmt.Fprintln(w, "  Options:")
+			printOptions(w, "    ", b.Options)
+		}
+		if len(b.Upstream) > 0 {
+			fmt.Fprintf(w, "  Upstream: %s\n", strings.Join(b.Upstream, ", "))
+		}
+		if len(b.Downstream) > 0 {
+			fmt.Fprintf(w, "  Downstream: %s\n", strings.Join(b.Downstream, ", "))
+		}
+		if b.Content != "" {
+			fmt.Fprintln(w, "  Content:")
+			for _, line := range strings.Split(b.Content, "\n") {
+				fmt.Fprintf(w, "    %s\n", strings.TrimRight(line, " \t\r"))
+			}
+		}
+	}
+}
+
+func printOptions(w io.Writer, indent string, options []analyst.EffectiveOption) {
+	for _, opt := range options {
+		fmt.Fprintf(w, "%s%s = %s (%s)\n", indent, opt.Key, opt.Value, opt.Scope)
+	}
+}
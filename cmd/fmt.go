@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+)
+
+//Fmt formats the scripts passed as arguments, or the script given by the script flag, in place. With the
+//check flag, it lists the scripts that are not formatted instead, and returns an error if there are any.
+func Fmt(c *cli.Context) error {
+	scripts := []string(c.Args())
+
+	if len(scripts) == 0 {
+		scripts = []string{c.String("script")}
+	}
+
+	var unformatted int
+
+	for _, script := range scripts {
+		b, err := ioutil.ReadFile(script)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return err
+		}
+		formatted, err := aql.Format(string(b))
+		if err != nil {
+			fmt.Printf("Error: %s: %s\n", script, err)
+			return err
+		}
+		if formatted == string(b) {
+			continue
+		}
+		if c.Bool("check") {
+			fmt.Println(script)
+			unformatted++
+			continue
+		}
+		info, err := os.Stat(script)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(script, []byte(formatted), info.Mode()); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return err
+		}
+	}
+
+	if unformatted > 0 {
+		return fmt.Errorf("%v script(s) are not formatted, run analyst fmt to format them", unformatted)
+	}
+	return nil
+}
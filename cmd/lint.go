@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/michaelbironneau/analyst"
+	"github.com/urfave/cli"
+	"os"
+)
+
+//Lint prints the issues found by the linter in the script, one per line as file:line:column: message (rule),
+//or as JSON. It returns an error if there are any.
+func Lint(c *cli.Context) error {
+	scriptFile := c.String("script")
+
+	if len(scriptFile) == 0 {
+		return fmt.Errorf("script file not set")
+	}
+
+	issues, err := analyst.LintFile(scriptFile, &analyst.RuntimeOptions{})
+
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return err
+	}
+
+	if c.Bool("json") {
+		if issues == nil {
+			issues = []analyst.LintIssue{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(issues); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("found %v issue(s) in %s", len(issues), scriptFile)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/michaelbironneau/analyst/lsp"
+	"github.com/urfave/cli"
+	"os"
+)
+
+//Lsp runs a language server for AQL over STDIN and STDOUT, until the editor exits.
+func Lsp(c *cli.Context) error {
+	return lsp.NewServer(os.Stdin, os.Stdout).Serve()
+}
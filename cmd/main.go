@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/michaelbironneau/analyst/transforms"
 	"github.com/urfave/cli"
 	"os"
 )
@@ -33,6 +34,22 @@ func main() {
 					Name:  "i",
 					Usage: "interactive mode (enter parameters on STDIN)",
 				},
+				cli.StringFlag{
+					Name:  "only",
+					Usage: "only run the given blocks (names or globs, comma-separated) and what they depend on",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "only run the given blocks, the blocks downstream of them and what they depend on",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "only run the given blocks and the blocks upstream of them",
+				},
+				cli.StringFlag{
+					Name:  "global-db",
+					Usage: "path to an SQLite database to save the GLOBAL tables to, and to load them from when blocks are selected",
+				},
 				cli.BoolFlag{
 					Name:  "v",
 					Usage: "verbose mode (display INFO events)",
@@ -75,6 +92,70 @@ func main() {
 					Value: ".analyst",
 					Usage: "path to script",
 				},
+				cli.StringFlag{
+					Name:  "report",
+					Usage: "report format for the test results (junit or json)",
+				},
+				cli.StringFlag{
+					Name:  "report-file",
+					Usage: "path to the report file (defaults to STDOUT)",
+				},
+				cli.BoolFlag{
+					Name:  "update-snapshots",
+					Usage: "overwrite the snapshots of snapshot tests with the current output",
+				},
+				cli.StringFlag{
+					Name:  "only",
+					Usage: "only test the given blocks (names or globs, comma-separated) and what they depend on",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "only test the given blocks, the blocks downstream of them and what they depend on",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "only test the given blocks and the blocks upstream of them",
+				},
+				cli.StringFlag{
+					Name:  "global-db",
+					Usage: "path to an SQLite database to load the GLOBAL tables from when blocks are selected",
+				},
+				cli.BoolFlag{
+					Name:  "v",
+					Usage: "verbose mode (display INFO events)",
+				},
+				cli.BoolFlag{
+					Name:  "vv",
+					Usage: "super-verbose mode (display TRACE events)",
+				},
+			},
+		},
+		{
+			Name:    "profile",
+			Aliases: []string{"p"},
+			Usage:   "runs the part of a script that a block depends on, and prints statistics about the columns of the block",
+			Action:  Profile,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "script",
+					Value: ".analyst",
+					Usage: "path to script",
+				},
+				cli.StringFlag{
+					Name:  "block",
+					Value: "",
+					Usage: "name of the block to profile",
+				},
+				cli.IntFlag{
+					Name:  "top",
+					Value: transforms.DefaultProfileTopValues,
+					Usage: "number of most frequent values to print for each column",
+				},
+				cli.StringFlag{
+					Name:  "params",
+					Value: "",
+					Usage: "script parameters, written as \"name:value;name_2:value_2;...\"",
+				},
 				cli.BoolFlag{
 					Name:  "v",
 					Usage: "verbose mode (display INFO events)",
@@ -85,6 +166,88 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:    "explain",
+			Aliases: []string{"e"},
+			Usage:   "prints how a script would be executed, without executing it",
+			Action:  Explain,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "script",
+					Value: ".analyst",
+					Usage: "path to script",
+				},
+				cli.StringFlag{
+					Name:  "params",
+					Value: "",
+					Usage: "script parameters, written as \"name:value;name_2:value_2;...\"",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the plan as JSON",
+				},
+				cli.BoolFlag{
+					Name:  "v",
+					Usage: "verbose mode (display INFO events)",
+				},
+				cli.BoolFlag{
+					Name:  "vv",
+					Usage: "super-verbose mode (display TRACE events)",
+				},
+			},
+		},
+		{
+			Name:    "lint",
+			Aliases: []string{"l"},
+			Usage:   "reports likely mistakes in a script, such as unused parameters and unknown options",
+			Action:  Lint,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "script",
+					Value: ".analyst",
+					Usage: "path to script",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the issues as JSON",
+				},
+			},
+		},
+		{
+			Name:      "fmt",
+			Usage:     "formats scripts in place",
+			ArgsUsage: "[SCRIPT...] (the script flag if omitted)",
+			Action:    Fmt,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "script",
+					Value: ".analyst",
+					Usage: "path to script",
+				},
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "list the scripts that are not formatted, without formatting them, and fail if there are any",
+				},
+			},
+		},
+		{
+			Name:   "lsp",
+			Usage:  "runs a language server for AQL over STDIN and STDOUT, for editors",
+			Action: Lsp,
+		},
+		{
+			Name:      "detokenize",
+			Usage:     "reverses values tokenized by MASK ... USING TOKENIZE",
+			ArgsUsage: "[TOKEN...] (read from STDIN if omitted)",
+			Action:    Detokenize,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "key-file",
+					Value: "",
+					Usage: "path to the key file used for tokenization",
+				},
+			},
+		},
 	}
 	app.Run(os.Args)
 
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/michaelbironneau/analyst"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	"github.com/michaelbironneau/analyst/transforms"
+	"github.com/urfave/cli"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+func Profile(c *cli.Context) error {
+	var (
+		opts []aql.Option
+		err  error
+	)
+	oString := c.String("params")
+	if len(oString) > 0 {
+		opts, err = aql.StrToOpts(oString)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	scriptFile := c.String("script")
+
+	if len(scriptFile) == 0 {
+		return fmt.Errorf("script file not set")
+	}
+
+	block := c.String("block")
+
+	if len(block) == 0 {
+		return fmt.Errorf("block not set")
+	}
+
+	var lev engine.LogLevel
+
+	lev = engine.Warning
+
+	if c.Bool("v") {
+		lev = engine.Info
+	}
+
+	if c.Bool("vv") {
+		lev = engine.Trace
+	}
+
+	l := engine.NewConsoleLogger(lev)
+
+	res, err := analyst.ProfileFile(scriptFile, block, c.Int("top"), &analyst.RuntimeOptions{Options: opts, Logger: l, ScriptDirectory: filepath.Dir(scriptFile)})
+
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(transforms.ProfileColumns, "\t"))
+	for _, row := range res {
+		var s []string
+		for _, v := range row {
+			if v == nil {
+				s = append(s, "")
+				continue
+			}
+			if f, ok := v.(float64); ok {
+				s = append(s, fmt.Sprintf("%.2f", f))
+				continue
+			}
+			s = append(s, fmt.Sprintf("%v", v))
+		}
+		fmt.Fprintln(w, strings.Join(s, "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/michaelbironneau/analyst/engine"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	reportJUnit = "junit"
+	reportJSON  = "json"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+type jsonTestCase struct {
+	Block     string                 `json:"block"`
+	Name      string                 `json:"name"`
+	Assertion string                 `json:"assertion"`
+	Status    string                 `json:"status"`
+	Message   string                 `json:"message,omitempty"`
+	Row       map[string]interface{} `json:"row,omitempty"`
+}
+
+//writeReport writes the test results to the file in the given format, or to STDOUT if the path is empty.
+func writeReport(format string, path string, results []engine.TestResult) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("could not create report file: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	switch format {
+	case reportJUnit:
+		return writeJUnitReport(w, results)
+	case reportJSON:
+		return writeJSONReport(w, results)
+	}
+	return fmt.Errorf("unknown report format %s, expected %s or %s", format, reportJUnit, reportJSON)
+}
+
+//failureDetails describes the assertion and the first row that failed it, if any.
+func failureDetails(r engine.TestResult) string {
+	s := fmt.Sprintf("Assertion: %s", r.Description)
+	if r.Row == nil {
+		return s
+	}
+	b, err := json.Marshal(r.Row)
+	if err != nil {
+		return fmt.Sprintf("%s\nRow: %v", s, r.Row)
+	}
+	return fmt.Sprintf("%s\nRow: %s", s, b)
+}
+
+//writeJUnitReport writes one test suite per tested block, and one test case per assertion. Warnings are
+//reported as passing test cases, with the warning in their error output, and assertions that were not
+//evaluated because another one failed are reported as skipped.
+func writeJUnitReport(w io.Writer, results []engine.TestResult) error {
+	var (
+		report junitTestSuites
+		blocks []string
+		suites = make(map[string]*junitTestSuite)
+	)
+	for _, r := range results {
+		suite, ok := suites[r.Block]
+		if !ok {
+			suite = &junitTestSuite{Name: r.Block}
+			suites[r.Block] = suite
+			blocks = append(blocks, r.Block)
+		}
+		tc := junitTestCase{Name: r.Name, ClassName: r.Block}
+		switch r.Status {
+		case engine.TestFailed:
+			tc.Failure = &junitFailure{Message: r.Message, Content: failureDetails(r)}
+			suite.Failures++
+			report.Failures++
+		case engine.TestWarning:
+			tc.SystemErr = fmt.Sprintf("Warning: %s\n%s", r.Message, failureDetails(r))
+		case engine.TestSkipped:
+			tc.Skipped = &junitSkipped{Message: r.Message}
+			suite.Skipped++
+			report.Skipped++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+		report.Tests++
+	}
+	sort.Strings(blocks)
+	for _, block := range blocks {
+		report.Suites = append(report.Suites, *suites[block])
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeJSONReport(w io.Writer, results []engine.TestResult) error {
+	cases := []jsonTestCase{}
+	for _, r := range results {
+		cases = append(cases, jsonTestCase{
+			Block:     r.Block,
+			Name:      r.Name,
+			Assertion: r.Description,
+			Status:    r.Status,
+			Message:   r.Message,
+			Row:       r.Row,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cases)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/michaelbironneau/analyst/engine"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+var reportResults = []engine.TestResult{
+	{Block: "Orders", Name: "Orders, assertion 1", Description: "COLUMN Id IS UNIQUE", Status: engine.TestPassed},
+	{Block: "Orders", Name: "Orders, assertion 2", Description: "COLUMN Amount HAS NO NULL VALUES", Status: engine.TestFailed, Message: "assertion failed on row 2", Row: map[string]interface{}{"Id": 2.0, "Amount": nil}},
+	{Block: "Orders", Name: "Orders, assertion 3", Description: "IT HAS 10 ROWS", Status: engine.TestSkipped, Message: "not evaluated because Orders, assertion 2 failed"},
+	{Block: "Customers", Name: "Customers, assertion 1", Description: "IT HAS AT LEAST 1 ROWS", Status: engine.TestWarning, Message: "assertion failed after all 0 rows were read"},
+}
+
+func TestJUnitReport(t *testing.T) {
+	Convey("Given test results", t, func() {
+		var buf bytes.Buffer
+		So(writeJUnitReport(&buf, reportResults), ShouldBeNil)
+		Convey("The JUnit report should have one suite per block, sorted by name", func() {
+			var report junitTestSuites
+			So(xml.Unmarshal(buf.Bytes(), &report), ShouldBeNil)
+			So(report.Tests, ShouldEqual, 4)
+			So(report.Failures, ShouldEqual, 1)
+			So(report.Skipped, ShouldEqual, 1)
+			So(report.Suites, ShouldHaveLength, 2)
+			So(report.Suites[0].Name, ShouldEqual, "Customers")
+			So(report.Suites[1].Name, ShouldEqual, "Orders")
+			So(report.Suites[1].Tests, ShouldEqual, 3)
+			So(report.Suites[1].Failures, ShouldEqual, 1)
+			So(report.Suites[1].Skipped, ShouldEqual, 1)
+		})
+		Convey("Failures, skipped assertions and warnings should be reported as such", func() {
+			var report junitTestSuites
+			So(xml.Unmarshal(buf.Bytes(), &report), ShouldBeNil)
+			warning, passed, failed, skipped := report.Suites[0].Cases[0], report.Suites[1].Cases[0], report.Suites[1].Cases[1], report.Suites[1].Cases[2]
+			So(passed.Failure, ShouldBeNil)
+			So(passed.Skipped, ShouldBeNil)
+			So(failed.ClassName, ShouldEqual, "Orders")
+			So(failed.Failure.Message, ShouldEqual, "assertion failed on row 2")
+			So(failed.Failure.Content, ShouldEqual, "Assertion: COLUMN Amount HAS NO NULL VALUES\nRow: {\"Amount\":null,\"Id\":2}")
+			So(skipped.Failure, ShouldBeNil)
+			So(skipped.Skipped.Message, ShouldEqual, "not evaluated because Orders, assertion 2 failed")
+			So(warning.Failure, ShouldBeNil)
+			So(warning.SystemErr, ShouldStartWith, "Warning: assertion failed after all 0 rows were read")
+		})
+		Convey("Skipped assertions should be written as skipped elements", func() {
+			So(buf.String(), ShouldContainSubstring, `<skipped message="not evaluated because Orders, assertion 2 failed"></skipped>`)
+		})
+	})
+}
+
+func TestJSONReport(t *testing.T) {
+	Convey("Given test results", t, func() {
+		var buf bytes.Buffer
+		So(writeJSONReport(&buf, reportResults), ShouldBeNil)
+		Convey("The JSON report should have one object per assertion, in the order that they were logged", func() {
+			var report []map[string]interface{}
+			So(json.Unmarshal(buf.Bytes(), &report), ShouldBeNil)
+			So(report, ShouldHaveLength, 4)
+			So(report[0], ShouldResemble, map[string]interface{}{
+				"block":     "Orders",
+				"name":      "Orders, assertion 1",
+				"assertion": "COLUMN Id IS UNIQUE",
+				"status":    "PASS",
+			})
+			So(report[1]["status"], ShouldEqual, "FAIL")
+			So(report[1]["row"], ShouldResemble, map[string]interface{}{"Id": 2.0, "Amount": nil})
+			So(report[2]["status"], ShouldEqual, "SKIP")
+			So(report[2]["message"], ShouldEqual, "not evaluated because Orders, assertion 2 failed")
+			So(report[3]["status"], ShouldEqual, "WARN")
+		})
+	})
+	Convey("Given no test results", t, func() {
+		var buf bytes.Buffer
+		So(writeJSONReport(&buf, nil), ShouldBeNil)
+		Convey("The JSON report should be an empty array", func() {
+			So(buf.String(), ShouldEqual, "[]\n")
+		})
+	})
+}
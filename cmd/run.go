@@ -7,6 +7,7 @@ import (
 	"github.com/michaelbironneau/analyst/engine"
 	"github.com/urfave/cli"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -46,10 +47,29 @@ func Run(c *cli.Context) error {
 
 	l := engine.NewConsoleLogger(lev)
 
-	err = analyst.ExecuteFile(scriptFile, &analyst.RuntimeOptions{opts, l, nil, nil, filepath.Dir(scriptFile)})
+	err = analyst.ExecuteFile(scriptFile, &analyst.RuntimeOptions{
+		Options:         opts,
+		Logger:          l,
+		ScriptDirectory: filepath.Dir(scriptFile),
+		Only:            selectors(c.String("only")),
+		From:            selectors(c.String("from")),
+		To:              selectors(c.String("to")),
+		GlobalDatabase:  c.String("global-db"),
+	})
 	time.Sleep(time.Millisecond * 1500) //give loggers time to flush
 	if err != nil {
 		fmt.Printf("Error: %s\n", err)
 	}
 	return err
 }
+
+//selectors splits a comma-separated list of block selectors.
+func selectors(s string) []string {
+	var ret []string
+	for _, selector := range strings.Split(s, ",") {
+		if selector = strings.TrimSpace(selector); selector != "" {
+			ret = append(ret, selector)
+		}
+	}
+	return ret
+}
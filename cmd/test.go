@@ -6,7 +6,10 @@ import (
 	"github.com/michaelbironneau/analyst/aql"
 	"github.com/michaelbironneau/analyst/engine"
 	"github.com/urfave/cli"
+	"io"
+	"os"
 	"path/filepath"
+	"strings"
 )
 
 func Test(c *cli.Context) error {
@@ -40,12 +43,56 @@ func Test(c *cli.Context) error {
 		lev = engine.Trace
 	}
 
-	l := engine.NewConsoleLogger(lev)
+	format := c.String("report")
 
-	err = analyst.TestFile(scriptFile, &analyst.RuntimeOptions{Options: opts, Logger: l, ScriptDirectory: filepath.Dir(scriptFile)})
+	if len(format) == 0 && len(c.String("report-file")) > 0 {
+		return fmt.Errorf("report format not set")
+	}
+
+	//if the report is written to STDOUT, everything else goes to STDERR so that the report can be piped
+	var console io.Writer = os.Stdout
+	if len(format) > 0 && len(c.String("report-file")) == 0 {
+		console = os.Stderr
+	}
+
+	var l engine.Logger = engine.NewConsoleLoggerTo(lev, console)
+
+	if len(format) > 0 {
+		format = strings.ToLower(format)
+		if format != reportJUnit && format != reportJSON {
+			return fmt.Errorf("unknown report format %s, expected %s or %s", format, reportJUnit, reportJSON)
+		}
+		l = engine.NewTestResultLogger(l)
+	}
+
+	var hooks []interface{}
+
+	if c.Bool("update-snapshots") {
+		hooks = append(hooks, analyst.UpdateSnapshots)
+	}
+
+	err = analyst.TestFile(scriptFile, &analyst.RuntimeOptions{
+		Options:         opts,
+		Logger:          l,
+		Hooks:           hooks,
+		ScriptDirectory: filepath.Dir(scriptFile),
+		Only:            selectors(c.String("only")),
+		From:            selectors(c.String("from")),
+		To:              selectors(c.String("to")),
+		GlobalDatabase:  c.String("global-db"),
+	})
+
+	if rl, ok := l.(*engine.TestResultLogger); ok {
+		if reportErr := writeReport(format, c.String("report-file"), rl.Results()); reportErr != nil {
+			fmt.Fprintf(console, "Error writing report: %s\n", reportErr)
+			if err == nil {
+				err = reportErr
+			}
+		}
+	}
 
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		fmt.Fprintf(console, "Error: %s\n", err)
 	}
 	return err
 }
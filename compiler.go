@@ -9,6 +9,7 @@ import (
 	"github.com/michaelbironneau/analyst/engine"
 	"github.com/michaelbironneau/analyst/plugins"
 	builtins "github.com/michaelbironneau/analyst/transforms"
+	"sort"
 	"strings"
 	"time"
 	"reflect"
@@ -22,12 +23,30 @@ const (
 	sqlSelectAll          = "SELECT * FROM %s"
 )
 
+//Values of the MODE and SEVERITY options of TEST blocks.
+const (
+	TestModeTest      = "test"
+	TestModeAlways    = "always"
+	TestSeverityError = "error"
+	TestSeverityWarn  = "warn"
+)
+
+//RuntimeOptions are the options that a job runs with. Fields are added to it as features are, so literals
+//must name the fields that they set (eg. &RuntimeOptions{Logger: l}) - positional literals will not compile
+//after the next such addition, as they stopped doing when Only, From, To and GlobalDatabase were added.
 type RuntimeOptions struct {
 	Options         []aql.Option
 	Logger          engine.Logger
 	Hooks           []interface{}
 	Context         context.Context
 	ScriptDirectory string
+	//Only, From and To select the blocks to run, by name or glob. See selectBlocks.
+	Only []string
+	From []string
+	To   []string
+	//GlobalDatabase is the path of an SQLite database that the GLOBAL tables are saved to after the job
+	//runs, and that the GLOBAL tables are loaded from when only some of the blocks are selected.
+	GlobalDatabase string
 }
 
 //  neutralizeExecs is a source hook to prevent side effects with execs whilst in test mode
@@ -40,6 +59,10 @@ func neutralizeExecs(name string, src engine.Source) (engine.Source, error) {
 
 //  neutralizeDestination replaces a given destination by a DevNull destination
 func neutralizeDestinations(name string, dest engine.Destination) (engine.Destination, error) {
+	if _, ok := dest.(*engine.SnapshotDestination); ok {
+		//snapshot tests don't have side effects, unless snapshots are being updated
+		return nil, nil
+	}
 	val := reflect.ValueOf(dest).Elem().FieldByName("Alias")
 	if val.Kind() == reflect.String && val.String() != "" {
 		return &engine.DevNull{Name: val.String()}, nil
@@ -94,7 +117,10 @@ func checkWrapLogger(l engine.Logger, options []aql.Option) (engine.Logger, erro
 
 }
 
-func execute(js *aql.JobScript, options []aql.Option, lg engine.Logger, compileOnly bool, hooks []interface{}, ctx context.Context, cwd string, runTests bool) error {
+//execute compiles and, unless compileOnly is true, executes the job. If beforeCompile is not nil, it is
+//called with the DAG once all the blocks have been added to it, eg. to add or remove nodes. Unless compileOnly
+//is true, globals must be a connection to the GLOBAL database that the caller holds until the job has run.
+func execute(js *aql.JobScript, options []aql.Option, lg engine.Logger, compileOnly bool, hooks []interface{}, ctx context.Context, cwd string, runTests bool, globals *globalDB, beforeCompile func(engine.Coordinator) error) error {
 	logger := lg
 	options = mergeOptions(js, options)
 
@@ -133,9 +159,9 @@ func execute(js *aql.JobScript, options []aql.Option, lg engine.Logger, compileO
 		return fmt.Errorf("error resolving external content: %v", err)
 	}
 
-	err = js.EvaluateParametrizedContent(options)
+	err = guards(js, options, logger)
 	if err != nil {
-		return fmt.Errorf("error evaluating parametrized content: %v", err)
+		return err
 	}
 
 	connMap, err := connectionMap(js)
@@ -143,6 +169,42 @@ func execute(js *aql.JobScript, options []aql.Option, lg engine.Logger, compileO
 		return fmt.Errorf("error parsing connections: %v", err)
 	}
 
+	err = references(js, connMap)
+	if err != nil {
+		return err
+	}
+
+	//options are checked before they are substituted into content, so that the options that it refers to are known
+	err = checkOptions(js, connMap, logger)
+	if err != nil {
+		return err
+	}
+
+	err = js.EvaluateParametrizedContent(options)
+	if err != nil {
+		return fmt.Errorf("error evaluating parametrized content: %v", err)
+	}
+
+	if runTests {
+		//Mocks are only used in test mode, so that tests don't need live databases
+		cleanup, err := mockConnections(js, connMap)
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+		mockHook, err := mockBlocks(js, connMap)
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, mockHook)
+	}
+
+	//ON SUCCESS and ON FAILURE blocks run after the job, as jobs of their own
+	onSuccess, onFailure, err := handlerScripts(js)
+	if err != nil {
+		return err
+	}
+
 	txManager, err := txManager(logger, connMap)
 
 	if err != nil {
@@ -161,7 +223,7 @@ func execute(js *aql.JobScript, options []aql.Option, lg engine.Logger, compileO
 	}
 
 	if !compileOnly {
-		err = globalInit(js)
+		err = globalInit(js, globals)
 
 		if err != nil {
 			return err
@@ -174,7 +236,7 @@ func execute(js *aql.JobScript, options []aql.Option, lg engine.Logger, compileO
 		return err
 	}
 
-	err = transforms(js, dag, connMap, options, txManager)
+	err = transforms(js, dag, connMap, params, options, txManager)
 
 	if err != nil {
 		return err
@@ -187,13 +249,11 @@ func execute(js *aql.JobScript, options []aql.Option, lg engine.Logger, compileO
 	}
 
 
-	if runTests {
-		//Only run tests in test mode - in production mode they could slow things down too much
-		err = tests(js, dag)
+	//In production mode, only run the tests that opt in - the others could slow things down too much
+	err = tests(js, dag, runTests)
 
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return err
 	}
 
 
@@ -209,6 +269,24 @@ func execute(js *aql.JobScript, options []aql.Option, lg engine.Logger, compileO
 		return err
 	}
 
+	for outcome, s := range []*aql.JobScript{engine.OnSuccess: onSuccess, engine.OnFailure: onFailure} {
+		h, err := handler(s, logger, hooks, connMap, params, options, runTests)
+		if err != nil {
+			return err
+		}
+		if h != nil {
+			dag.AddHandler(engine.Outcome(outcome), h)
+		}
+	}
+
+	if beforeCompile != nil {
+		err = beforeCompile(dag)
+
+		if err != nil {
+			return err
+		}
+	}
+
 	err = dag.Compile()
 
 	if err != nil {
@@ -275,7 +353,16 @@ func ExecuteString(script string, opts *RuntimeOptions) error {
 	if err != nil {
 		return err
 	}
-	return execute(js, opts.Options, opts.Logger, false, opts.Hooks, opts.Context, opts.ScriptDirectory, false)
+	globals, err := openGlobals()
+	if err != nil {
+		return err
+	}
+	defer globals.Close()
+	err = execute(js, opts.Options, opts.Logger, false, opts.Hooks, opts.Context, opts.ScriptDirectory, false, globals, selectBlocks(js, opts, globals))
+	if err != nil {
+		return err
+	}
+	return globals.save(opts.GlobalDatabase)
 }
 
 func TestString(script string, opts *RuntimeOptions) error {
@@ -286,8 +373,13 @@ func TestString(script string, opts *RuntimeOptions) error {
 	if err != nil {
 		return err
 	}
+	globals, err := openGlobals()
+	if err != nil {
+		return err
+	}
+	defer globals.Close()
 	hooks := append(opts.Hooks, engine.DestinationHook(neutralizeDestinations), engine.SourceHook(neutralizeExecs))
-	return execute(js, opts.Options, opts.Logger, false, hooks, opts.Context, opts.ScriptDirectory, true)
+	return execute(js, opts.Options, opts.Logger, false, hooks, opts.Context, opts.ScriptDirectory, true, globals, selectBlocks(js, opts, globals))
 }
 
 func TestFile(filename string, opts *RuntimeOptions) error {
@@ -298,8 +390,13 @@ func TestFile(filename string, opts *RuntimeOptions) error {
 	if err != nil {
 		return err
 	}
+	globals, err := openGlobals()
+	if err != nil {
+		return err
+	}
+	defer globals.Close()
 	hooks := append(opts.Hooks, engine.DestinationHook(neutralizeDestinations), engine.SourceHook(neutralizeExecs))
-	return execute(js, opts.Options, opts.Logger, false, hooks, opts.Context, opts.ScriptDirectory, true)
+	return execute(js, opts.Options, opts.Logger, false, hooks, opts.Context, opts.ScriptDirectory, true, globals, selectBlocks(js, opts, globals))
 }
 
 
@@ -311,7 +408,16 @@ func ExecuteFile(filename string, opts *RuntimeOptions) error {
 	if err != nil {
 		return err
 	}
-	return execute(js, opts.Options, opts.Logger, false, opts.Hooks, opts.Context, opts.ScriptDirectory, false)
+	globals, err := openGlobals()
+	if err != nil {
+		return err
+	}
+	defer globals.Close()
+	err = execute(js, opts.Options, opts.Logger, false, opts.Hooks, opts.Context, opts.ScriptDirectory, false, globals, selectBlocks(js, opts, globals))
+	if err != nil {
+		return err
+	}
+	return globals.save(opts.GlobalDatabase)
 }
 
 func ValidateString(script string, opts *RuntimeOptions) error {
@@ -322,7 +428,7 @@ func ValidateString(script string, opts *RuntimeOptions) error {
 	if err != nil {
 		return err
 	}
-	return execute(js, opts.Options, opts.Logger, true, opts.Hooks, opts.Context, opts.ScriptDirectory, false)
+	return execute(js, opts.Options, opts.Logger, true, opts.Hooks, opts.Context, opts.ScriptDirectory, false, nil, nil)
 }
 
 func ValidateFile(filename string, opts *RuntimeOptions) error {
@@ -333,7 +439,7 @@ func ValidateFile(filename string, opts *RuntimeOptions) error {
 	if err != nil {
 		return err
 	}
-	return execute(js, opts.Options, opts.Logger, true, opts.Hooks, opts.Context, opts.ScriptDirectory, false)
+	return execute(js, opts.Options, opts.Logger, true, opts.Hooks, opts.Context, opts.ScriptDirectory, false, nil, nil)
 }
 
 func declarations(js *aql.JobScript, p *engine.ParameterTable) error {
@@ -349,16 +455,11 @@ func declarations(js *aql.JobScript, p *engine.ParameterTable) error {
 //Any valid SQL can be used to initialize the database.
 //Currently, the GLOBAL database must live in-memory. In future releases
 //the SET [OPTION_NAME] [OPTION_VALUE] syntax will be available to configure this.
-func globalInit(js *aql.JobScript) error {
-	db, err := sql.Open(globalDbDriver, globalDbConnString)
-	if err != nil {
-		return err
-	}
-
+func globalInit(js *aql.JobScript, globals *globalDB) error {
 	for _, block := range js.Globals {
-		_, err := db.Exec(block.Content)
+		_, err := globals.conn.ExecContext(context.Background(), block.Content)
 		if err != nil {
-			return fmt.Errorf("error initializing GLOBAL with block %s: %v", block.Name, err)
+			return aql.Errorf(block.Position, "error initializing GLOBAL with block %s: %v", block.Name, err)
 		}
 	}
 
@@ -403,6 +504,29 @@ func constraints(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*
 	return nil
 }
 
+//parameterDependencies returns the queries that set the given parameters, which the transform reads when it is
+//opened, and that the transform does not already run AFTER.
+func parameterDependencies(js *aql.JobScript, transform *aql.Transform, parameters []string) []string {
+	after := make(map[string]bool)
+	for _, dep := range transform.Dependencies {
+		after[strings.ToLower(dep)] = true
+	}
+	var ret []string
+	for _, query := range js.Queries {
+		for _, dest := range query.Destinations {
+			for _, v := range dest.Variables {
+				for _, param := range parameters {
+					if strings.ToLower(v) == strings.ToLower(param) && !after[strings.ToLower(query.Name)] {
+						after[strings.ToLower(query.Name)] = true
+						ret = append(ret, query.Name)
+					}
+				}
+			}
+		}
+	}
+	return ret
+}
+
 //scripts makes engine.Transforms out of JobScript scripts.
 //Script sources/destinations are not yet supported. We can have:
 //  [NOT YET IMPLEMENTED] script source    -> GLOBAL
@@ -414,8 +538,8 @@ func constraints(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*
 //  [NOT YET IMPLEMENTED] script transform -> script destination
 //  script transform -> GLOBAL destination
 //  script transform -> SQL destination
-func transforms(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*aql.Connection, globalOptions []aql.Option, txManager engine.TransactionManager) error {
-	for _, transform := range js.Transforms {
+func transforms(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*aql.Connection, params *engine.ParameterTable, globalOptions []aql.Option, txManager engine.TransactionManager) error {
+	for i, transform := range js.Transforms {
 
 		var (
 			plugin engine.SequenceableTransform
@@ -425,8 +549,27 @@ func transforms(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*a
 		if !transform.Plugin {
 			plugin, err = builtins.Parse(transform.Content)
 			if err != nil {
+				return aql.Errorf(transform.Position, "TRANSFORM %s: %v", transform.Name, err)
+			}
+			//built-ins can expose options through "aql" struct tags, eg. SORT_BUFFER_ROWS
+			scan := aql.OptionScanner(transform.Name, "", transform.Options, globalOptions)
+			maybeScan := aql.MaybeOptionScanner(transform.Name, "", transform.Options, globalOptions)
+			if err := aql.ScanOptions(scan, maybeScan, plugin); err != nil {
 				return err
 			}
+			if c, ok := plugin.(engine.CheckedTransform); ok {
+				if err := c.CheckOptions(); err != nil {
+					return aql.Errorf(transform.Position, "TRANSFORM %s: %v", transform.Name, err)
+				}
+			}
+			//built-ins can also read declared parameters at runtime, eg. the salt for MASK
+			if p, ok := plugin.(engine.ParametrizedTransform); ok {
+				if err := p.SetParameterTable(params); err != nil {
+					return aql.Errorf(transform.Position, "TRANSFORM %s: %v", transform.Name, err)
+				}
+				//the constraints are added with the AFTER constraints, once the parameter destinations exist
+				js.Transforms[i].Dependencies = append(js.Transforms[i].Dependencies, parameterDependencies(js, &transform, p.Parameters())...)
+			}
 			err = dag.AddTransform(strings.ToLower(transform.Name), strings.ToLower(transform.Name), plugin)
 			plugin.SetName(strings.ToLower(transform.Name))
 		} else {
@@ -454,14 +597,17 @@ func transforms(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*a
 				connectionAlias = alias(*source, nil)
 				tableOpt, ok := aql.FindOverridableOption("TABLE", connectionAlias, transform.Options)
 
+				if opt, misspelled := aql.FindMisspelledOption("TABLE", transform.Options); !ok && misspelled {
+					return aql.Errorf(opt.Position, "expected TABLE option for %s in the TRANSFORM %s options (is %s misspelled?)", connectionAlias, transform.Name, opt.Key)
+				}
 				if !ok {
-					return fmt.Errorf("expected TABLE option for %s in the TRANSFORM %s options", connectionAlias, transform.Name)
+					return aql.Errorf(transform.Position, "expected TABLE option for %s in the TRANSFORM %s options", connectionAlias, transform.Name)
 				}
 
 				sourceTable, ok = tableOpt.String()
 
 				if !ok {
-					return fmt.Errorf("expected TABLE option to be a STRING for %s source in TRANSFORM %s", connectionAlias, transform.Name)
+					return aql.Errorf(tableOpt.Position, "expected TABLE option to be a STRING for %s source in TRANSFORM %s", connectionAlias, transform.Name)
 				}
 			}
 
@@ -486,7 +632,7 @@ func transforms(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*a
 
 			if source.Database != nil {
 				if connMap[strings.ToLower(*source.Database)] == nil {
-					return fmt.Errorf("could not find connection %s for TRANSFORM %s", *source.Database, transform.Name)
+					return aql.Errorf(transform.Position, "could not find connection %s for TRANSFORM %s%s", *source.Database, transform.Name, aql.DidYouMean(*source.Database, connectionNames(connMap)))
 				}
 				conn := connMap[strings.ToLower(*source.Database)]
 
@@ -578,17 +724,43 @@ func transforms(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*a
 }
 
 func createDataBlock(js *aql.JobScript, dag engine.Coordinator, dataBlock *aql.Data, source *aql.SourceSink) error {
+	ls, err := literalSource(dataBlock.Name, dataBlock.Position, dataBlock.Content, dataBlock.Options)
+
+	if err != nil {
+		return err
+	}
+
+	if source == nil {
+		return dag.AddSource(strings.ToLower(dataBlock.Name), strings.ToLower(dataBlock.Name), ls)
+	}
+	if source.Alias != nil {
+		ls.SetName(*source.Alias)
+		err = dag.AddSource(strings.ToLower(*source.Block), *source.Alias, ls)
+	} else {
+		ls.SetName(strings.ToLower(dataBlock.Name))
+		err = dag.AddSource(strings.ToLower(*source.Block), *source.Block, ls)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//literalSource makes a literal source out of the content of a data block, using the COLUMNS and FORMAT options.
+func literalSource(name string, pos aql.Position, content string, options []aql.Option) (*engine.LiteralSource, error) {
 	var columns []string
 
-	colsOpt, ok := aql.FindOption(dataBlock.Options, "COLUMNS")
+	colsOpt, ok := aql.FindOption(options, "COLUMNS")
 
 	if !ok {
-		return fmt.Errorf("expected COLUMNS option for data block %s", dataBlock.Name)
+		return nil, aql.Errorf(pos, "expected COLUMNS option for data block %s", name)
 	}
 
 	cols, ok2 := colsOpt.String()
 	if !ok2 {
-		return fmt.Errorf("expected COLUMNS option to be a STRING for data block %s", dataBlock.Name)
+		return nil, aql.Errorf(colsOpt.Position, "expected COLUMNS option to be a STRING for data block %s", name)
 	}
 	columns = strings.Split(cols, ",")
 	for i := range columns {
@@ -597,7 +769,7 @@ func createDataBlock(js *aql.JobScript, dag engine.Coordinator, dataBlock *aql.D
 
 	var dataFormat engine.LiteralSourceFormat
 
-	format, ok := aql.FindOption(dataBlock.Options, "FORMAT")
+	format, ok := aql.FindOption(options, "FORMAT")
 
 	if !ok {
 		dataFormat = engine.JSONArray
@@ -605,40 +777,22 @@ func createDataBlock(js *aql.JobScript, dag engine.Coordinator, dataBlock *aql.D
 		fStr, ok2 := format.String()
 
 		if !ok2 {
-			return fmt.Errorf("expected FORMAT option to be a STRING in data block %s", dataBlock.Name)
+			return nil, aql.Errorf(format.Position, "expected FORMAT option to be a STRING in data block %s", name)
 		}
 
 		f, ok := engine.LiteralSourceFormats[strings.ToUpper(fStr)]
 		if !ok {
-			return fmt.Errorf("expected FORMAT option to be one of JSON_ARRAY, JSON_OBJECTS or CSV but got %v", format)
+			return nil, aql.Errorf(format.Position, "expected FORMAT option to be one of JSON_ARRAY, JSON_OBJECTS or CSV but got %v", fStr)
 		}
 		dataFormat = f
 	}
 
-	//create new literal source before attempting to connect
-	ls := engine.LiteralSource{
-		Name:    strings.ToLower(dataBlock.Name),
-		Content: dataBlock.Content,
+	return &engine.LiteralSource{
+		Name:    strings.ToLower(name),
+		Content: content,
 		Columns: columns,
 		Format:  dataFormat,
-	}
-	var err error
-	if source == nil {
-		return dag.AddSource(strings.ToLower(dataBlock.Name), strings.ToLower(dataBlock.Name), &ls)
-	}
-	if source.Alias != nil {
-		ls.SetName(*source.Alias)
-		err = dag.AddSource(strings.ToLower(*source.Block), *source.Alias, &ls)
-	} else {
-		ls.SetName(strings.ToLower(dataBlock.Name))
-		err = dag.AddSource(strings.ToLower(*source.Block), *source.Block, &ls)
-	}
-
-	if err != nil {
-		return err
-	}
-
-	return nil
+	}, nil
 }
 
 //findDataBlock attempts to find the data block with the given name, if it exists.
@@ -661,7 +815,7 @@ func sequenceSources(transform engine.SequenceableTransform, block aql.Block, so
 		seqStr, ok2 := seq.String()
 
 		if !ok2 {
-			return fmt.Errorf("expected MULTISOURCE_ORDER option to be a string in transform %s", block.GetName())
+			return aql.Errorf(seq.Position, "expected MULTISOURCE_ORDER option to be a string in transform %s", block.GetName())
 		}
 		switch strings.ToUpper(seqStr) {
 		case "PARALLEL":
@@ -669,7 +823,7 @@ func sequenceSources(transform engine.SequenceableTransform, block aql.Block, so
 		case "SEQUENTIAL":
 			sequence = true
 		default:
-			return fmt.Errorf("expected MULTISOURCE_ORDER	 to be PARALLEL or SEQUENTIAL in transform %s but got '%s'", block.GetName(), seqStr)
+			return aql.Errorf(seq.Position, "expected MULTISOURCE_ORDER	 to be PARALLEL or SEQUENTIAL in transform %s but got '%s'", block.GetName(), seqStr)
 
 		}
 
@@ -714,7 +868,7 @@ func addPlugin(js *aql.JobScript, dag engine.Coordinator, transform aql.Transfor
 
 	if ok {
 		if err := json.Unmarshal([]byte(argStr), &argList); err != nil {
-			return nil, fmt.Errorf("error parsing JSON for ARGS option in transform %s: %v", transform.Name, err)
+			return nil, aql.Errorf(transform.Position, "error parsing JSON for ARGS option in transform %s: %v", transform.Name, err)
 		}
 	}
 
@@ -736,12 +890,14 @@ func addPlugin(js *aql.JobScript, dag engine.Coordinator, transform aql.Transfor
 	return &s, nil
 }
 
-func toCondition(assertion aql.Assertion) (engine.Condition, error){
+func toCondition(assertion aql.Assertion, refs map[string]*engine.ReferenceSet) (engine.Condition, error){
 	switch {
 	case assertion.Global != nil:
 		switch {
 		case assertion.Global.Expr != nil:
 			return engine.NewSQLCondition(*assertion.Global.Expr)
+		case assertion.Global.Where != nil:
+			return engine.NewSQLCondition(*assertion.Global.Where)
 		case assertion.Global.NRows != nil:
 			if assertion.Global.NRows.AtLeast {
 				return engine.HasAtLeastNRowsCondition(assertion.Global.NRows.N)
@@ -755,19 +911,42 @@ func toCondition(assertion aql.Assertion) (engine.Condition, error){
 			panic("unmapped global assertion")
 		}
 	case assertion.Column != nil:
+		col := *assertion.Column.TargetColumn
 		switch {
-		case assertion.Column.Distinct != nil :
-			if assertion.Column.Distinct.AtLeast {
-				return engine.HasAtLeastNDistinctValuesCondition(*assertion.Column.TargetColumn, assertion.Column.Distinct.N)
-			} else if assertion.Column.Distinct.AtMost {
-				return engine.HasAtMostNDistinctValuesCondition(*assertion.Column.TargetColumn, assertion.Column.Distinct.N)
+		case assertion.Column.Count != nil && assertion.Column.Distinct:
+			if assertion.Column.Count.AtLeast {
+				return engine.HasAtLeastNDistinctValuesCondition(col, assertion.Column.Count.N)
+			} else if assertion.Column.Count.AtMost {
+				return engine.HasAtMostNDistinctValuesCondition(col, assertion.Column.Count.N)
 			} else {
-				return engine.HasExactlyNDistinctValuesCondition(*assertion.Column.TargetColumn, assertion.Column.Distinct.N)
+				return engine.HasExactlyNDistinctValuesCondition(col, assertion.Column.Count.N)
 			}
+		case assertion.Column.Count != nil && assertion.Column.PercentNulls:
+			if assertion.Column.Count.AtLeast {
+				return engine.HasAtLeastNPercentNullValuesCondition(col, assertion.Column.Count.N)
+			} else if assertion.Column.Count.AtMost {
+				return engine.HasAtMostNPercentNullValuesCondition(col, assertion.Column.Count.N)
+			}
+			return nil, fmt.Errorf("the percentage of null values of column %s should be AT LEAST or AT MOST a value", col)
 		case assertion.Column.NoDuplicates :
-			return engine.HasNoDuplicates(*assertion.Column.TargetColumn)
+			return engine.HasNoDuplicates(col)
 		case assertion.Column.NoNulls:
-			return engine.HasNoNullValues(*assertion.Column.TargetColumn)
+			return engine.HasNoNullValues(col)
+		case len(assertion.Column.Between) == 2:
+			return engine.HasValuesBetweenCondition(col, assertion.Column.Between[0].Text(), assertion.Column.Between[1].Text())
+		case len(assertion.Column.In) > 0:
+			var values []string
+			for i := range assertion.Column.In {
+				values = append(values, assertion.Column.In[i].Text())
+			}
+			return engine.HasValuesInCondition(col, values)
+		case assertion.Column.Type != nil:
+			return engine.HasTypeCondition(col, *assertion.Column.Type)
+		case assertion.Column.Matches != nil:
+			return engine.HasValuesMatchingCondition(col, *assertion.Column.Matches)
+		case assertion.Column.References != nil:
+			ref := assertion.Column.References
+			return engine.ReferencesCondition(col, refs[referenceKey(ref)])
 		default:
 			panic("unmapped column assertion")
 		}
@@ -777,21 +956,118 @@ func toCondition(assertion aql.Assertion) (engine.Condition, error){
 
 }
 
-//  tests parses the AQL assertions and maps them to engine.Conditions. These are then
-//  added to the DAG. These will be ignored if the job is not in test mode.
-func tests(js *aql.JobScript, dag engine.Coordinator) error {
+//referenceKey identifies the values of a column of a block, that other blocks reference.
+func referenceKey(ref *aql.Reference) string {
+	return strings.ToLower(ref.Block) + "." + strings.ToLower(ref.Column)
+}
 
-	for tNumber, t := range js.Tests {
-		assertions, err := t.Parse()
+//testSeverity returns the severity of the test block from its MODE and SEVERITY options, and
+//whether it should run. Tests run in test mode, and also in production if their MODE is 'always'.
+func testSeverity(t *aql.Test, testMode bool) (engine.TestSeverity, bool, error) {
+	var (
+		mode     = TestModeTest
+		severity = TestSeverityError
+	)
+	if opt, ok := aql.FindOption(t.Options, "MODE"); ok {
+		s, ok := opt.String()
+		if !ok {
+			return engine.SeverityError, false, aql.Errorf(opt.Position, "the MODE option of the test of %s should be a string", t.TargetBlock)
+		}
+		mode = strings.ToLower(s)
+	}
+	if opt, ok := aql.FindOption(t.Options, "SEVERITY"); ok {
+		s, ok := opt.String()
+		if !ok {
+			return engine.SeverityError, false, aql.Errorf(opt.Position, "the SEVERITY option of the test of %s should be a string", t.TargetBlock)
+		}
+		severity = strings.ToLower(s)
+	}
+	if mode != TestModeTest && mode != TestModeAlways {
+		return engine.SeverityError, false, aql.Errorf(t.Position, "the MODE option of the test of %s should be '%s' or '%s' but got '%s'", t.TargetBlock, TestModeTest, TestModeAlways, mode)
+	}
+	switch severity {
+	case TestSeverityError:
+		return engine.SeverityError, testMode || mode == TestModeAlways, nil
+	case TestSeverityWarn:
+		return engine.SeverityWarning, testMode || mode == TestModeAlways, nil
+	}
+	return engine.SeverityError, false, aql.Errorf(t.Position, "the SEVERITY option of the test of %s should be '%s' or '%s' but got '%s'", t.TargetBlock, TestSeverityWarn, TestSeverityError, severity)
+}
+
+//  tests parses the AQL assertions and maps them to engine.Conditions. These are then
+//  added to the DAG. Outside of test mode, only the tests with MODE = 'always' are added,
+//  and snapshot tests are never added.
+//  The values of referenced blocks are collected by unnamed tests, which are added first
+//  so that they are complete by the time the referencing tests need them.
+func tests(js *aql.JobScript, dag engine.Coordinator, testMode bool) error {
+	var (
+		parsed     [][]aql.Assertion
+		severities []engine.TestSeverity
+	)
+	refs := make(map[string]*engine.ReferenceSet)
+	snapshots := make(map[string]int)
+
+	for i := range js.Tests {
+		if js.Tests[i].Snapshot != nil {
+			//snapshots are only compared in test mode, as production output is expected to change
+			parsed = append(parsed, nil)
+			severities = append(severities, engine.SeverityError)
+			if !testMode {
+				continue
+			}
+			if err := snapshot(&js.Tests[i], dag, snapshots[strings.ToLower(js.Tests[i].TargetBlock)]); err != nil {
+				return err
+			}
+			snapshots[strings.ToLower(js.Tests[i].TargetBlock)]++
+			continue
+		}
+		severity, run, err := testSeverity(&js.Tests[i], testMode)
 		if err != nil {
 			return err
 		}
+		severities = append(severities, severity)
+		if !run {
+			parsed = append(parsed, nil)
+			continue
+		}
+		assertions, err := js.Tests[i].Parse()
+		if err != nil {
+			return aql.Errorf(js.Tests[i].Position, "the test of %s is invalid: %v", js.Tests[i].TargetBlock, err)
+		}
+		parsed = append(parsed, assertions)
+		for j := range assertions {
+			if assertions[j].Column == nil || assertions[j].Column.References == nil {
+				continue
+			}
+			ref := assertions[j].Column.References
+			if refs[referenceKey(ref)] != nil {
+				continue
+			}
+			refs[referenceKey(ref)] = engine.NewReferenceSet()
+			c, err := refs[referenceKey(ref)].CollectCondition(ref.Column)
+			if err != nil {
+				return err
+			}
+			if err := dag.AddTest(strings.ToLower(ref.Block), "", "", c); err != nil {
+				return aql.Errorf(js.Tests[i].Position, "could not find referenced block %s: %v%s", ref.Block, err, aql.DidYouMean(ref.Block, blockNames(js)))
+			}
+		}
+	}
+
+	//assertions are numbered across all the tests of the same block
+	counts := make(map[string]int)
+	for tNumber, t := range js.Tests {
+		target := strings.ToLower(t.TargetBlock)
+		offset := counts[target]
+		statements := t.Statements()
+		counts[target] += len(statements)
+		assertions := parsed[tNumber]
 		for i := range assertions {
-			c, err := toCondition(assertions[i])
+			c, err := toCondition(assertions[i], refs)
 			if err != nil {
 				return err
 			}
-			if err := dag.AddTest(strings.ToLower(t.TargetBlock), assertionNodeName(tNumber,i), "", c); err != nil {
+			if err := dag.AddTestWithSeverity(target, assertionNodeName(t.TargetBlock, offset+i), statements[i], severities[tNumber], c); err != nil {
 				return err
 			}
 		}
@@ -799,9 +1075,8 @@ func tests(js *aql.JobScript, dag engine.Coordinator) error {
 	return nil
 }
 
-
-func assertionNodeName(testIndex, assertionIndex int) string {
-	return fmt.Sprintf("Test %d, assertion %d", testIndex+1, assertionIndex+1)
+func assertionNodeName(target string, assertionIndex int) string {
+	return fmt.Sprintf("%s, assertion %d", target, assertionIndex+1)
 }
 
 //sources makes engine.Source s out of JobScript sources.
@@ -819,7 +1094,7 @@ func sources(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*aql.
 	}
 	for _, exec := range js.Execs {
 		if len(exec.Destinations) > 0 {
-			return fmt.Errorf("execs are queries that returns no results, and thus cannot have destinations: %s", exec.Name)
+			return aql.Errorf(exec.Position, "execs are queries that returns no results, and thus cannot have destinations: %s", exec.Name)
 		}
 	}
 	var index = -1
@@ -827,10 +1102,10 @@ func sources(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*aql.
 		index++
 		execOnly := index >= len(js.Queries)
 		if len(query.Sources) != 1 {
-			return fmt.Errorf("queries must have exactly one source but %s has %v", query.Name, len(query.Sources))
+			return aql.Errorf(query.Position, "queries must have exactly one source but %s has %v", query.Name, len(query.Sources))
 		}
 		if query.Sources[0].Console {
-			return fmt.Errorf("console sources are not supported: %s", query.Name)
+			return aql.Errorf(query.Position, "console sources are not supported: %s", query.Name)
 		}
 		if query.Sources[0].Global {
 
@@ -850,10 +1125,10 @@ func sources(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*aql.
 			continue
 		}
 		if query.Sources[0].Database == nil {
-			return fmt.Errorf("at present only GLOBAL, SCRIPT and CONNECTION sources are supported for query %s", query.Name)
+			return aql.Errorf(query.Position, "at present only GLOBAL, SCRIPT and CONNECTION sources are supported for query %s", query.Name)
 		}
 		if connMap[strings.ToLower(*query.Sources[0].Database)] == nil {
-			return fmt.Errorf("could not find connection %s for query %s", *query.Sources[0].Database, query.Name)
+			return aql.Errorf(query.Position, "could not find connection %s for query %s%s", *query.Sources[0].Database, query.Name, aql.DidYouMean(*query.Sources[0].Database, connectionNames(connMap)))
 		}
 		conn := connMap[strings.ToLower(*query.Sources[0].Database)]
 		var autoSQL bool
@@ -1096,11 +1371,11 @@ func mandrillDest(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 	if len(senderStr) > 0 {
 		pr, err := engine.ParseEmailRecipients(senderStr)
 		if err != nil {
-			return fmt.Errorf("error parsing SENDER: %v", err)
+			return aql.Errorf(block.GetPosition(), "error parsing SENDER: %v", err)
 		}
 
 		if len(pr) > 1 {
-			return fmt.Errorf("there can only be one SENDER: %s", senderStr)
+			return aql.Errorf(block.GetPosition(), "there can only be one SENDER: %s", senderStr)
 		}
 
 		m.Sender = &pr[0]
@@ -1282,7 +1557,7 @@ func httpSource(js *aql.JobScript, dag engine.Coordinator, connMap map[string]*a
 		err = json.Unmarshal([]byte(headerStr), &headers)
 
 		if err != nil {
-			return fmt.Errorf("error parsing JSON for HEADERS option: %v", err)
+			return aql.Errorf(block.GetPosition(), "error parsing JSON for HEADERS option: %v", err)
 
 		}
 	}
@@ -1351,7 +1626,7 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 				} else if ok && strings.ToLower(outputFormat) == "table" {
 					outputJSON = false
 				} else if ok {
-					return fmt.Errorf("unknown OUTPUT_FORMAT value %s", outputFormat)
+					return aql.Errorf(query.Position, "unknown OUTPUT_FORMAT value %s", outputFormat)
 				}
 
 				d = &engine.ConsoleDestination{Name: name, FormatAsJSON: outputJSON}
@@ -1364,7 +1639,7 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 				continue
 			}
 			if dest.Block != nil {
-				return fmt.Errorf("BLOCK destinations are not allowed because they create non-deterministic source orders: %s", query.Name)
+				return aql.Errorf(query.Position, "BLOCK destinations are not allowed because they create non-deterministic source orders: %s", query.Name)
 			}
 
 			if dest.Global {
@@ -1374,7 +1649,7 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 				continue
 			}
 			if dest.Database != nil && connMap[strings.ToLower(*dest.Database)] == nil {
-				return fmt.Errorf("destination %s not found for query %s", *dest.Database, query.Name)
+				return aql.Errorf(query.Position, "destination %s not found for query %s%s", *dest.Database, query.Name, aql.DidYouMean(*dest.Database, connectionNames(connMap)))
 			}
 			conn := *connMap[strings.ToLower(*dest.Database)]
 			var err error
@@ -1394,7 +1669,7 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 	for _, transform := range js.Transforms {
 		for _, dest := range transform.Destinations {
 			if dest.Block != nil {
-				return fmt.Errorf("BLOCK destinations are not allowed because they create non-deterministic source orders: %s", transform.Name)
+				return aql.Errorf(transform.Position, "BLOCK destinations are not allowed because they create non-deterministic source orders: %s", transform.Name)
 			}
 
 			if dest.Global {
@@ -1428,7 +1703,7 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 				} else if ok && strings.ToLower(outputFormat) == "table" {
 					outputJSON = false
 				} else if ok {
-					return fmt.Errorf("unknown OUTPUT_FORMAT value %s", outputFormat)
+					return aql.Errorf(transform.Position, "unknown OUTPUT_FORMAT value %s", outputFormat)
 				}
 
 				d = &engine.ConsoleDestination{Name: name, FormatAsJSON: outputJSON}
@@ -1442,7 +1717,7 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 			}
 
 			if dest.Database != nil && connMap[strings.ToLower(*dest.Database)] == nil {
-				return fmt.Errorf("destination %s not found for query %s", *dest.Database, transform.Name)
+				return aql.Errorf(transform.Position, "destination %s not found for query %s%s", *dest.Database, transform.Name, aql.DidYouMean(*dest.Database, connectionNames(connMap)))
 			}
 			conn := *connMap[strings.ToLower(*dest.Database)]
 			var err error
@@ -1462,7 +1737,7 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 	for _, data := range js.Data {
 		for _, dest := range data.Destinations {
 			if dest.Block != nil {
-				return fmt.Errorf("BLOCK destinations are not allowed because they create non-deterministic source orders: %s", data.Name)
+				return aql.Errorf(data.Position, "BLOCK destinations are not allowed because they create non-deterministic source orders: %s", data.Name)
 			}
 
 			if dest.Global {
@@ -1496,7 +1771,7 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 				} else if ok && strings.ToLower(outputFormat) == "table" {
 					outputJSON = false
 				} else if ok {
-					return fmt.Errorf("unknown OUTPUT_FORMAT value %s", outputFormat)
+					return aql.Errorf(data.Position, "unknown OUTPUT_FORMAT value %s", outputFormat)
 				}
 
 				d = &engine.ConsoleDestination{Name: name, FormatAsJSON: outputJSON}
@@ -1510,7 +1785,7 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 			}
 
 			if dest.Database != nil && connMap[strings.ToLower(*dest.Database)] == nil {
-				return fmt.Errorf("destination %s not found for query %s", *dest.Database, data.Name)
+				return aql.Errorf(data.Position, "destination %s not found for query %s%s", *dest.Database, data.Name, aql.DidYouMean(*dest.Database, connectionNames(connMap)))
 			}
 			conn := *connMap[strings.ToLower(*dest.Database)]
 			var err error
@@ -1530,6 +1805,83 @@ func destinations(js *aql.JobScript, dag engine.Coordinator, connMap map[string]
 	return nil
 }
 
+//connectionNames returns the names of the connections, sorted so that suggestions are deterministic.
+func connectionNames(connMap map[string]*aql.Connection) []string {
+	var ret []string
+	for _, conn := range connMap {
+		ret = append(ret, conn.Name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+//references checks that the blocks and connections that blocks refer to exist, so that a misspelled name is
+//reported at the block that refers to it, along with the name that it was probably meant to be.
+func references(js *aql.JobScript, connMap map[string]*aql.Connection) error {
+	var (
+		blocks = blockNames(js)
+		conns  = connectionNames(connMap)
+		exists = make(map[string]bool)
+	)
+	for _, name := range blocks {
+		exists[strings.ToLower(name)] = true
+	}
+	block := func(pos aql.Position, kind, name, ref string) error {
+		if exists[strings.ToLower(ref)] {
+			return nil
+		}
+		return aql.Errorf(pos, "%s %s refers to block %s, which does not exist%s", kind, name, ref, aql.DidYouMean(ref, blocks))
+	}
+	sourceSinks := func(pos aql.Position, kind, name string, list []aql.SourceSink, after []string) error {
+		for _, ss := range list {
+			if ss.Database != nil && connMap[strings.ToLower(*ss.Database)] == nil {
+				return aql.Errorf(pos, "%s %s refers to connection %s, which does not exist%s", kind, name, *ss.Database, aql.DidYouMean(*ss.Database, conns))
+			}
+			if ss.Block != nil {
+				if err := block(pos, kind, name, *ss.Block); err != nil {
+					return err
+				}
+			}
+		}
+		for _, ref := range after {
+			if err := block(pos, kind, name, ref); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, q := range append(js.Queries, js.Execs...) {
+		kind := "QUERY"
+		if i >= len(js.Queries) {
+			kind = "EXEC"
+		}
+		list := append(append([]aql.SourceSink(nil), q.Sources...), q.Destinations...)
+		if err := sourceSinks(q.Position, kind, q.Name, list, q.Dependencies); err != nil {
+			return err
+		}
+	}
+	for _, t := range js.Transforms {
+		list := append([]aql.SourceSink(nil), t.Destinations...)
+		for _, source := range t.Sources {
+			list = append(list, *source)
+		}
+		if err := sourceSinks(t.Position, "TRANSFORM", t.Name, list, t.Dependencies); err != nil {
+			return err
+		}
+	}
+	for _, d := range js.Data {
+		if err := sourceSinks(d.Position, "DATA", d.Name, d.Destinations, nil); err != nil {
+			return err
+		}
+	}
+	for _, t := range js.Tests {
+		if !exists[strings.ToLower(t.TargetBlock)] {
+			return aql.Errorf(t.Position, "the test of %s refers to a block that does not exist%s", t.TargetBlock, aql.DidYouMean(t.TargetBlock, blocks))
+		}
+	}
+	return nil
+}
+
 func connectionMap(js *aql.JobScript) (map[string]*aql.Connection, error) {
 	conns, err := js.ParseConnections()
 	if err != nil {
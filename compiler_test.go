@@ -8,7 +8,10 @@ import (
 	"github.com/michaelbironneau/analyst/aql"
 	"github.com/michaelbironneau/analyst/engine"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -74,7 +77,7 @@ func TestCompilerDataLiteralAndHooks(t *testing.T) {
 				cd.Writer = buf
 				return nil, nil
 			})
-			err := ExecuteString(script, &RuntimeOptions{nil, l, []interface{}{replaceReaderHook}, nil, ""})
+			err := ExecuteString(script, &RuntimeOptions{Logger: l, Hooks: []interface{}{replaceReaderHook}})
 			So(err, ShouldBeNil)
 			So(buf.String(), ShouldEqual, "[{\"Total\":3}]")
 		})
@@ -122,7 +125,7 @@ func TestCompilerAssertions(t *testing.T) {
 				cd.Writer = buf
 				return nil, nil
 			})
-			err := TestString(script, &RuntimeOptions{nil, l, []interface{}{replaceReaderHook}, nil, ""})
+			err := TestString(script, &RuntimeOptions{Logger: l, Hooks: []interface{}{replaceReaderHook}})
 			So(err, ShouldNotBeNil)
 			So(buf.String(), ShouldHaveLength, 0) //Should have been replaced by DevNull destination
 		})
@@ -136,13 +139,515 @@ func TestCompilerAssertions(t *testing.T) {
 				cd.Writer = buf
 				return nil, nil
 			})
-			err := TestString(script2, &RuntimeOptions{nil, l, []interface{}{replaceReaderHook}, nil, ""})
+			err := TestString(script2, &RuntimeOptions{Logger: l, Hooks: []interface{}{replaceReaderHook}})
 			So(err, ShouldBeNil)
 			So(buf.String(), ShouldHaveLength, 0) //Should have been replaced by DevNull destination
 		})
 	})
 }
 
+func TestCompilerReferenceAssertions(t *testing.T) {
+	script := `
+		DATA 'Parents' (
+		[
+	  		[1],
+			[2]
+		]
+		)
+			INTO CONSOLE
+			WITH (FORMAT = 'JSON_ARRAY', COLUMNS = 'Id')
+
+		DATA 'Children' (
+		[
+	  		[1, "a"],
+			[%s, "b"]
+		]
+		)
+			INTO CONSOLE
+			WITH (FORMAT = 'JSON_ARRAY', COLUMNS = 'ParentId, Code')
+
+		TEST Children WITH ASSERTIONS (
+			COLUMN ParentId REFERENCES BLOCK Parents COLUMN Id;
+			COLUMN Code MATCHES '^[a-z]$';
+			COLUMN ParentId HAS VALUES BETWEEN 1 AND 3
+		)
+	`
+	Convey("Given a block that references another block", t, func() {
+		Convey("It should return no error if all the values are referenced", func() {
+			l := engine.NewConsoleLogger(engine.Trace)
+			err := TestString(fmt.Sprintf(script, "2"), &RuntimeOptions{Logger: l})
+			So(err, ShouldBeNil)
+		})
+		Convey("It should return an error if some values are not referenced", func() {
+			l := engine.NewConsoleLogger(engine.Trace)
+			err := TestString(fmt.Sprintf(script, "3"), &RuntimeOptions{Logger: l})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("It should report an unknown block referenced by a later assertion at its test", func() {
+			l := engine.NewConsoleLogger(engine.Trace)
+			misspelled := strings.Replace(fmt.Sprintf(script, "2"), "COLUMN Code MATCHES '^[a-z]$';", "COLUMN Code REFERENCES BLOCK Parnets COLUMN Id;", 1)
+			err := TestString(misspelled, &RuntimeOptions{Logger: l})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "20:3: could not find referenced block Parnets")
+		})
+	})
+}
+
+func TestCompilerProductionAssertions(t *testing.T) {
+	script := `
+		DATA 'Values' (
+		[
+	  		["Hello, World"],
+			["Hello, World"]
+		]
+		)
+			INTO CONSOLE
+			WITH (FORMAT = 'JSON_ARRAY',
+                  COLUMNS = 'Word')
+
+		TEST Values WITH ASSERTIONS (
+			COLUMN Word HAS UNIQUE VALUES
+		) %s
+	`
+	run := func(options string) (string, error) {
+		l := engine.NewConsoleLogger(engine.Trace)
+		buf := bytes.NewBufferString("")
+		replaceReaderHook := engine.DestinationHook(func(s string, d engine.Destination) (engine.Destination, error) {
+			cd, _ := d.(*engine.ConsoleDestination)
+			cd.Writer = buf
+			return nil, nil
+		})
+		err := ExecuteString(fmt.Sprintf(script, options), &RuntimeOptions{Logger: l, Hooks: []interface{}{replaceReaderHook}})
+		return buf.String(), err
+	}
+	Convey("Given a failing test in a production run", t, func() {
+		Convey("It should be ignored by default", func() {
+			out, err := run("")
+			So(err, ShouldBeNil)
+			So(out, ShouldNotBeEmpty)
+		})
+		Convey("It should stop the job if it always runs with error severity", func() {
+			_, err := run("WITH (MODE = 'always', SEVERITY = 'error')")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("It should let the job continue if it always runs with warn severity", func() {
+			out, err := run("WITH (MODE = 'always', SEVERITY = 'warn')")
+			So(err, ShouldBeNil)
+			So(out, ShouldNotBeEmpty)
+		})
+		Convey("It should return an error if the options are invalid", func() {
+			_, err := run("WITH (MODE = 'sometimes')")
+			So(err, ShouldNotBeNil)
+			_, err = run("WITH (SEVERITY = 'fatal')")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCompilerMocks(t *testing.T) {
+	script := `
+	CONNECTION 'Warehouse' (
+		Driver = 'postgres',
+		ConnectionString = 'postgres://nowhere/warehouse'
+	)
+
+	QUERY 'GetOrders' FROM CONNECTION Warehouse (
+		SELECT Id, Amount FROM Orders
+	) INTO CONSOLE
+
+	QUERY 'GetCustomers' FROM CONNECTION Warehouse (
+		SELECT Id FROM Customers
+	) INTO CONSOLE
+
+	MOCK CONNECTION Warehouse WITH FIXTURE (
+		CREATE TABLE Orders (Id INTEGER, Amount REAL);
+		INSERT INTO Orders VALUES (1, 10.5), (2, %s);
+	)
+
+	MOCK BLOCK GetCustomers WITH DATA (
+		[[1], [2]]
+	) WITH (COLUMNS = 'Id')
+
+	TEST GetOrders WITH ASSERTIONS (
+		IT OUTPUTS EXACTLY 2 ROWS;
+		COLUMN Amount HAS VALUES BETWEEN 0 AND 100
+	)
+
+	TEST GetCustomers WITH ASSERTIONS (
+		IT OUTPUTS EXACTLY 2 ROWS
+	)
+	`
+	Convey("Given a script with a mocked connection and a mocked block", t, func() {
+		Convey("The tests should run against the mocks", func() {
+			l := engine.NewConsoleLogger(engine.Trace)
+			err := TestString(fmt.Sprintf(script, "20"), &RuntimeOptions{Logger: l})
+			So(err, ShouldBeNil)
+		})
+		Convey("Failing tests should return an error", func() {
+			l := engine.NewConsoleLogger(engine.Trace)
+			err := TestString(fmt.Sprintf(script, "200"), &RuntimeOptions{Logger: l})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("The mocks should be ignored outside of test mode", func() {
+			err := ValidateString(fmt.Sprintf(script, "20"), &RuntimeOptions{})
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestCompilerSnapshots(t *testing.T) {
+	script := `
+	DATA 'Orders' (
+		[[1, 10.5], [2, %s]]
+	) INTO CONSOLE WITH (COLUMNS = 'Id, Amount')
+
+	TEST Orders WITH SNAPSHOT '%s' WITH (KEYS = 'Id')
+	`
+	Convey("Given a script with a snapshot test", t, func() {
+		dir, err := ioutil.TempDir("", "analyst-snapshots")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "snapshots", "orders.json")
+		Convey("It should fail if the snapshot does not exist", func() {
+			err := TestString(fmt.Sprintf(script, "20", path), &RuntimeOptions{Logger: engine.NewConsoleLogger(engine.Trace)})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("It should write the snapshot when updating snapshots", func() {
+			err := TestString(fmt.Sprintf(script, "20", path), &RuntimeOptions{Logger: engine.NewConsoleLogger(engine.Trace), Hooks: []interface{}{UpdateSnapshots}})
+			So(err, ShouldBeNil)
+			s, err := engine.ReadSnapshot(path)
+			So(err, ShouldBeNil)
+			So(s.Columns, ShouldResemble, []string{"Id", "Amount"})
+			So(s.Rows, ShouldHaveLength, 2)
+			Convey("It should pass if the output matches the snapshot", func() {
+				err := TestString(fmt.Sprintf(script, "20", path), &RuntimeOptions{Logger: engine.NewConsoleLogger(engine.Trace)})
+				So(err, ShouldBeNil)
+			})
+			Convey("It should fail if the output differs from the snapshot", func() {
+				rl := engine.NewTestResultLogger(engine.NewConsoleLogger(engine.Trace))
+				err := TestString(fmt.Sprintf(script, "30", path), &RuntimeOptions{Logger: rl})
+				So(err, ShouldNotBeNil)
+				results := rl.Results()
+				So(results, ShouldHaveLength, 1)
+				So(results[0].Status, ShouldEqual, engine.TestFailed)
+				So(results[0].Message, ShouldContainSubstring, "Id=2: Amount changed from 20 to 30")
+			})
+		})
+		Convey("Snapshots should be ignored outside of test mode", func() {
+			err := ExecuteString(fmt.Sprintf(script, "20", path), &RuntimeOptions{Logger: engine.NewConsoleLogger(engine.Trace)})
+			So(err, ShouldBeNil)
+			_, err = os.Stat(path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestCompilerSelection(t *testing.T) {
+	script := `
+	DATA 'Values' (
+		[[1], [2]]
+	) INTO CONSOLE WITH (COLUMNS = 'Number', OUTPUT_FORMAT = 'JSON')
+
+	DATA 'Other' (
+		[[5]]
+	) INTO CONSOLE WITH (COLUMNS = 'Number', OUTPUT_FORMAT = 'JSON')
+
+	TRANSFORM 'Total' FROM BLOCK Values (
+		AGGREGATE SUM(Number) AS Total
+	) INTO CONSOLE WITH (OUTPUT_FORMAT = 'JSON')
+	`
+	//run returns the names of the destinations that were run
+	run := func(opts RuntimeOptions) ([]string, error) {
+		var names []string
+		hook := engine.DestinationHook(func(s string, d engine.Destination) (engine.Destination, error) {
+			names = append(names, s)
+			d.(*engine.ConsoleDestination).Writer = bytes.NewBufferString("")
+			return nil, nil
+		})
+		opts.Logger = engine.NewConsoleLogger(engine.Trace)
+		opts.Hooks = []interface{}{hook}
+		err := ExecuteString(script, &opts)
+		return names, err
+	}
+	Convey("Given a script with several blocks", t, func() {
+		Convey("Only should run the selected blocks and the blocks that they need, without their destinations", func() {
+			names, err := run(RuntimeOptions{Only: []string{"Total"}})
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"total > console"})
+		})
+		Convey("Only should select blocks by glob", func() {
+			names, err := run(RuntimeOptions{Only: []string{"o*"}})
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"other > console"})
+		})
+		Convey("From should run the selected blocks and the blocks downstream of them", func() {
+			names, err := run(RuntimeOptions{From: []string{"Values"}})
+			So(err, ShouldBeNil)
+			So(names, ShouldHaveLength, 2)
+			So(names, ShouldContain, "values > console")
+			So(names, ShouldContain, "total > console")
+		})
+		Convey("To should run the selected blocks and the blocks upstream of them", func() {
+			names, err := run(RuntimeOptions{To: []string{"Values"}})
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"values > console"})
+		})
+		Convey("It should fail if a selector does not match any block", func() {
+			_, err := run(RuntimeOptions{Only: []string{"Missing"}})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("It should fail if Only is used with From or To", func() {
+			_, err := run(RuntimeOptions{Only: []string{"Total"}, To: []string{"Values"}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCompilerSelectionGlobalDatabase(t *testing.T) {
+	script := `
+	GLOBAL 'Initialize' (
+		CREATE TABLE IF NOT EXISTS SelectionStaging (Id INTEGER);
+	)
+
+	DATA 'LoadRows' (
+		[[1], [2]]
+	) INTO GLOBAL WITH (COLUMNS = 'Id', TABLE = 'SelectionStaging')
+
+	QUERY 'CountRows' FROM GLOBAL (
+		SELECT COUNT(*) AS N FROM SelectionStaging
+	) INTO CONSOLE WITH (OUTPUT_FORMAT = 'JSON')
+	AFTER LoadRows
+	`
+	Convey("Given a script that writes to a GLOBAL table and queries it", t, func() {
+		dir, err := ioutil.TempDir("", "analyst-global")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "global.db")
+		db, err := sql.Open(globalDbDriver, globalDbConnString)
+		So(err, ShouldBeNil)
+		defer db.Close()
+		_, err = db.Exec("DROP TABLE IF EXISTS SelectionStaging")
+		So(err, ShouldBeNil)
+
+		count := func(opts RuntimeOptions) (string, error) {
+			buf := bytes.NewBufferString("")
+			hook := engine.DestinationHook(func(s string, d engine.Destination) (engine.Destination, error) {
+				if cd, ok := d.(*engine.ConsoleDestination); ok {
+					cd.Writer = buf
+				}
+				return nil, nil
+			})
+			opts.Logger = engine.NewConsoleLogger(engine.Trace)
+			opts.Hooks = []interface{}{hook}
+			err := ExecuteString(script, &opts)
+			return buf.String(), err
+		}
+
+		out, err := count(RuntimeOptions{GlobalDatabase: path})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, `[{"N":2}]`)
+		_, err = db.Exec("DELETE FROM SelectionStaging")
+		So(err, ShouldBeNil)
+
+		//the pruned block does not write to the table
+		out, err = count(RuntimeOptions{Only: []string{"CountRows"}})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, `[{"N":0}]`)
+
+		//the table is loaded from the previous run instead
+		out, err = count(RuntimeOptions{Only: []string{"CountRows"}, GlobalDatabase: path})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, `[{"N":2}]`)
+	})
+}
+
+func TestCompilerErrorPositions(t *testing.T) {
+	script := `
+	CONNECTION 'Warehouse' (Driver = 'sqlite3', ConnectionString = ':memory:')
+	QUERY 'GetOrders' FROM CONNECTION Warehouse (
+		SELECT 1 AS Id
+	) INTO CONSOLE
+	%s
+	`
+	Convey("Given a script with a misspelled name", t, func() {
+		dir, err := ioutil.TempDir("", "analyst-positions")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		validate := func(block string) error {
+			return ValidateString(fmt.Sprintf(script, block), &RuntimeOptions{Logger: engine.NewConsoleLogger(engine.Error), ScriptDirectory: dir})
+		}
+		Convey("It should report a misspelled block at the block that refers to it", func() {
+			err := validate("TRANSFORM 'Total' FROM BLOCK GetOrdres (AGGREGATE COUNT(Id) AS N) INTO CONSOLE")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "6:2: TRANSFORM Total refers to block GetOrdres, which does not exist (did you mean getorders?)")
+		})
+		Convey("It should report a misspelled option at the option", func() {
+			err := validate("TRANSFORM 'Total' FROM CONNECTION Warehouse (AGGREGATE COUNT(Id) AS N) INTO CONSOLE WITH (TABEL = 'Orders')")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "6:92: expected TABLE option for Warehouse in the TRANSFORM Total options (is TABEL misspelled?)")
+		})
+		Convey("It should report errors in included scripts with their file", func() {
+			included := "QUERY 'Copy' FROM CONNECTION Warehose (SELECT 1) INTO CONSOLE\n"
+			So(ioutil.WriteFile(filepath.Join(dir, "included.aql"), []byte(included), 0644), ShouldBeNil)
+			err := validate("INCLUDE 'included.aql'")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, filepath.Join(dir, "included.aql")+":1:1: QUERY Copy refers to connection Warehose, which does not exist (did you mean Warehouse?)")
+		})
+	})
+}
+
+func TestCompilerOptionSchema(t *testing.T) {
+	script := `
+	CONNECTION 'Warehouse' (Driver = 'sqlite3', ConnectionString = ':memory:')
+	QUERY 'GetOrders' FROM CONNECTION Warehouse (
+		SELECT 1 AS Id
+	) INTO CONNECTION Warehouse
+	WITH (TABLE = 'Orders', %s)
+	`
+	Convey("Given a query with options", t, func() {
+		validate := func(options string) (string, error) {
+			var buf bytes.Buffer
+			l := engine.NewGenericLogger(engine.Warning, &buf)
+			err := ValidateString(fmt.Sprintf(script, options), &RuntimeOptions{Logger: l})
+			close(l.Chan())
+			l.Wait()
+			return buf.String(), err
+		}
+		Convey("It should accept options of the right type", func() {
+			out, err := validate("ROWS_PER_BATCH = 100, Warehouse_DROP_NULLS = 'true'")
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "")
+		})
+		Convey("It should reject options of the wrong type", func() {
+			_, err := validate("ROWS_PER_BATCH = '100'")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "6:26: option ROWS_PER_BATCH of QUERY GetOrders should be a number")
+		})
+		Convey("It should warn about unknown options", func() {
+			out, err := validate("ROWS_PER_BACTH = 100")
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "6:26: option ROWS_PER_BACTH of QUERY GetOrders is not known to any of its components, and is ignored (did you mean ROWS_PER_BATCH?)")
+		})
+		Convey("It should warn about options namespaced to aliases that do not exist", func() {
+			out, err := validate("Warehose_ROWS_PER_BATCH = 100")
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "6:26: option Warehose_ROWS_PER_BATCH of QUERY GetOrders is namespaced to Warehose, which is not one of its connections or aliases, and is ignored (did you mean WAREHOUSE?)")
+		})
+	})
+	Convey("Given a transform with an invalid TRY_CAST_ON_ERROR option", t, func() {
+		err := ValidateString(`
+		QUERY 'GetReadings' FROM GLOBAL (
+			SELECT '1.5' AS Reading
+		)
+		TRANSFORM 'ParseReadings' FROM BLOCK GetReadings (
+			APPLY TRY_CAST(Reading AS FLOAT) AS Reading
+		) INTO CONSOLE WITH (TRY_CAST_ON_ERROR = 'SKIP')
+		`, &RuntimeOptions{Logger: engine.NewConsoleLogger(engine.Error)})
+		Convey("It should fail to compile", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "TRY_CAST_ON_ERROR should be NULL or REJECT but got SKIP")
+		})
+	})
+}
+
+func TestCompilerControlFlow(t *testing.T) {
+	script := `
+	DATA 'Values' (
+		[[1], [2]]
+	) INTO CONSOLE WITH (COLUMNS = 'Number', OUTPUT_FORMAT = 'JSON')
+
+	DATA 'Other' (
+		[[5]]
+	) INTO CONSOLE WITH (COLUMNS = 'Number', OUTPUT_FORMAT = 'JSON')
+	IF @Mode = 'Prod'
+
+	TRANSFORM 'Total' FROM BLOCK Other (
+		AGGREGATE SUM(Number) AS Total
+	) INTO CONSOLE WITH (OUTPUT_FORMAT = 'JSON')
+
+	EXEC 'Break' FROM GLOBAL (
+		SELECT * FROM MissingTable
+	) IF @Fail = 1
+
+	QUERY 'Succeeded' FROM GLOBAL (
+		SELECT 'done' AS Status
+	) INTO CONSOLE WITH (OUTPUT_FORMAT = 'JSON')
+	ON SUCCESS
+
+	QUERY 'Failed' FROM GLOBAL (
+		SELECT ? AS Error
+	) USING PARAMETER @Error
+	INTO CONSOLE WITH (OUTPUT_FORMAT = 'JSON')
+	ON FAILURE
+	`
+	//run returns the output of each console destination that was run
+	run := func(s string, params string) (map[string]*bytes.Buffer, error) {
+		outputs := make(map[string]*bytes.Buffer)
+		hook := engine.DestinationHook(func(s string, d engine.Destination) (engine.Destination, error) {
+			if cd, ok := d.(*engine.ConsoleDestination); ok {
+				outputs[s] = bytes.NewBufferString("")
+				cd.Writer = outputs[s]
+			}
+			return nil, nil
+		})
+		opts, err := aql.StrToOpts(params)
+		if err != nil {
+			return nil, err
+		}
+		err = ExecuteString(s, &RuntimeOptions{Logger: engine.NewConsoleLogger(engine.Trace), Hooks: []interface{}{hook}, Options: opts})
+		return outputs, err
+	}
+	Convey("Given a script with guards and ON SUCCESS and ON FAILURE blocks", t, func() {
+		options, err := aql.StrToOpts(`{"Mode": "Test", "Fail": 0}`)
+		So(err, ShouldBeNil)
+		Convey("It should leave out the blocks whose guard does not hold, and the blocks that read from them", func() {
+			outputs, err := run(script, `{"Mode": "Test", "Fail": 0}`)
+			So(err, ShouldBeNil)
+			So(outputs["values > console"], ShouldNotBeNil)
+			So(outputs["other > console"], ShouldBeNil)
+			So(outputs["total > console"], ShouldBeNil)
+		})
+		Convey("It should run the blocks whose guard holds", func() {
+			outputs, err := run(script, `{"Mode": "Prod", "Fail": 0}`)
+			So(err, ShouldBeNil)
+			So(outputs["other > console"], ShouldNotBeNil)
+			So(outputs["total > console"].String(), ShouldEqual, `[{"Total":5}]`)
+		})
+		Convey("It should run the ON SUCCESS blocks after a successful job", func() {
+			outputs, err := run(script, `{"Mode": "Test", "Fail": 0}`)
+			So(err, ShouldBeNil)
+			So(outputs["succeeded > console"].String(), ShouldEqual, `[{"Status":"done"}]`)
+			So(outputs["failed > console"], ShouldBeNil)
+		})
+		Convey("It should run the ON FAILURE blocks with the error after a failed job", func() {
+			outputs, err := run(script, `{"Mode": "Test", "Fail": 1}`)
+			So(err, ShouldNotBeNil)
+			So(outputs["succeeded > console"], ShouldBeNil)
+			So(outputs["failed > console"].String(), ShouldContainSubstring, "MissingTable")
+		})
+		Convey("It should not allow blocks to refer to blocks that run at another time", func() {
+			err := ValidateString(script+`
+	TRANSFORM 'Report' FROM BLOCK Values (
+		AGGREGATE COUNT(Number) AS N
+	) INTO CONSOLE
+	ON FAILURE`, &RuntimeOptions{Logger: engine.NewConsoleLogger(engine.Trace), Options: options})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEndWith, "TRANSFORM report runs ON FAILURE, but refers to block values, which runs as part of the job")
+		})
+		Convey("It should not allow guards on global options that are not set", func() {
+			_, err := run(script, `{"Mode": "Test", "Fial": 0}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEndWith, "the IF guard of EXEC break is on the global option Fail, which is not set (did you mean Fial?)")
+		})
+		Convey("It should not allow guards on parameters", func() {
+			_, err := run(script+`
+	DECLARE @Fail`, `{"Mode": "Test"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEndWith, "the IF guard of EXEC break is on the parameter @Fail, but guards are evaluated at compile time, so they can only be on global options")
+		})
+	})
+}
+
 func TestCompilerDataLiteralSourceDest(t *testing.T) {
 	script := `
 		DATA 'MyMessage' (
@@ -160,7 +665,7 @@ func TestCompilerDataLiteralSourceDest(t *testing.T) {
 				cd.Writer = buf
 				return nil, nil
 			})
-			err := ExecuteString(script, &RuntimeOptions{nil, l, []interface{}{replaceReaderHook}, nil, ""})
+			err := ExecuteString(script, &RuntimeOptions{Logger: l, Hooks: []interface{}{replaceReaderHook}})
 			So(err, ShouldBeNil)
 			So(buf.String(), ShouldEqual, "[{\"Message\":\"Hello, World\"}]")
 		})
@@ -185,7 +690,7 @@ func TestCompilerHTTPAutoSQL(t *testing.T) {
 	Convey("Given a script using an HTTP connection and a QUERY", t, func() {
 		Convey("It should run without errors", func() {
 			l := engine.NewConsoleLogger(engine.Trace)
-			err := ExecuteString(script, &RuntimeOptions{nil, l, nil, nil, ""})
+			err := ExecuteString(script, &RuntimeOptions{Logger: l})
 			//l.Close()
 			So(err, ShouldBeNil)
 		})
@@ -214,7 +719,7 @@ func TestCompiler(t *testing.T) {
 	`
 	Convey("Given a coordinator and an Excel data destination", t, func() {
 		l := engine.NewConsoleLogger(engine.Trace)
-		err := ExecuteString(script, &RuntimeOptions{nil, l, nil, nil, ""})
+		err := ExecuteString(script, &RuntimeOptions{Logger: l})
 		So(err, ShouldBeNil)
 		_, err = os.Stat("./output.xlsx")
 		os.Remove("./output.xlsx") //best effort cleanup attempt
@@ -522,6 +1027,39 @@ func TestCompilerWithParameters(t *testing.T) {
 
 }
 
+func TestCompilerParameterDependencies(t *testing.T) {
+	script := `
+	DECLARE @Salt;
+
+	QUERY 'GetSalt' FROM GLOBAL (
+		SELECT 'pepper' AS Salt
+	) INTO PARAMETER (@Salt);
+
+	QUERY 'GetCustomers' FROM GLOBAL (
+		SELECT 'bob@example.com' AS Email
+	);
+
+	TRANSFORM 'MaskCustomers' FROM BLOCK GetCustomers (
+		MASK Email USING SHA256 SALT @Salt
+	) INTO CONSOLE
+	`
+	Convey("Given a transform that reads a parameter that a query sets", t, func() {
+		plan, err := ExplainString(script, &RuntimeOptions{})
+		So(err, ShouldBeNil)
+		Convey("It should run after the query", func() {
+			So(plan.Blocks[2].After, ShouldResemble, []string{"GetSalt"})
+			So(plan.Waves, ShouldResemble, [][]string{{"GetSalt", "GetCustomers"}, {"MaskCustomers"}})
+		})
+	})
+	Convey("Given a transform that already runs after the query that sets the parameter", t, func() {
+		plan, err := ExplainString(script+"AFTER GetSalt", &RuntimeOptions{})
+		So(err, ShouldBeNil)
+		Convey("It should not run after it twice", func() {
+			So(plan.Blocks[2].After, ShouldResemble, []string{"GetSalt"})
+		})
+	})
+}
+
 func TestCompilerWithEmail(t *testing.T) {
 	script := `
 	CONNECTION 'SendTestEmail' (
@@ -588,7 +1126,7 @@ func TestCompilerWithTransform(t *testing.T) {
 	Convey("Given a script with a transform and an Excel data destination", t, func() {
 		l := engine.NewConsoleLogger(engine.Trace)
 		Convey("It should execute without error", func() {
-			err := ExecuteString(script, &RuntimeOptions{nil, l, nil, nil, ""})
+			err := ExecuteString(script, &RuntimeOptions{Logger: l})
 			So(err, ShouldBeNil)
 			_, err = os.Stat("./output_transform.xlsx")
 			So(err, ShouldBeNil)
@@ -668,7 +1206,7 @@ func TestTxManagerRollback(t *testing.T) {
 	`
 	l := engine.NewConsoleLogger(engine.Trace)
 	Convey("Given a script with EXECs one of which violates PK constraint", t, func() {
-		ExecuteString(script, &RuntimeOptions{nil, l, nil, nil, ""})
+		ExecuteString(script, &RuntimeOptions{Logger: l})
 		Convey("All writes should get rolled back", func() {
 			//So(err, ShouldNotBeNil)
 			db, err := sql.Open(globalDbDriver, "tx_manager_rollback_test.db")
@@ -690,3 +1228,56 @@ func TestTxManagerRollback(t *testing.T) {
 
 	})
 }
+
+func TestProfile(t *testing.T) {
+	script := `
+	QUERY 'People' FROM GLOBAL (
+		SELECT 1 AS Id, 'Bob' AS Name
+		UNION ALL
+		SELECT 2, 'Alice'
+		UNION ALL
+		SELECT 3, NULL
+	) INTO CONSOLE
+	`
+	Convey("Given a script with a query", t, func() {
+		Convey("It should profile the columns of the query", func() {
+			l := engine.NewConsoleLogger(engine.Trace)
+			res, err := ProfileString(script, "People", 0, &RuntimeOptions{Logger: l})
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveLength, 2)
+			So(res[0][0], ShouldEqual, "Id")
+			So(res[0][2], ShouldEqual, int64(3))
+			So(res[1][0], ShouldEqual, "Name")
+			So(res[1][3], ShouldEqual, int64(1))
+		})
+		Convey("It should fail for a block that does not exist", func() {
+			l := engine.NewConsoleLogger(engine.Trace)
+			_, err := ProfileString(script, "Nobody", 0, &RuntimeOptions{Logger: l})
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Given a script with a query that runs after the query that writes its table", t, func() {
+		script := `
+		GLOBAL 'CreateTables' (
+			CREATE TABLE Staging (Id INT);
+		)
+		QUERY 'Load' FROM GLOBAL (
+			SELECT 1 AS Id
+			UNION ALL
+			SELECT 2
+		) INTO GLOBAL WITH (Table = 'Staging')
+		QUERY 'Read' FROM GLOBAL (
+			SELECT Id FROM Staging
+		) INTO CONSOLE
+		AFTER Load
+		`
+		Convey("It should run the query that writes the table before profiling", func() {
+			l := engine.NewConsoleLogger(engine.Trace)
+			res, err := ProfileString(script, "Read", 0, &RuntimeOptions{Logger: l})
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveLength, 1)
+			So(res[0][0], ShouldEqual, "Id")
+			So(res[0][2], ShouldEqual, int64(2))
+		})
+	})
+}
@@ -0,0 +1,336 @@
+package analyst
+
+import (
+	"fmt"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	"strings"
+	"time"
+)
+
+//ErrorParameter is the parameter that ON SUCCESS and ON FAILURE blocks can use to read the error that made the
+//job fail. It is NULL for ON SUCCESS blocks.
+const ErrorParameter = "@Error"
+
+//controlBlock is the part of a block that decides whether, and when, it runs.
+type controlBlock struct {
+	kind         string
+	name         string
+	position     aql.Position
+	guard        *aql.Guard
+	on           *aql.Outcome
+	sources      []string //the blocks that it reads from
+	dependencies []string //the blocks that it runs AFTER
+	destinations int
+}
+
+//controlBlocks returns the queries, execs, transforms and data blocks of the script. Names are lowercase.
+func controlBlocks(js *aql.JobScript) []controlBlock {
+	var ret []controlBlock
+	query := func(kind string, q *aql.Query) {
+		b := controlBlock{kind, strings.ToLower(q.Name), q.Position, q.Guard, q.On, nil, lower(q.Dependencies), len(q.Destinations)}
+		for _, source := range q.Sources {
+			if source.Block != nil {
+				b.sources = append(b.sources, strings.ToLower(*source.Block))
+			}
+		}
+		ret = append(ret, b)
+	}
+	for i := range js.Queries {
+		query("QUERY", &js.Queries[i])
+	}
+	for i := range js.Execs {
+		query("EXEC", &js.Execs[i])
+	}
+	for _, t := range js.Transforms {
+		b := controlBlock{"TRANSFORM", strings.ToLower(t.Name), t.Position, t.Guard, t.On, nil, lower(t.Dependencies), len(t.Destinations)}
+		for _, source := range t.Sources {
+			if source.Block != nil {
+				b.sources = append(b.sources, strings.ToLower(*source.Block))
+			}
+		}
+		ret = append(ret, b)
+	}
+	for _, d := range js.Data {
+		ret = append(ret, controlBlock{"DATA", strings.ToLower(d.Name), d.Position, d.Guard, d.On, nil, nil, len(d.Destinations)})
+	}
+	return ret
+}
+
+func lower(names []string) []string {
+	var ret []string
+	for _, name := range names {
+		ret = append(ret, strings.ToLower(name))
+	}
+	return ret
+}
+
+//guards leaves out the blocks whose IF guard does not hold for the global options. The blocks that read from
+//a block that is left out are left out too, as are the blocks whose output was only read by blocks that are
+//left out. The tests, mocks and AFTER constraints of the blocks that are left out are removed with them.
+//Guards on options that are not set are errors.
+func guards(js *aql.JobScript, options []aql.Option, logger engine.Logger) error {
+	var (
+		blocks = controlBlocks(js)
+		out    = make(map[string]bool)
+	)
+	leaveOut := func(b controlBlock, reason string) {
+		out[b.name] = true
+		logger.Chan() <- engine.Event{
+			Source:  "Compiler",
+			Level:   engine.Info,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("%s %s is left out because %s", b.kind, b.name, reason),
+		}
+	}
+	for _, b := range blocks {
+		if b.guard == nil {
+			continue
+		}
+		if err := checkGuard(js, b, options); err != nil {
+			return err
+		}
+		if !b.guard.Holds(options) {
+			leaveOut(b, fmt.Sprintf("%s does not hold", b.guard))
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	for changed := true; changed; {
+		changed = false
+		readByIn, readByOut := make(map[string]bool), make(map[string]bool)
+		for _, b := range blocks {
+			for _, source := range b.sources {
+				if out[b.name] {
+					readByOut[source] = true
+				} else {
+					readByIn[source] = true
+				}
+			}
+		}
+		for _, b := range blocks {
+			if out[b.name] {
+				continue
+			}
+			for _, source := range b.sources {
+				if out[source] {
+					leaveOut(b, fmt.Sprintf("it reads from block %s, which is left out", source))
+					changed = true
+					break
+				}
+			}
+			if !out[b.name] && b.kind != "EXEC" && b.destinations == 0 && readByOut[b.name] && !readByIn[b.name] {
+				leaveOut(b, "the blocks that read from it are left out")
+				changed = true
+			}
+		}
+	}
+
+	var (
+		queries, execs []aql.Query
+		transforms     []aql.Transform
+		data           []aql.Data
+		tests          []aql.Test
+		mocks          []aql.Mock
+	)
+	for _, q := range js.Queries {
+		if !out[strings.ToLower(q.Name)] {
+			q.Dependencies = keep(q.Dependencies, out)
+			queries = append(queries, q)
+		}
+	}
+	for _, q := range js.Execs {
+		if !out[strings.ToLower(q.Name)] {
+			q.Dependencies = keep(q.Dependencies, out)
+			execs = append(execs, q)
+		}
+	}
+	for _, t := range js.Transforms {
+		if !out[strings.ToLower(t.Name)] {
+			t.Dependencies = keep(t.Dependencies, out)
+			transforms = append(transforms, t)
+		}
+	}
+	for _, d := range js.Data {
+		if !out[strings.ToLower(d.Name)] {
+			data = append(data, d)
+		}
+	}
+	for _, t := range js.Tests {
+		if !out[strings.ToLower(t.TargetBlock)] {
+			tests = append(tests, t)
+		}
+	}
+	for _, m := range js.Mocks {
+		if m.Block == nil || !out[strings.ToLower(*m.Block)] {
+			mocks = append(mocks, m)
+		}
+	}
+	js.Queries, js.Execs, js.Transforms, js.Data, js.Tests, js.Mocks = queries, execs, transforms, data, tests, mocks
+	return nil
+}
+
+//checkGuard returns an error if the guard of the block is not on a global option that is set. Guards are
+//evaluated at compile time, so they cannot be on a declared parameter, whose value is only known at runtime.
+func checkGuard(js *aql.JobScript, b controlBlock, options []aql.Option) error {
+	name := strings.TrimPrefix(b.guard.Option, "@")
+	if _, ok := aql.FindOption(options, name); ok {
+		return nil
+	}
+	for _, d := range js.Declarations {
+		if strings.EqualFold(strings.TrimPrefix(d.Name, "@"), name) {
+			return aql.Errorf(b.position, "the IF guard of %s %s is on the parameter @%s, but guards are evaluated at compile time, so they can only be on global options", b.kind, b.name, name)
+		}
+	}
+	var names []string
+	for _, opt := range options {
+		names = append(names, opt.Key)
+	}
+	return aql.Errorf(b.position, "the IF guard of %s %s is on the global option %s, which is not set%s", b.kind, b.name, name, aql.DidYouMean(name, names))
+}
+
+//keep returns the names that are not left out.
+func keep(names []string, out map[string]bool) []string {
+	var ret []string
+	for _, name := range names {
+		if !out[strings.ToLower(name)] {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+//phase returns when the block runs: as part of the job, or ON SUCCESS or ON FAILURE after it.
+func (b controlBlock) phase() string {
+	if b.on == nil {
+		return "as part of the job"
+	}
+	return b.on.String()
+}
+
+//handlerScripts moves the ON SUCCESS and ON FAILURE blocks, along with their tests, out of the job script and
+//into scripts of their own. Blocks cannot read from, or run after, blocks that run at another time.
+func handlerScripts(js *aql.JobScript) (onSuccess *aql.JobScript, onFailure *aql.JobScript, err error) {
+	var (
+		blocks = controlBlocks(js)
+		phases = make(map[string]string)
+		ons    = make(map[string]*aql.Outcome)
+	)
+	for _, b := range blocks {
+		phases[b.name] = b.phase()
+		ons[b.name] = b.on
+	}
+	for _, b := range blocks {
+		for _, other := range append(b.sources, b.dependencies...) {
+			if phase, ok := phases[other]; ok && phase != b.phase() {
+				return nil, nil, aql.Errorf(b.position, "%s %s runs %s, but refers to block %s, which runs %s", b.kind, b.name, b.phase(), other, phase)
+			}
+		}
+	}
+
+	var main aql.JobScript
+	onSuccess, onFailure = &aql.JobScript{}, &aql.JobScript{}
+	script := func(name string) *aql.JobScript {
+		on := ons[strings.ToLower(name)]
+		switch {
+		case on == nil:
+			return &main
+		case on.Failure:
+			return onFailure
+		}
+		return onSuccess
+	}
+	for _, q := range js.Queries {
+		s := script(q.Name)
+		s.Queries = append(s.Queries, q)
+	}
+	for _, q := range js.Execs {
+		s := script(q.Name)
+		s.Execs = append(s.Execs, q)
+	}
+	for _, t := range js.Transforms {
+		s := script(t.Name)
+		s.Transforms = append(s.Transforms, t)
+	}
+	for _, d := range js.Data {
+		s := script(d.Name)
+		s.Data = append(s.Data, d)
+	}
+	for _, t := range js.Tests {
+		s := script(t.TargetBlock)
+		s.Tests = append(s.Tests, t)
+	}
+	js.Queries, js.Execs, js.Transforms, js.Data, js.Tests = main.Queries, main.Execs, main.Transforms, main.Data, main.Tests
+	return onSuccess, onFailure, nil
+}
+
+//handler compiles the script of the blocks that run on an outcome of the job into a job of its own. The handler
+//runs it with the error of the job as the @Error parameter. It returns nil if the script has no blocks.
+//It does not use the context of the job, so that ON FAILURE blocks still run after the job was cancelled.
+func handler(s *aql.JobScript, logger engine.Logger, hooks []interface{}, connMap map[string]*aql.Connection, params *engine.ParameterTable, options []aql.Option, runTests bool) (engine.Handler, error) {
+	if len(s.Queries)+len(s.Execs)+len(s.Transforms)+len(s.Data) == 0 {
+		return nil, nil
+	}
+
+	if !params.Declared(ErrorParameter) {
+		if err := params.Declare(ErrorParameter); err != nil {
+			return nil, err
+		}
+	}
+
+	//the transactions of the job are over by the time that the handler runs, so it has its own
+	tm, err := txManager(logger, connMap)
+	if err != nil {
+		return nil, fmt.Errorf("error startin transaction manager: %v", err)
+	}
+
+	dag := engine.NewCoordinator(logger, tm)
+	dag.RegisterHooks(hooks...)
+
+	err = sources(s, dag, connMap, params, options, tm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = transforms(s, dag, connMap, params, options, tm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = destinations(s, dag, connMap, params, options, tm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tests(s, dag, runTests)
+	if err != nil {
+		return nil, err
+	}
+
+	err = constraints(s, dag, connMap)
+	if err != nil {
+		return nil, err
+	}
+
+	err = terminateExecs(s, dag)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dag.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(jobErr error) error {
+		var msg interface{}
+		if jobErr != nil {
+			msg = jobErr.Error()
+		}
+		if err := params.Set(ErrorParameter, msg); err != nil {
+			return err
+		}
+		return dag.Run()
+	}, nil
+}
@@ -7,7 +7,11 @@ import (
 	"fmt"
 	"github.com/araddon/qlbridge/vm"
 	"github.com/araddon/qlbridge/value"
+	"math"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 //Condition is a func that returns true if the message passes the test and false otherwise.
@@ -166,3 +170,269 @@ func HasNoDuplicates(col string) (Condition, error){
 	}, nil
 }
 
+//columnValue returns the value of the column, matching its name case-insensitively if there is
+//no exact match.
+func columnValue(msg map[string]interface{}, col string) interface{} {
+	if v, ok := msg[col]; ok {
+		return v
+	}
+	for k, v := range msg {
+		if strings.ToLower(k) == strings.ToLower(col) {
+			return v
+		}
+	}
+	return nil
+}
+
+//conditionFloat converts numbers, and strings that represent numbers, to float64.
+func conditionFloat(v interface{}) (float64, bool) {
+	switch vv := v.(type) {
+	case int:
+		return float64(vv), true
+	case int8:
+		return float64(vv), true
+	case int16:
+		return float64(vv), true
+	case int32:
+		return float64(vv), true
+	case int64:
+		return float64(vv), true
+	case uint:
+		return float64(vv), true
+	case uint8:
+		return float64(vv), true
+	case uint16:
+		return float64(vv), true
+	case uint32:
+		return float64(vv), true
+	case uint64:
+		return float64(vv), true
+	case float32:
+		return float64(vv), true
+	case float64:
+		return vv, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(vv), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+var conditionTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+//HasValuesBetweenCondition checks that the non-null values of the column are between min and max, inclusive.
+//The bounds are compared as numbers if they are both numbers, as times if the value is a time, and as strings otherwise.
+func HasValuesBetweenCondition(col string, min string, max string) (Condition, error) {
+	var compare func(v interface{}) bool
+	minF, errMin := strconv.ParseFloat(min, 64)
+	maxF, errMax := strconv.ParseFloat(max, 64)
+	if errMin == nil && errMax == nil {
+		compare = func(v interface{}) bool {
+			f, ok := conditionFloat(v)
+			return ok && f >= minF && f <= maxF
+		}
+	} else {
+		var (
+			minT, maxT time.Time
+			isTime     bool
+		)
+		for _, layout := range conditionTimeLayouts {
+			var err1, err2 error
+			minT, err1 = time.Parse(layout, min)
+			maxT, err2 = time.Parse(layout, max)
+			if err1 == nil && err2 == nil {
+				isTime = true
+				break
+			}
+		}
+		compare = func(v interface{}) bool {
+			if t, ok := v.(time.Time); ok && isTime {
+				return !t.Before(minT) && !t.After(maxT)
+			}
+			s := fmt.Sprintf("%v", v)
+			return s >= min && s <= max
+		}
+	}
+	return func(msg map[string]interface{}, eof bool) bool {
+		if eof {
+			return true
+		}
+		v := columnValue(msg, col)
+		return v == nil || compare(v)
+	}, nil
+}
+
+//HasValuesInCondition checks that the non-null values of the column are in the list, comparing their string representations.
+func HasValuesInCondition(col string, values []string) (Condition, error) {
+	allowed := make(map[string]bool)
+	for _, v := range values {
+		allowed[v] = true
+	}
+	return func(msg map[string]interface{}, eof bool) bool {
+		if eof {
+			return true
+		}
+		v := columnValue(msg, col)
+		return v == nil || allowed[fmt.Sprintf("%v", v)]
+	}, nil
+}
+
+//HasValuesMatchingCondition checks that the non-null values of the column match the regular expression.
+func HasValuesMatchingCondition(col string, pattern string) (Condition, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression '%s': %v", pattern, err)
+	}
+	return func(msg map[string]interface{}, eof bool) bool {
+		if eof {
+			return true
+		}
+		v := columnValue(msg, col)
+		return v == nil || re.MatchString(fmt.Sprintf("%v", v))
+	}, nil
+}
+
+//HasTypeCondition checks that the non-null values of the column have the given type, which is one of
+//int, float, bool, string or datetime. Floats that are whole numbers are considered to be ints, as
+//some sources such as JSON do not distinguish between the two.
+func HasTypeCondition(col string, typ string) (Condition, error) {
+	var check func(v interface{}) bool
+	switch strings.ToLower(typ) {
+	case "int", "integer":
+		check = func(v interface{}) bool {
+			switch vv := v.(type) {
+			case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+				return true
+			case float32:
+				return float64(vv) == math.Trunc(float64(vv))
+			case float64:
+				return vv == math.Trunc(vv)
+			}
+			return false
+		}
+	case "float", "number", "numeric":
+		check = func(v interface{}) bool {
+			if _, ok := v.(string); ok {
+				return false
+			}
+			_, ok := conditionFloat(v)
+			return ok
+		}
+	case "bool", "boolean":
+		check = func(v interface{}) bool {
+			_, ok := v.(bool)
+			return ok
+		}
+	case "string", "varchar", "text":
+		check = func(v interface{}) bool {
+			_, ok := v.(string)
+			return ok
+		}
+	case "datetime", "date", "time":
+		check = func(v interface{}) bool {
+			_, ok := v.(time.Time)
+			return ok
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %s, expected one of int, float, bool, string or datetime", typ)
+	}
+	return func(msg map[string]interface{}, eof bool) bool {
+		if eof {
+			return true
+		}
+		v := columnValue(msg, col)
+		return v == nil || check(v)
+	}, nil
+}
+
+//HasAtMostNPercentNullValuesCondition checks that at most n percent of the values of the column are null.
+func HasAtMostNPercentNullValuesCondition(col string, n int) (Condition, error) {
+	var rows, nulls int
+	return func(msg map[string]interface{}, eof bool) bool {
+		if eof {
+			return rows == 0 || float64(nulls)*100 <= float64(n*rows)
+		}
+		rows++
+		if columnValue(msg, col) == nil {
+			nulls++
+		}
+		return true
+	}, nil
+}
+
+//HasAtLeastNPercentNullValuesCondition checks that at least n percent of the values of the column are null.
+func HasAtLeastNPercentNullValuesCondition(col string, n int) (Condition, error) {
+	var rows, nulls int
+	return func(msg map[string]interface{}, eof bool) bool {
+		if eof {
+			return rows == 0 || float64(nulls)*100 >= float64(n*rows)
+		}
+		rows++
+		if columnValue(msg, col) == nil {
+			nulls++
+		}
+		return true
+	}, nil
+}
+
+//ReferenceSet holds the values of a column of a block, so that the values of another block
+//can be checked against them for referential integrity.
+type ReferenceSet struct {
+	values map[string]bool
+	done   chan struct{}
+}
+
+func NewReferenceSet() *ReferenceSet {
+	return &ReferenceSet{
+		values: make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+}
+
+//CollectCondition returns a condition that adds the values of the column to the set. It always passes,
+//and it should be added to the referenced block before any other condition.
+func (r *ReferenceSet) CollectCondition(col string) (Condition, error) {
+	var closed bool
+	return func(msg map[string]interface{}, eof bool) bool {
+		if eof {
+			if !closed {
+				closed = true
+				close(r.done)
+			}
+			return true
+		}
+		if v := columnValue(msg, col); v != nil {
+			r.values[fmt.Sprintf("%v", v)] = true
+		}
+		return true
+	}, nil
+}
+
+//ReferencesCondition checks that the non-null values of the column are in the reference set. Until
+//the referenced block has been read, values are kept and they are checked at EOF.
+func ReferencesCondition(col string, r *ReferenceSet) (Condition, error) {
+	pending := make(map[string]bool)
+	return func(msg map[string]interface{}, eof bool) bool {
+		if eof {
+			<-r.done
+			for v := range pending {
+				if !r.values[v] {
+					return false
+				}
+			}
+			return true
+		}
+		v := columnValue(msg, col)
+		if v == nil {
+			return true
+		}
+		s := fmt.Sprintf("%v", v)
+		select {
+		case <-r.done:
+			return r.values[s]
+		default:
+			pending[s] = true
+			return true
+		}
+	}, nil
+}
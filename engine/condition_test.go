@@ -93,4 +93,90 @@ func TestHasNoDuplicatesCondition(t *testing.T){
 			So(c(nil, true), ShouldBeTrue)
 		})
 	})
-}
\ No newline at end of file
+}
+
+func TestValueConditions(t *testing.T) {
+	Convey("Given a slice of messages", t, func() {
+		msg := [][]interface{}{[]interface{}{"AB-1", 5.0}, []interface{}{"AB-2", 12.0}, []interface{}{nil, nil}, []interface{}{"c", "text"}}
+		converter := mapConverter([]string{"Code", "Amount"})
+		Convey("The 'values between' condition should be correctly evaluated", func() {
+			c, err := HasValuesBetweenCondition("Amount", "0", "10")
+			So(err, ShouldBeNil)
+			So(c(converter(msg[0]), false), ShouldBeTrue)
+			So(c(converter(msg[1]), false), ShouldBeFalse)
+			So(c(converter(msg[2]), false), ShouldBeTrue)
+			So(c(converter(msg[3]), false), ShouldBeFalse)
+			c, _ = HasValuesBetweenCondition("code", "AB-0", "AB-1")
+			So(c(converter(msg[0]), false), ShouldBeTrue)
+			So(c(converter(msg[1]), false), ShouldBeFalse)
+		})
+		Convey("The 'values in' condition should be correctly evaluated", func() {
+			c, _ := HasValuesInCondition("Code", []string{"AB-1", "AB-2"})
+			So(c(converter(msg[0]), false), ShouldBeTrue)
+			So(c(converter(msg[2]), false), ShouldBeTrue)
+			So(c(converter(msg[3]), false), ShouldBeFalse)
+			c, _ = HasValuesInCondition("Amount", []string{"5"})
+			So(c(converter(msg[0]), false), ShouldBeTrue)
+			So(c(converter(msg[1]), false), ShouldBeFalse)
+		})
+		Convey("The 'matches' condition should be correctly evaluated", func() {
+			c, err := HasValuesMatchingCondition("Code", `^[A-Z]+-\d$`)
+			So(err, ShouldBeNil)
+			So(c(converter(msg[0]), false), ShouldBeTrue)
+			So(c(converter(msg[3]), false), ShouldBeFalse)
+			_, err = HasValuesMatchingCondition("Code", "[")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("The 'has type' condition should be correctly evaluated", func() {
+			c, err := HasTypeCondition("Amount", "int")
+			So(err, ShouldBeNil)
+			So(c(converter(msg[0]), false), ShouldBeTrue)
+			So(c(converter(msg[2]), false), ShouldBeTrue)
+			So(c(converter(msg[3]), false), ShouldBeFalse)
+			So(c(converter([]interface{}{"a", 1.5}), false), ShouldBeFalse)
+			c, _ = HasTypeCondition("Code", "string")
+			So(c(converter(msg[0]), false), ShouldBeTrue)
+			_, err = HasTypeCondition("Code", "blob")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("The percentage of null values conditions should be correctly evaluated", func() {
+			c, _ := HasAtMostNPercentNullValuesCondition("Code", 25)
+			c2, _ := HasAtMostNPercentNullValuesCondition("Code", 20)
+			c3, _ := HasAtLeastNPercentNullValuesCondition("Code", 50)
+			for i := range msg {
+				So(c(converter(msg[i]), false), ShouldBeTrue)
+				So(c2(converter(msg[i]), false), ShouldBeTrue)
+				So(c3(converter(msg[i]), false), ShouldBeTrue)
+			}
+			So(c(nil, true), ShouldBeTrue)
+			So(c2(nil, true), ShouldBeFalse)
+			So(c3(nil, true), ShouldBeFalse)
+		})
+	})
+}
+
+func TestReferencesCondition(t *testing.T) {
+	Convey("Given a referenced and a referencing block", t, func() {
+		parents := mapConverter([]string{"Id"})
+		children := mapConverter([]string{"ParentId"})
+		r := NewReferenceSet()
+		collect, _ := r.CollectCondition("Id")
+		Convey("Values that are read before the referenced block should be checked at EOF", func() {
+			c, _ := ReferencesCondition("ParentId", r)
+			So(c(children([]interface{}{1.0}), false), ShouldBeTrue)
+			So(c(children([]interface{}{3.0}), false), ShouldBeTrue)
+			So(c(children([]interface{}{nil}), false), ShouldBeTrue)
+			So(collect(parents([]interface{}{1.0}), false), ShouldBeTrue)
+			So(collect(parents([]interface{}{2.0}), false), ShouldBeTrue)
+			So(collect(nil, true), ShouldBeTrue)
+			So(c(nil, true), ShouldBeFalse)
+		})
+		Convey("Values that are read after the referenced block should be checked straight away", func() {
+			c, _ := ReferencesCondition("ParentId", r)
+			So(collect(parents([]interface{}{1.0}), false), ShouldBeTrue)
+			So(collect(nil, true), ShouldBeTrue)
+			So(c(children([]interface{}{1.0}), false), ShouldBeTrue)
+			So(c(children([]interface{}{2.0}), false), ShouldBeFalse)
+		})
+	})
+}
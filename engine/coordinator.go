@@ -31,17 +31,43 @@ type (
 	DestinationHook func(string, Destination) (Destination, error)
 )
 
+//Outcome is whether a job succeeded or failed, which decides the handlers that run after it.
+type Outcome int
+
+const (
+	OnSuccess Outcome = iota
+	OnFailure
+)
+
+func (o Outcome) String() string {
+	if o == OnFailure {
+		return "ON FAILURE"
+	}
+	return "ON SUCCESS"
+}
+
+//Handler runs after a job has been committed or rolled back. It is given the error that made the job fail, if any.
+type Handler func(err error) error
+
 type Coordinator interface {
 	RegisterHooks(...interface{}) //arguments should be SourceHook, TransformHook or DestinationHook
 	AddSource(name string, alias string, s Source) error
 	AddDestination(name string, alias string, d Destination) error
 	AddTest(node string, name string, desc string, c Condition) error
+	AddTestWithSeverity(node string, name string, desc string, severity TestSeverity, c Condition) error
 	AddTransform(name string, alias string, t Transform) error
 	AddConstraint(before, after string) error
+	AddHandler(outcome Outcome, h Handler)
 	Connect(from string, to string) error
+	Upstream(names ...string) ([]string, error)
+	Prerequisites(names ...string) ([]string, error)
+	Downstream(names ...string) ([]string, error)
+	Destinations(names ...string) ([]string, error)
+	Retain(names ...string) error
 	UseContext(ctx context.Context)
 	Compile() error
 	Execute() error
+	Run() error
 	Stop()
 }
 
@@ -71,6 +97,7 @@ type coordinator struct {
 	constraintMap    map[string][]string //map after -> before
 	constraintMapRev map[string][]string //map before -> after
 	txManager        TransactionManager
+	handlers         map[Outcome][]Handler
 }
 
 type GraphNode interface {
@@ -251,6 +278,9 @@ func (c *coordinator) Compile() error {
 					return fmt.Errorf("a source cannot be a destination, but %s is", name)
 				}
 			}
+			if m, ok := nv.(*transformNode).t.(MultiSourceTransform); ok {
+				m.SetSourceCount(len(c.g.To(c.nodeIds[name])))
+			}
 		default:
 			panic(fmt.Sprintf("Unknown node type %T for node %s", nv, name))
 		}
@@ -274,7 +304,61 @@ func (c *coordinator) RegisterHooks(hooks ...interface{}) {
 	}
 }
 
+//AddHandler adds a handler that runs after the job if it has the outcome, once the job has been committed
+//or rolled back.
+func (c *coordinator) AddHandler(outcome Outcome, h Handler) {
+	c.handlers[outcome] = append(c.handlers[outcome], h)
+}
+
+//Execute executes the job and then the handlers of its outcome, and closes the logger once they are done.
 func (c *coordinator) Execute() error {
+	err := c.Run()
+	close(c.l.Chan())
+	c.l.Wait()
+	if err != nil {
+		return err
+	}
+	return c.l.Error()
+}
+
+//Run is like Execute, but it leaves the logger open, so that a job can itself run as the handler of another
+//job. It returns the error that made the job fail, if any, which the handlers are given.
+func (c *coordinator) Run() error {
+	jobLogger := c.l
+	phase := newPhaseLogger(jobLogger)
+	c.l = phase
+	interrupted, endErr := c.run()
+	close(phase.Chan())
+	phase.Wait()
+	c.l = jobLogger
+
+	err := endErr
+	if err == nil {
+		err = phase.Error()
+	}
+	if err == nil && interrupted {
+		err = ErrInterrupted
+	}
+	outcome := OnSuccess
+	if err != nil {
+		outcome = OnFailure
+	}
+	for _, h := range c.handlers[outcome] {
+		if hErr := h(err); hErr != nil {
+			c.l.Chan() <- Event{
+				Source:  "Coordinator",
+				Level:   Error,
+				Time:    time.Now(),
+				Message: fmt.Sprintf("Error running the %s blocks: %v", outcome, hErr),
+			}
+		}
+	}
+	return err
+}
+
+//run executes the job, then commits it or rolls it back. It returns whether the job was interrupted by the
+//context, and the error of the job's start, commit or rollback, if any.
+func (c *coordinator) run() (bool, error) {
 	var wg sync.WaitGroup
 	var interrupted bool
 	var done = make(chan bool, 1)
@@ -312,7 +396,8 @@ func (c *coordinator) Execute() error {
 			upstream = n.name
 		case *sourceNode:
 			if err := n.s.Ping(); err != nil {
-				return err
+				done <- true
+				return false, err
 			}
 			wg.Add(1)
 			go func(name string) {
@@ -343,7 +428,11 @@ func (c *coordinator) Execute() error {
 			// upstream -> downstream(s)
 			// becomes
 			// upstream -> test node -> downstream(s)
-			c.tests[upstream].Open(c.streams[upstream], c.testStreams[upstream], c.l, c.s)
+			wg.Add(1)
+			go func(tn *testNode, name string) {
+				tn.Open(c.streams[name], c.testStreams[name], c.l, c.s)
+				wg.Done()
+			}(c.tests[upstream], upstream)
 		}
 
 		if len(neighbors) > 0 {
@@ -371,7 +460,8 @@ func (c *coordinator) Execute() error {
 				}(d.name)
 			case *destinationNode:
 				if err := d.d.Ping(); err != nil {
-					return err
+					done <- true
+					return false, err
 				}
 				wg.Add(1)
 				go func(name string) {
@@ -398,18 +488,7 @@ func (c *coordinator) Execute() error {
 	} else {
 		endErr = c.txManager.Commit()
 	}
-	close(c.l.Chan())
-	if endErr != nil {
-		return endErr
-	}
-	c.l.Wait()
-	if loggedErr := c.l.Error(); loggedErr != nil {
-		return loggedErr
-	}
-	if interrupted {
-		return ErrInterrupted
-	}
-	return nil
+	return interrupted, endErr
 }
 
 func (c *coordinator) getNodeName(node graph.Node) string {
@@ -459,6 +538,7 @@ func NewCoordinator(logger Logger, txManager TransactionManager) Coordinator {
 		constraintMap:    make(map[string][]string),
 		constraintMapRev: make(map[string][]string),
 		txManager:        txManager,
+		handlers:         make(map[Outcome][]Handler),
 	}
 }
 
@@ -531,10 +611,16 @@ func (c *coordinator) AddTransform(name string, alias string, t Transform) error
 	return nil
 }
 
-//AddTest is a shortcut that adds a test destination
+//AddTest is a shortcut that adds a test destination. Tests without a name support other
+//tests, for example by collecting referenced values, and they are not reported.
 func (c *coordinator) AddTest(node string, name string, desc string, co Condition) error {
+	return c.AddTestWithSeverity(node, name, desc, SeverityError, co)
+}
+
+//AddTestWithSeverity adds a test destination whose failures are handled according to the severity.
+func (c *coordinator) AddTestWithSeverity(node string, name string, desc string, severity TestSeverity, co Condition) error {
 	if tn, ok := c.tests[node]; ok {
-		tn.Add(name, desc, co)
+		tn.Add(name, desc, severity, co)
 		return nil
 	}
 
@@ -542,8 +628,8 @@ func (c *coordinator) AddTest(node string, name string, desc string, co Conditio
 		return fmt.Errorf("name does not exist %s", node)
 	}
 
-	tn := testNode{}
-	tn.Add(name, desc, co)
+	tn := testNode{block: node}
+	tn.Add(name, desc, severity, co)
 	c.tests[node] = &tn
 	c.testStreams[node] = NewStream(nil, DefaultBufferSize)
 	return nil
@@ -565,3 +651,152 @@ func (c *coordinator) Connect(from string, to string) error {
 	c.g.SetEdge(simple.Edge{c.nodeIds[from], c.nodeIds[to], 1})
 	return nil
 }
+
+//Upstream returns the given nodes along with all the nodes that they are connected from,
+//directly or indirectly, ie. the part of the job that produces their input.
+func (c *coordinator) Upstream(names ...string) ([]string, error) {
+	var (
+		ret     []string
+		visited = make(map[int]bool)
+		stack   []graph.Node
+	)
+	for _, name := range names {
+		node, ok := c.nodeIds[name]
+		if !ok {
+			return nil, fmt.Errorf("name does not exist %s", name)
+		}
+		stack = append(stack, node)
+	}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[node.ID()] {
+			continue
+		}
+		visited[node.ID()] = true
+		ret = append(ret, c.getNodeName(node))
+		stack = append(stack, c.g.To(node)...)
+	}
+	return ret, nil
+}
+
+//Prerequisites is like Upstream, but it also follows AFTER constraints, so that it returns all the nodes that
+//have to run for the given nodes to get the same input as in the whole job, such as a query that writes the
+//table that they read, along with its destinations.
+func (c *coordinator) Prerequisites(names ...string) ([]string, error) {
+	var (
+		ret     []string
+		visited = make(map[int]bool)
+		stack   []graph.Node
+	)
+	for _, name := range names {
+		node, ok := c.nodeIds[name]
+		if !ok {
+			return nil, fmt.Errorf("name does not exist %s", name)
+		}
+		stack = append(stack, node)
+	}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[node.ID()] {
+			continue
+		}
+		visited[node.ID()] = true
+		name := c.getNodeName(node)
+		ret = append(ret, name)
+		stack = append(stack, c.g.To(node)...)
+		for _, before := range c.constraintMap[name] {
+			stack = append(stack, c.nodeIds[before])
+		}
+	}
+	return ret, nil
+}
+
+//Downstream returns the given nodes along with all the nodes that they are connected to,
+//directly or indirectly, ie. the part of the job that consumes their output.
+func (c *coordinator) Downstream(names ...string) ([]string, error) {
+	var (
+		ret     []string
+		visited = make(map[int]bool)
+		stack   []graph.Node
+	)
+	for _, name := range names {
+		node, ok := c.nodeIds[name]
+		if !ok {
+			return nil, fmt.Errorf("name does not exist %s", name)
+		}
+		stack = append(stack, node)
+	}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[node.ID()] {
+			continue
+		}
+		visited[node.ID()] = true
+		ret = append(ret, c.getNodeName(node))
+		stack = append(stack, c.g.From(node)...)
+	}
+	return ret, nil
+}
+
+//Destinations returns the destinations that the given nodes are directly connected to.
+func (c *coordinator) Destinations(names ...string) ([]string, error) {
+	var ret []string
+	for _, name := range names {
+		node, ok := c.nodeIds[name]
+		if !ok {
+			return nil, fmt.Errorf("name does not exist %s", name)
+		}
+		for _, to := range c.g.From(node) {
+			toName := c.getNodeName(to)
+			if _, ok := c.destinations[toName]; ok {
+				ret = append(ret, toName)
+			}
+		}
+	}
+	return ret, nil
+}
+
+//Retain removes all the nodes except the given ones, along with their tests and any
+//constraints that involve them, so that only part of the job is executed. It should be
+//called before Compile().
+func (c *coordinator) Retain(names ...string) error {
+	keep := make(map[string]bool)
+	for _, name := range names {
+		if _, ok := c.nodes[name]; !ok {
+			return fmt.Errorf("name does not exist %s", name)
+		}
+		keep[name] = true
+	}
+	for name := range c.nodes {
+		if keep[name] {
+			continue
+		}
+		node := c.nodeIds[name]
+		c.g.RemoveNode(node)
+		delete(c.nodeIdsRev, node.ID())
+		delete(c.nodeIds, name)
+		delete(c.nodes, name)
+		delete(c.sources, name)
+		delete(c.destinations, name)
+		delete(c.transformations, name)
+		delete(c.streams, name)
+		delete(c.tests, name)
+		delete(c.testStreams, name)
+	}
+	var constraints []constraint
+	c.constraintMap = make(map[string][]string)
+	c.constraintMapRev = make(map[string][]string)
+	for _, con := range c.constraints {
+		if !keep[con.Before] || !keep[con.After] {
+			continue
+		}
+		constraints = append(constraints, con)
+		c.constraintMap[con.After] = append(c.constraintMap[con.After], con.Before)
+		c.constraintMapRev[con.Before] = append(c.constraintMapRev[con.Before], con.After)
+	}
+	c.constraints = constraints
+	return nil
+}
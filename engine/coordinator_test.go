@@ -2,6 +2,7 @@ package engine
 
 import (
 	"context"
+	"errors"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 )
@@ -207,10 +208,133 @@ func TestTester(t *testing.T) {
 			So(err, ShouldNotBeNil)
 			So(d.Results(), ShouldHaveLength, 0)
 		})
+		Convey("It should log the result of the test along with the failing row", func() {
+			rl := NewTestResultLogger(l)
+			c := NewCoordinator(rl, tx)
+			s := NewSliceSource(cols, msg)
+			s.SetName("s")
+			d := SliceDestination{Alias: "d"}
+			err := c.AddSource("source", "s", s)
+			So(err, ShouldBeNil)
+			err = c.AddTest("source", "failed test", "always failing test", failTester)
+			So(err, ShouldBeNil)
+			err = c.AddDestination("destination", "d", &d)
+			err = c.Connect("source", "destination")
+			So(err, ShouldBeNil)
+			err = c.Compile()
+			So(err, ShouldBeNil)
+			err = c.Execute()
+			So(err, ShouldNotBeNil)
+			results := rl.Results()
+			So(results, ShouldHaveLength, 1)
+			So(results[0].Block, ShouldEqual, "source")
+			So(results[0].Name, ShouldEqual, "failed test")
+			So(results[0].Description, ShouldEqual, "always failing test")
+			So(results[0].Status, ShouldEqual, TestFailed)
+			So(results[0].Row, ShouldResemble, map[string]interface{}{"1": "a", "2": "b", "3": "c"})
+		})
+		Convey("It should report the tests that were not evaluated after a failure as skipped", func() {
+			rl := NewTestResultLogger(l)
+			c := NewCoordinator(rl, tx)
+			s := NewSliceSource(cols, msg)
+			s.SetName("s")
+			err := c.AddSource("source", "s", s)
+			So(err, ShouldBeNil)
+			err = c.AddTest("source", "failed test", "always failing test", failTester)
+			So(err, ShouldBeNil)
+			err = c.AddTest("source", "other test", "always passing test", func(map[string]interface{}, bool) bool {
+				return true
+			})
+			So(err, ShouldBeNil)
+			d := SliceDestination{Alias: "d"}
+			err = c.AddDestination("destination", "d", &d)
+			So(err, ShouldBeNil)
+			err = c.Connect("source", "destination")
+			So(err, ShouldBeNil)
+			err = c.Compile()
+			So(err, ShouldBeNil)
+			err = c.Execute()
+			So(err, ShouldNotBeNil)
+			results := rl.Results()
+			So(results, ShouldHaveLength, 2)
+			So(results[0].Status, ShouldEqual, TestFailed)
+			So(results[1].Name, ShouldEqual, "other test")
+			So(results[1].Status, ShouldEqual, TestSkipped)
+			So(results[1].Message, ShouldEqual, "not evaluated because failed test failed")
+		})
+		Convey("It should not stop stream if a warning test fails", func() {
+			s := NewSliceSource(cols, msg)
+			s.SetName("s")
+			d := SliceDestination{Alias: "d"}
+			err := c.AddSource("source", "s", s)
+			So(err, ShouldBeNil)
+			err = c.AddTestWithSeverity("source", "failed test", "always failing test", SeverityWarning, failTester)
+			So(err, ShouldBeNil)
+			err = c.AddDestination("destination", "d", &d)
+			err = c.Connect("source", "destination")
+			So(err, ShouldBeNil)
+			err = c.Compile()
+			So(err, ShouldBeNil)
+			err = c.Execute()
+			So(err, ShouldBeNil)
+			So(d.Results(), ShouldHaveLength, 2)
+		})
 
 	})
 }
 
+func TestHandlers(t *testing.T) {
+	Convey("Given a coordinator with handlers", t, func() {
+		l := NewConsoleLogger(Trace)
+		tx := NewTransactionManager(l)
+		c := NewCoordinator(l, tx)
+		msg := [][]interface{}{[]interface{}{"a", "b", "c"}, []interface{}{"d", "e", "f"}}
+		cols := []string{"1", "2", "3"}
+		s := NewSliceSource(cols, msg)
+		s.SetName("s")
+		d := SliceDestination{Alias: "d"}
+		So(c.AddSource("source", "s", s), ShouldBeNil)
+		So(c.AddDestination("destination", "d", &d), ShouldBeNil)
+		So(c.Connect("source", "destination"), ShouldBeNil)
+		var succeeded, failed []error
+		c.AddHandler(OnSuccess, func(err error) error {
+			succeeded = append(succeeded, err)
+			return nil
+		})
+		c.AddHandler(OnFailure, func(err error) error {
+			failed = append(failed, err)
+			return nil
+		})
+		Convey("It should run the success handlers after a successful job", func() {
+			So(c.Compile(), ShouldBeNil)
+			So(c.Execute(), ShouldBeNil)
+			So(d.Results(), ShouldHaveLength, 2)
+			So(succeeded, ShouldResemble, []error{nil})
+			So(failed, ShouldBeEmpty)
+		})
+		Convey("It should run the failure handlers with the error of a failed job", func() {
+			So(c.AddTest("source", "failed test", "always failing test", func(map[string]interface{}, bool) bool {
+				return false
+			}), ShouldBeNil)
+			So(c.Compile(), ShouldBeNil)
+			err := c.Execute()
+			So(err, ShouldNotBeNil)
+			So(succeeded, ShouldBeEmpty)
+			So(failed, ShouldHaveLength, 1)
+			So(failed[0].Error(), ShouldEqual, err.Error())
+		})
+		Convey("It should fail the job if a handler fails", func() {
+			c.AddHandler(OnSuccess, func(error) error {
+				return errors.New("could not clean up")
+			})
+			So(c.Compile(), ShouldBeNil)
+			err := c.Execute()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "could not clean up")
+		})
+	})
+}
+
 func TestCancellation(t *testing.T) {
 	Convey("Given a coordinator and context that cancels straight away", t, func() {
 		l := NewConsoleLogger(Trace)
@@ -306,3 +430,58 @@ func TestNamedStreams(t *testing.T) {
 		})
 	})
 }
+
+func TestCoordinatorRetainUpstream(t *testing.T) {
+	Convey("Given a coordinator with two independent flows", t, func() {
+		l := NewConsoleLogger(Trace)
+		tx := NewTransactionManager(l)
+		c := NewCoordinator(l, tx)
+		msg := [][]interface{}{[]interface{}{"a", "b", "c"}}
+		cols := []string{"1", "2", "3"}
+		d1 := SliceDestination{Alias: "slice1"}
+		d2 := SliceDestination{Alias: "slice2"}
+		So(c.AddSource("source1", "source1", NewSliceSource(cols, msg)), ShouldBeNil)
+		So(c.AddSource("source2", "source2", NewSliceSource(cols, msg)), ShouldBeNil)
+		So(c.AddDestination("destination1", "slice1", &d1), ShouldBeNil)
+		So(c.AddDestination("destination2", "slice2", &d2), ShouldBeNil)
+		So(c.Connect("source1", "destination1"), ShouldBeNil)
+		So(c.Connect("source2", "destination2"), ShouldBeNil)
+		So(c.AddConstraint("source2", "source1"), ShouldBeNil)
+		Convey("It should only execute the retained nodes", func() {
+			upstream, err := c.Upstream("destination1")
+			So(err, ShouldBeNil)
+			So(upstream, ShouldHaveLength, 2)
+			So(upstream, ShouldContain, "source1")
+			So(c.Retain(upstream...), ShouldBeNil)
+			So(c.Compile(), ShouldBeNil)
+			So(c.Execute(), ShouldBeNil)
+			So(d1.Results(), ShouldResemble, msg)
+			So(d2.Results(), ShouldBeEmpty)
+		})
+		Convey("It should execute the nodes that the retained nodes run after, with their destinations", func() {
+			prerequisites, err := c.Prerequisites("destination1")
+			So(err, ShouldBeNil)
+			So(prerequisites, ShouldHaveLength, 4)
+			So(prerequisites, ShouldContain, "source2")
+			So(prerequisites, ShouldContain, "destination2")
+			So(c.Retain(prerequisites...), ShouldBeNil)
+			So(c.Compile(), ShouldBeNil)
+			So(c.Execute(), ShouldBeNil)
+			So(d1.Results(), ShouldResemble, msg)
+			So(d2.Results(), ShouldResemble, msg)
+		})
+		Convey("It should fail to retain nodes that do not exist", func() {
+			So(c.Retain("source3"), ShouldNotBeNil)
+		})
+		Convey("It should find the downstream nodes and the destinations", func() {
+			downstream, err := c.Downstream("source2")
+			So(err, ShouldBeNil)
+			So(downstream, ShouldResemble, []string{"source2", "destination2"})
+			destinations, err := c.Destinations("source1", "source2")
+			So(err, ShouldBeNil)
+			So(destinations, ShouldResemble, []string{"destination1", "destination2"})
+			_, err = c.Downstream("source3")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	colors "github.com/logrusorgru/aurora"
 	"io"
+	"os"
+	"sync"
 	"time"
 	"errors"
 )
@@ -45,6 +47,7 @@ type Event struct {
 	Source  string
 	Level   LogLevel
 	Message string
+	Test    *TestResult //set if the event logs the result of a test
 }
 
 type Logger interface {
@@ -63,6 +66,7 @@ type ConsoleLogger struct {
 	latestError error
 	waitChan chan bool
 	c        chan Event
+	w        io.Writer
 }
 
 type GenericLogger struct {
@@ -111,10 +115,17 @@ func (gl *GenericLogger) Wait(){
 }
 
 func NewConsoleLogger(minLevel LogLevel) *ConsoleLogger {
+	return NewConsoleLoggerTo(minLevel, os.Stdout)
+}
+
+//NewConsoleLoggerTo returns a console logger that writes to w rather than STDOUT, for example to STDERR
+//when STDOUT is used for something else.
+func NewConsoleLoggerTo(minLevel LogLevel, w io.Writer) *ConsoleLogger {
 	cl := ConsoleLogger{
 		MinLevel: minLevel,
 		waitChan: make(chan bool, 1),
 		c:        make(chan Event, DefaultBufferSize),
+		w:        w,
 	}
 
 	go func() {
@@ -123,7 +134,7 @@ func NewConsoleLogger(minLevel LogLevel) *ConsoleLogger {
 				cl.latestError = errors.New(event.Message)
 			}
 			if event.Level >= cl.MinLevel {
-				fmt.Println(eventTypeColors[event.Level](eventTypeMap[event.Level]), event.Time.Format(timeFormat), "- ("+event.Source+")", event.Message)
+				fmt.Fprintln(cl.w, eventTypeColors[event.Level](eventTypeMap[event.Level]), event.Time.Format(timeFormat), "- ("+event.Source+")", event.Message)
 			}
 		}
 		cl.waitChan <- true
@@ -143,4 +154,94 @@ func (cl *ConsoleLogger) Error() error {
 
 func (cl *ConsoleLogger) Wait() {
 	<- cl.waitChan
-}
\ No newline at end of file
+}
+
+//TestResultLogger forwards events to another logger and keeps the results of the tests that they log.
+type TestResultLogger struct {
+	Logger
+	sync.Mutex
+	c       chan Event
+	results []TestResult
+	done    chan bool
+}
+
+func NewTestResultLogger(l Logger) *TestResultLogger {
+	tl := TestResultLogger{
+		Logger: l,
+		c:      make(chan Event, DefaultBufferSize),
+		done:   make(chan bool, 1),
+	}
+
+	go func() {
+		for event := range tl.c {
+			if event.Test != nil {
+				tl.Lock()
+				tl.results = append(tl.results, *event.Test)
+				tl.Unlock()
+			}
+			tl.Logger.Chan() <- event
+		}
+		close(tl.Logger.Chan())
+		tl.done <- true
+	}()
+
+	return &tl
+}
+
+func (tl *TestResultLogger) Chan() chan<- Event {
+	return tl.c
+}
+
+func (tl *TestResultLogger) Wait() {
+	<-tl.done
+	tl.Logger.Wait()
+}
+
+//Results returns the results of the tests in the order that they were logged.
+func (tl *TestResultLogger) Results() []TestResult {
+	tl.Lock()
+	defer tl.Unlock()
+	return append([]TestResult(nil), tl.results...)
+}
+
+//phaseLogger forwards the events of a phase of a job to the job's logger, and keeps track of the latest
+//error that was logged during the phase. Unlike the other loggers, it leaves the job's logger open.
+type phaseLogger struct {
+	Logger
+	c           chan Event
+	latestError error
+	done        chan bool
+}
+
+func newPhaseLogger(l Logger) *phaseLogger {
+	pl := phaseLogger{
+		Logger: l,
+		c:      make(chan Event, DefaultBufferSize),
+		done:   make(chan bool, 1),
+	}
+
+	go func() {
+		for event := range pl.c {
+			if event.Level == Error {
+				pl.latestError = errors.New(event.Message)
+			}
+			pl.Logger.Chan() <- event
+		}
+		pl.done <- true
+	}()
+
+	return &pl
+}
+
+func (pl *phaseLogger) Chan() chan<- Event {
+	return pl.c
+}
+
+func (pl *phaseLogger) Error() error {
+	return pl.latestError
+}
+
+//Wait blocks until the events of the phase have been forwarded. The sender should close the chan first.
+func (pl *phaseLogger) Wait() {
+	<-pl.done
+}
@@ -36,6 +36,13 @@ func (p *ParameterTable) Declare(name string) error {
 	return nil
 }
 
+//Declared returns whether the parameter has been declared, regardless of whether it has been set.
+func (p *ParameterTable) Declared(name string) bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.allowedNames[strings.ToLower(name)]
+}
+
 func (p *ParameterTable) Get(name string) (interface{}, bool) {
 	p.Lock()
 	defer p.Unlock()
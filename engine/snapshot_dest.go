@@ -0,0 +1,252 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//MaxSnapshotDiffLines is the maximum number of differences that are reported when a block's output
+//does not match its snapshot.
+const MaxSnapshotDiffLines = 20
+
+//Snapshot is the stored output of a block.
+type Snapshot struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+//ReadSnapshot reads a snapshot from a JSON file.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+//Write writes the snapshot to a JSON file, creating its directory if needed.
+func (s *Snapshot) Write(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+//Diff returns the differences between the snapshot and the actual output, one per line. Rows are matched
+//by the values of their key columns, so that changed values are reported by column. Without keys, rows are
+//matched by all their values, so a changed row is reported as a removed row and an added row.
+//The order of the rows is ignored.
+func (s *Snapshot) Diff(actual *Snapshot, keys []string) ([]string, error) {
+	if !sameColumns(s.Columns, actual.Columns) {
+		return []string{fmt.Sprintf("columns changed from %v to %v", s.Columns, actual.Columns)}, nil
+	}
+	var keyIxs []int
+	for _, key := range keys {
+		ix, ok := findColumn(s.Columns, key)
+		if !ok {
+			return nil, fmt.Errorf("key column %s is not in the snapshot", key)
+		}
+		keyIxs = append(keyIxs, ix)
+	}
+	if len(keyIxs) == 0 {
+		for i := range s.Columns {
+			keyIxs = append(keyIxs, i)
+		}
+	}
+
+	var (
+		diff     []string
+		expected = make(map[string][][]string)
+		order    []string
+	)
+	for _, row := range s.Rows {
+		cells := snapshotCells(row)
+		key := snapshotKey(s.Columns, keyIxs, cells)
+		if _, ok := expected[key]; !ok {
+			order = append(order, key)
+		}
+		expected[key] = append(expected[key], cells)
+	}
+	for _, row := range actual.Rows {
+		cells := snapshotCells(row)
+		key := snapshotKey(s.Columns, keyIxs, cells)
+		if len(expected[key]) == 0 {
+			diff = append(diff, "+ "+snapshotRow(cells))
+			continue
+		}
+		want := expected[key][0]
+		expected[key] = expected[key][1:]
+		for i := range cells {
+			if cells[i] != want[i] {
+				diff = append(diff, fmt.Sprintf("~ %s: %s changed from %s to %s", key, s.Columns[i], want[i], cells[i]))
+			}
+		}
+	}
+	for _, key := range order {
+		for _, cells := range expected[key] {
+			diff = append(diff, "- "+snapshotRow(cells))
+		}
+	}
+	return diff, nil
+}
+
+//snapshotCells returns the JSON representation of each value of the row, so that values read from a
+//snapshot compare equal to the values that they were written from.
+func snapshotCells(row []interface{}) []string {
+	ret := make([]string, len(row))
+	for i := range row {
+		b, err := json.Marshal(row[i])
+		if err != nil {
+			ret[i] = fmt.Sprintf("%v", row[i])
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err == nil {
+			b, _ = json.Marshal(v)
+		}
+		ret[i] = string(b)
+	}
+	return ret
+}
+
+func snapshotKey(cols []string, keyIxs []int, cells []string) string {
+	var s []string
+	for _, ix := range keyIxs {
+		if ix >= len(cells) {
+			continue
+		}
+		s = append(s, cols[ix]+"="+cells[ix])
+	}
+	return strings.Join(s, ", ")
+}
+
+func snapshotRow(cells []string) string {
+	return "[" + strings.Join(cells, ", ") + "]"
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if strings.ToLower(a[i]) != strings.ToLower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func findColumn(cols []string, col string) (int, bool) {
+	for i := range cols {
+		if strings.ToLower(cols[i]) == strings.ToLower(col) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+//SnapshotDestination collects the full output of a block and compares it against a snapshot once the block
+//is done. The comparison is reported as a test result. If Update is true, the snapshot is overwritten instead.
+type SnapshotDestination struct {
+	SliceDestination
+	Block  string
+	Name   string
+	Path   string
+	Keys   []string
+	Update bool
+}
+
+func (sd *SnapshotDestination) Ping() error { return nil }
+
+func (sd *SnapshotDestination) Open(s Stream, l Logger, st Stopper) {
+	sd.SliceDestination.Open(s, l, st)
+	if st.Stopped() {
+		return
+	}
+	cols := s.Columns()
+	if cols == nil {
+		cols = []string{}
+	}
+	rows := sd.Results()
+	if rows == nil {
+		rows = [][]interface{}{}
+	}
+	actual := Snapshot{Columns: cols, Rows: rows}
+
+	if sd.Update {
+		if err := actual.Write(sd.Path); err != nil {
+			sd.report(l, st, TestFailed, fmt.Sprintf("could not write snapshot %s: %v", sd.Path, err))
+			return
+		}
+		l.Chan() <- Event{
+			Source:  sd.Name,
+			Level:   Info,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("Updated snapshot %s with %v rows", sd.Path, len(rows)),
+		}
+		return
+	}
+
+	expected, err := ReadSnapshot(sd.Path)
+	if os.IsNotExist(err) {
+		sd.report(l, st, TestFailed, fmt.Sprintf("snapshot %s does not exist, run analyst test --update-snapshots to create it", sd.Path))
+		return
+	}
+	if err != nil {
+		sd.report(l, st, TestFailed, err.Error())
+		return
+	}
+	diff, err := expected.Diff(&actual, sd.Keys)
+	if err != nil {
+		sd.report(l, st, TestFailed, err.Error())
+		return
+	}
+	if len(diff) == 0 {
+		sd.report(l, st, TestPassed, "")
+		return
+	}
+	n := len(diff)
+	if n > MaxSnapshotDiffLines {
+		diff = append(diff[:MaxSnapshotDiffLines], fmt.Sprintf("... and %v more differences", n-MaxSnapshotDiffLines))
+	}
+	sd.report(l, st, TestFailed, fmt.Sprintf("output does not match snapshot %s (%v differences):\n%s", sd.Path, n, strings.Join(diff, "\n")))
+}
+
+//report logs the result of the comparison, stopping the job if it failed.
+func (sd *SnapshotDestination) report(l Logger, st Stopper, status string, message string) {
+	level := Info
+	msg := fmt.Sprintf("[%s] %s", status, sd.Name)
+	if status == TestFailed {
+		level = Error
+		msg += ": " + message
+	}
+	l.Chan() <- Event{
+		Source:  "Test node",
+		Level:   level,
+		Time:    time.Now(),
+		Message: msg,
+		Test: &TestResult{
+			Block:       sd.Block,
+			Name:        sd.Name,
+			Description: "matches snapshot " + sd.Path,
+			Status:      status,
+			Message:     message,
+		},
+	}
+	if status == TestFailed {
+		st.Stop()
+	}
+}
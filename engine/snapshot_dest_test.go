@@ -0,0 +1,56 @@
+package engine
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	Convey("Given a snapshot", t, func() {
+		expected := Snapshot{
+			Columns: []string{"Id", "Name"},
+			Rows:    [][]interface{}{{1.0, "a"}, {2.0, "b"}, {3.0, "c"}},
+		}
+		Convey("It should not report differences for the same rows in a different order", func() {
+			actual := Snapshot{
+				Columns: []string{"id", "name"},
+				Rows:    [][]interface{}{{int64(3), "c"}, {int64(1), "a"}, {int64(2), "b"}},
+			}
+			diff, err := expected.Diff(&actual, nil)
+			So(err, ShouldBeNil)
+			So(diff, ShouldBeEmpty)
+		})
+		Convey("It should report changed values by key", func() {
+			actual := Snapshot{
+				Columns: []string{"Id", "Name"},
+				Rows:    [][]interface{}{{1, "a"}, {2, "x"}, {4, "d"}},
+			}
+			diff, err := expected.Diff(&actual, []string{"Id"})
+			So(err, ShouldBeNil)
+			So(diff, ShouldResemble, []string{
+				`~ Id=2: Name changed from "b" to "x"`,
+				`+ [4, "d"]`,
+				`- [3, "c"]`,
+			})
+		})
+		Convey("It should report changed rows as removed and added without keys", func() {
+			actual := Snapshot{
+				Columns: []string{"Id", "Name"},
+				Rows:    [][]interface{}{{1, "a"}, {2, "x"}, {3, "c"}},
+			}
+			diff, err := expected.Diff(&actual, nil)
+			So(err, ShouldBeNil)
+			So(diff, ShouldResemble, []string{`+ [2, "x"]`, `- [2, "b"]`})
+		})
+		Convey("It should report changed columns", func() {
+			actual := Snapshot{Columns: []string{"Id"}}
+			diff, err := expected.Diff(&actual, nil)
+			So(err, ShouldBeNil)
+			So(diff, ShouldHaveLength, 1)
+		})
+		Convey("It should fail if a key is not a column", func() {
+			_, err := expected.Diff(&expected, []string{"Missing"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
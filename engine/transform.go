@@ -21,10 +21,67 @@ type SequenceableTransform interface {
 	Sequenceable
 }
 
+//MultiSourceTransform is a transform that needs to know how many upstream nodes
+//it will be opened with, for example to know when all of its inputs are exhausted.
+//The coordinator sets the count at Compile() time.
+type MultiSourceTransform interface {
+	Transform
+	SetSourceCount(n int)
+}
+
+//ParametrizedTransform is a transform that reads declared parameters at runtime, for
+//example a salt that should not be written in the script. The compiler sets the parameter
+//table before the transform is opened, and the transform returns an error if it references
+//parameters that have not been declared. The compiler makes the transform run after the
+//queries that set the parameters that it reads.
+type ParametrizedTransform interface {
+	Transform
+	SetParameterTable(p *ParameterTable) error
+	Parameters() []string
+}
+
+//CheckedTransform is a transform whose options are checked by the compiler once they have been
+//scanned, so that invalid values are reported at compile time rather than when the job runs.
+type CheckedTransform interface {
+	Transform
+	CheckOptions() error
+}
+
+//TestSeverity determines what happens when a test fails.
+type TestSeverity int
+
+const (
+	//SeverityError stops the job on failure, which rolls back its transactions
+	SeverityError TestSeverity = iota
+	//SeverityWarning logs a warning on failure and lets the job continue
+	SeverityWarning
+)
+
+//Statuses of test results.
+const (
+	TestPassed  = "PASS"
+	TestFailed  = "FAIL"
+	TestWarning = "WARN"
+	TestSkipped = "SKIP"
+)
+
+//TestResult is the result of a test, which is attached to the event that logs it.
+type TestResult struct {
+	Block       string
+	Name        string
+	Description string
+	Status      string
+	Message     string
+	//Row is the first row that failed the test. It is nil if the test passed or failed once all the rows were read.
+	Row map[string]interface{}
+}
+
 type testNode struct {
+	block        string
 	names        []string
 	descs        []string
 	conds        []Condition
+	severities   []TestSeverity
 	outgoingName string
 }
 
@@ -32,9 +89,10 @@ func (tn *testNode) SetName(name string) {
 	tn.outgoingName = name
 }
 
-func (tn *testNode) Add(name string, desc string, cond Condition) {
+func (tn *testNode) Add(name string, desc string, severity TestSeverity, cond Condition) {
 	tn.names = append(tn.names, name)
 	tn.descs = append(tn.descs, desc)
+	tn.severities = append(tn.severities, severity)
 	tn.conds = append(tn.conds, cond)
 }
 
@@ -64,9 +122,50 @@ func (tn *testNode) log(l Logger, level LogLevel, msg string) {
 	}
 }
 
+//report logs the result of the i-th test. The row is the first row that failed the test, if any.
+func (tn *testNode) report(l Logger, i int, status string, message string, row map[string]interface{}) {
+	level := Info
+	switch status {
+	case TestFailed:
+		level = Error
+	case TestWarning:
+		level = Warning
+	}
+	l.Chan() <- Event{
+		Time:    time.Now(),
+		Source:  "Test node",
+		Message: fmt.Sprintf("[%s] %s", status, tn.names[i]),
+		Level:   level,
+		Test: &TestResult{
+			Block:       tn.block,
+			Name:        tn.names[i],
+			Description: tn.descs[i],
+			Status:      status,
+			Message:     message,
+			Row:         row,
+		},
+	}
+}
+
+//skip reports the named tests that will not be evaluated because the i-th test failed and stopped the job.
+//Tests that have already reported a warning are not reported again.
+func (tn *testNode) skip(l Logger, i int, warned []bool) {
+	message := fmt.Sprintf("not evaluated because %s failed", tn.names[i])
+	if tn.names[i] == "" {
+		message = "not evaluated because another assertion failed"
+	}
+	for j := range tn.conds {
+		if j != i && !warned[j] && tn.names[j] != "" {
+			tn.report(l, j, TestSkipped, message, nil)
+		}
+	}
+}
+
 func (tn *testNode) Open(s Stream, dest Stream, l Logger, st Stopper) {
 	var firstMessage = true
 	var converter func([]interface{}) map[string]interface{}
+	var rows int
+	warned := make([]bool, len(tn.conds))
 	d := dest.Chan(tn.outgoingName)
 	for msg := range s.Chan(tn.outgoingName) {
 		tn.log(l, Trace, fmt.Sprintf("Found message %s", msg.Data))
@@ -76,28 +175,56 @@ func (tn *testNode) Open(s Stream, dest Stream, l Logger, st Stopper) {
 			firstMessage = false
 		}
 		mappedMsg := converter(msg.Data)
+		rows++
 		for i := range tn.conds {
+			if warned[i] {
+				continue
+			}
 			//execute tests with EOF = false
-			if !tn.conds[i](mappedMsg, false) {
-				tn.log(l, Error, fmt.Sprintf("[FAIL] %s", tn.names[i]))
-				st.Stop()
-				close(d)
-				return //a test should stop the job on first failure
+			if tn.conds[i](mappedMsg, false) {
+				continue
+			}
+			message := fmt.Sprintf("assertion failed on row %d", rows)
+			if tn.severities[i] == SeverityWarning {
+				//warnings are only reported once, on the first failure
+				tn.report(l, i, TestWarning, message, mappedMsg)
+				warned[i] = true
+				continue
+			}
+			tn.report(l, i, TestFailed, message, mappedMsg)
+			tn.skip(l, i, warned)
+			st.Stop()
+			close(d)
+			//release unnamed conditions, as other tests may be waiting for them
+			for j := range tn.conds {
+				if tn.names[j] == "" {
+					tn.conds[j](nil, true)
+				}
 			}
+			return //a test should stop the job on first failure
 		}
 		d <- msg
 	}
+	//downstream nodes don't need to wait for the EOF tests, and they may be referenced by them
+	close(d)
 	//execute tests with EOF = true
+	message := fmt.Sprintf("assertion failed after all %d rows were read", rows)
 	for i := range tn.conds {
-		if !tn.conds[i](nil, true){
-			tn.log(l, Error, fmt.Sprintf("[FAIL] %s", tn.names[i]))
+		if warned[i] {
+			continue
+		}
+		passed := tn.conds[i](nil, true)
+		switch {
+		case !passed && tn.severities[i] == SeverityWarning:
+			tn.report(l, i, TestWarning, message, nil)
+		case !passed:
+			tn.report(l, i, TestFailed, message, nil)
 			st.Stop()
-			close(d)
-		} else {
-			tn.log(l, Info, fmt.Sprintf("[PASS] %s", tn.names[i]))
+		case tn.names[i] != "":
+			//unnamed conditions support other tests and are not reported
+			tn.report(l, i, TestPassed, "", nil)
 		}
 	}
-	close(d)
 }
 
 type Passthrough struct {
@@ -0,0 +1,306 @@
+package analyst
+
+import (
+	"fmt"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//MaskedValue replaces secrets in explain plans.
+const MaskedValue = "*****"
+
+//Scopes that the effective value of an option comes from.
+const (
+	OptionScopeBlock      = "block"
+	OptionScopeConnection = "connection"
+	OptionScopeGlobal     = "global"
+)
+
+var (
+	secretKey         = regexp.MustCompile(`(?i)(password|pwd|secret|token|api_?key|credentials)`)
+	secretKeyValue    = regexp.MustCompile(`(?i)((?:password|pwd|secret|token|api_?key)\s*=\s*)('[^']*'|"[^"]*"|[^;\s]*)`)
+	secretUserInfoDSN = regexp.MustCompile(`^([^:/@]+):([^@]*)@`)
+)
+
+//Plan describes how a job will be executed, without executing it.
+type Plan struct {
+	Blocks []BlockPlan
+	//Waves are the names of the blocks that start together, in order. A block starts in the wave after
+	//the last of the blocks that it runs AFTER.
+	Waves [][]string
+}
+
+//BlockPlan describes how a block will be executed.
+type BlockPlan struct {
+	Kind string
+	Name string
+	//Content is the query or transform, once external content and parameters are resolved.
+	Content     string
+	Wave        int
+	After       []string
+	Connections []ConnectionPlan
+	//Options are the effective options of the block, outside of any connection namespace.
+	Options    []EffectiveOption
+	Upstream   []string
+	Downstream []string
+}
+
+//ConnectionPlan describes a connection used by a block, along with the effective options of the block
+//for that connection. Secrets are masked.
+type ConnectionPlan struct {
+	Name             string
+	Driver           string
+	ConnectionString string
+	Options          []EffectiveOption
+}
+
+//EffectiveOption is the value of an option, once it has been overridden, and the scope that it comes from.
+type EffectiveOption struct {
+	Key   string
+	Value string
+	Scope string
+}
+
+//ExplainString compiles the script without executing it, and returns how it would be executed.
+func ExplainString(script string, opts *RuntimeOptions) (*Plan, error) {
+	if opts.Logger == nil {
+		opts.Logger = engine.NewConsoleLogger(engine.Error)
+	}
+	js, err := aql.ParseString(script)
+	if err != nil {
+		return nil, err
+	}
+	return explain(js, opts)
+}
+
+//ExplainFile is like ExplainString, for a script file.
+func ExplainFile(filename string, opts *RuntimeOptions) (*Plan, error) {
+	if opts.Logger == nil {
+		opts.Logger = engine.NewConsoleLogger(engine.Error)
+	}
+	js, err := aql.ParseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return explain(js, opts)
+}
+
+func explain(js *aql.JobScript, opts *RuntimeOptions) (*Plan, error) {
+	var dag engine.Coordinator
+	capture := func(d engine.Coordinator) error {
+		dag = d
+		return nil
+	}
+	//compiling resolves the content of the blocks in place
+	if err := execute(js, opts.Options, opts.Logger, true, opts.Hooks, opts.Context, opts.ScriptDirectory, false, nil, capture); err != nil {
+		return nil, err
+	}
+	connMap, err := connectionMap(js)
+	if err != nil {
+		return nil, err
+	}
+	globals := mergeOptions(js, opts.Options)
+
+	var plan Plan
+	add := func(kind string, block aql.Block, content string, sources []aql.SourceSink, destinations []aql.SourceSink, after []string) error {
+		bp := BlockPlan{
+			Kind:    kind,
+			Name:    block.GetName(),
+			Content: strings.TrimSpace(content),
+			After:   after,
+			Options: effectiveOptions(block.GetOptions(), nil, globals, ""),
+		}
+		seen := make(map[string]bool)
+		for _, ss := range append(sources, destinations...) {
+			if ss.Database == nil || seen[strings.ToLower(*ss.Database)] {
+				continue
+			}
+			seen[strings.ToLower(*ss.Database)] = true
+			conn, ok := connMap[strings.ToLower(*ss.Database)]
+			if !ok {
+				return fmt.Errorf("could not find connection %s of block %s", *ss.Database, block.GetName())
+			}
+			bp.Connections = append(bp.Connections, ConnectionPlan{
+				Name:             conn.Name,
+				Driver:           conn.Driver,
+				ConnectionString: maskConnectionString(conn.ConnectionString),
+				Options:          effectiveOptions(block.GetOptions(), conn.Options, globals, conn.Name),
+			})
+		}
+		//blocks that nothing reads from, such as unused DATA blocks, are not part of the DAG
+		name := strings.ToLower(block.GetName())
+		if upstream, err := dag.Upstream(name); err == nil {
+			bp.Upstream = withoutNode(upstream, name)
+		}
+		if downstream, err := dag.Downstream(name); err == nil {
+			bp.Downstream = withoutNode(downstream, name)
+		}
+		plan.Blocks = append(plan.Blocks, bp)
+		return nil
+	}
+
+	for i := range js.Data {
+		if err := add("DATA", &js.Data[i], js.Data[i].Content, nil, js.Data[i].Destinations, nil); err != nil {
+			return nil, err
+		}
+	}
+	for i := range js.Queries {
+		q := &js.Queries[i]
+		if err := add("QUERY", q, q.Content, q.Sources, q.Destinations, q.Dependencies); err != nil {
+			return nil, err
+		}
+	}
+	for i := range js.Execs {
+		q := &js.Execs[i]
+		if err := add("EXEC", q, q.Content, q.Sources, q.Destinations, q.Dependencies); err != nil {
+			return nil, err
+		}
+	}
+	for i := range js.Transforms {
+		t := &js.Transforms[i]
+		var sources []aql.SourceSink
+		for _, s := range t.Sources {
+			sources = append(sources, *s)
+		}
+		kind := "TRANSFORM"
+		if t.Plugin {
+			kind = "TRANSFORM PLUGIN"
+		}
+		if err := add(kind, t, t.Content, sources, t.Destinations, t.Dependencies); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := plan.computeWaves(); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func withoutNode(names []string, name string) []string {
+	var ret []string
+	for _, n := range names {
+		if n != name {
+			ret = append(ret, n)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+//computeWaves sets the wave of each block from its AFTER dependencies.
+func (p *Plan) computeWaves() error {
+	blocks := make(map[string]*BlockPlan)
+	for i := range p.Blocks {
+		blocks[strings.ToLower(p.Blocks[i].Name)] = &p.Blocks[i]
+	}
+	visiting := make(map[string]bool)
+	var wave func(b *BlockPlan) (int, error)
+	wave = func(b *BlockPlan) (int, error) {
+		if b.Wave > 0 {
+			return b.Wave, nil
+		}
+		name := strings.ToLower(b.Name)
+		if visiting[name] {
+			return 0, fmt.Errorf("the AFTER dependencies of %s are cyclical", b.Name)
+		}
+		visiting[name] = true
+		w := 1
+		for _, after := range b.After {
+			dep, ok := blocks[strings.ToLower(after)]
+			if !ok {
+				return 0, fmt.Errorf("block %s runs after %s, which does not exist", b.Name, after)
+			}
+			depWave, err := wave(dep)
+			if err != nil {
+				return 0, err
+			}
+			if depWave+1 > w {
+				w = depWave + 1
+			}
+		}
+		b.Wave = w
+		return w, nil
+	}
+	for i := range p.Blocks {
+		w, err := wave(&p.Blocks[i])
+		if err != nil {
+			return err
+		}
+		for len(p.Waves) < w {
+			p.Waves = append(p.Waves, nil)
+		}
+		p.Waves[w-1] = append(p.Waves[w-1], p.Blocks[i].Name)
+	}
+	return nil
+}
+
+//effectiveOptions returns the effective value of each option that is set for the block, for the given connection
+//namespace. Options are looked up as FindOverridableOption does, so block options override connection options,
+//which override global options, and namespaced options override generic ones within each scope.
+func effectiveOptions(block []aql.Option, conn []aql.Option, globals []aql.Option, namespace string) []EffectiveOption {
+	var (
+		keys    []string
+		found   = make(map[string]bool)
+		prefix  = strings.ToLower(namespace) + "_"
+		scopes  = []string{OptionScopeBlock, OptionScopeConnection, OptionScopeGlobal}
+		options = [][]aql.Option{block, conn, globals}
+	)
+	for _, opts := range options {
+		for _, opt := range opts {
+			key := strings.ToUpper(opt.Key)
+			if namespace != "" && strings.HasPrefix(strings.ToLower(key), prefix) {
+				key = key[len(prefix):]
+			}
+			if !found[key] {
+				found[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	var ret []EffectiveOption
+	for _, key := range keys {
+		for i, opts := range options {
+			opt, ok := aql.FindOverridableOption(key, namespace, opts)
+			if !ok {
+				continue
+			}
+			ret = append(ret, EffectiveOption{Key: key, Value: optionValue(opt), Scope: scopes[i]})
+			break
+		}
+	}
+	return ret
+}
+
+//optionValue formats the value of the option as it would be written in a script, masking secrets.
+func optionValue(opt *aql.Option) string {
+	if secretKey.MatchString(opt.Key) {
+		return MaskedValue
+	}
+	if s, ok := opt.String(); ok {
+		return "'" + s + "'"
+	}
+	if opt.Value != nil && opt.Value.Number != nil {
+		return fmt.Sprintf("%v", *opt.Value.Number)
+	}
+	return ""
+}
+
+//maskConnectionString masks the passwords of URLs, DSNs such as user:password@tcp(host)/db and
+//key-value connection strings, as well as the values of keys such as token or secret.
+func maskConnectionString(s string) string {
+	if u, err := url.Parse(s); err == nil && u.User != nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), MaskedValue)
+			s = strings.Replace(u.String(), url.QueryEscape(MaskedValue), MaskedValue, 1)
+		}
+	} else if secretUserInfoDSN.MatchString(s) {
+		s = secretUserInfoDSN.ReplaceAllString(s, "$1:"+MaskedValue+"@")
+	}
+	return secretKeyValue.ReplaceAllString(s, "${1}"+MaskedValue)
+}
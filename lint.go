@@ -0,0 +1,369 @@
+package analyst
+
+import (
+	"fmt"
+	"github.com/michaelbironneau/analyst/aql"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//Rules of the linter.
+const (
+	LintUnusedParameter  = "unused-parameter"
+	LintUnusedConnection = "unused-connection"
+	LintNoDestination    = "no-destination"
+	LintUnsetParameter   = "unset-parameter"
+	LintSelectStar       = "select-star"
+	LintRedundantAfter   = "redundant-after"
+	LintUnknownOption    = "unknown-option"
+	LintDuplicateBlock   = "duplicate-block"
+)
+
+var selectStar = regexp.MustCompile(`(?i)\bSELECT\s+(DISTINCT\s+)?\*`)
+
+//LintIssue is a problem found by the linter, at the position of the block or option that it is about.
+type LintIssue struct {
+	aql.Position
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s: %s (%s)", i.Position, i.Message, i.Rule)
+}
+
+//lintScript is a script, or one of the scripts that it includes, along with the position of its blocks by kind.
+type lintScript struct {
+	file      string
+	js        *aql.JobScript
+	positions map[string][]aql.BlockPosition
+}
+
+//position returns the position of the block of the given kind, by index in the JobScript.
+func (s *lintScript) position(kind string, index int) aql.BlockPosition {
+	if index >= len(s.positions[kind]) {
+		return aql.BlockPosition{Position: aql.Position{File: s.file}}
+	}
+	return s.positions[kind][index]
+}
+
+//optionPosition returns the position of the option of the block of the given kind, by index in the block.
+func (s *lintScript) optionPosition(kind string, index int, option int) aql.Position {
+	block := s.position(kind, index)
+	if option >= len(block.Options) {
+		return block.Position
+	}
+	return block.Options[option]
+}
+
+//lintBlock is a QUERY, EXEC, TRANSFORM or DATA block of any of the scripts.
+type lintBlock struct {
+	script       *lintScript
+	kind         string
+	index        int
+	name         string
+	content      string
+	sources      []aql.SourceSink
+	destinations []aql.SourceSink
+	options      []aql.Option
+	parameters   []string
+	after        []string
+	schema       *blockSchema
+}
+
+//LintString lints the script, and the scripts that it includes from the script directory of the options.
+//The issues are sorted by position.
+func LintString(script string, opts *RuntimeOptions) ([]LintIssue, error) {
+	scripts, err := lintScripts("", script, opts.ScriptDirectory, 0)
+	if err != nil {
+		return nil, err
+	}
+	return lint(scripts)
+}
+
+//LintFile is like LintString, for a script file. Its includes are relative to the file.
+func LintFile(filename string, opts *RuntimeOptions) ([]LintIssue, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	scripts, err := lintScripts(filename, string(b), filepath.Dir(filename), 0)
+	if err != nil {
+		return nil, err
+	}
+	return lint(scripts)
+}
+
+//lintScripts parses the script and, recursively, the scripts that it includes, resolving their EXTERN content.
+//Unlike the compiler, it keeps them separate so that issues can be reported in the file that they are in.
+func lintScripts(file, script, dir string, depth int) ([]*lintScript, error) {
+	if depth > aql.MaxIncludeDepth {
+		return nil, fmt.Errorf("maximum INCLUDE depth %v reached", aql.MaxIncludeDepth)
+	}
+	js, err := aql.ParseString(script)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	positions, err := aql.Positions(script)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	s := &lintScript{file: file, js: js, positions: make(map[string][]aql.BlockPosition)}
+	for _, p := range positions {
+		p.File = file
+		for i := range p.Options {
+			p.Options[i].File = file
+		}
+		s.positions[p.Kind] = append(s.positions[p.Kind], p)
+	}
+
+	extern := func(path *string, content *string) error {
+		//parametrized paths are only known at runtime
+		if path == nil || strings.Contains(*path, "{{") {
+			return nil
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, *path))
+		if err != nil {
+			return err
+		}
+		*content = string(b)
+		return nil
+	}
+	for i := range js.Queries {
+		if err := extern(js.Queries[i].Extern, &js.Queries[i].Content); err != nil {
+			return nil, err
+		}
+	}
+	for i := range js.Execs {
+		if err := extern(js.Execs[i].Extern, &js.Execs[i].Content); err != nil {
+			return nil, err
+		}
+	}
+	for i := range js.Transforms {
+		if err := extern(js.Transforms[i].Extern, &js.Transforms[i].Content); err != nil {
+			return nil, err
+		}
+	}
+	for i := range js.Data {
+		if err := extern(js.Data[i].Extern, &js.Data[i].Content); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := []*lintScript{s}
+	for _, include := range js.Includes {
+		path := filepath.Join(dir, include.Source)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		included, err := lintScripts(path, string(b), filepath.Dir(path), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, included...)
+	}
+	return ret, nil
+}
+
+func lint(scripts []*lintScript) ([]LintIssue, error) {
+	var (
+		issues  []LintIssue
+		blocks  []lintBlock
+		connMap = make(map[string]*aql.Connection)
+	)
+	report := func(pos aql.Position, rule string, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Position: pos, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, s := range scripts {
+		conns, err := s.js.ParseConnections()
+		if err != nil {
+			return nil, err
+		}
+		for i := range conns {
+			connMap[strings.ToLower(conns[i].Name)] = &conns[i]
+		}
+	}
+	for _, s := range scripts {
+		for i := range s.js.Queries {
+			q := &s.js.Queries[i]
+			blocks = append(blocks, lintBlock{s, "QUERY", i, q.Name, q.Content, q.Sources, q.Destinations, q.Options, q.Parameters, q.Dependencies, querySchema(q, connMap)})
+		}
+		for i := range s.js.Execs {
+			q := &s.js.Execs[i]
+			blocks = append(blocks, lintBlock{s, "EXEC", i, q.Name, q.Content, q.Sources, q.Destinations, q.Options, q.Parameters, q.Dependencies, querySchema(q, connMap)})
+		}
+		for i := range s.js.Transforms {
+			t := &s.js.Transforms[i]
+			var sources []aql.SourceSink
+			for _, source := range t.Sources {
+				sources = append(sources, *source)
+			}
+			blocks = append(blocks, lintBlock{s, "TRANSFORM", i, t.Name, t.Content, sources, t.Destinations, t.Options, nil, t.Dependencies, transformSchema(t, connMap)})
+		}
+		for i := range s.js.Data {
+			d := &s.js.Data[i]
+			blocks = append(blocks, lintBlock{s, "DATA", i, d.Name, d.Content, nil, d.Destinations, d.Options, nil, nil, dataSchema(d, connMap)})
+		}
+	}
+
+	var (
+		byName            = make(map[string]*lintBlock)
+		readers           = make(map[string][]string)
+		usedConns         = make(map[string]bool)
+		usedParams        = make(map[string]bool)
+		setParams         = make(map[string]bool)
+		transformContents []string
+	)
+	for i := range blocks {
+		b := &blocks[i]
+		name := strings.ToLower(b.name)
+		if other, ok := byName[name]; ok {
+			report(b.script.position(b.kind, b.index).Position, LintDuplicateBlock, "block %s is already defined at %s", b.name, other.script.position(other.kind, other.index).Position)
+		} else {
+			byName[name] = b
+		}
+		for _, list := range [][]aql.SourceSink{b.sources, b.destinations} {
+			for _, ss := range list {
+				if ss.Database != nil {
+					usedConns[strings.ToLower(*ss.Database)] = true
+				}
+				for _, v := range ss.Variables {
+					setParams[v] = true
+				}
+			}
+		}
+		for _, source := range b.sources {
+			if source.Block != nil {
+				readers[strings.ToLower(*source.Block)] = append(readers[strings.ToLower(*source.Block)], name)
+			}
+		}
+		for _, p := range b.parameters {
+			usedParams[p] = true
+		}
+		if b.kind == "TRANSFORM" {
+			//built-in transforms can read parameters, like the salt of MASK
+			transformContents = append(transformContents, b.content)
+		}
+	}
+
+	for _, s := range scripts {
+		for i, declaration := range s.js.Declarations {
+			used := usedParams[declaration.Name]
+			for _, content := range transformContents {
+				used = used || strings.Contains(content, declaration.Name)
+			}
+			if !used {
+				report(s.position("DECLARE", i).Position, LintUnusedParameter, "parameter %s is declared but never used", declaration.Name)
+			}
+		}
+		for i, conn := range s.js.Connections {
+			if !usedConns[strings.ToLower(conn.Name)] {
+				report(s.position("CONNECTION", i).Position, LintUnusedConnection, "connection %s is never used", conn.Name)
+			}
+		}
+	}
+
+	for i := range blocks {
+		b := &blocks[i]
+		pos := b.script.position(b.kind, b.index).Position
+		name := strings.ToLower(b.name)
+		if b.kind != "EXEC" && len(b.destinations) == 0 && len(readers[name]) == 0 {
+			report(pos, LintNoDestination, "%s %s has no destination and no block reads from it", b.kind, b.name)
+		}
+		for _, p := range b.parameters {
+			if !setParams[p] {
+				report(pos, LintUnsetParameter, "%s %s uses parameter %s, which no block sets", b.kind, b.name, p)
+			}
+		}
+		if b.kind == "QUERY" && selectStar.MatchString(b.content) {
+			for _, dest := range b.destinations {
+				if sqlDestination(dest, connMap) {
+					target := "GLOBAL"
+					if dest.Database != nil {
+						target = *dest.Database
+					}
+					report(pos, LintSelectStar, "QUERY %s writes SELECT * to %s, so the columns it inserts will change with its source", b.name, target)
+					break
+				}
+			}
+		}
+		for _, after := range b.after {
+			if upstreamOf(strings.ToLower(after), name, readers) {
+				report(pos, LintRedundantAfter, "%s %s runs AFTER %s, which it already reads from", b.kind, b.name, after)
+			}
+		}
+		for j, opt := range b.options {
+			if !b.schema.accepts(opt.Key) {
+				report(b.script.optionPosition(b.kind, b.index, j), LintUnknownOption, "option %s of %s %s is not known to any of its components", opt.Key, b.kind, b.name)
+			}
+		}
+	}
+
+	for _, s := range scripts {
+		for i, t := range s.js.Tests {
+			schema := newBlockSchema()
+			schema.add(componentTest)
+			for j, opt := range t.Options {
+				if !schema.accepts(opt.Key) {
+					report(s.optionPosition("TEST", i, j), LintUnknownOption, "option %s of the test of %s is not known", opt.Key, t.TargetBlock)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i].Position, issues[j].Position
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return issues, nil
+}
+
+//sqlDestination returns true if the destination is written to with INSERT statements.
+func sqlDestination(dest aql.SourceSink, connMap map[string]*aql.Connection) bool {
+	if dest.Global {
+		return true
+	}
+	if dest.Database == nil {
+		return false
+	}
+	conn, ok := connMap[strings.ToLower(*dest.Database)]
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(conn.Driver) {
+	case "excel", "http", "mandrill":
+		return false
+	}
+	return true
+}
+
+//upstreamOf returns true if the block reads from the other block, directly or indirectly.
+func upstreamOf(other, block string, readers map[string][]string) bool {
+	visited := make(map[string]bool)
+	queue := []string{other}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, reader := range readers[name] {
+			if reader == block {
+				return true
+			}
+			if !visited[reader] {
+				visited[reader] = true
+				queue = append(queue, reader)
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package analyst
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompilerLint(t *testing.T) {
+	script := `
+	DECLARE @Unused
+	DECLARE @Since
+	CONNECTION 'Warehouse' (Driver = 'postgres', ConnectionString = 'postgres://db/warehouse')
+	CONNECTION 'Archive' (Driver = 'postgres', ConnectionString = 'postgres://db/archive')
+	QUERY 'GetOrders' FROM CONNECTION Warehouse (
+		SELECT * FROM Orders
+	) INTO CONNECTION Warehouse
+	WITH (TABLE = 'OrdersCopy', ROWS_PER_BACTH = 100)
+	TRANSFORM 'Total' FROM BLOCK GetOrders (
+		AGGREGATE SUM(Amount) AS Total
+	) AFTER GetOrders
+	EXEC 'Cleanup' FROM CONNECTION Warehouse (
+		DELETE FROM Orders WHERE Time < ?
+	) USING PARAMETER @Since
+	INCLUDE 'included.aql'
+	`
+	included := "QUERY 'GetOrders' FROM CONNECTION Warehouse (SELECT Id FROM Orders) INTO CONSOLE\n"
+	Convey("Given a script and an included script with issues", t, func() {
+		dir, err := ioutil.TempDir("", "analyst-lint")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(ioutil.WriteFile(filepath.Join(dir, "included.aql"), []byte(included), 0644), ShouldBeNil)
+		issues, err := LintString(script, &RuntimeOptions{ScriptDirectory: dir})
+		So(err, ShouldBeNil)
+		Convey("It should report each issue at the position of its block or option", func() {
+			type found struct {
+				Rule   string
+				File   string
+				Line   int
+				Column int
+			}
+			var ff []found
+			for _, issue := range issues {
+				ff = append(ff, found{issue.Rule, issue.File, issue.Line, issue.Column})
+			}
+			So(ff, ShouldResemble, []found{
+				{LintUnusedParameter, "", 2, 2},
+				{LintUnusedConnection, "", 5, 2},
+				{LintSelectStar, "", 6, 2},
+				{LintUnknownOption, "", 9, 30},
+				{LintNoDestination, "", 10, 2},
+				{LintRedundantAfter, "", 10, 2},
+				{LintUnsetParameter, "", 13, 2},
+				{LintDuplicateBlock, filepath.Join(dir, "included.aql"), 1, 1},
+			})
+		})
+		Convey("It should format issues as file:line:column", func() {
+			So(issues[3].String(), ShouldEqual, "9:30: option ROWS_PER_BACTH of QUERY GetOrders is not known to any of its components (unknown-option)")
+		})
+	})
+}
@@ -0,0 +1,412 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/michaelbironneau/analyst"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//DiagnosticSource is the source of the diagnostics published by the server.
+const DiagnosticSource = "analyst"
+
+//errorPosition matches the position of an error, such as 12:3: or included.aql:12:3:
+var errorPosition = regexp.MustCompile(`(?:^|\s)((?:[^\s:]+:)?)(\d+):(\d+): `)
+
+//blockKinds are the kinds of blocks that other blocks refer to by name.
+var blockKinds = map[string]bool{"QUERY": true, "EXEC": true, "TRANSFORM": true, "DATA": true}
+
+//Server is a Language Server Protocol server for AQL. It publishes diagnostics from the parser, the compiler and
+//the linter, and provides completion, go-to-definition and hover. Documents are synchronized in full.
+type Server struct {
+	r         *bufio.Reader
+	w         io.Writer
+	documents map[string]string
+}
+
+//definition is a top-level block of a script, or of one of the scripts that it includes.
+type definition struct {
+	uri   string
+	block aql.BlockPosition
+}
+
+//NewServer returns a server that reads requests from r and writes responses to w, such as STDIN and STDOUT.
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		r:         bufio.NewReader(r),
+		w:         w,
+		documents: make(map[string]string),
+	}
+}
+
+//Serve handles requests until the client exits or closes the connection.
+func (s *Server) Serve() error {
+	for {
+		b, err := readMessage(s.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(b, &req); err != nil {
+			if err := s.respondError(nil, errParse, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+//handle handles a request or notification. It only returns an error if the response cannot be written.
+func (s *Server) handle(req *request) error {
+	var result interface{}
+	switch req.Method {
+	case "initialize":
+		result = initializeResult{Capabilities: serverCapabilities{
+			TextDocumentSync:   textDocumentSyncFull,
+			CompletionProvider: struct{}{},
+			DefinitionProvider: true,
+			HoverProvider:      true,
+		}}
+	case "shutdown":
+		result = nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		s.documents[params.TextDocument.URI] = params.TextDocument.Text
+		return s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		s.documents[params.TextDocument.URI] = params.ContentChanges[len(params.ContentChanges)-1].Text
+		return s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didSave":
+		//included and external files may have changed
+		var params textDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		return s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didClose":
+		var params textDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return writeMessage(s.w, notification{"2.0", "textDocument/publishDiagnostics", publishDiagnosticsParams{params.TextDocument.URI, []diagnostic{}}})
+	case "textDocument/completion", "textDocument/definition", "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.respondError(req.ID, errInvalidParams, err.Error())
+		}
+		switch req.Method {
+		case "textDocument/completion":
+			result = s.completion(params.TextDocument.URI, params.Position)
+		case "textDocument/definition":
+			if loc, ok := s.definition(params.TextDocument.URI, params.Position); ok {
+				result = loc
+			}
+		default:
+			if h, ok := s.hover(params.TextDocument.URI, params.Position); ok {
+				result = h
+			}
+		}
+	default:
+		//notifications that the server does not handle are ignored, such as initialized
+		if req.ID == nil {
+			return nil
+		}
+		return s.respondError(req.ID, errMethodNotFound, "method not found: "+req.Method)
+	}
+	if req.ID == nil {
+		return nil
+	}
+	return writeMessage(s.w, response{"2.0", req.ID, result})
+}
+
+func (s *Server) respondError(id *json.RawMessage, code int, message string) error {
+	return writeMessage(s.w, errorResponse{"2.0", id, responseError{code, message}})
+}
+
+//text returns the text of a document, from the client if it is open, or from disk otherwise.
+func (s *Server) text(uri string) (string, error) {
+	if text, ok := s.documents[uri]; ok {
+		return text, nil
+	}
+	b, err := ioutil.ReadFile(uriToPath(uri))
+	return string(b), err
+}
+
+//publishDiagnostics publishes the errors of the parser and the compiler for the document, or the issues of
+//the linter if it compiles.
+func (s *Server) publishDiagnostics(uri string) error {
+	text := s.documents[uri]
+	diagnostics := []diagnostic{}
+	add := func(d diagnostic) {
+		diagnostics = append(diagnostics, d)
+	}
+	dir := filepath.Dir(uriToPath(uri))
+	if _, err := aql.ParseString(text); err != nil {
+		add(errorDiagnostic(err, text))
+	} else {
+		l := engine.NewGenericLogger(engine.Error, ioutil.Discard)
+		err := analyst.ValidateString(text, &analyst.RuntimeOptions{Logger: l, ScriptDirectory: dir})
+		close(l.Chan())
+		if err != nil {
+			add(errorDiagnostic(err, text))
+		}
+		issues, err := analyst.LintString(text, &analyst.RuntimeOptions{ScriptDirectory: dir})
+		if err == nil {
+			for _, issue := range issues {
+				//issues in included scripts are published with those scripts
+				if issue.File != "" {
+					continue
+				}
+				add(diagnostic{
+					Range:    lineRange(text, issue.Line, issue.Column),
+					Severity: severityWarning,
+					Code:     issue.Rule,
+					Source:   DiagnosticSource,
+					Message:  issue.Message,
+				})
+			}
+		}
+	}
+	return writeMessage(s.w, notification{"2.0", "textDocument/publishDiagnostics", publishDiagnosticsParams{uri, diagnostics}})
+}
+
+//errorDiagnostic returns a diagnostic for the error, at the position that it gives if it is in the document,
+//or else at the block that it names, or else at the start of the document.
+func errorDiagnostic(err error, text string) diagnostic {
+	msg := err.Error()
+	line, col := 1, 1
+	if m := errorPosition.FindStringSubmatch(msg); m != nil && m[1] == "" {
+		line, _ = strconv.Atoi(m[2])
+		col, _ = strconv.Atoi(m[3])
+	} else if blocks, err := aql.Positions(text); err == nil {
+		var longest string
+		for _, block := range blocks {
+			if block.Name != "" && len(block.Name) > len(longest) && strings.Contains(msg, block.Name) {
+				longest = block.Name
+				line, col = block.Line, block.Column
+			}
+		}
+	}
+	return diagnostic{
+		Range:    lineRange(text, line, col),
+		Severity: severityError,
+		Source:   DiagnosticSource,
+		Message:  msg,
+	}
+}
+
+//lineRange returns the range from the line and column, which start at 1, to the end of the line.
+func lineRange(text string, line, col int) textRange {
+	lines := strings.Split(text, "\n")
+	end := col - 1
+	if line >= 1 && line <= len(lines) && len(lines[line-1]) > end {
+		end = len(lines[line-1])
+	}
+	return textRange{position{line - 1, col - 1}, position{line - 1, end}}
+}
+
+//definitions returns the top-level blocks of the document and, recursively, of the scripts that it includes.
+func (s *Server) definitions(uri string, depth int) []definition {
+	if depth > aql.MaxIncludeDepth {
+		return nil
+	}
+	text, err := s.text(uri)
+	if err != nil {
+		return nil
+	}
+	blocks, err := aql.Positions(text)
+	if err != nil {
+		return nil
+	}
+	var ret []definition
+	for _, block := range blocks {
+		ret = append(ret, definition{uri, block})
+		if block.Kind == "INCLUDE" {
+			ret = append(ret, s.definitions(pathToURI(filepath.Join(filepath.Dir(uriToPath(uri)), block.Name)), depth+1)...)
+		}
+	}
+	return ret
+}
+
+//find returns the block or connection with the given name.
+func (s *Server) find(uri string, name string) (definition, bool) {
+	for _, d := range s.definitions(uri, 0) {
+		if (blockKinds[d.block.Kind] || d.block.Kind == "CONNECTION") && strings.ToLower(d.block.Name) == strings.ToLower(name) {
+			return d, true
+		}
+	}
+	return definition{}, false
+}
+
+//tokenAt returns the tokens of the document and the index of the token at the position.
+func (s *Server) tokenAt(uri string, pos position) ([]aql.Item, int, bool) {
+	text, err := s.text(uri)
+	if err != nil {
+		return nil, 0, false
+	}
+	items, err := aql.Lex(text)
+	if err != nil {
+		return nil, 0, false
+	}
+	for i, item := range items {
+		if item.ID == aql.PAREN_BODY || item.LineNumber-1 != pos.Line {
+			continue
+		}
+		length := len(item.Content)
+		if item.ID == aql.QUOTED_STRING {
+			length += 2
+		}
+		if pos.Character >= item.Column-1 && pos.Character <= item.Column-1+length {
+			return items, i, true
+		}
+	}
+	return nil, 0, false
+}
+
+//file returns the path of the file that the token names, if it follows INCLUDE, EXTERN, SNAPSHOT or FIXTURE.
+func (s *Server) file(uri string, items []aql.Item, i int) (string, bool) {
+	if items[i].ID != aql.QUOTED_STRING || i == 0 {
+		return "", false
+	}
+	switch items[i-1].ID {
+	case aql.INCLUDE, aql.EXTERN, aql.SNAPSHOT, aql.FIXTURE:
+		return filepath.Join(filepath.Dir(uriToPath(uri)), items[i].Content), true
+	}
+	return "", false
+}
+
+//definition returns the location of the block or connection at the position, or of the file that is included
+//or referred to by EXTERN at the position.
+func (s *Server) definition(uri string, pos position) (*location, bool) {
+	items, i, ok := s.tokenAt(uri, pos)
+	if !ok {
+		return nil, false
+	}
+	if path, ok := s.file(uri, items, i); ok {
+		return &location{URI: pathToURI(path)}, true
+	}
+	if items[i].ID != aql.IDENTIFIER && items[i].ID != aql.QUOTED_STRING {
+		return nil, false
+	}
+	d, ok := s.find(uri, items[i].Content)
+	if !ok {
+		return nil, false
+	}
+	start := position{d.block.Line - 1, d.block.Column - 1}
+	return &location{
+		URI:   d.uri,
+		Range: textRange{start, position{start.Line, start.Character + len(d.block.Kind)}},
+	}, true
+}
+
+//hover describes the block or connection at the position with the comments right before it, or the included
+//script at the position with its DESCRIPTION.
+func (s *Server) hover(uri string, pos position) (*hover, bool) {
+	items, i, ok := s.tokenAt(uri, pos)
+	if !ok {
+		return nil, false
+	}
+	if path, ok := s.file(uri, items, i); ok && items[i-1].ID == aql.INCLUDE {
+		for _, d := range s.definitions(pathToURI(path), aql.MaxIncludeDepth) {
+			if d.block.Kind == "DESCRIPTION" {
+				return &hover{markupContent{"markdown", fmt.Sprintf("**INCLUDE** `%s`\n\n%s", items[i].Content, d.block.Name)}}, true
+			}
+		}
+		return nil, false
+	}
+	if items[i].ID != aql.IDENTIFIER && items[i].ID != aql.QUOTED_STRING {
+		return nil, false
+	}
+	d, ok := s.find(uri, items[i].Content)
+	if !ok {
+		return nil, false
+	}
+	value := fmt.Sprintf("**%s** `%s`", d.block.Kind, d.block.Name)
+	if d.block.Comment != "" {
+		value += "\n\n" + d.block.Comment
+	}
+	if d.uri != uri {
+		value += fmt.Sprintf("\n\nDefined in `%s`", uriToPath(d.uri))
+	}
+	return &hover{markupContent{"markdown", value}}, true
+}
+
+//completion returns the names that can be written at the position: block names after BLOCK, AFTER and TEST,
+//connection names after CONNECTION, and otherwise keywords, option names, block names and connection names.
+func (s *Server) completion(uri string, pos position) []completionItem {
+	var (
+		ret        = []completionItem{}
+		blocks     []completionItem
+		conns      []completionItem
+		seen       = make(map[string]bool)
+		text, _    = s.text(uri)
+		lines      = strings.Split(text, "\n")
+		beforeWord string
+	)
+	for _, d := range s.definitions(uri, 0) {
+		key := d.block.Kind + " " + strings.ToLower(d.block.Name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		switch {
+		case blockKinds[d.block.Kind]:
+			blocks = append(blocks, completionItem{Label: d.block.Name, Kind: completionClass, Detail: d.block.Kind})
+		case d.block.Kind == "CONNECTION":
+			conns = append(conns, completionItem{Label: d.block.Name, Kind: completionModule, Detail: "CONNECTION"})
+		}
+	}
+	if pos.Line < len(lines) {
+		line := lines[pos.Line]
+		if pos.Character < len(line) {
+			line = line[:pos.Character]
+		}
+		//the word before the one being written
+		words := strings.Fields(strings.Replace(line, ",", " , ", -1))
+		if len(words) > 0 && !strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\t") {
+			words = words[:len(words)-1]
+		}
+		if len(words) > 0 {
+			beforeWord = strings.ToUpper(words[len(words)-1])
+		}
+	}
+	switch beforeWord {
+	case "BLOCK", "AFTER", "TEST":
+		return append(ret, blocks...)
+	case "CONNECTION":
+		return append(ret, conns...)
+	}
+	for _, keyword := range aql.Keywords() {
+		ret = append(ret, completionItem{Label: keyword, Kind: completionKeyword})
+	}
+	for _, opt := range analyst.OptionNames() {
+		ret = append(ret, completionItem{Label: opt, Kind: completionProperty, Detail: "option"})
+	}
+	ret = append(ret, blocks...)
+	return append(ret, conns...)
+}
@@ -0,0 +1,147 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//serve sends the messages to a server, then returns the messages that it wrote.
+func serve(messages ...interface{}) ([]map[string]interface{}, error) {
+	var in, out bytes.Buffer
+	for _, msg := range messages {
+		if err := writeMessage(&in, msg); err != nil {
+			return nil, err
+		}
+	}
+	if err := NewServer(&in, &out).Serve(); err != nil {
+		return nil, err
+	}
+	var ret []map[string]interface{}
+	r := bufio.NewReader(&out)
+	for out.Len() > 0 || r.Buffered() > 0 {
+		b, err := readMessage(r)
+		if err != nil {
+			return nil, err
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(b, &msg); err != nil {
+			return nil, err
+		}
+		ret = append(ret, msg)
+	}
+	return ret, nil
+}
+
+func call(id int, method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func notify(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+func at(uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": character},
+	}
+}
+
+func TestServer(t *testing.T) {
+	script := `INCLUDE 'included.aql'
+QUERY 'GetOrders' FROM CONNECTION Warehouse (
+	SELECT Id FROM Orders
+) INTO CONSOLE
+TRANSFORM 'Count' FROM BLOCK GetOrders (
+	AGGREGATE COUNT(Id) AS Orders
+) INTO CONSOLE`
+	included := `DESCRIPTION 'Connections of the warehouse'
+-- The data warehouse
+CONNECTION 'Warehouse' (Driver = 'sqlite3', ConnectionString = ':memory:')`
+	Convey("Given a script that includes another script", t, func() {
+		dir, err := ioutil.TempDir("", "analyst-lsp")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(ioutil.WriteFile(filepath.Join(dir, "included.aql"), []byte(included), 0644), ShouldBeNil)
+		uri := pathToURI(filepath.Join(dir, "script.aql"))
+		open := notify("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "languageId": "aql", "version": 1, "text": script},
+		})
+		Convey("It should initialize and shut down", func() {
+			msgs, err := serve(call(1, "initialize", map[string]interface{}{}), notify("initialized", map[string]interface{}{}), call(2, "shutdown", nil), notify("exit", nil))
+			So(err, ShouldBeNil)
+			So(msgs, ShouldHaveLength, 2)
+			caps := msgs[0]["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+			So(caps["textDocumentSync"], ShouldEqual, float64(textDocumentSyncFull))
+			So(caps["hoverProvider"], ShouldBeTrue)
+			So(msgs[1]["result"], ShouldBeNil)
+		})
+		Convey("It should publish parse errors as diagnostics", func() {
+			msgs, err := serve(notify("textDocument/didOpen", map[string]interface{}{
+				"textDocument": map[string]interface{}{"uri": uri, "text": "QUERY 'Broken' FROM (SELECT 1)"},
+			}))
+			So(err, ShouldBeNil)
+			So(msgs, ShouldHaveLength, 1)
+			So(msgs[0]["method"], ShouldEqual, "textDocument/publishDiagnostics")
+			diagnostics := msgs[0]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+			So(diagnostics, ShouldHaveLength, 1)
+			So(diagnostics[0].(map[string]interface{})["severity"], ShouldEqual, float64(severityError))
+		})
+		Convey("It should complete block names after BLOCK", func() {
+			msgs, err := serve(open, call(1, "textDocument/completion", at(uri, 4, 29)))
+			So(err, ShouldBeNil)
+			So(msgs, ShouldHaveLength, 2)
+			var labels []string
+			for _, item := range msgs[1]["result"].([]interface{}) {
+				labels = append(labels, item.(map[string]interface{})["label"].(string))
+			}
+			So(labels, ShouldResemble, []string{"GetOrders", "Count"})
+		})
+		Convey("It should complete keywords and options elsewhere", func() {
+			msgs, err := serve(open, call(1, "textDocument/completion", at(uri, 6, 0)))
+			So(err, ShouldBeNil)
+			var labels []string
+			for _, item := range msgs[1]["result"].([]interface{}) {
+				labels = append(labels, item.(map[string]interface{})["label"].(string))
+			}
+			So(labels, ShouldContain, "TRANSFORM")
+			So(labels, ShouldContain, "TABLE")
+			So(labels, ShouldContain, "Warehouse")
+		})
+		Convey("It should go to the definition of a connection in an included script", func() {
+			msgs, err := serve(open, call(1, "textDocument/definition", at(uri, 1, 36)))
+			So(err, ShouldBeNil)
+			loc := msgs[1]["result"].(map[string]interface{})
+			So(loc["uri"], ShouldEqual, pathToURI(filepath.Join(dir, "included.aql")))
+			start := loc["range"].(map[string]interface{})["start"].(map[string]interface{})
+			So(start["line"], ShouldEqual, 2.0)
+			So(start["character"], ShouldEqual, 0.0)
+		})
+		Convey("It should go to the included script", func() {
+			msgs, err := serve(open, call(1, "textDocument/definition", at(uri, 0, 10)))
+			So(err, ShouldBeNil)
+			So(msgs[1]["result"].(map[string]interface{})["uri"], ShouldEqual, pathToURI(filepath.Join(dir, "included.aql")))
+		})
+		Convey("It should describe blocks and included scripts on hover", func() {
+			msgs, err := serve(open, call(1, "textDocument/hover", at(uri, 1, 36)), call(2, "textDocument/hover", at(uri, 0, 10)))
+			So(err, ShouldBeNil)
+			So(msgs, ShouldHaveLength, 3)
+			contents := msgs[1]["result"].(map[string]interface{})["contents"].(map[string]interface{})
+			So(contents["value"], ShouldContainSubstring, "**CONNECTION** `Warehouse`")
+			So(contents["value"], ShouldContainSubstring, "The data warehouse")
+			contents = msgs[2]["result"].(map[string]interface{})["contents"].(map[string]interface{})
+			So(contents["value"], ShouldContainSubstring, "Connections of the warehouse")
+		})
+		Convey("It should reject unknown requests", func() {
+			msgs, err := serve(call(1, "workspace/symbol", map[string]interface{}{}))
+			So(err, ShouldBeNil)
+			So(msgs[0]["error"].(map[string]interface{})["code"], ShouldEqual, float64(errMethodNotFound))
+		})
+	})
+}
@@ -0,0 +1,106 @@
+package analyst
+
+import (
+	"database/sql"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const mockFilePrefix = "analyst-mock-"
+
+//mockConnections replaces the mocked connections by throwaway SQLite databases, seeded by their fixtures.
+//It returns a function that removes the databases, which should be called once the job has run.
+func mockConnections(js *aql.JobScript, connMap map[string]*aql.Connection) (func(), error) {
+	var files []string
+	cleanup := func() {
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}
+	for _, mock := range js.Mocks {
+		if mock.Connection == nil {
+			continue
+		}
+		if !mock.Fixture {
+			return cleanup, aql.Errorf(mock.Position, "connection %s should be mocked WITH FIXTURE", *mock.Connection)
+		}
+		conn, ok := connMap[strings.ToLower(*mock.Connection)]
+		if !ok {
+			return cleanup, aql.Errorf(mock.Position, "could not find mocked connection %s%s", *mock.Connection, aql.DidYouMean(*mock.Connection, connectionNames(connMap)))
+		}
+		f, err := ioutil.TempFile("", mockFilePrefix)
+		if err != nil {
+			return cleanup, aql.Errorf(mock.Position, "could not create database for mocked connection %s: %v", *mock.Connection, err)
+		}
+		f.Close()
+		files = append(files, f.Name())
+		if err := seedFixture(f.Name(), mock.Content); err != nil {
+			return cleanup, aql.Errorf(mock.Position, "error seeding mocked connection %s: %v", *mock.Connection, err)
+		}
+		conn.Driver = globalDbDriver
+		conn.ConnectionString = f.Name()
+	}
+	return cleanup, nil
+}
+
+func seedFixture(path string, fixture string) error {
+	db, err := sql.Open(globalDbDriver, path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if strings.TrimSpace(fixture) == "" {
+		return nil
+	}
+	_, err = db.Exec(fixture)
+	return err
+}
+
+//mockBlocks returns a source hook that replaces the mocked query blocks by their data.
+func mockBlocks(js *aql.JobScript, connMap map[string]*aql.Connection) (engine.SourceHook, error) {
+	mocked := make(map[string]*engine.LiteralSource)
+	for _, mock := range js.Mocks {
+		if mock.Block == nil {
+			continue
+		}
+		if !mock.Data {
+			return nil, aql.Errorf(mock.Position, "block %s should be mocked WITH DATA", *mock.Block)
+		}
+		name := strings.ToLower(*mock.Block)
+		var (
+			query      *aql.Query
+			queryNames []string
+		)
+		for i := range js.Queries {
+			if strings.ToLower(js.Queries[i].Name) == name {
+				query = &js.Queries[i]
+			}
+			queryNames = append(queryNames, js.Queries[i].Name)
+		}
+		if query == nil {
+			return nil, aql.Errorf(mock.Position, "could not find mocked query %s%s", *mock.Block, aql.DidYouMean(*mock.Block, queryNames))
+		}
+		if len(query.Sources) == 1 && query.Sources[0].Database != nil {
+			if conn, ok := connMap[strings.ToLower(*query.Sources[0].Database)]; ok {
+				if driver := strings.ToLower(conn.Driver); driver == "excel" || driver == "http" {
+					return nil, aql.Errorf(mock.Position, "query %s cannot be mocked as it uses the %s connection %s", query.Name, driver, conn.Name)
+				}
+			}
+		}
+		ls, err := literalSource(query.Name, mock.Position, mock.Content, mock.Options)
+		if err != nil {
+			return nil, err
+		}
+		ls.SetName(query.Name)
+		mocked[name] = ls
+	}
+	return func(name string, s engine.Source) (engine.Source, error) {
+		if ls, ok := mocked[name]; ok {
+			return ls, nil
+		}
+		return nil, nil
+	}, nil
+}
@@ -0,0 +1,366 @@
+package analyst
+
+import (
+	"fmt"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	builtins "github.com/michaelbironneau/analyst/transforms"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Components whose options are known. A block accepts the options of all the components that it is made of,
+//such as its source, its destinations and its transform.
+const (
+	componentData             = "DATA"
+	componentTransform        = "TRANSFORM"
+	componentTest             = "TEST"
+	componentConsole          = "CONSOLE"
+	componentSQLSource        = "SQL source"
+	componentSQLDestination   = "SQL destination"
+	componentExcelSource      = "Excel source"
+	componentExcelDestination = "Excel destination"
+	componentHTTPSource       = "HTTP source"
+	componentMandrill         = "Mandrill destination"
+)
+
+//templateOption matches the options that the content of a block refers to, such as {{ .Start }}.
+var templateOption = regexp.MustCompile(`{{[^}]*\.([A-Za-z_][A-Za-z0-9_]*)`)
+
+//optionType is the type of the value of an option.
+type optionType int
+
+const (
+	//optionAny options accept strings and numbers, like boolean options, which are truthy or not.
+	optionAny optionType = iota
+	optionString
+	optionNumber
+)
+
+func (t optionType) String() string {
+	switch t {
+	case optionString:
+		return "string"
+	case optionNumber:
+		return "number"
+	}
+	return "string or number"
+}
+
+//accepts returns true if the value is of the type.
+func (t optionType) accepts(v *aql.OptionValue) bool {
+	switch t {
+	case optionString:
+		return v != nil && v.Str != nil
+	case optionNumber:
+		return v != nil && v.Number != nil
+	}
+	return true
+}
+
+//optionSpec is an option that a component accepts, along with the type of its value.
+type optionSpec struct {
+	name string
+	typ  optionType
+}
+
+//componentOptions is the registry of the options that each component accepts: those of its "aql" struct tags,
+//and those that the compiler reads itself, which are registered by hand.
+var componentOptions = map[string][]optionSpec{
+	componentData:             {{"COLUMNS", optionString}, {"FORMAT", optionString}},
+	componentTransform:        {{"MULTISOURCE_ORDER", optionString}, {"TABLE", optionString}},
+	componentTest:             {{"KEYS", optionString}, {"MODE", optionString}, {"SEVERITY", optionString}},
+	componentConsole:          {{"OUTPUT_FORMAT", optionString}},
+	componentSQLSource:        {{"MANAGED_TRANSACTION", optionAny}},
+	componentSQLDestination:   optionTags(&engine.SQLDestination{}),
+	componentExcelSource:      append(optionTags(&engine.ExcelSource{}), optionSpec{"RANGE", optionString}),
+	componentExcelDestination: append(optionTags(&engine.ExcelDestination{}), optionSpec{"RANGE", optionString}),
+	componentHTTPSource:       append(optionTags(&engine.HTTPSource{}), optionSpec{"HEADERS", optionString}),
+	componentMandrill:         append(optionTags(&engine.MandrillDestination{}), optionSpec{"SENDER", optionString}, optionSpec{"RECIPIENTS", optionString}),
+}
+
+//optionTags returns the options of the "aql" struct tags of v, which should be a struct or a pointer to one.
+//Their type is that of the field that they are scanned into.
+func optionTags(v interface{}) []optionSpec {
+	var ret []optionSpec
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("aql")
+		if !ok {
+			continue
+		}
+		spec := optionSpec{name: strings.ToUpper(strings.TrimSpace(strings.Split(tag, ",")[0]))}
+		switch t.Field(i).Type.Kind() {
+		case reflect.String, reflect.Slice:
+			//lists are comma-separated strings
+			spec.typ = optionString
+		case reflect.Int, reflect.Int64, reflect.Float64:
+			spec.typ = optionNumber
+		}
+		ret = append(ret, spec)
+	}
+	return ret
+}
+
+//OptionNames returns the names of all the options that are known to the compiler, sorted. These are the
+//options of the components of blocks and built-in transforms, and global options such as SLACK_CHANNEL.
+func OptionNames() []string {
+	found := make(map[string]bool)
+	for _, opts := range componentOptions {
+		for _, opt := range opts {
+			found[opt.name] = true
+		}
+	}
+	for _, v := range append(builtins.Configurables(), &engine.SlackOpts{}) {
+		for _, opt := range optionTags(v) {
+			found[opt.name] = true
+		}
+	}
+	var ret []string
+	for opt := range found {
+		ret = append(ret, opt)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+//blockSchema is the set of options that a block accepts. Options can be namespaced to any of the block's
+//connections or aliases, such as Warehouse_TABLE.
+type blockSchema struct {
+	options    map[string]optionType
+	namespaces map[string]bool
+	//any is true if the block accepts any option, like plugin transforms, which are passed all their options.
+	any bool
+}
+
+func newBlockSchema() *blockSchema {
+	return &blockSchema{options: make(map[string]optionType), namespaces: make(map[string]bool)}
+}
+
+func (s *blockSchema) add(components ...string) {
+	for _, c := range components {
+		for _, opt := range componentOptions[c] {
+			s.options[opt.name] = opt.typ
+		}
+	}
+}
+
+//addSourceSink adds the component and namespaces of a source or destination of the block.
+func (s *blockSchema) addSourceSink(ss aql.SourceSink, connMap map[string]*aql.Connection, source bool) {
+	if ss.Alias != nil {
+		s.namespaces[strings.ToUpper(*ss.Alias)] = true
+	}
+	switch {
+	case ss.Console:
+		s.namespaces[strings.ToUpper(engine.ConsoleDestinationName)] = true
+		s.add(componentConsole)
+	case ss.Global:
+		s.namespaces["GLOBAL"] = true
+		if source {
+			s.add(componentSQLSource)
+		} else {
+			s.add(componentSQLDestination)
+		}
+	case ss.Database != nil:
+		s.namespaces[strings.ToUpper(*ss.Database)] = true
+		conn, ok := connMap[strings.ToLower(*ss.Database)]
+		if !ok {
+			return
+		}
+		driver := strings.ToLower(conn.Driver)
+		switch {
+		case driver == "excel" && source:
+			s.add(componentExcelSource)
+		case driver == "excel":
+			s.add(componentExcelDestination)
+		case driver == "http":
+			s.add(componentHTTPSource)
+		case driver == "mandrill":
+			s.add(componentMandrill)
+		case source:
+			s.add(componentSQLSource)
+		default:
+			s.add(componentSQLDestination)
+		}
+	}
+}
+
+//addContent adds the options that the content of the block refers to, which are substituted into it.
+func (s *blockSchema) addContent(content string) {
+	for _, m := range templateOption.FindAllStringSubmatch(content, -1) {
+		s.options[strings.ToUpper(m[1])] = optionAny
+	}
+}
+
+//accepts returns true if the block accepts the option, either directly or namespaced.
+func (s *blockSchema) accepts(key string) bool {
+	_, ok := s.lookup(key)
+	return ok
+}
+
+//lookup returns the type of the option, if the block accepts it, either directly or namespaced.
+func (s *blockSchema) lookup(key string) (optionType, bool) {
+	key = strings.ToUpper(key)
+	if s.any {
+		return optionAny, true
+	}
+	if t, ok := s.options[key]; ok {
+		return t, true
+	}
+	for ns := range s.namespaces {
+		if !strings.HasPrefix(key, ns+"_") {
+			continue
+		}
+		if t, ok := s.options[key[len(ns)+1:]]; ok {
+			return t, true
+		}
+	}
+	return optionAny, false
+}
+
+//namespace returns the namespace of an option that the block does not accept, if it is an option that the
+//block accepts, namespaced to something other than one of its connections or aliases, like Warehuse_TABLE.
+func (s *blockSchema) namespace(key string) (string, bool) {
+	for i := 1; i < len(key); i++ {
+		if key[i] != '_' {
+			continue
+		}
+		if _, ok := s.options[strings.ToUpper(key[i+1:])]; ok {
+			return key[:i], true
+		}
+	}
+	return "", false
+}
+
+//names returns the options and the namespaces that the block accepts, sorted, for suggestions.
+func (s *blockSchema) names() (options []string, namespaces []string) {
+	for opt := range s.options {
+		options = append(options, opt)
+	}
+	for ns := range s.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(options)
+	sort.Strings(namespaces)
+	return options, namespaces
+}
+
+//querySchema returns the options that a query or exec accepts.
+func querySchema(q *aql.Query, connMap map[string]*aql.Connection) *blockSchema {
+	s := newBlockSchema()
+	for _, ss := range q.Sources {
+		s.addSourceSink(ss, connMap, true)
+	}
+	for _, ss := range q.Destinations {
+		s.addSourceSink(ss, connMap, false)
+	}
+	s.addContent(q.Content)
+	return s
+}
+
+//transformSchema returns the options that a transform accepts, including those of the "aql" struct tags
+//of built-in transforms, such as SORT_BUFFER_ROWS.
+func transformSchema(t *aql.Transform, connMap map[string]*aql.Connection) *blockSchema {
+	s := newBlockSchema()
+	s.any = t.Plugin
+	s.add(componentTransform)
+	for _, ss := range t.Sources {
+		s.addSourceSink(*ss, connMap, true)
+	}
+	for _, ss := range t.Destinations {
+		s.addSourceSink(ss, connMap, false)
+	}
+	if !t.Plugin {
+		plugin, err := builtins.Parse(t.Content)
+		if err != nil {
+			//the compiler will report the error, and the options of the transform are not known until then
+			s.any = true
+			return s
+		}
+		for _, opt := range optionTags(plugin) {
+			s.options[opt.name] = opt.typ
+		}
+	}
+	s.addContent(t.Content)
+	return s
+}
+
+//dataSchema returns the options that a data block accepts.
+func dataSchema(d *aql.Data, connMap map[string]*aql.Connection) *blockSchema {
+	s := newBlockSchema()
+	s.add(componentData)
+	for _, ss := range d.Destinations {
+		s.addSourceSink(ss, connMap, false)
+	}
+	s.addContent(d.Content)
+	return s
+}
+
+//checkOptions validates the options of the blocks and tests of the script against the registry of the options of
+//their components. An option whose value has the wrong type is an error. Unknown options, including those that are
+//namespaced to something other than a connection or alias of the block, are ignored, so they are reported as warnings.
+func checkOptions(js *aql.JobScript, connMap map[string]*aql.Connection, logger engine.Logger) error {
+	type block struct {
+		kind    string
+		name    string
+		options []aql.Option
+		schema  *blockSchema
+	}
+	var blocks []block
+	for i := range js.Queries {
+		q := &js.Queries[i]
+		blocks = append(blocks, block{"QUERY", q.Name, q.Options, querySchema(q, connMap)})
+	}
+	for i := range js.Execs {
+		q := &js.Execs[i]
+		blocks = append(blocks, block{"EXEC", q.Name, q.Options, querySchema(q, connMap)})
+	}
+	for i := range js.Transforms {
+		t := &js.Transforms[i]
+		blocks = append(blocks, block{"TRANSFORM", t.Name, t.Options, transformSchema(t, connMap)})
+	}
+	for i := range js.Data {
+		d := &js.Data[i]
+		blocks = append(blocks, block{"DATA", d.Name, d.Options, dataSchema(d, connMap)})
+	}
+	for _, t := range js.Tests {
+		schema := newBlockSchema()
+		schema.add(componentTest)
+		blocks = append(blocks, block{"TEST of", t.TargetBlock, t.Options, schema})
+	}
+
+	for _, b := range blocks {
+		options, namespaces := b.schema.names()
+		for _, opt := range b.options {
+			t, ok := b.schema.lookup(opt.Key)
+			if ok && !t.accepts(opt.Value) {
+				return aql.Errorf(opt.Position, "option %s of %s %s should be a %s", opt.Key, b.kind, b.name, t)
+			}
+			if ok {
+				continue
+			}
+			var msg string
+			if ns, ok := b.schema.namespace(opt.Key); ok {
+				msg = fmt.Sprintf("option %s of %s %s is namespaced to %s, which is not one of its connections or aliases, and is ignored%s", opt.Key, b.kind, b.name, ns, aql.DidYouMean(ns, namespaces))
+			} else {
+				msg = fmt.Sprintf("option %s of %s %s is not known to any of its components, and is ignored%s", opt.Key, b.kind, b.name, aql.DidYouMean(opt.Key, options))
+			}
+			logger.Chan() <- engine.Event{
+				Source:  "Compiler",
+				Level:   engine.Warning,
+				Time:    time.Now(),
+				Message: aql.Errorf(opt.Position, "%s", msg).Error(),
+			}
+		}
+	}
+	return nil
+}
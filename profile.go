@@ -0,0 +1,83 @@
+package analyst
+
+import (
+	"fmt"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	builtins "github.com/michaelbironneau/analyst/transforms"
+	"strings"
+)
+
+const (
+	profileTransformName   = "analyst profile"
+	profileDestinationName = profileTransformName + destinationUniquifier + "results"
+)
+
+//ProfileString runs the part of the script that the block depends on, and returns the statistics
+//of each column output by the block, in the order of transforms.ProfileColumns.
+func ProfileString(script string, block string, topValues int, opts *RuntimeOptions) ([][]interface{}, error) {
+	if opts.Logger == nil {
+		opts.Logger = engine.NewConsoleLogger(engine.Trace)
+	}
+	js, err := aql.ParseString(script)
+	if err != nil {
+		return nil, err
+	}
+	return profile(js, block, topValues, opts)
+}
+
+//ProfileFile is like ProfileString, for a script file.
+func ProfileFile(filename string, block string, topValues int, opts *RuntimeOptions) ([][]interface{}, error) {
+	if opts.Logger == nil {
+		opts.Logger = engine.NewConsoleLogger(engine.Trace)
+	}
+	js, err := aql.ParseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return profile(js, block, topValues, opts)
+}
+
+//profile connects a PROFILE transform to the block, and removes everything that it does not depend on
+//before executing the job, so that the only destinations that are written to are those of the blocks that
+//it runs AFTER, directly or indirectly.
+func profile(js *aql.JobScript, block string, topValues int, opts *RuntimeOptions) ([][]interface{}, error) {
+	if topValues <= 0 {
+		topValues = builtins.DefaultProfileTopValues
+	}
+	p, err := builtins.NewProfile(fmt.Sprintf("PROFILE TOP %d", topValues))
+	if err != nil {
+		return nil, err
+	}
+	p.SetName(profileTransformName)
+	dest := engine.SliceDestination{Alias: profileTransformName}
+	addProfile := func(dag engine.Coordinator) error {
+		if err := dag.AddTransform(profileTransformName, profileTransformName, p); err != nil {
+			return err
+		}
+		if err := dag.Connect(strings.ToLower(block), profileTransformName); err != nil {
+			return fmt.Errorf("could not profile block %s: %v", block, err)
+		}
+		if err := dag.AddDestination(profileDestinationName, profileTransformName, &dest); err != nil {
+			return err
+		}
+		if err := dag.Connect(profileTransformName, profileDestinationName); err != nil {
+			return err
+		}
+		prerequisites, err := dag.Prerequisites(profileDestinationName)
+		if err != nil {
+			return err
+		}
+		return dag.Retain(prerequisites...)
+	}
+	globals, err := openGlobals()
+	if err != nil {
+		return nil, err
+	}
+	defer globals.Close()
+	err = execute(js, opts.Options, opts.Logger, false, opts.Hooks, opts.Context, opts.ScriptDirectory, false, globals, addProfile)
+	if err != nil {
+		return nil, err
+	}
+	return dest.Results(), nil
+}
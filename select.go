@@ -0,0 +1,327 @@
+package analyst
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	"os"
+	"path"
+	"strings"
+)
+
+//selectBlocks returns a function that prunes the DAG to the blocks selected by the runtime options, or nil if
+//no blocks are selected. The selected blocks are:
+//	- with Only, the blocks that match
+//	- with From, the blocks that match and all the blocks downstream of them
+//	- with To, the blocks that match and all the blocks upstream of them
+//	- with From and To, the blocks that are both downstream of From and upstream of To
+//The selected blocks run with their destinations, along with the blocks that they need as input, which run
+//without their destinations. If the runtime options have a GLOBAL database, the GLOBAL tables that the selected
+//blocks do not write to are then loaded from it into globals, in place of those written by the pruned blocks.
+func selectBlocks(js *aql.JobScript, opts *RuntimeOptions, globals *globalDB) func(engine.Coordinator) error {
+	if len(opts.Only) == 0 && len(opts.From) == 0 && len(opts.To) == 0 {
+		return nil
+	}
+	return func(dag engine.Coordinator) error {
+		selected, err := selectedBlocks(js, dag, opts)
+		if err != nil {
+			return err
+		}
+		destinations, err := dag.Destinations(selected...)
+		if err != nil {
+			return err
+		}
+		retained, err := referencedUpstream(js, dag, append(selected, destinations...))
+		if err != nil {
+			return err
+		}
+		if err := dag.Retain(retained...); err != nil {
+			return err
+		}
+		if opts.GlobalDatabase == "" {
+			return nil
+		}
+		return globals.load(opts.GlobalDatabase, globalTables(js, selected))
+	}
+}
+
+//blockNames returns the names of the blocks of the script that can be selected, as they are named in the DAG.
+func blockNames(js *aql.JobScript) []string {
+	var ret []string
+	for _, query := range append(js.Queries, js.Execs...) {
+		ret = append(ret, strings.ToLower(query.Name))
+	}
+	for _, transform := range js.Transforms {
+		ret = append(ret, strings.ToLower(transform.Name))
+	}
+	for _, data := range js.Data {
+		ret = append(ret, strings.ToLower(data.Name))
+	}
+	return ret
+}
+
+//matchBlocks returns the blocks whose name matches any of the patterns, which are block names or globs
+//such as Load*. Patterns are not case-sensitive, and each of them should match at least one block.
+func matchBlocks(js *aql.JobScript, patterns []string) ([]string, error) {
+	var (
+		ret   []string
+		names = blockNames(js)
+		found = make(map[string]bool)
+	)
+	for _, pattern := range patterns {
+		var matched bool
+		for _, name := range names {
+			ok, err := path.Match(strings.ToLower(pattern), name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid block selector %s: %v", pattern, err)
+			}
+			if !ok {
+				continue
+			}
+			matched = true
+			if !found[name] {
+				found[name] = true
+				ret = append(ret, name)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("block selector %s does not match any block%s", pattern, aql.DidYouMean(pattern, names))
+		}
+	}
+	return ret, nil
+}
+
+func selectedBlocks(js *aql.JobScript, dag engine.Coordinator, opts *RuntimeOptions) ([]string, error) {
+	if len(opts.Only) > 0 && (len(opts.From) > 0 || len(opts.To) > 0) {
+		return nil, fmt.Errorf("blocks can be selected with either Only, or From and To, but not both")
+	}
+	if len(opts.Only) > 0 {
+		return matchBlocks(js, opts.Only)
+	}
+	var from, to []string
+	if len(opts.From) > 0 {
+		matched, err := matchBlocks(js, opts.From)
+		if err != nil {
+			return nil, err
+		}
+		if from, err = dag.Downstream(matched...); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.To) > 0 {
+		matched, err := matchBlocks(js, opts.To)
+		if err != nil {
+			return nil, err
+		}
+		if to, err = dag.Upstream(matched...); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case from == nil:
+		return to, nil
+	case to == nil:
+		return from, nil
+	}
+	upstream := make(map[string]bool)
+	for _, name := range to {
+		upstream[name] = true
+	}
+	var ret []string
+	for _, name := range from {
+		if upstream[name] {
+			ret = append(ret, name)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("none of the blocks selected by From are upstream of the blocks selected by To")
+	}
+	return ret, nil
+}
+
+//referencedUpstream returns the nodes along with all the nodes upstream of them, including the blocks that
+//are referenced by the referential integrity assertions of their tests, so that the assertions can complete.
+func referencedUpstream(js *aql.JobScript, dag engine.Coordinator, nodes []string) ([]string, error) {
+	retained := make(map[string]bool)
+	for {
+		upstream, err := dag.Upstream(nodes...)
+		if err != nil {
+			return nil, err
+		}
+		nodes = nil
+		for _, name := range upstream {
+			retained[name] = true
+		}
+		for _, t := range js.Tests {
+			if !retained[strings.ToLower(t.TargetBlock)] {
+				continue
+			}
+			assertions, err := t.Parse()
+			if err != nil {
+				return nil, err
+			}
+			for _, assertion := range assertions {
+				if assertion.Column == nil || assertion.Column.References == nil {
+					continue
+				}
+				block := strings.ToLower(assertion.Column.References.Block)
+				if !retained[block] {
+					nodes = append(nodes, block)
+				}
+			}
+		}
+		if len(nodes) == 0 {
+			break
+		}
+	}
+	var ret []string
+	for name := range retained {
+		ret = append(ret, name)
+	}
+	return ret, nil
+}
+
+//globalTables returns the GLOBAL tables that the given blocks write to.
+func globalTables(js *aql.JobScript, blocks []string) map[string]bool {
+	selected := make(map[string]bool)
+	for _, block := range blocks {
+		selected[block] = true
+	}
+	ret := make(map[string]bool)
+	add := func(block aql.Block, destinations []aql.SourceSink) {
+		if !selected[strings.ToLower(block.GetName())] {
+			return
+		}
+		for _, dest := range destinations {
+			if !dest.Global {
+				continue
+			}
+			var table string
+			maybeScan := aql.MaybeOptionScanner(block.GetName(), "", block.GetOptions())
+			if ok, err := maybeScan("TABLE", &table); ok && err == nil {
+				ret[strings.ToLower(table)] = true
+			}
+		}
+	}
+	for i := range js.Queries {
+		add(&js.Queries[i], js.Queries[i].Destinations)
+	}
+	for i := range js.Transforms {
+		add(&js.Transforms[i], js.Transforms[i].Destinations)
+	}
+	for i := range js.Data {
+		add(&js.Data[i], js.Data[i].Destinations)
+	}
+	return ret
+}
+
+//globalDB is a connection to the GLOBAL database. As the GLOBAL database is a shared in-memory SQLite
+//database, it only exists for as long as a connection to it is open, so the job holds one from before
+//GLOBAL is initialized until after the GLOBAL tables are saved.
+type globalDB struct {
+	db   *sql.DB
+	conn *sql.Conn
+}
+
+//openGlobals opens a connection to the GLOBAL database. It must be closed once the job has run.
+func openGlobals() (*globalDB, error) {
+	db, err := sql.Open(globalDbDriver, globalDbConnString)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &globalDB{db: db, conn: conn}, nil
+}
+
+func (g *globalDB) Close() error {
+	g.conn.Close()
+	return g.db.Close()
+}
+
+//load replaces the tables of the GLOBAL database by those of the SQLite database at the given path,
+//except for the given tables, which the job writes to.
+func (g *globalDB) load(dbPath string, exclude map[string]bool) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("could not load GLOBAL database: %v", err)
+	}
+	return g.withAttached(dbPath, func() error {
+		tables, err := sqliteTables(g.conn, "persisted")
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if exclude[strings.ToLower(table)] {
+				continue
+			}
+			if err := copyTable(g.conn, "persisted", "main", table); err != nil {
+				return fmt.Errorf("could not load GLOBAL table %s: %v", table, err)
+			}
+		}
+		return nil
+	})
+}
+
+//save saves the tables of the GLOBAL database to the SQLite database at the given path, replacing
+//the tables with the same name. It does nothing if the path is empty.
+func (g *globalDB) save(dbPath string) error {
+	if dbPath == "" {
+		return nil
+	}
+	return g.withAttached(dbPath, func() error {
+		tables, err := sqliteTables(g.conn, "main")
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if err := copyTable(g.conn, "main", "persisted", table); err != nil {
+				return fmt.Errorf("could not save GLOBAL table %s: %v", table, err)
+			}
+		}
+		return nil
+	})
+}
+
+//withAttached calls f with the SQLite database at the given path attached to the connection as "persisted".
+func (g *globalDB) withAttached(dbPath string, f func() error) error {
+	ctx := context.Background()
+	if _, err := g.conn.ExecContext(ctx, "ATTACH DATABASE ? AS persisted", dbPath); err != nil {
+		return fmt.Errorf("could not open GLOBAL database %s: %v", dbPath, err)
+	}
+	defer g.conn.ExecContext(ctx, "DETACH DATABASE persisted")
+	return f()
+}
+
+func sqliteTables(conn *sql.Conn, schema string) ([]string, error) {
+	rows, err := conn.QueryContext(context.Background(), fmt.Sprintf("SELECT name FROM %s.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%%'", schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		ret = append(ret, name)
+	}
+	return ret, rows.Err()
+}
+
+//copyTable replaces the table in the destination schema by a copy of the table in the source schema.
+//The copy has the same columns and rows, but not the indexes and constraints of the original table.
+func copyTable(conn *sql.Conn, from, to, table string) error {
+	ctx := context.Background()
+	quoted := `"` + strings.Replace(table, `"`, `""`, -1) + `"`
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", to, quoted)); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s.%s AS SELECT * FROM %s.%s", to, quoted, from, quoted))
+	return err
+}
@@ -0,0 +1,67 @@
+package analyst
+
+import (
+	"fmt"
+	"github.com/michaelbironneau/analyst/aql"
+	"github.com/michaelbironneau/analyst/engine"
+	"strings"
+)
+
+//UpdateSnapshots is a destination hook that makes snapshot tests overwrite their snapshots with the
+//output of their block, instead of comparing it against them.
+var UpdateSnapshots = engine.DestinationHook(updateSnapshots)
+
+func updateSnapshots(name string, dest engine.Destination) (engine.Destination, error) {
+	if d, ok := dest.(*engine.SnapshotDestination); ok {
+		d.Update = true
+	}
+	return nil, nil
+}
+
+//snapshotNodeName is the name of the snapshot test of the block, numbered like its assertions.
+func snapshotNodeName(target string, index int) string {
+	return fmt.Sprintf("%s, snapshot %d", target, index+1)
+}
+
+//snapshotKeys returns the columns that the rows of the snapshot are matched by, from the KEYS option.
+func snapshotKeys(t *aql.Test) ([]string, error) {
+	opt, ok := aql.FindOption(t.Options, "KEYS")
+	if !ok {
+		return nil, nil
+	}
+	s, ok := opt.String()
+	if !ok {
+		return nil, aql.Errorf(opt.Position, "the KEYS option of the snapshot test of %s should be a comma-separated list of columns", t.TargetBlock)
+	}
+	var keys []string
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+//snapshot connects a destination to the target block, that compares its output against the snapshot.
+func snapshot(t *aql.Test, dag engine.Coordinator, index int) error {
+	keys, err := snapshotKeys(t)
+	if err != nil {
+		return err
+	}
+	name := snapshotNodeName(t.TargetBlock, index)
+	nodeName := strings.ToLower(t.TargetBlock + destinationUniquifier + name)
+	d := &engine.SnapshotDestination{
+		SliceDestination: engine.SliceDestination{Alias: nodeName},
+		Block:            t.TargetBlock,
+		Name:             name,
+		Path:             *t.Snapshot,
+		Keys:             keys,
+	}
+	if err := dag.AddDestination(nodeName, nodeName, d); err != nil {
+		return err
+	}
+	if err := dag.Connect(strings.ToLower(t.TargetBlock), nodeName); err != nil {
+		return aql.Errorf(t.Position, "could not find block %s of snapshot test: %v", t.TargetBlock, err)
+	}
+	return nil
+}
@@ -26,7 +26,25 @@ var (
 		`|(?P<String>'[^']*'|"[^"]*")`+
 		`|(?P<Operators><>|!=|<=|>=|[-+*/%,.()=<>])`,
 	)), "Keyword"), "String")
-	Reducers                  = map[string]Reducer{"cdf(": &cdf{}, "quantile(": &quantile{}, "count(": &count{}, "sum(": &sum{}, "min(": &min{}, "max(": &max{}, "avg(": &avg{}, "zoh(": &zoh{}}
+	Reducers = map[string]Reducer{
+		"cdf(":                   &cdf{},
+		"quantile(":              &quantile{},
+		"count(":                 &count{},
+		"sum(":                   &sum{},
+		"min(":                   &min{},
+		"max(":                   &max{},
+		"avg(":                   &avg{},
+		"zoh(":                   &zoh{},
+		"stddev(":                &variance{stddev: true},
+		"variance(":              &variance{},
+		"median(":                &median{},
+		"count_distinct(":        &countDistinct{},
+		"approx_count_distinct(": &countDistinct{approximate: true},
+		"first(":                 &first{},
+		"last(":                  &first{last: true},
+		"string_agg(":            &stringAgg{},
+		"mode(":                  &mode{},
+	}
 	DefaultArgMap ArgumentMap = func(i []interface{}) []interface{} { return i }
 )
 
@@ -86,8 +104,8 @@ type Reducer interface {
 	ParameterLen() int //ParameterLen returns the number of parameters the function takes (should be constant)
 	SetArgumentMap(ArgumentMap)
 	Reduce(arg []interface{}) error
-	Copy() Reducer //Returns a reducer with blank state
-	Return() *float64
+	Copy() Reducer       //Returns a reducer with blank state
+	Return() interface{} //Returns the result, or nil if there is none (eg. if all inputs were NULL)
 }
 
 type groupByRow struct {
@@ -235,13 +253,7 @@ func (a *aggregate) Open(s engine.Stream, dest engine.Stream, l engine.Logger, s
 				continue
 			}
 			if g, ok := row.aggregates[col]; ok {
-				var f *float64
-				f = g.Return()
-				if f == nil {
-					data = append(data, nil)
-				} else {
-					data = append(data, *f)
-				}
+				data = append(data, g.Return())
 				continue
 			}
 			panic(fmt.Sprintf("column %s not found", col)) //should be unreachable
@@ -289,38 +301,42 @@ func getFunctionArgs(a *Aggregate, fIx int) func(cols []string) (ArgumentMap, er
 		if a.Select[fIx].Function == nil {
 			panic("cannot apply getFunctionArgs to nil Function")
 		}
+		return bindFunctionArgs(a.Select[fIx].Function.Columns, cols)
+	}
+}
 
-		//static params we work out at Open()-time.
-		params := make([]interface{}, len(a.Select[fIx].Function.Columns))
-
-		for i, col := range a.Select[fIx].Function.Columns {
-			if col.String != nil {
-				params[i] = *col.String
-			} else if col.Number != nil {
-				params[i] = *col.Number
-			} else {
-				ix, ok := find(cols, col.Column)
-				if !ok {
-					return nil, fmt.Errorf("column not found %s", col.Column)
-				}
-				params[i] = columnIndex(ix)
+//bindFunctionArgs returns the argument map for the function arguments, given the actual columns.
+func bindFunctionArgs(args []FunctionArgument, cols []string) (ArgumentMap, error) {
+	//static params we work out at Open()-time.
+	params := make([]interface{}, len(args))
+
+	for i, col := range args {
+		if col.String != nil {
+			params[i] = *col.String
+		} else if col.Number != nil {
+			params[i] = *col.Number
+		} else {
+			ix, ok := find(cols, col.Column)
+			if !ok {
+				return nil, fmt.Errorf("column not found %s", col.Column)
 			}
+			params[i] = columnIndex(ix)
 		}
+	}
 
-		//dynamic params we work out at run-time.
-		return func(msg []interface{}) []interface{} {
-			ret := make([]interface{}, len(params), len(params))
-			for i := range params {
-				switch v := params[i].(type) {
-				case columnIndex:
-					ret[i] = msg[v]
-				default:
-					ret[i] = v
-				}
+	//dynamic params we work out at run-time.
+	return func(msg []interface{}) []interface{} {
+		ret := make([]interface{}, len(params), len(params))
+		for i := range params {
+			switch v := params[i].(type) {
+			case columnIndex:
+				ret[i] = msg[v]
+			default:
+				ret[i] = v
 			}
-			return ret
-		}, nil
-	}
+		}
+		return ret
+	}, nil
 }
 
 func (a *aggregate) Sequence(seq []string) {
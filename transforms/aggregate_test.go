@@ -242,3 +242,38 @@ func TestCountAggregate(t *testing.T) {
 	})
 
 }
+
+func TestCountAggregateOfColumn(t *testing.T) {
+	Convey("Given a count aggregate of a column", t, func() {
+		a, err := NewAggregate(`AGGREGATE COUNT(A) As Val, B GROUP BY B`)
+		So(err, ShouldBeNil)
+		Convey("It should count the rows of each group rather than sum the column, including NULLs", func() {
+			in := engine.NewStream([]string{"A", "B"}, 100)
+			out := engine.NewStream(nil, 100)
+			l := engine.ConsoleLogger{}
+			st := engine.NewStopper()
+			a.SetName("Agg")
+			for i := 0; i < 5; i++ {
+				var v interface{} = 10 * i
+				if i == 4 {
+					v = nil
+				}
+				in.Chan("Agg") <- engine.Message{Source: "Source", Destination: "Agg", Data: []interface{}{v, i % 2}}
+			}
+			close(in.Chan("Agg"))
+			a.Open(in, out, &l, st)
+			var res [][]interface{}
+			for msg := range out.Chan(engine.DestinationWildcard) {
+				res = append(res, msg.Data)
+			}
+			So(res, ShouldHaveLength, 2)
+			for _, row := range res {
+				if row[1].(int) == 0 {
+					So(row, ShouldResemble, []interface{}{3.0, 0})
+				} else {
+					So(row, ShouldResemble, []interface{}{2.0, 1})
+				}
+			}
+		})
+	})
+}
@@ -5,13 +5,15 @@ import (
 	"github.com/alecthomas/participle"
 	"github.com/alecthomas/participle/lexer"
 	"github.com/michaelbironneau/analyst/engine"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var (
 	applyLexer = lexer.Unquote(lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
-		`|(?P<Keyword>(?i)APPLY\s|CAST\(|AS\s)`+
+		`|(?P<Keyword>(?i)APPLY\s|TRY_CAST\(|CAST\(|AS\s)`+
+		`|(?P<TimeZone>(?i)AT\s+TIME\s+ZONE\s)`+
 		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
 		`|(?P<Number>[-+]?\d*\.?\d+([eE][-+]?\d+)?)`+
 		`|(?P<String>'[^']*'|"[^"]*")`+
@@ -25,9 +27,13 @@ type Column struct {
 }
 
 type CastColumn struct {
-	Column   string  `"CAST(" @Ident`
-	DestType string  `"AS " @Ident ")"`
-	Alias    *string `["AS " @Ident]`
+	Try      bool     `( @"TRY_CAST(" | "CAST(" )`
+	Column   string   `@Ident`
+	DestType string   `"AS " @Ident`
+	Args     []string `[ "(" @Number { "," @Number } ")" ]`
+	Format   *string  `[ @String ]`
+	TimeZone *string  `[ TimeZone @String ] ")"`
+	Alias    *string  `["AS " @Ident]`
 }
 
 type ConversionColumn struct {
@@ -62,14 +68,24 @@ func projectArray(projectionColumns []string, actualColumns []string) (func([]in
 	}, nil
 }
 
+const (
+	//TryCastNull makes TRY_CAST output NULL for values that cannot be cast. This is the default.
+	TryCastNull = "NULL"
+
+	//TryCastReject makes TRY_CAST drop rows with values that cannot be cast, logging them as warnings.
+	TryCastReject = "REJECT"
+)
+
 type apply struct {
 	outgoingName string
 	sourceSeq    []string
 	sourceCols   []string
 	outputCols   []string
 	castFns      []CastFn
+	tryCast      []bool
 	projection   []ConversionColumn
 	sequencer    engine.Sequencer
+	TryCastError string `aql:"TRY_CAST_ON_ERROR, optional"`
 }
 
 //  Sequence is required to satisfy Sequenceable interface, but does nothing for a apply.
@@ -78,6 +94,15 @@ func (l *apply) Sequence([]string) {}
 
 func (l *apply) SetName(name string) { l.outgoingName = name }
 
+//CheckOptions checks that TRY_CAST_ON_ERROR, if it is set, is one of the values that TRY_CAST understands.
+func (l *apply) CheckOptions() error {
+	switch strings.ToUpper(l.TryCastError) {
+	case "", TryCastNull, TryCastReject:
+		return nil
+	}
+	return fmt.Errorf("TRY_CAST_ON_ERROR should be %s or %s but got %s", TryCastNull, TryCastReject, l.TryCastError)
+}
+
 func (l *apply) Open(s engine.Stream, dest engine.Stream, logger engine.Logger, st engine.Stopper) {
 
 	inChan := s.Chan(l.outgoingName)
@@ -90,6 +115,11 @@ func (l *apply) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 		err          error
 	)
 
+	if err := l.CheckOptions(); err != nil {
+		l.fatalerr(err, dest, logger, st)
+		return
+	}
+
 	l.log(logger, engine.Info, "Apply transform opened")
 	for msg := range inChan {
 		if st.Stopped() {
@@ -97,6 +127,7 @@ func (l *apply) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 			return
 		}
 		if firstMessage {
+			firstMessage = false
 			projectOp, err = projectArray(l.sourceCols, s.Columns())
 			if err != nil {
 				l.fatalerr(err, s, logger, st)
@@ -104,20 +135,34 @@ func (l *apply) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 			}
 		}
 		l.log(logger, engine.Trace, "Found row %s", msg.Data)
-		out := make([]interface{}, len(msg.Data), len(msg.Data))
 		projected := projectOp(msg.Data)
+		out := make([]interface{}, len(projected), len(projected))
+		rejected := false
 		for i := range projected {
-			if l.castFns[i] != nil {
-				//this is a cast
-				out[i], err = l.castFns[i](projected[i])
-				if err != nil {
-					l.fatalerr(err, s, logger, st)
-					return
-				}
-			} else {
+			if l.castFns[i] == nil {
 				//this is a simple lookup
 				out[i] = projected[i]
+				continue
 			}
+			//this is a cast
+			out[i], err = l.castFns[i](projected[i])
+			if err == nil {
+				continue
+			}
+			if !l.tryCast[i] {
+				l.fatalerr(err, s, logger, st)
+				return
+			}
+			if strings.ToUpper(l.TryCastError) == TryCastReject {
+				l.log(logger, engine.Warning, "Rejected row %v: could not cast column %s: %v", msg.Data, l.sourceCols[i], err)
+				rejected = true
+				break
+			}
+			out[i] = nil
+		}
+
+		if rejected {
+			continue
 		}
 
 		outChan <- engine.Message{
@@ -155,6 +200,7 @@ func newApply(c *Apply) (*apply, error) {
 	var ret apply
 
 	ret.castFns = make([]CastFn, len(c.Projections), len(c.Projections))
+	ret.tryCast = make([]bool, len(c.Projections), len(c.Projections))
 
 	//set up source and destination columns
 	for i, proj := range c.Projections {
@@ -164,10 +210,19 @@ func newApply(c *Apply) (*apply, error) {
 			} else {
 				ret.outputCols = append(ret.outputCols, proj.Cast.Column)
 			}
-			var ok bool
-			if ret.castFns[i], ok = castFns[strings.ToLower(proj.Cast.DestType)]; !ok {
-				return nil, fmt.Errorf("unknown destination type for cast: %s", proj.Cast.DestType)
+			var args []int
+			for _, arg := range proj.Cast.Args {
+				n, err := strconv.Atoi(arg)
+				if err != nil {
+					return nil, fmt.Errorf("expected an integer argument for %s but got %s", proj.Cast.DestType, arg)
+				}
+				args = append(args, n)
+			}
+			var err error
+			if ret.castFns[i], err = newCastFn(proj.Cast.DestType, args, proj.Cast.Format, proj.Cast.TimeZone); err != nil {
+				return nil, err
 			}
+			ret.tryCast[i] = proj.Cast.Try
 			ret.sourceCols = append(ret.sourceCols, proj.Cast.Column)
 			continue
 		}
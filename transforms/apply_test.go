@@ -78,4 +78,47 @@ func TestApply(t *testing.T){
 			So(count, ShouldEqual, 1)
 		})
 	})
-}
\ No newline at end of file
+}
+
+func TestApplyTryCast(t *testing.T) {
+	rows := [][]interface{}{
+		[]interface{}{"a", "1.5"},
+		[]interface{}{"b", "oops"},
+	}
+	Convey("Given an APPLY transform with TRY_CAST", t, func() {
+		l, err := NewApply(`APPLY Name, TRY_CAST(Value AS DECIMAL(5, 1)) AS Value`)
+		So(err, ShouldBeNil)
+		So(l.tryCast, ShouldResemble, []bool{false, true})
+		Convey("It should output NULL for values that cannot be cast", func() {
+			_, res := runTransform(l, []string{"Name", "Value"}, rows)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{"a", 1.5},
+				[]interface{}{"b", nil},
+			})
+		})
+		Convey("It should drop rows with values that cannot be cast if TRY_CAST_ON_ERROR is REJECT", func() {
+			l.TryCastError = TryCastReject
+			_, res := runTransform(l, []string{"Name", "Value"}, rows)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{"a", 1.5},
+			})
+		})
+	})
+	Convey("Given a CAST with a date format and time zone", t, func() {
+		l, err := NewApply(`APPLY CAST(Day AS DATETIME 'DD/MM/YYYY HH24:MI' AT TIME ZONE 'Europe/Paris') AS Day`)
+		So(err, ShouldBeNil)
+		Convey("It should parse times in that time zone", func() {
+			_, res := runTransform(l, []string{"Day"}, [][]interface{}{
+				[]interface{}{"25/12/2018 13:30"},
+			})
+			So(res, ShouldHaveLength, 1)
+			So(res[0][0].(*time.Time).UTC().Format(time.RFC3339), ShouldEqual, "2018-12-25T12:30:00Z")
+		})
+	})
+	Convey("Given an invalid CAST", t, func() {
+		_, err := NewApply(`APPLY CAST(Value AS INT(2))`)
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -42,11 +42,11 @@ func (s *avg) Reduce(arg []interface{}) error {
 	return nil
 }
 
-func (s *avg) Return() *float64 {
+func (s *avg) Return() interface{} {
 	if !s.notNull {
 		return nil
 	}
-	return &s.result
+	return s.result
 }
 
 func (s *avg) Copy() Reducer {
@@ -24,7 +24,7 @@ func TestAvg(t *testing.T) {
 			}
 			f := agg.Return()
 			So(f, ShouldNotBeNil)
-			So(*f, ShouldEqual, 0.5)
+			So(f, ShouldEqual, 0.5)
 		})
 	})
 }
@@ -2,7 +2,9 @@ package transforms
 
 import (
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -10,7 +12,118 @@ import (
 type CastFn func(src interface{}) (interface{}, error)
 
 // castFns is a map from the destination type to the cast function
-var castFns = map[string]CastFn{"int": castToInt, "varchar": castToString, "datetime": castToTime}
+var castFns = map[string]CastFn{
+	"int":      castToInt,
+	"float":    castToFloat,
+	"bool":     castToBool,
+	"boolean":  castToBool,
+	"varchar":  castToString,
+	"datetime": castToTime,
+	"date":     castToDate,
+}
+
+// dateFormatTokens maps the tokens of a date format such as 'DD/MM/YYYY' to the Go layout, longest tokens first.
+var dateFormatTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"},
+	{"HH24", "15"},
+	{"HH12", "03"},
+	{"MON", "Jan"},
+	{"FFF", "000"},
+	{"YY", "06"},
+	{"MM", "01"},
+	{"DD", "02"},
+	{"HH", "15"},
+	{"MI", "04"},
+	{"SS", "05"},
+	{"AM", "PM"},
+	{"PM", "PM"},
+	{"TZ", "Z07:00"},
+}
+
+// dateLayout converts a date format such as 'DD/MM/YYYY HH24:MI:SS' to a Go time layout. For compatibility with
+// DefaultDatabaseDateFormat, MM means minutes rather than months when it directly follows an hour and a colon,
+// as in 'HH:MM'.
+func dateLayout(format string) string {
+	var (
+		layout string
+		upper  = strings.ToUpper(format)
+		//afterHour is 1 right after an hour, and 2 right after an hour and a colon
+		afterHour int
+	)
+outer:
+	for i := 0; i < len(format); {
+		for _, t := range dateFormatTokens {
+			if !strings.HasPrefix(upper[i:], t.token) {
+				continue
+			}
+			switch {
+			case t.token == "MM" && afterHour == 2:
+				layout += "04"
+				afterHour = 0
+			case strings.HasPrefix(t.token, "HH"):
+				layout += t.layout
+				afterHour = 1
+			default:
+				layout += t.layout
+				afterHour = 0
+			}
+			i += len(t.token)
+			continue outer
+		}
+		if format[i] == ':' && afterHour == 1 {
+			afterHour = 2
+		} else {
+			afterHour = 0
+		}
+		layout += format[i : i+1]
+		i++
+	}
+	return layout
+}
+
+// newCastFn returns the cast function for the destination type, which may have arguments such as the precision
+// and scale of a decimal, a date format, or a time zone.
+func newCastFn(destType string, args []int, format *string, timezone *string) (CastFn, error) {
+	destType = strings.ToLower(destType)
+	if destType == "decimal" {
+		if format != nil || timezone != nil {
+			return nil, fmt.Errorf("DECIMAL does not accept a format or time zone")
+		}
+		return newDecimalCast(args)
+	}
+	if len(args) > 0 {
+		return nil, fmt.Errorf("%s does not accept arguments", strings.ToUpper(destType))
+	}
+	if format == nil && timezone == nil {
+		fn, ok := castFns[destType]
+		if !ok {
+			return nil, fmt.Errorf("unknown destination type for cast: %s", destType)
+		}
+		return fn, nil
+	}
+	var (
+		layout string
+		loc    = time.UTC
+		err    error
+	)
+	if format != nil {
+		layout = dateLayout(*format)
+	}
+	if timezone != nil {
+		if loc, err = time.LoadLocation(*timezone); err != nil {
+			return nil, fmt.Errorf("unknown time zone '%s': %v", *timezone, err)
+		}
+	}
+	switch destType {
+	case "datetime":
+		return newTimeCast(layout, loc, timezone != nil, false), nil
+	case "date":
+		return newTimeCast(layout, loc, timezone != nil, true), nil
+	case "varchar":
+		return newTimeFormatCast(layout, loc, timezone != nil), nil
+	}
+	return nil, fmt.Errorf("%s does not accept a format or time zone", strings.ToUpper(destType))
+}
 
 func castToInt(src interface{}) (interface{}, error) {
 	if src == nil {
@@ -66,6 +179,87 @@ func castToString(src interface{}) (interface{}, error) {
 	}
 }
 
+func castToFloat(src interface{}) (interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	switch v := src.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	case bool:
+		if v {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	default:
+		return nil, fmt.Errorf("unsupported type for casting to float: %T", src)
+	}
+}
+
+func castToBool(src interface{}) (interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	switch v := src.(type) {
+	case bool:
+		return v, nil
+	case int:
+		return v != 0, nil
+	case int64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "t", "yes", "y", "1":
+			return true, nil
+		case "false", "f", "no", "n", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean '%s'", v)
+	default:
+		return nil, fmt.Errorf("unsupported type for casting to boolean: %T", src)
+	}
+}
+
+// newDecimalCast returns a cast to a float rounded to the scale, given the arguments of DECIMAL(precision[, scale]).
+// Values with more than precision - scale digits before the decimal point are rejected.
+func newDecimalCast(args []int) (CastFn, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, fmt.Errorf("expected DECIMAL(precision[, scale])")
+	}
+	precision, scale := args[0], 0
+	if len(args) == 2 {
+		scale = args[1]
+	}
+	if precision <= 0 || scale < 0 || scale > precision {
+		return nil, fmt.Errorf("invalid DECIMAL(%v, %v): precision must be positive and scale must be between 0 and precision", precision, scale)
+	}
+	limit := math.Pow10(precision - scale)
+	return func(src interface{}) (interface{}, error) {
+		f, err := castToFloat(src)
+		if err != nil || f == nil {
+			return f, err
+		}
+		rounded, err := strconv.ParseFloat(strconv.FormatFloat(f.(float64), 'f', scale, 64), 64)
+		if err != nil {
+			return nil, err
+		}
+		if math.Abs(rounded) >= limit {
+			return nil, fmt.Errorf("value %v does not fit in DECIMAL(%v, %v)", src, precision, scale)
+		}
+		return rounded, nil
+	}, nil
+}
+
 func castToTime(src interface{}) (interface{}, error) {
 	if src == nil {
 		return nil, nil
@@ -77,8 +271,94 @@ func castToTime(src interface{}) (interface{}, error) {
 	case int:
 		t := time.Unix(int64(v), 0)
 		return &t, nil
+	case int64:
+		t := time.Unix(v, 0)
+		return &t, nil
+	case time.Time:
+		return &v, nil
+	case *time.Time:
+		if v == nil {
+			return nil, nil
+		}
+		return v, nil
 	default:
-		return nil, fmt.Errorf("unsupported type for casting to integer: %T", src)
+		return nil, fmt.Errorf("unsupported type for casting to datetime: %T", src)
+	}
+
+}
+
+func castToDate(src interface{}) (interface{}, error) {
+	t, err := castToTime(src)
+	if err != nil || t == nil {
+		return t, err
+	}
+	return truncateToDate(t.(*time.Time)), nil
+}
+
+func truncateToDate(t *time.Time) *time.Time {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return &d
+}
+
+// newTimeCast returns a cast to a datetime, or to a date if dateOnly is true. Strings are parsed with the layout
+// if it is not empty and are otherwise interpreted in the same way as castToTime. If convert is true, times are
+// converted to the location, and strings without a time zone are interpreted in it.
+func newTimeCast(layout string, loc *time.Location, convert bool, dateOnly bool) CastFn {
+	return func(src interface{}) (interface{}, error) {
+		var (
+			t   *time.Time
+			err error
+		)
+		if s, ok := src.(string); ok && layout != "" {
+			var parsed time.Time
+			if parsed, err = time.ParseInLocation(layout, strings.TrimSpace(s), loc); err != nil {
+				return nil, err
+			}
+			t = &parsed
+		} else if ok {
+			if t, _, err = parseTimeIn(s, loc); err != nil {
+				return nil, err
+			}
+		} else {
+			v, err := castToTime(src)
+			if err != nil || v == nil {
+				return v, err
+			}
+			t = v.(*time.Time)
+		}
+		if convert {
+			converted := t.In(loc)
+			t = &converted
+		}
+		if dateOnly {
+			t = truncateToDate(t)
+		}
+		return t, nil
 	}
+}
 
+// newTimeFormatCast returns a cast from a datetime to a string with the layout, or RFC3339 if it is empty. If
+// convert is true, the time is converted to the location first. Other types are cast as in castToString.
+func newTimeFormatCast(layout string, loc *time.Location, convert bool) CastFn {
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return func(src interface{}) (interface{}, error) {
+		var t time.Time
+		switch v := src.(type) {
+		case time.Time:
+			t = v
+		case *time.Time:
+			if v == nil {
+				return nil, nil
+			}
+			t = *v
+		default:
+			return castToString(src)
+		}
+		if convert {
+			t = t.In(loc)
+		}
+		return t.Format(layout), nil
+	}
 }
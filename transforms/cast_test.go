@@ -69,3 +69,105 @@ func TestCastToTime(t *testing.T) {
 		})
 	})
 }
+
+func TestCastToFloatAndBool(t *testing.T) {
+	Convey("Given valid values", t, func() {
+		Convey("It should be possible to cast them to a float", func() {
+			tests := map[interface{}]float64{1: 1, 2.5: 2.5, "10.25": 10.25, true: 1}
+			for input, output := range tests {
+				actual, err := castToFloat(input)
+				So(actual, ShouldEqual, output)
+				So(err, ShouldBeNil)
+			}
+		})
+		Convey("It should be possible to cast them to a boolean", func() {
+			tests := map[interface{}]bool{1: true, 0.0: false, "TRUE": true, "no": false, false: false}
+			for input, output := range tests {
+				actual, err := castToBool(input)
+				So(actual, ShouldEqual, output)
+				So(err, ShouldBeNil)
+			}
+		})
+	})
+	Convey("Given invalid values", t, func() {
+		Convey("It should be impossible to cast them to a float or a boolean", func() {
+			_, err := castToFloat("asdf")
+			So(err, ShouldNotBeNil)
+			_, err = castToBool("maybe")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCastToDecimal(t *testing.T) {
+	Convey("Given a DECIMAL(5, 2) cast", t, func() {
+		fn, err := newCastFn("DECIMAL", []int{5, 2}, nil, nil)
+		So(err, ShouldBeNil)
+		Convey("It should round values to the scale", func() {
+			actual, err := fn("12.345")
+			So(err, ShouldBeNil)
+			So(actual, ShouldEqual, 12.35)
+		})
+		Convey("It should reject values that exceed the precision", func() {
+			_, err := fn(1000)
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Given an invalid DECIMAL cast", t, func() {
+		_, err := newCastFn("DECIMAL", []int{2, 3}, nil, nil)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCastWithFormat(t *testing.T) {
+	Convey("Given date formats", t, func() {
+		Convey("They should be converted to Go layouts", func() {
+			So(dateLayout("DD/MM/YYYY"), ShouldEqual, "02/01/2006")
+			So(dateLayout("yyyy-mm-dd hh24:mi:ss.fff"), ShouldEqual, "2006-01-02 15:04:05.000")
+			So(dateLayout(DefaultDatabaseDateFormat), ShouldEqual, defaultDatabaseGoDateFormat)
+			So(dateLayout("HH24:MI DD/MM/YYYY"), ShouldEqual, "15:04 02/01/2006")
+			So(dateLayout("HH:MM DD/MM/YYYY"), ShouldEqual, "15:04 02/01/2006")
+		})
+	})
+	Convey("Given a DATE cast with a format", t, func() {
+		fn, err := newCastFn("date", nil, &[]string{"DD/MM/YYYY"}[0], nil)
+		So(err, ShouldBeNil)
+		Convey("It should parse dates in that format", func() {
+			actual, err := fn("25/12/2018")
+			So(err, ShouldBeNil)
+			So(actual.(*time.Time).Equal(time.Date(2018, 12, 25, 0, 0, 0, 0, time.UTC)), ShouldBeTrue)
+			_, err = fn("2018-12-25")
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Given a VARCHAR cast with a format and time zone", t, func() {
+		fn, err := newCastFn("varchar", nil, &[]string{"YYYY-MM-DD HH24:MI"}[0], &[]string{"America/New_York"}[0])
+		So(err, ShouldBeNil)
+		Convey("It should convert and format times", func() {
+			actual, err := fn(time.Date(2018, 12, 25, 12, 0, 0, 0, time.UTC))
+			So(err, ShouldBeNil)
+			So(actual, ShouldEqual, "2018-12-25 07:00")
+		})
+	})
+	Convey("Given a DATETIME cast with a time zone but no format", t, func() {
+		london, err := time.LoadLocation("Europe/London")
+		So(err, ShouldBeNil)
+		fn, err := newCastFn("datetime", nil, nil, &[]string{"Europe/London"}[0])
+		So(err, ShouldBeNil)
+		Convey("It should interpret strings without a time zone in it", func() {
+			actual, err := fn("2018-07-01 12:00:00")
+			So(err, ShouldBeNil)
+			So(actual.(*time.Time).Equal(time.Date(2018, 7, 1, 12, 0, 0, 0, london)), ShouldBeTrue)
+		})
+		Convey("It should convert strings with a time zone to it", func() {
+			actual, err := fn("2018-07-01T12:00:00Z")
+			So(err, ShouldBeNil)
+			So(actual.(*time.Time).Equal(time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)), ShouldBeTrue)
+			So(actual.(*time.Time).Hour(), ShouldEqual, 13)
+		})
+	})
+	Convey("Given an unknown time zone", t, func() {
+		_, err := newCastFn("datetime", nil, nil, &[]string{"Nowhere/Special"}[0])
+		So(err, ShouldNotBeNil)
+	})
+}
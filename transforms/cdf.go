@@ -63,14 +63,13 @@ func (q *cdf) Reduce(arg []interface{}) error {
 	return nil
 }
 
-func (q *cdf) Return() *float64 {
+func (q *cdf) Return() interface{} {
 	if !q.notNull || !q.notFirst {
 		return nil
 	}
-	f := q.td.CDF(q.val)
-	return &f
+	return q.td.CDF(q.val)
 }
 
 func (q *cdf) Copy() Reducer {
-	return &quantile{am: q.am}
+	return &cdf{am: q.am}
 }
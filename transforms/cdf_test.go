@@ -24,7 +24,24 @@ func TestCDF(t *testing.T) {
 			}
 			f := agg.Return()
 			So(f, ShouldNotBeNil)
-			So(*f, ShouldEqual, 0.5)
+			So(f, ShouldEqual, 0.5)
+		})
+	})
+}
+
+func TestCDFCopy(t *testing.T) {
+	Convey("Given a copy of a CDF aggregate", t, func() {
+		blank := cdf{}
+		blank.SetArgumentMap(DefaultArgMap)
+		agg := blank.Copy()
+		Convey("It should be a CDF aggregate", func() {
+			So(agg, ShouldHaveSameTypeAs, &cdf{})
+		})
+		Convey("It should return the CDF at the value rather than a quantile", func() {
+			for _, v := range []int{1, 2, 3, 4} {
+				So(agg.Reduce([]interface{}{v, 10.0}), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, 1.0)
 		})
 	})
 }
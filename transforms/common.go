@@ -29,3 +29,21 @@ func parseTime(s string) (*time.Time, string, error) {
 	}
 	return &t, time.RFC3339, nil
 }
+
+//zonelessGoDateFormats are the formats, without a time zone, that parseTimeIn accepts on top of those of parseTime.
+var zonelessGoDateFormats = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+//parseTimeIn parses the string in the same way as parseTime, but also accepts ISO 8601 times without a time zone,
+//such as 2018-01-02 15:04:05, which are interpreted in the location.
+func parseTimeIn(s string, loc *time.Location) (*time.Time, string, error) {
+	t, format, err := parseTime(s)
+	if err == nil {
+		return t, format, nil
+	}
+	for _, layout := range zonelessGoDateFormats {
+		if parsed, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return &parsed, layout, nil
+		}
+	}
+	return nil, "", fmt.Errorf("unknown time format %s: expected RFC3339, RFC3339 with nanoseconds, %s or YYYY-MM-DD HH:MM:SS", s, DefaultDatabaseDateFormat)
+}
@@ -16,16 +16,17 @@ func (s *count) SetArgumentMap(am ArgumentMap) {
 
 func (s *count) Reduce(arg []interface{}) error {
 	s.result += 1
+	s.notNull = true
 	return nil
 }
 
-func (s *count) Return() *float64 {
+func (s *count) Return() interface{} {
 	if !s.notNull {
 		return nil
 	}
-	return &s.result
+	return s.result
 }
 
 func (s *count) Copy() Reducer {
-	return &sum{am: s.am}
+	return &count{am: s.am}
 }
@@ -0,0 +1,39 @@
+package transforms
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"math"
+	"testing"
+)
+
+func TestCountDistinct(t *testing.T) {
+	Convey("Given a count distinct aggregate", t, func() {
+		agg := countDistinct{}
+		agg.SetArgumentMap(DefaultArgMap)
+		Convey("It should reduce to 0 if there are no messages", func() {
+			So(agg.Return(), ShouldEqual, 0.0)
+		})
+		Convey("It should reduce multiple message correctly", func() {
+			for _, v := range []interface{}{"a", "b", nil, "a", 1} {
+				So(agg.Reduce([]interface{}{v}), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, 3.0)
+		})
+	})
+	Convey("Given an approximate count distinct aggregate", t, func() {
+		agg := countDistinct{approximate: true}
+		agg.SetArgumentMap(DefaultArgMap)
+		Convey("It should be exact for small sets", func() {
+			for _, v := range []interface{}{"a", "b", nil, "a", 1} {
+				So(agg.Reduce([]interface{}{v}), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, 3.0)
+		})
+		Convey("It should be accurate for large sets", func() {
+			for i := 0; i < 100000; i++ {
+				So(agg.Reduce([]interface{}{i % 50000}), ShouldBeNil)
+			}
+			So(math.Abs(agg.Return().(float64)-50000)/50000, ShouldBeLessThan, 0.03)
+		})
+	})
+}
@@ -0,0 +1,266 @@
+package transforms
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/michaelbironneau/analyst/engine"
+	"hash/fnv"
+	"io"
+	"time"
+)
+
+const (
+	//DefaultDistinctBufferKeys is the number of distinct keys that DISTINCT will keep in memory
+	//before spilling rows with unseen keys to disk. It can be overridden with the DISTINCT_BUFFER_KEYS option.
+	DefaultDistinctBufferKeys = 1000000
+
+	//distinctPartitions is the number of files that spilled rows are partitioned into, by key hash.
+	distinctPartitions = 16
+)
+
+var (
+	distinctLexer = lexer.Unquote(lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Keyword>(?i)(?:DISTINCT|ON|KEEP|FIRST|LAST)\b)`+
+		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
+		`|(?P<Number>[-+]?\d*\.?\d+([eE][-+]?\d+)?)`+
+		`|(?P<String>'[^']*'|"[^"]*")`+
+		`|(?P<Operators><>|!=|<=|>=|[-+*/%,.()=<>])`,
+	)), "Keyword"), "String")
+)
+
+type Distinct struct {
+	Columns []string `"DISTINCT" [ "ON" "(" @Ident { "," @Ident } ")" ]`
+	First   bool     `[ "KEEP" ( @"FIRST"`
+	Last    bool     `| @"LAST" ) ]`
+}
+
+type distinct struct {
+	name       string
+	keyColumns []string
+	keepLast   bool
+	sourceSeq  []string
+	BufferKeys int `aql:"DISTINCT_BUFFER_KEYS, optional"`
+}
+
+func (d *distinct) SetName(name string) {
+	d.name = name
+}
+
+func (d *distinct) Sequence(seq []string) {
+	d.sourceSeq = seq
+}
+
+func (d *distinct) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  d.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(d.name))
+}
+
+func (d *distinct) log(l engine.Logger, level engine.LogLevel, msg string, args ...interface{}) {
+	l.Chan() <- engine.Event{
+		Source:  d.name,
+		Level:   level,
+		Time:    time.Now(),
+		Message: fmt.Sprintf(msg, args...),
+	}
+}
+
+//  Open de-duplicates rows by key. While the number of distinct keys is below the buffer
+//  threshold everything happens in memory. Past it, rows whose key has not been seen
+//  are partitioned by key hash into spill files, and each partition is de-duplicated
+//  separately at the end. Because a key always lands in the same partition, and rows
+//  are written in arrival order, first/last semantics are preserved.
+func (d *distinct) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		getKey       func([]interface{}) string
+		seen         = make(map[string][]interface{})
+		order        []string
+		partitions   []*spillFile
+		bufferKeys   = d.BufferKeys
+		firstMessage = true
+		err          error
+	)
+
+	if bufferKeys <= 0 {
+		bufferKeys = DefaultDistinctBufferKeys
+	}
+
+	if d.sourceSeq != nil {
+		seq := engine.NewSequencedStream(s, d.sourceSeq)
+		inChan = seq.Chan(d.name)
+	} else {
+		inChan = s.Chan(d.name)
+	}
+	outChan = dest.Chan(d.name)
+
+	defer func() {
+		for _, p := range partitions {
+			p.Close()
+		}
+	}()
+
+	send := func(row []interface{}) {
+		outChan <- engine.Message{
+			Source:      d.name,
+			Destination: engine.DestinationWildcard,
+			Data:        row,
+		}
+	}
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			cols := s.Columns()
+			if err := dest.SetColumns(d.name, cols); err != nil {
+				d.fatalerr(err, dest, l, st)
+				return
+			}
+			keyColumns := d.keyColumns
+			if keyColumns == nil {
+				keyColumns = cols
+			}
+			getKey, err = groupBy(keyColumns, cols)
+			if err != nil {
+				d.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		key := getKey(msg.Data)
+		if _, ok := seen[key]; ok {
+			if d.keepLast {
+				seen[key] = msg.Data
+			}
+			continue
+		}
+		if len(seen) < bufferKeys {
+			order = append(order, key)
+			if d.keepLast {
+				seen[key] = msg.Data
+			} else {
+				seen[key] = nil
+				send(msg.Data)
+			}
+			continue
+		}
+		if partitions == nil {
+			d.log(l, engine.Info, "Reached %v distinct keys, spilling remaining rows to disk", bufferKeys)
+			partitions, err = newPartitions(distinctPartitions)
+			if err != nil {
+				d.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		if err := partitions[partitionOf(key, len(partitions))].Write(msg.Data); err != nil {
+			d.fatalerr(err, dest, l, st)
+			return
+		}
+	}
+
+	if d.keepLast {
+		for _, key := range order {
+			send(seen[key])
+		}
+	}
+	seen = nil
+	order = nil
+
+	for _, p := range partitions {
+		if err := d.dedupePartition(p, getKey, send); err != nil {
+			d.fatalerr(err, dest, l, st)
+			return
+		}
+	}
+
+	close(outChan)
+}
+
+//dedupePartition de-duplicates all the rows in a spilled partition, emitting the survivors.
+func (d *distinct) dedupePartition(p *spillFile, getKey func([]interface{}) string, emit func([]interface{})) error {
+	var (
+		seen  = make(map[string][]interface{})
+		order []string
+	)
+	if err := p.Rewind(); err != nil {
+		return err
+	}
+	for {
+		row, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		key := getKey(row)
+		if _, ok := seen[key]; ok {
+			if d.keepLast {
+				seen[key] = row
+			}
+			continue
+		}
+		seen[key] = row
+		order = append(order, key)
+	}
+	for _, key := range order {
+		emit(seen[key])
+	}
+	return nil
+}
+
+//newPartitions creates n spill files to partition rows into.
+func newPartitions(n int) ([]*spillFile, error) {
+	var ret []*spillFile
+	for i := 0; i < n; i++ {
+		p, err := newSpillFile()
+		if err != nil {
+			for _, pp := range ret {
+				pp.Close()
+			}
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+//partitionOf returns the partition that the key belongs to.
+func partitionOf(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+func newDistinct(d *Distinct) (*distinct, error) {
+	return &distinct{keyColumns: d.Columns, keepLast: d.Last}, nil
+}
+
+func NewDistinct(aqlBody string) (*distinct, error) {
+	p, err := participle.Build(&Distinct{}, distinctLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var d Distinct
+	err = p.ParseString(aqlBody, &d)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newDistinct(&d)
+}
+
+func distinctInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewDistinct(aqlBody)
+}
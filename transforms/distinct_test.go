@@ -0,0 +1,89 @@
+package transforms
+
+import (
+	"github.com/alecthomas/participle"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestDistinctParsing(t *testing.T) {
+	parser, err := participle.Build(&Distinct{}, distinctLexer)
+	if err != nil {
+		panic(err)
+	}
+	Convey("Given a valid distinct", t, func() {
+		d := Distinct{}
+		err = parser.ParseString(`DISTINCT ON (A, b) KEEP LAST`, &d)
+		So(err, ShouldBeNil)
+		So(d.Columns, ShouldResemble, []string{"A", "b"})
+		So(d.Last, ShouldBeTrue)
+		So(d.First, ShouldBeFalse)
+	})
+	Convey("Given a distinct without key columns", t, func() {
+		d := Distinct{}
+		err = parser.ParseString(`DISTINCT`, &d)
+		So(err, ShouldBeNil)
+		So(d.Columns, ShouldBeNil)
+		So(d.Last, ShouldBeFalse)
+	})
+}
+
+func TestDistinct(t *testing.T) {
+	cols := []string{"Id", "Value"}
+	rows := [][]interface{}{
+		[]interface{}{1, "a"},
+		[]interface{}{2, "b"},
+		[]interface{}{1, "c"},
+		[]interface{}{3, "d"},
+		[]interface{}{2, "e"},
+	}
+	Convey("Given a DISTINCT transform that keeps the first row", t, func() {
+		d, err := NewDistinct(`DISTINCT ON (Id) KEEP FIRST`)
+		So(err, ShouldBeNil)
+		expected := [][]interface{}{
+			[]interface{}{1, "a"},
+			[]interface{}{2, "b"},
+			[]interface{}{3, "d"},
+		}
+		Convey("It should de-duplicate in memory", func() {
+			_, res := runTransform(d, cols, rows)
+			So(res, ShouldResemble, expected)
+		})
+		Convey("It should de-duplicate when spilling to disk", func() {
+			d.BufferKeys = 1
+			_, res := runTransform(d, cols, rows)
+			So(res, ShouldHaveLength, 3)
+			So(res[0], ShouldResemble, []interface{}{1, "a"})
+			So(res, ShouldContain, []interface{}{2, "b"})
+			So(res, ShouldContain, []interface{}{3, "d"})
+		})
+	})
+	Convey("Given a DISTINCT transform that keeps the last row", t, func() {
+		d, err := NewDistinct(`DISTINCT ON (Id) KEEP LAST`)
+		So(err, ShouldBeNil)
+		Convey("It should de-duplicate in memory", func() {
+			_, res := runTransform(d, cols, rows)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, "c"},
+				[]interface{}{2, "e"},
+				[]interface{}{3, "d"},
+			})
+		})
+		Convey("It should de-duplicate when spilling to disk", func() {
+			d.BufferKeys = 1
+			_, res := runTransform(d, cols, rows)
+			So(res, ShouldHaveLength, 3)
+			So(res[0], ShouldResemble, []interface{}{1, "c"})
+			So(res, ShouldContain, []interface{}{2, "e"})
+			So(res, ShouldContain, []interface{}{3, "d"})
+		})
+	})
+	Convey("Given a DISTINCT transform over all columns", t, func() {
+		d, err := NewDistinct(`DISTINCT`)
+		So(err, ShouldBeNil)
+		Convey("It should only remove identical rows", func() {
+			_, res := runTransform(d, cols, append(rows, []interface{}{1, "a"}))
+			So(res, ShouldHaveLength, 5)
+		})
+	})
+}
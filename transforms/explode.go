@@ -0,0 +1,169 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/michaelbironneau/analyst/engine"
+	"time"
+)
+
+var (
+	jsonLexer = lexer.Unquote(lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Keyword>(?i)(?:EXPLODE|OUTER|AS|FLATTEN|PREFIX|COLUMNS)\b)`+
+		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
+		`|(?P<String>'[^']*'|"[^"]*")`+
+		`|(?P<Operators>[,()])`,
+	)), "Keyword"), "String")
+)
+
+type Explode struct {
+	Outer  bool   `"EXPLODE" [ @"OUTER" ]`
+	Column string `@Ident`
+	Alias  string `"AS" @Ident`
+}
+
+type explode struct {
+	name      string
+	column    string
+	alias     string
+	outer     bool
+	sourceSeq []string
+}
+
+func (e *explode) SetName(name string) {
+	e.name = name
+}
+
+func (e *explode) Sequence(seq []string) {
+	e.sourceSeq = seq
+}
+
+func (e *explode) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  e.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(e.name))
+}
+
+//  Open outputs one row per element of the array in the exploded column, replacing the
+//  column with the element. Rows where the array is NULL or empty are dropped, unless
+//  the explode is OUTER, in which case they are output once with a NULL element.
+func (e *explode) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		ix           int
+		firstMessage = true
+	)
+
+	if e.sourceSeq != nil {
+		seq := engine.NewSequencedStream(s, e.sourceSeq)
+		inChan = seq.Chan(e.name)
+	} else {
+		inChan = s.Chan(e.name)
+	}
+	outChan = dest.Chan(e.name)
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			cols := s.Columns()
+			var ok bool
+			if ix, ok = find(cols, e.column); !ok {
+				e.fatalerr(fmt.Errorf("could not find column %s", e.column), dest, l, st)
+				return
+			}
+			outCols := make([]string, len(cols))
+			copy(outCols, cols)
+			outCols[ix] = e.alias
+			if i, ok := find(cols, e.alias); ok && i != ix {
+				e.fatalerr(fmt.Errorf("column %s already exists", e.alias), dest, l, st)
+				return
+			}
+			if err := dest.SetColumns(e.name, outCols); err != nil {
+				e.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		elements, err := toArray(msg.Data[ix])
+		if err != nil {
+			e.fatalerr(err, dest, l, st)
+			return
+		}
+		if len(elements) == 0 && e.outer {
+			elements = []interface{}{nil}
+		}
+		for _, element := range elements {
+			row := make([]interface{}, len(msg.Data))
+			copy(row, msg.Data)
+			row[ix] = element
+			outChan <- engine.Message{
+				Source:      e.name,
+				Destination: engine.DestinationWildcard,
+				Data:        row,
+			}
+		}
+	}
+	close(outChan)
+}
+
+//toArray returns the elements of v, which should be NULL, an array or a string containing
+//a JSON array.
+func toArray(v interface{}) ([]interface{}, error) {
+	switch vv := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return vv, nil
+	case []map[string]interface{}:
+		ret := make([]interface{}, len(vv))
+		for i := range vv {
+			ret[i] = vv[i]
+		}
+		return ret, nil
+	case string:
+		var ret []interface{}
+		if err := json.Unmarshal([]byte(vv), &ret); err != nil {
+			return nil, fmt.Errorf("expected a JSON array but got '%s': %v", vv, err)
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("expected an array but got %T %v", v, v)
+}
+
+func newExplode(ex *Explode) (*explode, error) {
+	return &explode{
+		column: ex.Column,
+		alias:  ex.Alias,
+		outer:  ex.Outer,
+	}, nil
+}
+
+func NewExplode(aqlBody string) (*explode, error) {
+	p, err := participle.Build(&Explode{}, jsonLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var ex Explode
+	err = p.ParseString(aqlBody, &ex)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newExplode(&ex)
+}
+
+func explodeInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewExplode(aqlBody)
+}
@@ -0,0 +1,74 @@
+package transforms
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestExplode(t *testing.T) {
+	rows := [][]interface{}{
+		[]interface{}{1, []interface{}{"a", "b"}},
+		[]interface{}{2, nil},
+		[]interface{}{3, `["c"]`},
+	}
+	Convey("Given an EXPLODE transform", t, func() {
+		e, err := NewExplode(`EXPLODE Items AS Item`)
+		So(err, ShouldBeNil)
+		Convey("It should output one row per array element", func() {
+			cols, res := runTransform(e, []string{"Id", "Items"}, rows)
+			So(cols, ShouldResemble, []string{"Id", "Item"})
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, "a"},
+				[]interface{}{1, "b"},
+				[]interface{}{3, "c"},
+			})
+		})
+	})
+	Convey("Given an EXPLODE OUTER transform", t, func() {
+		e, err := NewExplode(`EXPLODE OUTER Items AS Item`)
+		So(err, ShouldBeNil)
+		Convey("It should keep rows without elements", func() {
+			_, res := runTransform(e, []string{"Id", "Items"}, rows)
+			So(res, ShouldHaveLength, 4)
+			So(res[2], ShouldResemble, []interface{}{2, nil})
+		})
+	})
+}
+
+func TestFlatten(t *testing.T) {
+	rows := [][]interface{}{
+		[]interface{}{1, map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"c": "x"}}},
+		[]interface{}{2, `{"a": 2, "d": true}`},
+		[]interface{}{3, nil},
+	}
+	Convey("Given a FLATTEN transform without declared columns", t, func() {
+		f, err := NewFlatten(`FLATTEN Payload PREFIX 'p_'`)
+		So(err, ShouldBeNil)
+		Convey("It should discover the columns from the sampled rows", func() {
+			cols, res := runTransform(f, []string{"Id", "Payload"}, rows)
+			So(cols, ShouldResemble, []string{"Id", "p_a", "p_b_c", "p_d"})
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, 1.0, "x", nil},
+				[]interface{}{2, 2.0, nil, true},
+				[]interface{}{3, nil, nil, nil},
+			})
+		})
+		Convey("It should drop keys that were not sampled", func() {
+			f.SampleRows = 1
+			cols, res := runTransform(f, []string{"Id", "Payload"}, rows)
+			So(cols, ShouldResemble, []string{"Id", "p_a", "p_b_c"})
+			So(res[1], ShouldResemble, []interface{}{2, 2.0, nil})
+		})
+	})
+	Convey("Given a FLATTEN transform with declared columns", t, func() {
+		f, err := NewFlatten(`FLATTEN Payload COLUMNS ('b.c', 'a')`)
+		So(err, ShouldBeNil)
+		Convey("It should only output those columns", func() {
+			cols, res := runTransform(f, []string{"Id", "Payload"}, rows[:1])
+			So(cols, ShouldResemble, []string{"Id", "b_c", "a"})
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, "x", 1.0},
+			})
+		})
+	})
+}
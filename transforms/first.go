@@ -0,0 +1,51 @@
+package transforms
+
+import "fmt"
+
+//first returns the value of its first argument for the row with the lowest value of its second
+//argument (or the highest, if last is true). Rows where the second argument is NULL are ignored.
+type first struct {
+	last    bool
+	value   interface{}
+	order   interface{}
+	notNull bool
+	am      ArgumentMap
+}
+
+func (s *first) ParameterLen() int {
+	return 2
+}
+
+func (s *first) SetArgumentMap(am ArgumentMap) {
+	s.am = am
+}
+
+func (s *first) Reduce(arg []interface{}) error {
+	args := s.am(arg)
+	if len(args) != 2 {
+		name := "FIRST"
+		if s.last {
+			name = "LAST"
+		}
+		return fmt.Errorf("%s takes exactly 2 arguments but %v were provided", name, len(args))
+	}
+	if args[1] == nil {
+		return nil
+	}
+	//ties go to the earliest row for FIRST and to the latest row for LAST
+	c := compareValues(args[1], s.order)
+	if !s.notNull || (!s.last && c < 0) || (s.last && c >= 0) {
+		s.value = args[0]
+		s.order = args[1]
+		s.notNull = true
+	}
+	return nil
+}
+
+func (s *first) Return() interface{} {
+	return s.value
+}
+
+func (s *first) Copy() Reducer {
+	return &first{last: s.last, am: s.am}
+}
@@ -0,0 +1,39 @@
+package transforms
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestFirstLast(t *testing.T) {
+	msgs := [][]interface{}{
+		[]interface{}{"b", "2018-01-02T00:00:00Z"},
+		[]interface{}{"a", "2018-01-01T00:00:00Z"},
+		[]interface{}{"x", nil},
+		[]interface{}{"c", "2018-01-03T00:00:00Z"},
+		[]interface{}{"d", "2018-01-03T00:00:00Z"},
+	}
+	Convey("Given a first aggregate", t, func() {
+		agg := first{}
+		agg.SetArgumentMap(DefaultArgMap)
+		Convey("It should reduce to nil if there are no messages", func() {
+			So(agg.Return(), ShouldBeNil)
+		})
+		Convey("It should return the value with the lowest order", func() {
+			for _, msg := range msgs {
+				So(agg.Reduce(msg), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, "a")
+		})
+	})
+	Convey("Given a last aggregate", t, func() {
+		agg := first{last: true}
+		agg.SetArgumentMap(DefaultArgMap)
+		Convey("It should return the latest value with the highest order", func() {
+			for _, msg := range msgs {
+				So(agg.Reduce(msg), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, "d")
+		})
+	})
+}
@@ -0,0 +1,272 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/michaelbironneau/analyst/engine"
+	"sort"
+	"strings"
+	"time"
+)
+
+//DefaultFlattenSampleRows is the number of rows that FLATTEN samples to discover the nested keys,
+//when they are not declared. It can be overridden with the FLATTEN_SAMPLE_ROWS option.
+const DefaultFlattenSampleRows = 100
+
+type Flatten struct {
+	Column  string   `"FLATTEN" @Ident`
+	Prefix  string   `[ "PREFIX" @String ]`
+	Columns []string `[ "COLUMNS" "(" @String { "," @String } ")" ]`
+}
+
+type flatten struct {
+	name       string
+	column     string
+	prefix     string
+	paths      []string
+	declared   bool
+	sourceSeq  []string
+	SampleRows int `aql:"FLATTEN_SAMPLE_ROWS, optional"`
+}
+
+func (f *flatten) SetName(name string) {
+	f.name = name
+}
+
+func (f *flatten) Sequence(seq []string) {
+	f.sourceSeq = seq
+}
+
+func (f *flatten) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  f.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(f.name))
+}
+
+func (f *flatten) log(l engine.Logger, level engine.LogLevel, msg string, args ...interface{}) {
+	l.Chan() <- engine.Event{
+		Level:   level,
+		Source:  f.name,
+		Time:    time.Now(),
+		Message: fmt.Sprintf(msg, args...),
+	}
+}
+
+//  Open replaces the flattened column with one column per nested key. The keys are either
+//  declared or discovered by sampling the first rows, which are buffered until the columns
+//  are known. Keys that are not part of the columns are dropped with a warning.
+func (f *flatten) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan     chan engine.Message
+		outChan    chan engine.Message
+		ix         = -1
+		cols       []string
+		paths      = f.paths
+		discovered = make(map[string]bool)
+		buffer     [][]interface{}
+		dropped    = make(map[string]bool)
+		sampleRows = f.SampleRows
+		ready      = f.declared
+	)
+
+	if sampleRows <= 0 {
+		sampleRows = DefaultFlattenSampleRows
+	}
+
+	if f.sourceSeq != nil {
+		seq := engine.NewSequencedStream(s, f.sourceSeq)
+		inChan = seq.Chan(f.name)
+	} else {
+		inChan = s.Chan(f.name)
+	}
+	outChan = dest.Chan(f.name)
+
+	//start sets the output columns, once the paths are known
+	start := func() error {
+		var outCols []string
+		outCols = append(outCols, cols[:ix]...)
+		for _, path := range paths {
+			outCols = append(outCols, f.columnName(path))
+		}
+		outCols = append(outCols, cols[ix+1:]...)
+		for i := range outCols {
+			if j, _ := find(outCols, outCols[i]); j != i {
+				return fmt.Errorf("column %s already exists", outCols[i])
+			}
+		}
+		ready = true
+		return dest.SetColumns(f.name, outCols)
+	}
+
+	emit := func(row []interface{}) error {
+		values, err := flattenValue(row[ix])
+		if err != nil {
+			return err
+		}
+		out := make([]interface{}, 0, len(row)-1+len(paths))
+		out = append(out, row[:ix]...)
+		for _, path := range paths {
+			out = append(out, values[path])
+			delete(values, path)
+		}
+		out = append(out, row[ix+1:]...)
+		for path := range values {
+			if !dropped[path] {
+				dropped[path] = true
+				f.log(l, engine.Warning, "Dropped key %s because it was not in the columns", path)
+			}
+		}
+		outChan <- engine.Message{
+			Source:      f.name,
+			Destination: engine.DestinationWildcard,
+			Data:        out,
+		}
+		return nil
+	}
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if ix < 0 {
+			cols = s.Columns()
+			var ok bool
+			if ix, ok = find(cols, f.column); !ok {
+				f.fatalerr(fmt.Errorf("could not find column %s", f.column), dest, l, st)
+				return
+			}
+			if ready {
+				if err := start(); err != nil {
+					f.fatalerr(err, dest, l, st)
+					return
+				}
+			}
+		}
+		if ready {
+			if err := emit(msg.Data); err != nil {
+				f.fatalerr(err, dest, l, st)
+				return
+			}
+			continue
+		}
+		values, err := flattenValue(msg.Data[ix])
+		if err != nil {
+			f.fatalerr(err, dest, l, st)
+			return
+		}
+		var newPaths []string
+		for path := range values {
+			if !discovered[path] {
+				discovered[path] = true
+				newPaths = append(newPaths, path)
+			}
+		}
+		sort.Strings(newPaths)
+		paths = append(paths, newPaths...)
+		buffer = append(buffer, msg.Data)
+		if len(buffer) < sampleRows {
+			continue
+		}
+		f.log(l, engine.Info, "Discovered %v keys in %v rows", len(paths), len(buffer))
+		if err := start(); err != nil {
+			f.fatalerr(err, dest, l, st)
+			return
+		}
+		for _, row := range buffer {
+			if err := emit(row); err != nil {
+				f.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		buffer = nil
+	}
+
+	//the source had fewer rows than the sample size
+	if !ready && len(buffer) > 0 {
+		f.log(l, engine.Info, "Discovered %v keys in %v rows", len(paths), len(buffer))
+		if err := start(); err != nil {
+			f.fatalerr(err, dest, l, st)
+			return
+		}
+		for _, row := range buffer {
+			if err := emit(row); err != nil {
+				f.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+	}
+
+	close(outChan)
+}
+
+//columnName returns the name of the column for a nested key path such as a.b, which is the
+//prefix followed by the path with dots replaced by underscores.
+func (f *flatten) columnName(path string) string {
+	return f.prefix + strings.Replace(path, ".", "_", -1)
+}
+
+//flattenValue returns the values of the nested keys of v, by dot-separated path. v should be NULL,
+//an object, or a string containing a JSON object. Arrays are not flattened.
+func flattenValue(v interface{}) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+	switch vv := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		flattenObject(vv, "", ret)
+	case string:
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(vv), &obj); err != nil {
+			return nil, fmt.Errorf("expected a JSON object but got '%s': %v", vv, err)
+		}
+		flattenObject(obj, "", ret)
+	default:
+		return nil, fmt.Errorf("expected an object but got %T %v", v, v)
+	}
+	return ret, nil
+}
+
+func flattenObject(obj map[string]interface{}, prefix string, values map[string]interface{}) {
+	for k, v := range obj {
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenObject(nested, prefix+k+".", values)
+			continue
+		}
+		values[prefix+k] = v
+	}
+}
+
+func newFlatten(fl *Flatten) (*flatten, error) {
+	f := flatten{
+		column:   fl.Column,
+		prefix:   fl.Prefix,
+		paths:    fl.Columns,
+		declared: len(fl.Columns) > 0,
+	}
+	return &f, nil
+}
+
+func NewFlatten(aqlBody string) (*flatten, error) {
+	p, err := participle.Build(&Flatten{}, jsonLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var fl Flatten
+	err = p.ParseString(aqlBody, &fl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newFlatten(&fl)
+}
+
+func flattenInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewFlatten(aqlBody)
+}
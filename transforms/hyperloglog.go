@@ -0,0 +1,67 @@
+package transforms
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+//hyperLogLog is a HyperLogLog cardinality estimator with 2^precision registers.
+//Its relative standard error is about 1.04/sqrt(2^precision).
+type hyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	return &hyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+//hash64 hashes the string with FNV-1a, then mixes the bits with the MurmurHash3 finalizer
+//as FNV on its own does not spread short strings well enough across the high bits.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+//Add adds a value to the set.
+func (h *hyperLogLog) Add(s string) {
+	x := hash64(s)
+	ix := x >> (64 - h.precision)
+	//the guard bit bounds the rank if the remaining bits are all zero
+	rank := uint8(bits.LeadingZeros64(x<<h.precision|1<<(h.precision-1))) + 1
+	if rank > h.registers[ix] {
+		h.registers[ix] = rank
+	}
+}
+
+//Count returns the estimated number of distinct values added to the set.
+func (h *hyperLogLog) Count() float64 {
+	var (
+		m     = float64(len(h.registers))
+		sum   float64
+		zeros float64
+	)
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		//linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/zeros)
+	}
+	return math.Floor(estimate + 0.5)
+}
@@ -1,17 +1,26 @@
 package transforms
 
 import (
+	"errors"
 	"fmt"
 	"github.com/alecthomas/participle"
 	"github.com/alecthomas/participle/lexer"
 	"github.com/michaelbironneau/analyst/engine"
 	"strings"
+	"sync"
 	"time"
 )
 
+//DefaultLookupMemoryRows is the number of lookup table rows that LOOKUP will hold in memory
+//before moving them to an on-disk SQLite database. It can be overridden with the LOOKUP_MEMORY_ROWS option.
+const DefaultLookupMemoryRows = 1000000
+
+//errLookupTableClosed is returned when the lookup table is used after it was closed, eg. because the other source failed.
+var errLookupTableClosed = errors.New("the lookup table is closed")
+
 var (
 	lookupLexer = lexer.Unquote(lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
-		`|(?P<Keyword>(?i)LOOKUP\s|INNER\s|OUTER\s|JOIN\s|ON\s|AND\s|FROM\s|AS\s)`+
+		`|(?P<Keyword>(?i)LOOKUP\s|INNER\s|OUTER\s|LEFT\s|ANTI\s|SEMI\s|JOIN\s|ON\s|AND\s|FROM\s|AS\s)`+
 		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
 		`|(?P<Number>[-+]?\d*\.?\d+([eE][-+]?\d+)?)`+
 		`|(?P<String>'[^']*'|"[^"]*")`+
@@ -27,27 +36,71 @@ type LookupColumn struct {
 
 type JoinCondition struct {
 	T1Column *LookupColumn `@@`
-	T2Column *LookupColumn `"=" @@`
+	Operator string        `( @"=" | @"<>" | @"!=" | @"<=" | @">=" | @"<" | @">" )`
+	T2Column *LookupColumn `@@`
 }
 
 type Lookup struct {
-	Projection   []LookupColumn  `"LOOKUP " @@ {"," @@}`
+	All          bool            `"LOOKUP " ( @"*"`
+	Projection   []LookupColumn  `| @@ {"," @@} )`
 	FromSource   string          `"FROM " @Ident`
 	InnerJoin    bool            `(@"INNER "`
-	OuterJoin    bool            `| @"OUTER ")`
+	OuterJoin    bool            `| @"OUTER "`
+	LeftJoin     bool            `| @"LEFT "`
+	AntiJoin     bool            `| @"ANTI "`
+	SemiJoin     bool            `| @"SEMI ")`
 	LookupSource string          `"JOIN " @Ident`
 	Conditions   []JoinCondition `"ON " @@ { "AND " @@}`
 }
 
+//joinFilter is a non-equality join condition, in the form base column OPERATOR lookup column.
+type joinFilter struct {
+	baseColumn   string
+	operator     string
+	lookupColumn string
+}
+
+//flippedOperators maps each operator to the one that gives the same result when its operands are swapped.
+var flippedOperators = map[string]string{"<": ">", ">": "<", "<=": ">=", ">=": "<=", "<>": "<>", "!=": "!="}
+
+//match returns whether the condition holds. As in SQL, comparisons with NULL do not hold.
+func (f joinFilter) match(base interface{}, lookup interface{}) bool {
+	if base == nil || lookup == nil {
+		return false
+	}
+	c := compareValues(base, lookup)
+	switch f.operator {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	default:
+		return c != 0
+	}
+}
+
 type lookup struct {
 	outgoingName      string
 	sourceSeq         []string
 	baseJoinColumns   []string
 	lookupJoinColumns []string
 	outerJoin         bool
+	antiJoin          bool
+	semiJoin          bool
+	allColumns        bool
 	projection        []LookupColumn
+	filters           []joinFilter
 	sequencer         engine.Sequencer
-	lookupTable       map[string]map[string]interface{} //map from join key to row-map (map of col name to value)
+	lookupTable       lookupStore
+	tableLock         sync.Mutex //guards lookupTable and tableClosed, as the sources are opened in separate goroutines
+	tableClosed       bool
+	lookupColumns     []string       //columns of the lookup source, as received
+	cachedColumns     map[string]int //map from lower-case lookup column name to its index in cached rows
+	MemoryRows        int            `aql:"LOOKUP_MEMORY_ROWS, optional"`
 }
 
 func project(projectionColumns []string, actualColumns []string) (func([]interface{}) map[string]interface{}, error) {
@@ -117,12 +170,23 @@ func (l *lookup) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 		keyMap            func([]interface{}) string
 		lookupProjections []string
 		baseProjections   []string
+		projection        = l.projection
 		cols              []string
+		cacheOp           func([]interface{}) []interface{}
 		projectOp         func([]interface{}) map[string]interface{}
 		err               error
 		source            string
+		keepTable         bool
 	)
 
+	//the lookup table is still needed by the FROM source once the JOIN source is done, but not after
+	//that, nor if either of them fails
+	defer func() {
+		if !keepTable {
+			l.closeTable(logger)
+		}
+	}()
+
 	baseProjections, lookupProjections, err = splitProjections(l.projection, l.sourceSeq[1], l.sourceSeq[0])
 
 	if err != nil {
@@ -130,6 +194,12 @@ func (l *lookup) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 		return
 	}
 
+	//the filter columns are needed to evaluate the join, even if they are not projected
+	for _, f := range l.filters {
+		baseProjections = append(baseProjections, f.baseColumn)
+		lookupProjections = append(lookupProjections, f.lookupColumn)
+	}
+
 	for msg := range inChan {
 		if st.Stopped() {
 			return
@@ -142,18 +212,13 @@ func (l *lookup) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 
 			firstMessage = false
 
-			if source != strings.ToLower(l.sourceSeq[0]) && len(l.lookupTable) == 0 {
+			if source != strings.ToLower(l.sourceSeq[0]) && l.tableLen() == 0 {
 				l.fatalerr(fmt.Errorf("expected source %s but got source %s", l.sourceSeq[0], msg.Source), dest, logger, st)
 				return
 			}
 			cols = s.Columns()
 			l.log(logger, engine.Trace, "Found columns %v", cols)
 
-			if err := dest.SetColumns(l.outgoingName, columnNames(l.projection)); err != nil {
-				l.fatalerr(err, dest, logger, st)
-				return
-			}
-
 			if source == strings.ToLower(l.sourceSeq[0]) {
 				isLookupSource = true
 				keyMap, err = groupBy(l.lookupJoinColumns, cols)
@@ -166,8 +231,24 @@ func (l *lookup) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 				return
 			}
 
+			if l.allColumns && isLookupSource {
+				l.lookupColumns = cols
+				lookupProjections = cols
+			} else if l.allColumns {
+				projection = l.expandProjection(cols)
+				baseProjections = append(baseProjections, cols...)
+			}
+
+			if !l.allColumns || !isLookupSource {
+				//with LOOKUP *, the output columns are only known once both sources have been seen
+				if err := dest.SetColumns(l.outgoingName, columnNames(projection)); err != nil {
+					l.fatalerr(err, dest, logger, st)
+					return
+				}
+			}
+
 			if isLookupSource {
-				projectOp, err = project(lookupProjections, cols)
+				cacheOp, err = l.cache(lookupProjections, cols)
 			} else {
 				projectOp, err = project(baseProjections, cols)
 			}
@@ -183,15 +264,26 @@ func (l *lookup) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 
 		//cache the lookup table entry
 		if isLookupSource {
-			projections := projectOp(msg.Data)
-			l.lookupTable[key] = projections
-			l.log(logger, engine.Trace, "Cached row with key '%s': %v", key, projections)
+			row := cacheOp(msg.Data)
+			if err := l.add(key, row, logger); err != nil {
+				if st.Stopped() {
+					return //the table was closed because the FROM source stopped the job
+				}
+				l.fatalerr(err, dest, logger, st)
+				return
+			}
+			l.log(logger, engine.Trace, "Cached row with key '%s': %v", key, row)
 			continue
 		}
 
-		outMsg := l.getMessage(msg, key, projectOp)
+		outMsgs, err := l.getMessages(projectOp(msg.Data), key, projection)
 
-		if outMsg != nil {
+		if err != nil {
+			l.fatalerr(err, dest, logger, st)
+			return
+		}
+
+		for _, outMsg := range outMsgs {
 			outChan <- engine.Message{
 				Source:      l.outgoingName,
 				Destination: engine.DestinationWildcard,
@@ -200,6 +292,13 @@ func (l *lookup) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 		}
 	}
 	if source != "" {
+		if isLookupSource {
+			if err := l.readyTable(); err != nil {
+				l.fatalerr(err, dest, logger, st)
+				return
+			}
+			keepTable = true
+		}
 		l.sequencer.Done(source)
 		l.log(logger, engine.Info, "Finished processing messages for source %s", source)
 		if source == l.sourceSeq[1] {
@@ -212,31 +311,167 @@ func (l *lookup) Open(s engine.Stream, dest engine.Stream, logger engine.Logger,
 
 }
 
-func (l *lookup) getMessage(msg engine.Message, key string, projectOp func([]interface{}) map[string]interface{}) []interface{} {
-	baseColumnValues := projectOp(msg.Data)
+//closeTable closes the lookup table, removing its on-disk database if it has one. Only the first call has any effect.
+//Either source can close it if it fails, so the other source gets errLookupTableClosed rather than a closed store.
+func (l *lookup) closeTable(logger engine.Logger) {
+	l.tableLock.Lock()
+	defer l.tableLock.Unlock()
+	if l.tableClosed {
+		return
+	}
+	l.tableClosed = true
+	if err := l.lookupTable.Close(); err != nil {
+		l.log(logger, engine.Warning, "Could not clean up lookup table: %v", err)
+	}
+}
 
-	var outMsg []interface{}
-	for _, col := range l.projection {
-		if strings.ToLower(col.Source) == strings.ToLower(msg.Source) {
-			//base projection
-			val, ok := baseColumnValues[strings.ToLower(col.Column)]
+func (l *lookup) tableLen() int {
+	l.tableLock.Lock()
+	defer l.tableLock.Unlock()
+	return l.lookupTable.Len()
+}
+
+func (l *lookup) readyTable() error {
+	l.tableLock.Lock()
+	defer l.tableLock.Unlock()
+	if l.tableClosed {
+		return errLookupTableClosed
+	}
+	return l.lookupTable.Ready()
+}
 
-			if !ok && !l.outerJoin {
-				return nil //ignore as value is nil and this isn't an outer join
+//expandProjection returns the projection for LOOKUP *, given the columns of the FROM source:
+//all of its columns, followed by the columns of the JOIN source that it does not already have.
+func (l *lookup) expandProjection(baseColumns []string) []LookupColumn {
+	var ret []LookupColumn
+	for _, col := range baseColumns {
+		ret = append(ret, LookupColumn{Source: l.sourceSeq[1], Column: col})
+	}
+	if l.antiJoin || l.semiJoin {
+		return ret
+	}
+	for _, col := range l.lookupColumns {
+		if _, ok := find(baseColumns, col); !ok {
+			ret = append(ret, LookupColumn{Source: l.sourceSeq[0], Column: col})
+		}
+	}
+	return ret
+}
+
+//cache returns a function that extracts the given columns from a row of the lookup source,
+//which is what gets stored in the lookup table.
+func (l *lookup) cache(cacheColumns []string, actualColumns []string) (func([]interface{}) []interface{}, error) {
+	var indexes []int
+	l.cachedColumns = make(map[string]int)
+	for _, col := range cacheColumns {
+		if _, ok := l.cachedColumns[strings.ToLower(col)]; ok {
+			continue
+		}
+		ix, ok := find(actualColumns, col)
+		if !ok {
+			return nil, fmt.Errorf("could not find column %s", col)
+		}
+		l.cachedColumns[strings.ToLower(col)] = len(indexes)
+		indexes = append(indexes, ix)
+	}
+	return func(input []interface{}) []interface{} {
+		ret := make([]interface{}, len(indexes))
+		for i, ix := range indexes {
+			ret[i] = input[ix]
+		}
+		return ret
+	}, nil
+}
+
+//add adds a row to the lookup table, moving the table to disk once it exceeds the memory threshold.
+func (l *lookup) add(key string, row []interface{}, logger engine.Logger) error {
+	l.tableLock.Lock()
+	defer l.tableLock.Unlock()
+	if l.tableClosed {
+		return errLookupTableClosed
+	}
+	memoryRows := l.MemoryRows
+	if memoryRows <= 0 {
+		memoryRows = DefaultLookupMemoryRows
+	}
+	if m, ok := l.lookupTable.(*memoryLookupStore); ok && m.Len() >= memoryRows {
+		l.log(logger, engine.Info, "Lookup table has more than %v rows, moving it to disk", memoryRows)
+		disk, err := newSQLiteLookupStore()
+		if err != nil {
+			return err
+		}
+		for k, rows := range m.rows {
+			for _, r := range rows {
+				if err := disk.Add(k, r); err != nil {
+					disk.Close()
+					return err
+				}
 			}
+		}
+		m.Close()
+		l.lookupTable = disk
+	}
+	return l.lookupTable.Add(key, row)
+}
+
+//getMessages returns the output rows for a row of the FROM source, given the values of its
+//projected columns and its join key.
+func (l *lookup) getMessages(baseColumnValues map[string]interface{}, key string, projection []LookupColumn) ([][]interface{}, error) {
+	l.tableLock.Lock()
+	if l.tableClosed {
+		l.tableLock.Unlock()
+		return nil, errLookupTableClosed
+	}
+	entries, err := l.lookupTable.Get(key)
+	l.tableLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches [][]interface{}
+	for _, entry := range entries {
+		if l.matches(baseColumnValues, entry) {
+			matches = append(matches, entry)
+		}
+	}
+
+	switch {
+	case l.antiJoin && len(matches) > 0, l.semiJoin && len(matches) == 0:
+		return nil, nil
+	case l.antiJoin, l.semiJoin:
+		return [][]interface{}{l.outputRow(baseColumnValues, nil, projection)}, nil
+	case len(matches) == 0 && l.outerJoin:
+		return [][]interface{}{l.outputRow(baseColumnValues, nil, projection)}, nil
+	}
 
-			outMsg = append(outMsg, val)
+	var ret [][]interface{}
+	for _, entry := range matches {
+		ret = append(ret, l.outputRow(baseColumnValues, entry, projection))
+	}
+	return ret, nil
+}
+
+//matches returns whether a lookup table entry satisfies the non-equality join conditions.
+func (l *lookup) matches(baseColumnValues map[string]interface{}, entry []interface{}) bool {
+	for _, f := range l.filters {
+		if !f.match(baseColumnValues[f.baseColumn], entry[l.cachedColumns[f.lookupColumn]]) {
+			return false
+		}
+	}
+	return true
+}
 
+//outputRow builds an output row from the FROM source values and the lookup table entry, which
+//is nil if there is no match.
+func (l *lookup) outputRow(baseColumnValues map[string]interface{}, entry []interface{}, projection []LookupColumn) []interface{} {
+	var outMsg []interface{}
+	for _, col := range projection {
+		if strings.ToLower(col.Source) == l.sourceSeq[1] {
+			outMsg = append(outMsg, baseColumnValues[strings.ToLower(col.Column)])
+		} else if entry == nil {
+			outMsg = append(outMsg, nil)
 		} else {
-			//lookup projection
-			if entry, ok := l.lookupTable[key]; !ok && !l.outerJoin {
-				return nil //ignore as we don't have a matching row and this isn't an outer join
-			} else if !ok && l.outerJoin {
-				outMsg = append(outMsg, nil)
-				continue
-			} else {
-				outMsg = append(outMsg, entry[strings.ToLower(col.Column)])
-			}
+			outMsg = append(outMsg, entry[l.cachedColumns[strings.ToLower(col.Column)]])
 		}
 	}
 	return outMsg
@@ -267,31 +502,55 @@ func newLookup(l *Lookup) (*lookup, error) {
 	ret.sourceSeq = []string{strings.ToLower(l.LookupSource), strings.ToLower(l.FromSource)}
 	ret.sequencer = engine.NewSequencer(ret.sourceSeq)
 	ret.projection = l.Projection
-	if l.OuterJoin {
+	ret.allColumns = l.All
+	if l.OuterJoin || l.LeftJoin {
 		ret.outerJoin = true
 	}
-	ret.lookupTable = make(map[string]map[string]interface{})
+	ret.antiJoin = l.AntiJoin
+	ret.semiJoin = l.SemiJoin
+	ret.lookupTable = newMemoryLookupStore()
+	if ret.antiJoin || ret.semiJoin {
+		for _, col := range l.Projection {
+			if strings.ToLower(col.Source) != ret.sourceSeq[1] {
+				return nil, fmt.Errorf("ANTI and SEMI joins can only select columns from the FROM source, but found %s.%s", col.Source, col.Column)
+			}
+		}
+	}
 	for _, cond := range l.Conditions {
 		if strings.ToLower(cond.T1Column.Source) == strings.ToLower(cond.T2Column.Source) {
 			return nil, fmt.Errorf("join condition should be between FROM source and JOIN source, not from a source to itself: %v", cond)
 		}
 
+		var baseColumn, lookupColumn, operator string
+		operator = cond.Operator
+
 		if strings.ToLower(cond.T1Column.Source) == strings.ToLower(l.LookupSource) {
-			ret.lookupJoinColumns = append(ret.lookupJoinColumns, strings.ToLower(cond.T1Column.Column))
+			lookupColumn = strings.ToLower(cond.T1Column.Column)
+			//normalize to base column OPERATOR lookup column
+			if op, ok := flippedOperators[operator]; ok {
+				operator = op
+			}
 		} else if strings.ToLower(cond.T1Column.Source) == strings.ToLower(l.FromSource) {
-			ret.baseJoinColumns = append(ret.baseJoinColumns, strings.ToLower(cond.T1Column.Column))
+			baseColumn = strings.ToLower(cond.T1Column.Column)
 		} else {
 			return nil, fmt.Errorf("join condition does not reference either FROM source or JOIN source: %v", cond)
 		}
 
 		if strings.ToLower(cond.T2Column.Source) == strings.ToLower(l.LookupSource) {
-			ret.lookupJoinColumns = append(ret.lookupJoinColumns, strings.ToLower(cond.T2Column.Column))
+			lookupColumn = strings.ToLower(cond.T2Column.Column)
 		} else if strings.ToLower(cond.T2Column.Source) == strings.ToLower(l.FromSource) {
-			ret.baseJoinColumns = append(ret.baseJoinColumns, strings.ToLower(cond.T2Column.Column))
+			baseColumn = strings.ToLower(cond.T2Column.Column)
 		} else {
 			return nil, fmt.Errorf("join condition does not reference either FROM source or JOIN source: %v", cond)
 		}
 
+		if operator == "=" {
+			ret.baseJoinColumns = append(ret.baseJoinColumns, baseColumn)
+			ret.lookupJoinColumns = append(ret.lookupJoinColumns, lookupColumn)
+		} else {
+			ret.filters = append(ret.filters, joinFilter{baseColumn: baseColumn, operator: operator, lookupColumn: lookupColumn})
+		}
+
 	}
 
 	return &ret, nil
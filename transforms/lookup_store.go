@@ -0,0 +1,167 @@
+package transforms
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	_ "github.com/mattn/go-sqlite3" //SQLite driver
+	"io/ioutil"
+	"os"
+)
+
+//lookupStore holds the rows of a lookup table by join key. Rows are added while the lookup
+//source is being read, after which Ready is called and rows can be retrieved.
+type lookupStore interface {
+	Add(key string, row []interface{}) error
+	Ready() error
+	Get(key string) ([][]interface{}, error)
+	Len() int
+	Close() error
+}
+
+//memoryLookupStore is a lookupStore that holds all the rows in memory.
+type memoryLookupStore struct {
+	rows  map[string][][]interface{}
+	count int
+}
+
+func newMemoryLookupStore() *memoryLookupStore {
+	return &memoryLookupStore{rows: make(map[string][][]interface{})}
+}
+
+func (m *memoryLookupStore) Add(key string, row []interface{}) error {
+	m.rows[key] = append(m.rows[key], row)
+	m.count++
+	return nil
+}
+
+func (m *memoryLookupStore) Ready() error {
+	return nil
+}
+
+func (m *memoryLookupStore) Get(key string) ([][]interface{}, error) {
+	return m.rows[key], nil
+}
+
+func (m *memoryLookupStore) Len() int {
+	return m.count
+}
+
+func (m *memoryLookupStore) Close() error {
+	m.rows = nil
+	return nil
+}
+
+//sqliteLookupStore is a lookupStore backed by a temporary SQLite database, for lookup
+//tables that are too big to hold in memory. Rows are gob-encoded and indexed by key.
+type sqliteLookupStore struct {
+	path   string
+	db     *sql.DB
+	tx     *sql.Tx
+	insert *sql.Stmt
+	query  *sql.Stmt
+	count  int
+}
+
+func newSQLiteLookupStore() (*sqliteLookupStore, error) {
+	f, err := ioutil.TempFile("", spillFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	s := sqliteLookupStore{path: f.Name()}
+	if s.db, err = sql.Open("sqlite3", s.path); err != nil {
+		os.Remove(s.path)
+		return nil, err
+	}
+	//a single connection, so that the transaction and the statements see the same database
+	s.db.SetMaxOpenConns(1)
+	if _, err = s.db.Exec(`CREATE TABLE lookup (key TEXT NOT NULL, row BLOB NOT NULL)`); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if s.tx, err = s.db.Begin(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if s.insert, err = s.tx.Prepare(`INSERT INTO lookup (key, row) VALUES (?, ?)`); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *sqliteLookupStore) Add(key string, row []interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(normalizeRow(row)); err != nil {
+		return err
+	}
+	if _, err := s.insert.Exec(key, buf.Bytes()); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+//Ready commits the rows and indexes them by key.
+func (s *sqliteLookupStore) Ready() error {
+	if err := s.insert.Close(); err != nil {
+		return err
+	}
+	s.insert = nil
+	if err := s.tx.Commit(); err != nil {
+		return err
+	}
+	s.tx = nil
+	if _, err := s.db.Exec(`CREATE INDEX lookup_key ON lookup (key)`); err != nil {
+		return err
+	}
+	var err error
+	s.query, err = s.db.Prepare(`SELECT row FROM lookup WHERE key = ? ORDER BY rowid`)
+	return err
+}
+
+func (s *sqliteLookupStore) Get(key string) ([][]interface{}, error) {
+	rows, err := s.query.Query(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret [][]interface{}
+	for rows.Next() {
+		var (
+			b   []byte
+			row []interface{}
+		)
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&row); err != nil {
+			return nil, err
+		}
+		ret = append(ret, row)
+	}
+	return ret, rows.Err()
+}
+
+func (s *sqliteLookupStore) Len() int {
+	return s.count
+}
+
+//Close closes and removes the database.
+func (s *sqliteLookupStore) Close() error {
+	if s.insert != nil {
+		s.insert.Close()
+	}
+	if s.tx != nil {
+		s.tx.Rollback()
+	}
+	if s.query != nil {
+		s.query.Close()
+	}
+	err := s.db.Close()
+	if errR := os.Remove(s.path); err == nil {
+		err = errR
+	}
+	return err
+}
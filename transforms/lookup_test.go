@@ -151,3 +151,221 @@ func TestLookup(t *testing.T) {
 	})
 
 }
+
+//runLookup runs the lookup with a FROM source called a and a JOIN source called b.
+func runLookup(l *lookup, aCols []string, aMsgs [][]interface{}, bCols []string, bMsgs [][]interface{}) ([]string, [][]interface{}) {
+	inA := engine.NewStream(aCols, 100)
+	inB := engine.NewStream(bCols, 100)
+	out := engine.NewStream(nil, 100)
+	logger := engine.NewConsoleLogger(engine.Trace)
+	st := engine.NewStopper()
+	l.SetName("lookup")
+	for i := range aMsgs {
+		inA.Chan("lookup") <- engine.Message{Source: "a", Destination: "lookup", Data: aMsgs[i]}
+	}
+	for i := range bMsgs {
+		inB.Chan("lookup") <- engine.Message{Source: "b", Destination: "lookup", Data: bMsgs[i]}
+	}
+	close(inA.Chan("lookup"))
+	close(inB.Chan("lookup"))
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		l.Open(inA, out, logger, st)
+		wg.Done()
+	}()
+	go func() {
+		l.Open(inB, out, logger, st)
+		wg.Done()
+	}()
+	wg.Wait()
+	var ret [][]interface{}
+	for msg := range out.Chan(engine.DestinationWildcard) {
+		ret = append(ret, msg.Data)
+	}
+	return out.Columns(), ret
+}
+
+func TestLookupJoinTypes(t *testing.T) {
+	aCols := []string{"id", "something"}
+	bCols := []string{"id", "name"}
+	aMsgs := [][]interface{}{
+		[]interface{}{1, "A"},
+		[]interface{}{2, "B"},
+		[]interface{}{3, "C"},
+	}
+	bMsgs := [][]interface{}{
+		[]interface{}{2, "bob"},
+		[]interface{}{2, "bobby"},
+		[]interface{}{3, "john"},
+	}
+	Convey("Given a LEFT JOIN lookup", t, func() {
+		l, err := NewLookup(`LOOKUP a.Id, b.Name FROM a LEFT JOIN b ON a.Id = b.Id`)
+		So(err, ShouldBeNil)
+		So(l.outerJoin, ShouldBeTrue)
+		Convey("It should output every match, and unmatched rows with NULLs", func() {
+			_, res := runLookup(l, aCols, aMsgs, bCols, bMsgs)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, nil},
+				[]interface{}{2, "bob"},
+				[]interface{}{2, "bobby"},
+				[]interface{}{3, "john"},
+			})
+		})
+	})
+	Convey("Given an ANTI JOIN lookup", t, func() {
+		l, err := NewLookup(`LOOKUP a.Id, a.Something FROM a ANTI JOIN b ON a.Id = b.Id`)
+		So(err, ShouldBeNil)
+		Convey("It should only output rows without a match", func() {
+			_, res := runLookup(l, aCols, aMsgs, bCols, bMsgs)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, "A"},
+			})
+		})
+	})
+	Convey("Given a SEMI JOIN lookup", t, func() {
+		l, err := NewLookup(`LOOKUP a.Id FROM a SEMI JOIN b ON a.Id = b.Id`)
+		So(err, ShouldBeNil)
+		Convey("It should output rows with a match once", func() {
+			_, res := runLookup(l, aCols, aMsgs, bCols, bMsgs)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{2},
+				[]interface{}{3},
+			})
+		})
+	})
+	Convey("Given a SEMI JOIN lookup that selects columns from the JOIN source", t, func() {
+		_, err := NewLookup(`LOOKUP a.Id, b.Name FROM a SEMI JOIN b ON a.Id = b.Id`)
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Given a LOOKUP * lookup", t, func() {
+		l, err := NewLookup(`LOOKUP * FROM a INNER JOIN b ON a.Id = b.Id`)
+		So(err, ShouldBeNil)
+		Convey("It should output the FROM columns followed by the new JOIN columns", func() {
+			cols, res := runLookup(l, aCols, aMsgs, bCols, bMsgs)
+			So(cols, ShouldResemble, []string{"id", "something", "name"})
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{2, "B", "bob"},
+				[]interface{}{2, "B", "bobby"},
+				[]interface{}{3, "C", "john"},
+			})
+		})
+	})
+}
+
+func TestLookupDuplicateKeys(t *testing.T) {
+	Convey("Given an INNER JOIN lookup whose lookup table has several rows with the same key", t, func() {
+		l, err := NewLookup(`LOOKUP a.Id, b.Name FROM a INNER JOIN b ON a.Id = b.Id`)
+		So(err, ShouldBeNil)
+		aCols := []string{"id"}
+		bCols := []string{"id", "name"}
+		aMsgs := [][]interface{}{
+			[]interface{}{1},
+			[]interface{}{2},
+		}
+		bMsgs := [][]interface{}{
+			[]interface{}{1, "bob"},
+			[]interface{}{1, "bobby"},
+			[]interface{}{1, "robert"},
+			[]interface{}{2, "john"},
+		}
+		Convey("It should output a row for each of them, in the order that they were received", func() {
+			_, res := runLookup(l, aCols, aMsgs, bCols, bMsgs)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, "bob"},
+				[]interface{}{1, "bobby"},
+				[]interface{}{1, "robert"},
+				[]interface{}{2, "john"},
+			})
+		})
+		Convey("It should do the same with an on-disk lookup table", func() {
+			l.MemoryRows = 2
+			_, res := runLookup(l, aCols, aMsgs, bCols, bMsgs)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, "bob"},
+				[]interface{}{1, "bobby"},
+				[]interface{}{1, "robert"},
+				[]interface{}{2, "john"},
+			})
+		})
+	})
+}
+
+func TestLookupEmptySource(t *testing.T) {
+	Convey("Given a lookup whose FROM source is empty", t, func() {
+		l, err := NewLookup(`LOOKUP a.Id, b.Name FROM a INNER JOIN b ON a.Id = b.Id`)
+		So(err, ShouldBeNil)
+		l.MemoryRows = 10
+		l.SetName("lookup")
+		inA := engine.NewStream([]string{"id"}, 100)
+		inB := engine.NewStream([]string{"id", "name"}, 100)
+		out := engine.NewStream(nil, 100)
+		logger := engine.NewConsoleLogger(engine.Trace)
+		st := engine.NewStopper()
+		for i := 0; i < 100; i++ {
+			inB.Chan("lookup") <- engine.Message{Source: "b", Destination: "lookup", Data: []interface{}{i, "name"}}
+		}
+		close(inA.Chan("lookup"))
+		close(inB.Chan("lookup"))
+		Convey("It should stop without the JOIN source using the closed lookup table", func() {
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				l.Open(inA, out, logger, st)
+				wg.Done()
+			}()
+			go func() {
+				l.Open(inB, out, logger, st)
+				wg.Done()
+			}()
+			wg.Wait()
+			So(st.Stopped(), ShouldBeTrue)
+			So(l.add("1", []interface{}{1, "name"}, logger), ShouldEqual, errLookupTableClosed)
+		})
+	})
+}
+
+func TestLookupNonEqualityConditions(t *testing.T) {
+	Convey("Given a lookup with a range join condition", t, func() {
+		l, err := NewLookup(`
+		LOOKUP a.Time, b.Tariff FROM a
+		INNER JOIN b ON a.Meter = b.Meter AND b.ValidFrom <= a.Time AND a.Time < b.ValidTo
+		`)
+		So(err, ShouldBeNil)
+		So(l.baseJoinColumns, ShouldResemble, []string{"meter"})
+		So(l.filters, ShouldResemble, []joinFilter{
+			{baseColumn: "time", operator: ">=", lookupColumn: "validfrom"},
+			{baseColumn: "time", operator: "<", lookupColumn: "validto"},
+		})
+		aCols := []string{"Meter", "Time"}
+		bCols := []string{"Meter", "ValidFrom", "ValidTo", "Tariff"}
+		aMsgs := [][]interface{}{
+			[]interface{}{"m1", 5},
+			[]interface{}{"m1", 15},
+			[]interface{}{"m1", 25},
+		}
+		bMsgs := [][]interface{}{
+			[]interface{}{"m1", 0, 10, "day"},
+			[]interface{}{"m1", 10, 20, "night"},
+		}
+		Convey("It should only join rows that satisfy every condition", func() {
+			_, res := runLookup(l, aCols, aMsgs, bCols, bMsgs)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{5, "day"},
+				[]interface{}{15, "night"},
+			})
+		})
+		Convey("It should give the same results with an on-disk lookup table", func() {
+			l.MemoryRows = 1
+			_, res := runLookup(l, aCols, aMsgs, bCols, bMsgs)
+			_, ok := l.lookupTable.(*sqliteLookupStore)
+			So(ok, ShouldBeTrue)
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{5, "day"},
+				[]interface{}{15, "night"},
+			})
+		})
+	})
+}
@@ -0,0 +1,382 @@
+package transforms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/michaelbironneau/analyst/engine"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maskSHA256   = "sha256"
+	maskRedact   = "redact"
+	maskTokenize = "tokenize"
+
+	//TokenPrefix is the prefix of the tokens output by MASK ... USING TOKENIZE.
+	TokenPrefix = "tok_"
+
+	//MaskKeySize is the size in bytes of the key used by MASK ... USING TOKENIZE.
+	MaskKeySize = 32
+)
+
+var (
+	maskLexer = lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Keyword>(?i)(?:MASK|USING|SHA256|SALT|REDACT|TOKENIZE|AS)\b)`+
+		`|(?P<Parameter>@[a-zA-Z0-9_]+)`+
+		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
+		`|(?P<Number>\d+)`+
+		`|(?P<Operators>[,()])`,
+	)), "Keyword")
+)
+
+type MaskColumn struct {
+	Column   string  `@Ident "USING"`
+	Hash     bool    `( @"SHA256"`
+	Salt     *string `  [ "SALT" @Parameter ]`
+	Redact   *string `| "REDACT" "(" @Number ")"`
+	Tokenize bool    `| @"TOKENIZE" )`
+	Alias    *string `[ "AS" @Ident ]`
+}
+
+type Mask struct {
+	Columns []MaskColumn `"MASK" @@ { "," @@ }`
+}
+
+// maskColumn is a masked column along with its masking method and arguments.
+type maskColumn struct {
+	column string
+	alias  string
+	method string
+	salt   string
+	keep   int
+}
+
+type mask struct {
+	name      string
+	columns   []maskColumn
+	params    *engine.ParameterTable
+	sourceSeq []string
+	KeyFile   string `aql:"MASK_KEY_FILE, optional"`
+}
+
+func (m *mask) SetName(name string) {
+	m.name = name
+}
+
+func (m *mask) Sequence(seq []string) {
+	m.sourceSeq = seq
+}
+
+// SetParameterTable checks that the salts are declared parameters. Their values are read
+// when the transform is opened, as they are typically set by a query, which the compiler
+// makes the transform run after.
+func (m *mask) SetParameterTable(p *engine.ParameterTable) error {
+	for _, col := range m.columns {
+		if col.salt != "" && !p.Declared(col.salt) {
+			return fmt.Errorf("salt parameter %s needs to be declared before it can be used", col.salt)
+		}
+	}
+	m.params = p
+	return nil
+}
+
+// Parameters returns the salt parameters.
+func (m *mask) Parameters() []string {
+	var ret []string
+	for _, col := range m.columns {
+		if col.salt != "" {
+			ret = append(ret, col.salt)
+		}
+	}
+	return ret
+}
+
+func (m *mask) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  m.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(m.name))
+}
+
+// Open replaces the values of the masked columns, leaving the other columns and NULLs
+// untouched. Masked values are output as strings.
+func (m *mask) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		ixs          []int
+		firstMessage = true
+	)
+
+	if m.sourceSeq != nil {
+		seq := engine.NewSequencedStream(s, m.sourceSeq)
+		inChan = seq.Chan(m.name)
+	} else {
+		inChan = s.Chan(m.name)
+	}
+	outChan = dest.Chan(m.name)
+
+	maskFns, err := m.maskFns()
+	if err != nil {
+		m.fatalerr(err, dest, l, st)
+		return
+	}
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			cols := s.Columns()
+			outCols := make([]string, len(cols))
+			copy(outCols, cols)
+			for _, col := range m.columns {
+				ix, ok := find(cols, col.column)
+				if !ok {
+					m.fatalerr(fmt.Errorf("could not find column %s", col.column), dest, l, st)
+					return
+				}
+				ixs = append(ixs, ix)
+				outCols[ix] = col.alias
+			}
+			if err := dest.SetColumns(m.name, outCols); err != nil {
+				m.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		row := make([]interface{}, len(msg.Data))
+		copy(row, msg.Data)
+		for i, ix := range ixs {
+			if row[ix] == nil {
+				continue
+			}
+			if row[ix], err = maskFns[i](row[ix]); err != nil {
+				m.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		outChan <- engine.Message{
+			Source:      m.name,
+			Destination: engine.DestinationWildcard,
+			Data:        row,
+		}
+	}
+	close(outChan)
+}
+
+// maskFns returns the masking function of each column, reading the salts and key.
+func (m *mask) maskFns() ([]func(interface{}) (interface{}, error), error) {
+	var (
+		ret []func(interface{}) (interface{}, error)
+		key []byte
+		err error
+	)
+	for _, col := range m.columns {
+		col := col
+		switch col.method {
+		case maskSHA256:
+			var salt []byte
+			if col.salt != "" {
+				if salt, err = m.salt(col.salt); err != nil {
+					return nil, err
+				}
+			}
+			ret = append(ret, func(v interface{}) (interface{}, error) {
+				return hashValue(salt, maskString(v)), nil
+			})
+		case maskRedact:
+			ret = append(ret, func(v interface{}) (interface{}, error) {
+				return redactValue(maskString(v), col.keep), nil
+			})
+		case maskTokenize:
+			if key == nil {
+				if m.KeyFile == "" {
+					return nil, fmt.Errorf("the MASK_KEY_FILE option is required to tokenize column %s", col.column)
+				}
+				if key, err = ReadMaskKey(m.KeyFile); err != nil {
+					return nil, err
+				}
+			}
+			k := key
+			ret = append(ret, func(v interface{}) (interface{}, error) {
+				return Tokenize(k, maskString(v))
+			})
+		}
+	}
+	return ret, nil
+}
+
+// salt returns the value of the salt parameter, which must have been set by the time the transform is opened.
+func (m *mask) salt(name string) ([]byte, error) {
+	if m.params == nil {
+		return nil, fmt.Errorf("salt parameter %s is not available", name)
+	}
+	v, ok := m.params.Get(name)
+	if !ok || v == nil {
+		return nil, fmt.Errorf("salt parameter %s has not been set", name)
+	}
+	return []byte(maskString(v)), nil
+}
+
+func maskString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// hashValue returns the hex-encoded HMAC-SHA256 of the value, keyed with the salt, or its SHA-256 if there is no salt.
+func hashValue(salt []byte, s string) string {
+	if salt == nil {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	h := hmac.New(sha256.New, salt)
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// redactValue replaces all but the last keep characters with asterisks.
+func redactValue(s string, keep int) string {
+	r := []rune(s)
+	for i := 0; i < len(r)-keep; i++ {
+		r[i] = '*'
+	}
+	return string(r)
+}
+
+// ReadMaskKey reads a tokenization key, which is stored hex-encoded in a file.
+func ReadMaskKey(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s should contain a hex-encoded key: %v", path, err)
+	}
+	if len(key) != MaskKeySize {
+		return nil, fmt.Errorf("key file %s should contain a %v-byte key but it has %v bytes", path, MaskKeySize, len(key))
+	}
+	return key, nil
+}
+
+// maskSubkey derives the subkey for the given purpose from the tokenization key.
+func maskSubkey(key []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
+
+// Tokenize encrypts the value with AES-GCM. The nonce is derived from the value, so that the same value always
+// results in the same token and tokenized columns can still be joined and grouped on.
+func Tokenize(key []byte, s string) (string, error) {
+	aead, err := maskCipher(key)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, maskSubkey(key, "nonce"))
+	h.Write([]byte(s))
+	nonce := h.Sum(nil)[:aead.NonceSize()]
+	sealed := aead.Seal(nonce, nonce, []byte(s), nil)
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Detokenize reverses Tokenize, given the same key.
+func Detokenize(key []byte, token string) (string, error) {
+	aead, err := maskCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return "", fmt.Errorf("invalid token %s: expected prefix %s", token, TokenPrefix)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, TokenPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid token %s: %v", token, err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid token %s: too short", token)
+	}
+	b, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("could not detokenize %s, it may have been tokenized with a different key: %v", token, err)
+	}
+	return string(b), nil
+}
+
+func maskCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(maskSubkey(key, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newMask(ms *Mask) (*mask, error) {
+	var m mask
+	for _, col := range ms.Columns {
+		mc := maskColumn{column: col.Column, alias: col.Column}
+		if col.Alias != nil {
+			mc.alias = *col.Alias
+		}
+		switch {
+		case col.Hash:
+			mc.method = maskSHA256
+			if col.Salt != nil {
+				mc.salt = *col.Salt
+			}
+		case col.Redact != nil:
+			mc.method = maskRedact
+			n, err := strconv.Atoi(*col.Redact)
+			if err != nil {
+				return nil, fmt.Errorf("expected REDACT(n) where n is the number of characters to keep but got %s", *col.Redact)
+			}
+			mc.keep = n
+		case col.Tokenize:
+			mc.method = maskTokenize
+		}
+		for _, other := range m.columns {
+			if strings.ToLower(other.column) == strings.ToLower(mc.column) {
+				return nil, fmt.Errorf("column %s is masked more than once", mc.column)
+			}
+		}
+		m.columns = append(m.columns, mc)
+	}
+	return &m, nil
+}
+
+func NewMask(aqlBody string) (*mask, error) {
+	p, err := participle.Build(&Mask{}, maskLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var ms Mask
+	err = p.ParseString(aqlBody, &ms)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newMask(&ms)
+}
+
+func maskInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewMask(aqlBody)
+}
@@ -0,0 +1,78 @@
+package transforms
+
+import (
+	"encoding/hex"
+	"github.com/michaelbironneau/analyst/engine"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	rows := [][]interface{}{
+		[]interface{}{"bob@example.com", "0123456789", "Bob"},
+		[]interface{}{nil, 12345, "Alice"},
+	}
+	cols := []string{"Email", "Phone", "Name"}
+	Convey("Given a MASK transform", t, func() {
+		m, err := NewMask(`MASK Email USING SHA256 SALT @salt, Phone USING REDACT(4), Name USING TOKENIZE AS NameToken`)
+		So(err, ShouldBeNil)
+		p := engine.NewParameterTable()
+		Convey("It should require the salt to be declared", func() {
+			So(m.SetParameterTable(p), ShouldNotBeNil)
+		})
+		So(p.Declare("@salt"), ShouldBeNil)
+		So(m.SetParameterTable(p), ShouldBeNil)
+		Convey("It should fail if there is no key file", func() {
+			So(p.Set("@salt", "pepper"), ShouldBeNil)
+			_, res := runTransform(m, cols, rows)
+			So(res, ShouldBeEmpty)
+		})
+		Convey("It should mask the columns", func() {
+			So(p.Set("@salt", "pepper"), ShouldBeNil)
+			f, err := ioutil.TempFile("", "analyst-key")
+			So(err, ShouldBeNil)
+			defer os.Remove(f.Name())
+			key := []byte(strings.Repeat("k", MaskKeySize))
+			f.WriteString(hex.EncodeToString(key))
+			f.Close()
+			m.KeyFile = f.Name()
+			outCols, res := runTransform(m, cols, rows)
+			So(outCols, ShouldResemble, []string{"Email", "Phone", "NameToken"})
+			So(res, ShouldHaveLength, 2)
+			So(res[0][0], ShouldEqual, hashValue([]byte("pepper"), "bob@example.com"))
+			So(res[0][0], ShouldNotEqual, hashValue(nil, "bob@example.com"))
+			So(res[1][0], ShouldBeNil)
+			So(res[0][1], ShouldEqual, "******6789")
+			So(res[1][1], ShouldEqual, "*2345")
+			So(res[0][2], ShouldStartWith, TokenPrefix)
+			name, err := Detokenize(key, res[0][2].(string))
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "Bob")
+		})
+	})
+}
+
+func TestTokenize(t *testing.T) {
+	Convey("Given a key", t, func() {
+		key := []byte(strings.Repeat("a", MaskKeySize))
+		Convey("Tokenization should be deterministic and reversible", func() {
+			t1, err := Tokenize(key, "secret")
+			So(err, ShouldBeNil)
+			t2, _ := Tokenize(key, "secret")
+			t3, _ := Tokenize(key, "other")
+			So(t1, ShouldEqual, t2)
+			So(t1, ShouldNotEqual, t3)
+			s, err := Detokenize(key, t1)
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, "secret")
+		})
+		Convey("Detokenization should fail with a different key", func() {
+			tok, _ := Tokenize(key, "secret")
+			_, err := Detokenize([]byte(strings.Repeat("b", MaskKeySize)), tok)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
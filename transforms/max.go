@@ -55,11 +55,11 @@ func (s *max) Reduce(arg []interface{}) error {
 	return nil
 }
 
-func (s *max) Return() *float64 {
+func (s *max) Return() interface{} {
 	if !s.notNull {
 		return nil
 	}
-	return &s.result
+	return s.result
 }
 
 func (s *max) Copy() Reducer {
@@ -25,7 +25,7 @@ func TestMax(t *testing.T) {
 			}
 			f := agg.Return()
 			So(f, ShouldNotBeNil)
-			So(*f, ShouldEqual, 1.0)
+			So(f, ShouldEqual, 1.0)
 		})
 		Convey("It should reduce multiple messages all negative correctly", func() {
 			msgs := [][]interface{}{
@@ -39,7 +39,7 @@ func TestMax(t *testing.T) {
 			}
 			f := agg.Return()
 			So(f, ShouldNotBeNil)
-			So(*f, ShouldEqual, -1.0)
+			So(f, ShouldEqual, -1.0)
 		})
 		Convey("It should reduce multiple timestamps correctly", func() {
 			expectedMax := "2018-02-14T11:00:00Z"
@@ -59,7 +59,7 @@ func TestMax(t *testing.T) {
 			So(f, ShouldNotBeNil)
 			expectedMaxTimestamp, _, err := parseTime(expectedMax)
 			So(err, ShouldBeNil)
-			So(*f, ShouldEqual, float64(expectedMaxTimestamp.Unix()))
+			So(f, ShouldEqual, float64(expectedMaxTimestamp.Unix()))
 		})
 		Convey("It should raise an error when a string is not in the expected timestamp formats", func() {
 			expectedRejectionTimestampFormat := "FOO_BAR_BAZ"
@@ -0,0 +1,54 @@
+package transforms
+
+import (
+	"fmt"
+	"sort"
+)
+
+//median computes the exact median. Unlike QUANTILE, it holds all the values of each group in memory.
+type median struct {
+	values []float64
+	am     ArgumentMap
+}
+
+func (s *median) ParameterLen() int {
+	return 1
+}
+
+func (s *median) SetArgumentMap(am ArgumentMap) {
+	s.am = am
+}
+
+func (s *median) Reduce(arg []interface{}) error {
+	args := s.am(arg)
+	if len(args) != 1 {
+		return fmt.Errorf("MEDIAN takes exactly 1 argument but %v were provided", len(args))
+	}
+	if args[0] == nil {
+		return nil
+	}
+	v, ok := toFloat(args[0])
+	if !ok {
+		return fmt.Errorf("MEDIAN takes a single numerical argument, but %v was provided", args[0])
+	}
+	s.values = append(s.values, v)
+	return nil
+}
+
+func (s *median) Return() interface{} {
+	n := len(s.values)
+	if n == 0 {
+		return nil
+	}
+	sorted := make([]float64, n)
+	copy(sorted, s.values)
+	sort.Float64s(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func (s *median) Copy() Reducer {
+	return &median{am: s.am}
+}
@@ -0,0 +1,28 @@
+package transforms
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestMedian(t *testing.T) {
+	Convey("Given a median aggregate", t, func() {
+		agg := median{}
+		agg.SetArgumentMap(DefaultArgMap)
+		Convey("It should reduce to nil if there are no messages", func() {
+			So(agg.Return(), ShouldBeNil)
+		})
+		Convey("It should reduce an odd number of messages correctly", func() {
+			for _, v := range []interface{}{3, 1.0, nil, 2} {
+				So(agg.Reduce([]interface{}{v}), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, 2.0)
+		})
+		Convey("It should reduce an even number of messages correctly", func() {
+			for _, v := range []interface{}{4, 1, 3, 2} {
+				So(agg.Reduce([]interface{}{v}), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, 2.5)
+		})
+	})
+}
@@ -53,11 +53,11 @@ func (s *min) Reduce(arg []interface{}) error {
 	return nil
 }
 
-func (s *min) Return() *float64 {
+func (s *min) Return() interface{} {
 	if !s.notNull {
 		return nil
 	}
-	return &s.result
+	return s.result
 }
 
 func (s *min) Copy() Reducer {
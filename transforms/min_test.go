@@ -26,7 +26,7 @@ func TestMin(t *testing.T) {
 			}
 			f := agg.Return()
 			So(f, ShouldNotBeNil)
-			So(*f, ShouldEqual, -0.1)
+			So(f, ShouldEqual, -0.1)
 		})
 		Convey("It should reduce multiple message with negative numbers correctly", func() {
 			msgs := [][]interface{}{
@@ -40,7 +40,7 @@ func TestMin(t *testing.T) {
 			}
 			f := agg.Return()
 			So(f, ShouldNotBeNil)
-			So(*f, ShouldEqual, -1)
+			So(f, ShouldEqual, -1)
 		})
 		Convey("It should reduce multiple timestamps correctly", func() {
 			expectedMin := "2018-02-13T01:00:00Z"
@@ -66,7 +66,7 @@ func TestMin(t *testing.T) {
 			So(f, ShouldNotBeNil)
 			expectedMinTimestamp, _, err := parseTime(expectedMin)
 			So(err, ShouldBeNil)
-			So(*f, ShouldEqual, float64(expectedMinTimestamp.Unix()))
+			So(f, ShouldEqual, float64(expectedMinTimestamp.Unix()))
 		})
 		Convey("It should raise an error when a string is not in the expected timestamp formats", func() {
 			expectedRejectionTimestampFormat := "FOO_BAR_BAZ"
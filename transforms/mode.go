@@ -0,0 +1,61 @@
+package transforms
+
+import "fmt"
+
+//mode returns the most frequent non-NULL value of its argument. Ties go to the value that was seen first.
+type mode struct {
+	counts map[string]int
+	values map[string]interface{}
+	order  []string
+	am     ArgumentMap
+}
+
+func (s *mode) ParameterLen() int {
+	return 1
+}
+
+func (s *mode) SetArgumentMap(am ArgumentMap) {
+	s.am = am
+}
+
+func (s *mode) Reduce(arg []interface{}) error {
+	args := s.am(arg)
+	if len(args) != 1 {
+		return fmt.Errorf("MODE takes exactly 1 argument but %v were provided", len(args))
+	}
+	if args[0] == nil {
+		return nil
+	}
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+		s.values = make(map[string]interface{})
+	}
+	key := fmt.Sprintf("%v", args[0])
+	if _, ok := s.counts[key]; !ok {
+		s.order = append(s.order, key)
+		s.values[key] = args[0]
+	}
+	s.counts[key]++
+	return nil
+}
+
+func (s *mode) Return() interface{} {
+	var (
+		best      string
+		bestCount int
+	)
+	for _, key := range s.order {
+		if s.counts[key] > bestCount {
+			best = key
+			bestCount = s.counts[key]
+		}
+	}
+	if bestCount == 0 {
+		return nil
+	}
+	return s.values[best]
+}
+
+func (s *mode) Copy() Reducer {
+	return &mode{am: s.am}
+}
@@ -0,0 +1,28 @@
+package transforms
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestMode(t *testing.T) {
+	Convey("Given a mode aggregate", t, func() {
+		agg := mode{}
+		agg.SetArgumentMap(DefaultArgMap)
+		Convey("It should reduce to nil if there are no messages", func() {
+			So(agg.Return(), ShouldBeNil)
+		})
+		Convey("It should return the most frequent value", func() {
+			for _, v := range []interface{}{"a", 2, nil, 2, "a", 2} {
+				So(agg.Reduce([]interface{}{v}), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, 2)
+		})
+		Convey("It should break ties in favour of the first value seen", func() {
+			for _, v := range []interface{}{"b", "a", "a", "b"} {
+				So(agg.Reduce([]interface{}{v}), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, "b")
+		})
+	})
+}
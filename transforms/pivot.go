@@ -0,0 +1,194 @@
+package transforms
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/michaelbironneau/analyst/engine"
+	"time"
+)
+
+var (
+	pivotLexer = lexer.Unquote(lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Keyword>(?i)(?:PIVOT|UNPIVOT|FOR|IN|GROUP|BY|INTO)\b)`+
+		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
+		`|(?P<Number>[-+]?\d*\.?\d+([eE][-+]?\d+)?)`+
+		`|(?P<String>'[^']*'|"[^"]*")`+
+		`|(?P<Operators><>|!=|<=|>=|[-+*/%,.()=<>])`,
+	)), "Keyword"), "String")
+)
+
+type Pivot struct {
+	Value      string   `"PIVOT" @Ident`
+	Category   string   `"FOR" @Ident`
+	Categories []string `"IN" "(" @String { "," @String } ")"`
+	GroupBy    []string `[ "GROUP" "BY" @Ident { "," @Ident } ]`
+}
+
+//pivotRow is the state of a single output row: its key column values followed by one
+//cell for each category.
+type pivotRow struct {
+	key   []interface{}
+	cells []interface{}
+}
+
+type pivot struct {
+	name       string
+	value      string
+	category   string
+	categories []string
+	keyColumns []string
+	sourceSeq  []string
+}
+
+func (p *pivot) SetName(name string) {
+	p.name = name
+}
+
+func (p *pivot) Sequence(seq []string) {
+	p.sourceSeq = seq
+}
+
+func (p *pivot) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  p.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(p.name))
+}
+
+//  Open turns rows into columns. Each distinct combination of GROUP BY columns becomes an
+//  output row, with one column per category. If there is more than one value for a given
+//  key and category then the last non-NULL value is kept. Rows are output once the source
+//  is exhausted, in the order that each key was first seen.
+func (p *pivot) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		getKey       func([]interface{}) string
+		keyIndexes   []int
+		valueIx      int
+		categoryIx   int
+		categoryIxs  = make(map[string]int)
+		state        = make(map[string]*pivotRow)
+		order        []string
+		firstMessage = true
+		err          error
+	)
+
+	if p.sourceSeq != nil {
+		seq := engine.NewSequencedStream(s, p.sourceSeq)
+		inChan = seq.Chan(p.name)
+	} else {
+		inChan = s.Chan(p.name)
+	}
+	outChan = dest.Chan(p.name)
+
+	for i, c := range p.categories {
+		categoryIxs[c] = i
+	}
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			cols := s.Columns()
+			var ok bool
+			if valueIx, ok = find(cols, p.value); !ok {
+				p.fatalerr(fmt.Errorf("could not find value column %s", p.value), dest, l, st)
+				return
+			}
+			if categoryIx, ok = find(cols, p.category); !ok {
+				p.fatalerr(fmt.Errorf("could not find category column %s", p.category), dest, l, st)
+				return
+			}
+			getKey, err = groupBy(p.keyColumns, cols)
+			if err != nil {
+				p.fatalerr(err, dest, l, st)
+				return
+			}
+			for _, col := range p.keyColumns {
+				ix, _ := find(cols, col)
+				keyIndexes = append(keyIndexes, ix)
+			}
+			if err := dest.SetColumns(p.name, p.columns()); err != nil {
+				p.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		ix, ok := categoryIxs[fmt.Sprintf("%v", msg.Data[categoryIx])]
+		if !ok {
+			continue
+		}
+		key := getKey(msg.Data)
+		row, ok := state[key]
+		if !ok {
+			row = &pivotRow{cells: make([]interface{}, len(p.categories))}
+			for _, kix := range keyIndexes {
+				row.key = append(row.key, msg.Data[kix])
+			}
+			state[key] = row
+			order = append(order, key)
+		}
+		if msg.Data[valueIx] != nil {
+			row.cells[ix] = msg.Data[valueIx]
+		}
+	}
+
+	for _, key := range order {
+		outChan <- engine.Message{
+			Source:      p.name,
+			Destination: engine.DestinationWildcard,
+			Data:        append(state[key].key, state[key].cells...),
+		}
+	}
+	close(outChan)
+}
+
+//columns returns the output columns: the GROUP BY columns followed by the categories.
+func (p *pivot) columns() []string {
+	var ret []string
+	ret = append(ret, p.keyColumns...)
+	return append(ret, p.categories...)
+}
+
+func newPivot(pv *Pivot) (*pivot, error) {
+	seen := make(map[string]bool)
+	for _, c := range pv.Categories {
+		if seen[c] {
+			return nil, fmt.Errorf("category '%s' is repeated in PIVOT", c)
+		}
+		seen[c] = true
+	}
+	return &pivot{
+		value:      pv.Value,
+		category:   pv.Category,
+		categories: pv.Categories,
+		keyColumns: pv.GroupBy,
+	}, nil
+}
+
+func NewPivot(aqlBody string) (*pivot, error) {
+	p, err := participle.Build(&Pivot{}, pivotLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var pv Pivot
+	err = p.ParseString(aqlBody, &pv)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newPivot(&pv)
+}
+
+func pivotInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewPivot(aqlBody)
+}
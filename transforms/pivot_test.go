@@ -0,0 +1,79 @@
+package transforms
+
+import (
+	"github.com/alecthomas/participle"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestPivotParsing(t *testing.T) {
+	Convey("Given a valid pivot", t, func() {
+		parser, err := participle.Build(&Pivot{}, pivotLexer)
+		So(err, ShouldBeNil)
+		p := Pivot{}
+		err = parser.ParseString(`PIVOT Value FOR Meter IN ('a', 'b') GROUP BY Time`, &p)
+		So(err, ShouldBeNil)
+		So(p.Value, ShouldEqual, "Value")
+		So(p.Category, ShouldEqual, "Meter")
+		So(p.Categories, ShouldResemble, []string{"a", "b"})
+		So(p.GroupBy, ShouldResemble, []string{"Time"})
+	})
+	Convey("Given a valid unpivot", t, func() {
+		parser, err := participle.Build(&Unpivot{}, pivotLexer)
+		So(err, ShouldBeNil)
+		u := Unpivot{}
+		err = parser.ParseString(`UNPIVOT (a, b, c) INTO Meter, Value`, &u)
+		So(err, ShouldBeNil)
+		So(u.Columns, ShouldResemble, []string{"a", "b", "c"})
+		So(u.Name, ShouldEqual, "Meter")
+		So(u.Value, ShouldEqual, "Value")
+	})
+	Convey("Given a pivot with repeated categories", t, func() {
+		_, err := NewPivot(`PIVOT Value FOR Meter IN ('a', 'a')`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPivot(t *testing.T) {
+	Convey("Given a PIVOT transform", t, func() {
+		p, err := NewPivot(`PIVOT Value FOR Meter IN ('a', 'b') GROUP BY Time`)
+		So(err, ShouldBeNil)
+		Convey("It should turn categories into columns", func() {
+			cols, res := runTransform(p, []string{"Time", "Meter", "Value"}, [][]interface{}{
+				[]interface{}{1, "a", 1.0},
+				[]interface{}{1, "b", 2.0},
+				[]interface{}{2, "b", 3.0},
+				[]interface{}{2, "c", 4.0},
+			})
+			So(cols, ShouldResemble, []string{"Time", "a", "b"})
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, 1.0, 2.0},
+				[]interface{}{2, nil, 3.0},
+			})
+		})
+	})
+	Convey("Given an UNPIVOT transform", t, func() {
+		u, err := NewUnpivot(`UNPIVOT (a, b) INTO Meter, Value`)
+		So(err, ShouldBeNil)
+		Convey("It should turn columns into rows", func() {
+			cols, res := runTransform(u, []string{"Time", "a", "b"}, [][]interface{}{
+				[]interface{}{1, 1.0, 2.0},
+				[]interface{}{2, nil, 3.0},
+			})
+			So(cols, ShouldResemble, []string{"Time", "Meter", "Value"})
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, "a", 1.0},
+				[]interface{}{1, "b", 2.0},
+				[]interface{}{2, "b", 3.0},
+			})
+		})
+		Convey("It should fail if an output column already exists", func() {
+			u, err := NewUnpivot(`UNPIVOT (a) INTO Time, Value`)
+			So(err, ShouldBeNil)
+			_, res := runTransform(u, []string{"Time", "a", "b"}, [][]interface{}{
+				[]interface{}{1, 1.0, 2.0},
+			})
+			So(res, ShouldBeEmpty)
+		})
+	})
+}
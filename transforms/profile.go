@@ -0,0 +1,366 @@
+package transforms
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/michaelbironneau/analyst/engine"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//DefaultProfileTopValues is the number of most frequent values reported for each column by PROFILE.
+const DefaultProfileTopValues = 5
+
+//ProfileColumns are the columns of the rows output by PROFILE, one row per profiled column.
+var ProfileColumns = []string{"Column", "Type", "Count", "Nulls", "Distinct", "Min", "Max", "Mean", "MinLength", "MeanLength", "MaxLength", "TopValues"}
+
+var (
+	profileLexer = lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Keyword>(?i)(?:PROFILE|TOP|PASSTHROUGH)\b)`+
+		`|(?P<Number>\d+)`,
+	)), "Keyword")
+)
+
+type Profile struct {
+	Profile     bool    `@"PROFILE"`
+	Top         *string `[ "TOP" @Number ]`
+	Passthrough bool    `[ @"PASSTHROUGH" ]`
+}
+
+//Inferred column types, from most to least specific.
+const (
+	profileNull     = "null"
+	profileBool     = "bool"
+	profileInt      = "int"
+	profileFloat    = "float"
+	profileDatetime = "datetime"
+	profileVarchar  = "varchar"
+	profileMixed    = "mixed"
+)
+
+//columnProfile holds the statistics of a column. Top values are tracked with the
+//space-saving algorithm, so their counts are approximate for columns with many values.
+type columnProfile struct {
+	name        string
+	count       int64
+	nulls       int64
+	distinct    *hyperLogLog
+	min         interface{}
+	max         interface{}
+	sum         float64
+	numbers     int64
+	strings     int64
+	lengthSum   int64
+	minLength   int64
+	maxLength   int64
+	types       map[string]bool
+	top         map[string]int64
+	topCapacity int
+}
+
+func newColumnProfile(name string, topValues int) *columnProfile {
+	capacity := 10 * topValues
+	if capacity < 100 {
+		capacity = 100
+	}
+	return &columnProfile{
+		name:        name,
+		distinct:    newHyperLogLog(14),
+		types:       make(map[string]bool),
+		top:         make(map[string]int64),
+		topCapacity: capacity,
+	}
+}
+
+//Add adds a value to the profile.
+func (c *columnProfile) Add(v interface{}) {
+	c.count++
+	if v == nil {
+		c.nulls++
+		return
+	}
+	s := fmt.Sprintf("%v", v)
+	if t, ok := toTime(v); ok {
+		s = t.Format(time.RFC3339Nano)
+	}
+	c.distinct.Add(s)
+	c.addTop(s)
+	c.types[inferType(v)] = true
+	if c.min == nil || compareValues(v, c.min) < 0 {
+		c.min = v
+	}
+	if c.max == nil || compareValues(v, c.max) > 0 {
+		c.max = v
+	}
+	if f, ok := toFloat(v); ok {
+		c.sum += f
+		c.numbers++
+	}
+	if str, ok := v.(string); ok {
+		n := int64(utf8.RuneCountInString(str))
+		if c.strings == 0 || n < c.minLength {
+			c.minLength = n
+		}
+		if n > c.maxLength {
+			c.maxLength = n
+		}
+		c.lengthSum += n
+		c.strings++
+	}
+}
+
+//addTop counts the value, replacing the least frequent value if there are too many.
+func (c *columnProfile) addTop(s string) {
+	if _, ok := c.top[s]; ok || len(c.top) < c.topCapacity {
+		c.top[s]++
+		return
+	}
+	var (
+		minKey   string
+		minCount int64 = math.MaxInt64
+	)
+	for k, n := range c.top {
+		if n < minCount {
+			minKey, minCount = k, n
+		}
+	}
+	delete(c.top, minKey)
+	c.top[s] = minCount + 1
+}
+
+//Type returns the inferred type of the column. Strings are inferred as the type that they
+//represent, if all of them represent the same type.
+func (c *columnProfile) Type() string {
+	switch {
+	case len(c.types) == 0:
+		return profileNull
+	case len(c.types) == 1:
+		for t := range c.types {
+			return t
+		}
+	case len(c.types) == 2 && c.types[profileInt] && c.types[profileFloat]:
+		return profileFloat
+	case c.types[profileVarchar]:
+		return profileVarchar
+	}
+	return profileMixed
+}
+
+//TopValues returns the most frequent values, formatted as "value (count)".
+func (c *columnProfile) TopValues(n int) string {
+	var keys []string
+	for k := range c.top {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if c.top[keys[i]] != c.top[keys[j]] {
+			return c.top[keys[i]] > c.top[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	var s []string
+	for _, k := range keys {
+		s = append(s, fmt.Sprintf("%s (%v)", k, c.top[k]))
+	}
+	return strings.Join(s, ", ")
+}
+
+//Row returns the statistics of the column, in the order of ProfileColumns.
+func (c *columnProfile) Row(topValues int) []interface{} {
+	var mean, minLength, meanLength, maxLength interface{}
+	if c.numbers > 0 {
+		mean = c.sum / float64(c.numbers)
+	}
+	if c.strings > 0 {
+		minLength = c.minLength
+		meanLength = float64(c.lengthSum) / float64(c.strings)
+		maxLength = c.maxLength
+	}
+	distinct := int64(math.Floor(c.distinct.Count() + 0.5))
+	if distinct > c.count-c.nulls {
+		distinct = c.count - c.nulls
+	}
+	return []interface{}{c.name, c.Type(), c.count, c.nulls, distinct, c.min, c.max, mean, minLength, meanLength, maxLength, c.TopValues(topValues)}
+}
+
+//inferType returns the type of the value, or the type that it represents if it is a string.
+func inferType(v interface{}) string {
+	switch vv := v.(type) {
+	case bool:
+		return profileBool
+	case float32, float64:
+		return profileFloat
+	case string:
+		s := strings.TrimSpace(vv)
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return profileInt
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return profileFloat
+		}
+		if _, err := strconv.ParseBool(s); err == nil {
+			return profileBool
+		}
+		if _, _, err := parseTime(s); err == nil {
+			return profileDatetime
+		}
+		return profileVarchar
+	}
+	if _, ok := toFloat(v); ok {
+		return profileInt
+	}
+	if _, ok := toTime(v); ok {
+		return profileDatetime
+	}
+	return profileVarchar
+}
+
+type profile struct {
+	name        string
+	topValues   int
+	passthrough bool
+	sourceSeq   []string
+}
+
+func (p *profile) SetName(name string) {
+	p.name = name
+}
+
+func (p *profile) Sequence(seq []string) {
+	p.sourceSeq = seq
+}
+
+func (p *profile) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  p.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(p.name))
+}
+
+func (p *profile) log(l engine.Logger, level engine.LogLevel, msg string, args ...interface{}) {
+	l.Chan() <- engine.Event{
+		Level:   level,
+		Source:  p.name,
+		Time:    time.Now(),
+		Message: fmt.Sprintf(msg, args...),
+	}
+}
+
+//  Open computes the statistics of each column. By default, it outputs one row per column once
+//  the source is exhausted. With PASSTHROUGH, it outputs its input rows unchanged and logs the
+//  statistics of each column as INFO events instead.
+func (p *profile) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		columns      []*columnProfile
+		firstMessage = true
+	)
+
+	if p.sourceSeq != nil {
+		seq := engine.NewSequencedStream(s, p.sourceSeq)
+		inChan = seq.Chan(p.name)
+	} else {
+		inChan = s.Chan(p.name)
+	}
+	outChan = dest.Chan(p.name)
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			for _, col := range s.Columns() {
+				columns = append(columns, newColumnProfile(col, p.topValues))
+			}
+			cols := ProfileColumns
+			if p.passthrough {
+				cols = s.Columns()
+			}
+			if err := dest.SetColumns(p.name, cols); err != nil {
+				p.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		if len(msg.Data) != len(columns) {
+			p.fatalerr(fmt.Errorf("expected %v columns but got %v", len(columns), len(msg.Data)), dest, l, st)
+			return
+		}
+		for i := range columns {
+			columns[i].Add(msg.Data[i])
+		}
+		if p.passthrough {
+			outChan <- engine.Message{
+				Source:      p.name,
+				Destination: engine.DestinationWildcard,
+				Data:        msg.Data,
+			}
+		}
+	}
+
+	for _, col := range columns {
+		row := col.Row(p.topValues)
+		if p.passthrough {
+			var stats []string
+			for i := 1; i < len(row); i++ {
+				stats = append(stats, fmt.Sprintf("%s=%v", ProfileColumns[i], row[i]))
+			}
+			p.log(l, engine.Info, "Profile of %s: %s", col.name, strings.Join(stats, ", "))
+			continue
+		}
+		outChan <- engine.Message{
+			Source:      p.name,
+			Destination: engine.DestinationWildcard,
+			Data:        row,
+		}
+	}
+	close(outChan)
+}
+
+func newProfile(pr *Profile) (*profile, error) {
+	p := profile{
+		topValues:   DefaultProfileTopValues,
+		passthrough: pr.Passthrough,
+	}
+	if pr.Top != nil {
+		n, err := strconv.Atoi(*pr.Top)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("expected a positive number of top values but got %s", *pr.Top)
+		}
+		p.topValues = n
+	}
+	return &p, nil
+}
+
+func NewProfile(aqlBody string) (*profile, error) {
+	p, err := participle.Build(&Profile{}, profileLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var pr Profile
+	err = p.ParseString(aqlBody, &pr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newProfile(&pr)
+}
+
+func profileInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewProfile(aqlBody)
+}
@@ -0,0 +1,45 @@
+package transforms
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	cols := []string{"Id", "Name", "Amount", "Flag"}
+	rows := [][]interface{}{
+		[]interface{}{1, "bob", "1.5", nil},
+		[]interface{}{2, "alice", "2", nil},
+		[]interface{}{3, "bob", "x", nil},
+		[]interface{}{4, nil, "3", nil},
+	}
+	Convey("Given a PROFILE transform", t, func() {
+		p, err := NewProfile(`PROFILE TOP 1`)
+		So(err, ShouldBeNil)
+		Convey("It should output the statistics of each column", func() {
+			outCols, res := runTransform(p, cols, rows)
+			So(outCols, ShouldResemble, ProfileColumns)
+			So(res, ShouldHaveLength, 4)
+			So(res[0], ShouldResemble, []interface{}{"Id", "int", int64(4), int64(0), int64(4), 1, 4, 2.5, nil, nil, nil, "1 (1)"})
+			So(res[1], ShouldResemble, []interface{}{"Name", "varchar", int64(4), int64(1), int64(2), "alice", "bob", nil, int64(3), 11.0 / 3, int64(5), "bob (2)"})
+			So(res[2][1], ShouldEqual, "varchar")
+			So(res[3][1], ShouldEqual, "null")
+			So(res[3][3], ShouldEqual, int64(4))
+		})
+		Convey("It should pass rows through with PASSTHROUGH", func() {
+			p.passthrough = true
+			outCols, res := runTransform(p, cols, rows)
+			So(outCols, ShouldResemble, cols)
+			So(res, ShouldResemble, rows)
+		})
+	})
+	Convey("Given string values", t, func() {
+		Convey("Their type should be inferred", func() {
+			So(inferType("12"), ShouldEqual, profileInt)
+			So(inferType("1.5"), ShouldEqual, profileFloat)
+			So(inferType("true"), ShouldEqual, profileBool)
+			So(inferType("2018-01-01T00:00:00Z"), ShouldEqual, profileDatetime)
+			So(inferType("hello"), ShouldEqual, profileVarchar)
+		})
+	})
+}
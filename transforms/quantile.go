@@ -63,12 +63,11 @@ func (q *quantile) Reduce(arg []interface{}) error {
 	return nil
 }
 
-func (q *quantile) Return() *float64 {
+func (q *quantile) Return() interface{} {
 	if !q.notNull || !q.notFirst {
 		return nil
 	}
-	f := q.td.Quantile(q.quantile)
-	return &f
+	return q.td.Quantile(q.quantile)
 }
 
 func (q *quantile) Copy() Reducer {
@@ -24,7 +24,7 @@ func TestQuantile(t *testing.T) {
 			}
 			f := agg.Return()
 			So(f, ShouldNotBeNil)
-			So(*f, ShouldEqual, 1)
+			So(f, ShouldEqual, 1)
 		})
 	})
 }
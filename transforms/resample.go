@@ -0,0 +1,366 @@
+package transforms
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/michaelbironneau/analyst/engine"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	resampleZOH    = "zoh("
+	resampleLinear = "linear("
+	resampleLast   = "last("
+
+	fillNone     = "NONE"
+	fillNull     = "NULL"
+	fillPrevious = "PREVIOUS"
+	fillLinear   = "LINEAR"
+)
+
+//Function comes before Keyword so that linear( is not lexed as the LINEAR keyword.
+var (
+	resampleLexer = lexer.Unquote(lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Function>[a-zA-Z0-9_]+\()`+
+		`|(?P<Keyword>(?i)(?:RESAMPLE|EVERY|FROM|TO|USING|AS|FILL|NONE|NULL|PREVIOUS|LINEAR|GROUP|BY)\b)`+
+		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
+		`|(?P<String>'[^']*'|"[^"]*")`+
+		`|(?P<Operators>[,()])`,
+	)), "Keyword"), "String")
+)
+
+type Resample struct {
+	Time    string   `"RESAMPLE" @Ident`
+	Every   string   `"EVERY" @String`
+	From    *string  `[ "FROM" @String`
+	To      *string  `  "TO" @String ]`
+	Method  string   `"USING" @Function`
+	Value   string   `@Ident ")"`
+	Alias   string   `[ "AS" @Ident ]`
+	Fill    string   `[ "FILL" ( @"NONE" | @"NULL" | @"PREVIOUS" | @"LINEAR" ) ]`
+	GroupBy []string `[ "GROUP" "BY" @Ident { "," @Ident } ]`
+}
+
+//resampleGroup holds the points of a group, along with the values of its GROUP BY columns.
+type resampleGroup struct {
+	key    []interface{}
+	series Timeseries
+}
+
+type resample struct {
+	name       string
+	timeColumn string
+	interval   time.Duration
+	start      *time.Time
+	finish     *time.Time
+	method     string
+	value      string
+	alias      string
+	fill       string
+	keyColumns []string
+	sourceSeq  []string
+}
+
+func (r *resample) SetName(name string) {
+	r.name = name
+}
+
+func (r *resample) Sequence(seq []string) {
+	r.sourceSeq = seq
+}
+
+func (r *resample) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  r.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(r.name))
+}
+
+//  Open buffers the points of each group, then outputs one row per time bucket per group
+//  once the source is exhausted. Groups are output in the order that they were first seen.
+func (r *resample) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		getKey       func([]interface{}) string
+		keyIndexes   []int
+		timeIx       int
+		valueIx      int
+		groups       = make(map[string]*resampleGroup)
+		order        []string
+		stringTimes  bool
+		firstMessage = true
+		err          error
+	)
+
+	if r.sourceSeq != nil {
+		seq := engine.NewSequencedStream(s, r.sourceSeq)
+		inChan = seq.Chan(r.name)
+	} else {
+		inChan = s.Chan(r.name)
+	}
+	outChan = dest.Chan(r.name)
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			cols := s.Columns()
+			var ok bool
+			if timeIx, ok = find(cols, r.timeColumn); !ok {
+				r.fatalerr(fmt.Errorf("could not find time column %s", r.timeColumn), dest, l, st)
+				return
+			}
+			if valueIx, ok = find(cols, r.value); !ok {
+				r.fatalerr(fmt.Errorf("could not find value column %s", r.value), dest, l, st)
+				return
+			}
+			getKey, err = groupBy(r.keyColumns, cols)
+			if err != nil {
+				r.fatalerr(err, dest, l, st)
+				return
+			}
+			for _, col := range r.keyColumns {
+				ix, _ := find(cols, col)
+				keyIndexes = append(keyIndexes, ix)
+			}
+			_, stringTimes = msg.Data[timeIx].(string)
+			if err := dest.SetColumns(r.name, r.columns()); err != nil {
+				r.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		if msg.Data[timeIx] == nil || msg.Data[valueIx] == nil {
+			continue
+		}
+		t, err := parseTimeValue(msg.Data[timeIx])
+		if err != nil {
+			r.fatalerr(err, dest, l, st)
+			return
+		}
+		v, ok := toFloat(msg.Data[valueIx])
+		if !ok {
+			r.fatalerr(fmt.Errorf("RESAMPLE expects numerical values but got %T %v", msg.Data[valueIx], msg.Data[valueIx]), dest, l, st)
+			return
+		}
+		key := getKey(msg.Data)
+		g, ok := groups[key]
+		if !ok {
+			g = &resampleGroup{}
+			for _, ix := range keyIndexes {
+				g.key = append(g.key, msg.Data[ix])
+			}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.series = append(g.series, TimeseriesItem{Time: t, Value: v})
+	}
+
+	for _, key := range order {
+		g := groups[key]
+		sort.Stable(g.series)
+		buckets, values := r.resample(g.series)
+		for i := range buckets {
+			if values[i] == nil && r.fill == fillNone {
+				continue
+			}
+			var row []interface{}
+			row = append(row, g.key...)
+			if stringTimes {
+				row = append(row, buckets[i].Format(time.RFC3339))
+			} else {
+				row = append(row, buckets[i])
+			}
+			if values[i] != nil {
+				row = append(row, *values[i])
+			} else {
+				row = append(row, nil)
+			}
+			outChan <- engine.Message{
+				Source:      r.name,
+				Destination: engine.DestinationWildcard,
+				Data:        row,
+			}
+		}
+	}
+
+	close(outChan)
+}
+
+//columns returns the output columns: the GROUP BY columns, the time column, and the value column.
+func (r *resample) columns() []string {
+	var ret []string
+	ret = append(ret, r.keyColumns...)
+	return append(ret, r.timeColumn, r.alias)
+}
+
+//resample returns the start time of each bucket for the series, along with its value after gap-filling.
+func (r *resample) resample(series Timeseries) ([]time.Time, []*float64) {
+	var (
+		buckets []time.Time
+		values  []*float64
+		start   time.Time
+		finish  time.Time
+	)
+	if r.start != nil {
+		start, finish = *r.start, *r.finish
+	} else {
+		//without FROM/TO, the buckets cover the series
+		start = series[0].Time.Truncate(r.interval)
+		finish = series[len(series)-1].Time.Add(time.Nanosecond)
+	}
+	for b := start; b.Before(finish); b = b.Add(r.interval) {
+		buckets = append(buckets, b)
+		values = append(values, r.bucketValue(series, b, b.Add(r.interval)))
+	}
+	switch r.fill {
+	case fillPrevious:
+		for i := 1; i < len(values); i++ {
+			if values[i] == nil {
+				values[i] = values[i-1]
+			}
+		}
+	case fillLinear:
+		fillLinearGaps(values)
+	}
+	return buckets, values
+}
+
+//bucketValue returns the value of the series for the bucket [start, end), or nil if there is none.
+func (r *resample) bucketValue(series Timeseries, start time.Time, end time.Time) *float64 {
+	//first point strictly after start, and first point at or after start
+	after := sort.Search(len(series), func(i int) bool { return series[i].Time.After(start) })
+	atOrAfter := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(start) })
+	switch r.method {
+	case resampleZOH:
+		if after == 0 {
+			return nil
+		}
+		//Mean expects the first point to be at or before start and the second, if any, to be after it
+		last := sort.Search(len(series), func(i int) bool { return series[i].Time.After(end) })
+		return series[after-1:last].Mean(start, end)
+	case resampleLinear:
+		if atOrAfter < len(series) && series[atOrAfter].Time.Equal(start) {
+			return &series[after-1].Value
+		}
+		if atOrAfter == 0 || atOrAfter == len(series) {
+			return nil
+		}
+		p, q := series[atOrAfter-1], series[atOrAfter]
+		f := p.Value + (q.Value-p.Value)*float64(start.Sub(p.Time))/float64(q.Time.Sub(p.Time))
+		return &f
+	case resampleLast:
+		ix := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(end) }) - 1
+		if ix < 0 || series[ix].Time.Before(start) {
+			return nil
+		}
+		return &series[ix].Value
+	}
+	panic(fmt.Sprintf("unknown resampling method %s", r.method)) //should be unreachable
+}
+
+//fillLinearGaps interpolates runs of nil values between two non-nil values. Leading and trailing
+//gaps are left as they are.
+func fillLinearGaps(values []*float64) {
+	prev := -1
+	for i := range values {
+		if values[i] == nil {
+			continue
+		}
+		if prev >= 0 && i-prev > 1 {
+			for j := prev + 1; j < i; j++ {
+				f := *values[prev] + (*values[i]-*values[prev])*float64(j-prev)/float64(i-prev)
+				values[j] = &f
+			}
+		}
+		prev = i
+	}
+}
+
+//parseTimeValue returns the time represented by v, which should either be a time or a string
+//in one of the formats accepted by parseTime.
+func parseTimeValue(v interface{}) (time.Time, error) {
+	if t, ok := toTime(v); ok {
+		return t, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("expected a time but got %T %v", v, v)
+	}
+	t, _, err := parseTime(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return *t, nil
+}
+
+func newResample(rs *Resample) (*resample, error) {
+	var r = resample{
+		timeColumn: rs.Time,
+		method:     strings.ToLower(rs.Method),
+		value:      rs.Value,
+		alias:      rs.Alias,
+		fill:       rs.Fill,
+		keyColumns: rs.GroupBy,
+	}
+	switch r.method {
+	case resampleZOH, resampleLinear, resampleLast:
+	default:
+		return nil, fmt.Errorf("unknown resampling method %s), expected ZOH, LINEAR or LAST", rs.Method)
+	}
+	if r.alias == "" {
+		r.alias = rs.Value
+	}
+	if r.fill == "" {
+		r.fill = fillNull
+	}
+	interval, err := time.ParseDuration(rs.Every)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RESAMPLE interval '%s': %v", rs.Every, err)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("RESAMPLE interval must be positive but got '%s'", rs.Every)
+	}
+	r.interval = interval
+	if rs.From != nil {
+		if r.start, _, err = parseTime(*rs.From); err != nil {
+			return nil, err
+		}
+		if r.finish, _, err = parseTime(*rs.To); err != nil {
+			return nil, err
+		}
+		if !r.finish.After(*r.start) {
+			return nil, fmt.Errorf("RESAMPLE end time %s should be after start time %s", *rs.To, *rs.From)
+		}
+	}
+	return &r, nil
+}
+
+func NewResample(aqlBody string) (*resample, error) {
+	p, err := participle.Build(&Resample{}, resampleLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var r Resample
+	err = p.ParseString(aqlBody, &r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newResample(&r)
+}
+
+func resampleInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewResample(aqlBody)
+}
@@ -0,0 +1,108 @@
+package transforms
+
+import (
+	"github.com/alecthomas/participle"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestResampleParsing(t *testing.T) {
+	parser, err := participle.Build(&Resample{}, resampleLexer)
+	if err != nil {
+		panic(err)
+	}
+	Convey("Given a valid resample", t, func() {
+		r := Resample{}
+		err = parser.ParseString(`RESAMPLE Time EVERY '15m' FROM '2018-01-01T00:00:00Z' TO '2018-01-01T01:00:00Z' USING linear(Value) AS V FILL LINEAR GROUP BY Meter`, &r)
+		So(err, ShouldBeNil)
+		So(r.Time, ShouldEqual, "Time")
+		So(r.Every, ShouldEqual, "15m")
+		So(*r.From, ShouldEqual, "2018-01-01T00:00:00Z")
+		So(*r.To, ShouldEqual, "2018-01-01T01:00:00Z")
+		So(r.Method, ShouldEqual, "linear(")
+		So(r.Value, ShouldEqual, "Value")
+		So(r.Alias, ShouldEqual, "V")
+		So(r.Fill, ShouldEqual, "LINEAR")
+		So(r.GroupBy, ShouldResemble, []string{"Meter"})
+	})
+	Convey("Given invalid resamples", t, func() {
+		_, err := NewResample(`RESAMPLE Time EVERY '15x' USING zoh(Value)`)
+		So(err, ShouldNotBeNil)
+		_, err = NewResample(`RESAMPLE Time EVERY '15m' USING avg(Value)`)
+		So(err, ShouldNotBeNil)
+		_, err = NewResample(`RESAMPLE Time EVERY '15m' FROM '2018-01-01T01:00:00Z' TO '2018-01-01T00:00:00Z' USING zoh(Value)`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestResample(t *testing.T) {
+	cols := []string{"Meter", "Time", "Value"}
+	rows := [][]interface{}{
+		[]interface{}{"a", "2018-01-01T00:40:00Z", 40.0},
+		[]interface{}{"a", "2018-01-01T00:00:00Z", 10.0},
+		[]interface{}{"b", "2018-01-01T00:05:00Z", 1},
+		[]interface{}{"a", "2018-01-01T00:10:00Z", 20.0},
+	}
+	body := `RESAMPLE Time EVERY '15m' FROM '2018-01-01T00:00:00Z' TO '2018-01-01T01:00:00Z' USING last(Value) `
+	Convey("Given a RESAMPLE transform without gap filling", t, func() {
+		r, err := NewResample(body + `FILL NONE GROUP BY Meter`)
+		So(err, ShouldBeNil)
+		outCols, res := runTransform(r, cols, rows)
+		So(outCols, ShouldResemble, []string{"Meter", "Time", "Value"})
+		So(res, ShouldResemble, [][]interface{}{
+			[]interface{}{"a", "2018-01-01T00:00:00Z", 20.0},
+			[]interface{}{"a", "2018-01-01T00:30:00Z", 40.0},
+			[]interface{}{"b", "2018-01-01T00:00:00Z", 1.0},
+		})
+	})
+	Convey("Given a RESAMPLE transform with NULL gap filling", t, func() {
+		r, err := NewResample(body + `GROUP BY Meter`)
+		So(err, ShouldBeNil)
+		_, res := runTransform(r, cols, rows)
+		So(res, ShouldHaveLength, 8)
+		So(res[1], ShouldResemble, []interface{}{"a", "2018-01-01T00:15:00Z", nil})
+	})
+	Convey("Given a RESAMPLE transform with PREVIOUS gap filling", t, func() {
+		r, err := NewResample(body + `FILL PREVIOUS GROUP BY Meter`)
+		So(err, ShouldBeNil)
+		_, res := runTransform(r, cols, rows)
+		So(res, ShouldHaveLength, 8)
+		So(res[1][2], ShouldEqual, 20.0)
+		So(res[3][2], ShouldEqual, 40.0)
+		So(res[7][2], ShouldEqual, 1.0)
+	})
+	Convey("Given a RESAMPLE transform with LINEAR gap filling", t, func() {
+		r, err := NewResample(body + `FILL LINEAR GROUP BY Meter`)
+		So(err, ShouldBeNil)
+		_, res := runTransform(r, cols, rows)
+		So(res, ShouldHaveLength, 8)
+		So(res[1][2], ShouldEqual, 30.0)
+		So(res[3][2], ShouldBeNil)
+	})
+	Convey("Given a ZOH RESAMPLE transform", t, func() {
+		r, err := NewResample(`RESAMPLE Time EVERY '15m' FROM '2018-01-01T00:00:00Z' TO '2018-01-01T01:00:00Z' USING zoh(Value) AS Mean`)
+		So(err, ShouldBeNil)
+		outCols, res := runTransform(r, cols, rows[:2])
+		So(outCols, ShouldResemble, []string{"Time", "Mean"})
+		So(res, ShouldHaveLength, 4)
+		So(res[0][1], ShouldEqual, 10.0)
+		So(res[1][1], ShouldEqual, 10.0)
+		So(res[2][1].(float64), ShouldAlmostEqual, 20.0)
+		So(res[3][1], ShouldEqual, 40.0)
+	})
+	Convey("Given a LINEAR RESAMPLE transform without a range", t, func() {
+		r, err := NewResample(`RESAMPLE Time EVERY '15m' USING linear(Value)`)
+		So(err, ShouldBeNil)
+		start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+		_, res := runTransform(r, []string{"Time", "Value"}, [][]interface{}{
+			[]interface{}{start.Add(10 * time.Minute), 20.0},
+			[]interface{}{start.Add(40 * time.Minute), 40.0},
+		})
+		So(res, ShouldHaveLength, 3)
+		So(res[0], ShouldResemble, []interface{}{start, nil})
+		So(res[1][0], ShouldResemble, start.Add(15*time.Minute))
+		So(res[1][1].(float64), ShouldAlmostEqual, 20+20*5/30.0)
+		So(res[2][1].(float64), ShouldAlmostEqual, 20+20*20/30.0)
+	})
+}
@@ -0,0 +1,357 @@
+package transforms
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/michaelbironneau/analyst/engine"
+	"io"
+	"sort"
+	"time"
+)
+
+//DefaultSortBufferRows is the number of rows that SORT will hold in memory before
+//spilling a sorted run to disk. It can be overridden with the SORT_BUFFER_ROWS option.
+const DefaultSortBufferRows = 100000
+
+var (
+	sortLexer = lexer.Unquote(lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Keyword>(?i)(?:SORT|TOP|BY|ASC|DESC)\b)`+
+		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
+		`|(?P<Number>[-+]?\d*\.?\d+([eE][-+]?\d+)?)`+
+		`|(?P<String>'[^']*'|"[^"]*")`+
+		`|(?P<Operators><>|!=|<=|>=|[-+*/%,.()=<>])`,
+	)), "Keyword"), "String")
+)
+
+type OrderTerm struct {
+	Column string `@Ident`
+	Asc    bool   `[ @"ASC"`
+	Desc   bool   `| @"DESC" ]`
+}
+
+type Sort struct {
+	Terms []OrderTerm `"SORT" "BY" @@ { "," @@ }`
+}
+
+//rowComparator returns a function that compares two rows according to the order terms,
+//given the actual columns of the rows.
+func rowComparator(terms []OrderTerm, actualColumns []string) (func(a, b []interface{}) int, error) {
+	var (
+		indexes []int
+		desc    []bool
+	)
+	for _, term := range terms {
+		ix, ok := find(actualColumns, term.Column)
+		if !ok {
+			return nil, fmt.Errorf("could not find column %s", term.Column)
+		}
+		indexes = append(indexes, ix)
+		desc = append(desc, term.Desc)
+	}
+	return func(a, b []interface{}) int {
+		for i, ix := range indexes {
+			c := compareValues(a[ix], b[ix])
+			if c == 0 {
+				continue
+			}
+			if desc[i] {
+				return -c
+			}
+			return c
+		}
+		return 0
+	}, nil
+}
+
+type sorter struct {
+	name       string
+	terms      []OrderTerm
+	sourceSeq  []string
+	BufferRows int `aql:"SORT_BUFFER_ROWS, optional"`
+}
+
+func (s *sorter) SetName(name string) {
+	s.name = name
+}
+
+func (s *sorter) Sequence(seq []string) {
+	s.sourceSeq = seq
+}
+
+func (s *sorter) fatalerr(err error, st engine.Stream, l engine.Logger, stop engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  s.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	stop.Stop()
+	close(st.Chan(s.name))
+}
+
+func (s *sorter) log(l engine.Logger, level engine.LogLevel, msg string, args ...interface{}) {
+	l.Chan() <- engine.Event{
+		Source:  s.name,
+		Level:   level,
+		Time:    time.Now(),
+		Message: fmt.Sprintf(msg, args...),
+	}
+}
+
+func (s *sorter) Open(src engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		sorted       *externalSort
+		firstMessage = true
+	)
+
+	if s.sourceSeq != nil {
+		seq := engine.NewSequencedStream(src, s.sourceSeq)
+		inChan = seq.Chan(s.name)
+	} else {
+		inChan = src.Chan(s.name)
+	}
+	outChan = dest.Chan(s.name)
+
+	defer func() {
+		if sorted != nil {
+			sorted.Close()
+		}
+	}()
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			cols := src.Columns()
+			if err := dest.SetColumns(s.name, cols); err != nil {
+				s.fatalerr(err, dest, l, st)
+				return
+			}
+			compare, err := rowComparator(s.terms, cols)
+			if err != nil {
+				s.fatalerr(err, dest, l, st)
+				return
+			}
+			sorted = newExternalSort(compare, s.BufferRows)
+		}
+		spilled, err := sorted.Add(msg.Data)
+		if err != nil {
+			s.fatalerr(err, dest, l, st)
+			return
+		}
+		if spilled {
+			s.log(l, engine.Trace, "Spilled sorted run of %v rows to disk", sorted.bufferRows)
+		}
+	}
+
+	if sorted != nil {
+		if sorted.Runs() > 0 {
+			s.log(l, engine.Info, "Merging %v sorted runs from disk with the remaining rows", sorted.Runs())
+		}
+		err := sorted.Sort(func(row []interface{}) {
+			outChan <- engine.Message{
+				Source:      s.name,
+				Destination: engine.DestinationWildcard,
+				Data:        row,
+			}
+		})
+		if err != nil {
+			s.fatalerr(err, dest, l, st)
+			return
+		}
+	}
+
+	close(outChan)
+}
+
+//externalSort accumulates rows in memory, spilling sorted runs to disk whenever the
+//buffer is full. The runs are merged back together when the rows are read out.
+type externalSort struct {
+	compare    func(a, b []interface{}) int
+	bufferRows int
+	buffer     [][]interface{}
+	runs       []*spillFile
+}
+
+//newExternalSort creates an external sort that holds up to bufferRows rows in memory,
+//or DefaultSortBufferRows if bufferRows is not positive.
+func newExternalSort(compare func(a, b []interface{}) int, bufferRows int) *externalSort {
+	if bufferRows <= 0 {
+		bufferRows = DefaultSortBufferRows
+	}
+	return &externalSort{compare: compare, bufferRows: bufferRows}
+}
+
+//Add adds a row. It returns true if the buffer was spilled to disk as a result.
+func (e *externalSort) Add(row []interface{}) (bool, error) {
+	e.buffer = append(e.buffer, row)
+	if len(e.buffer) < e.bufferRows {
+		return false, nil
+	}
+	run, err := spillRun(e.buffer, e.compare)
+	if err != nil {
+		return false, err
+	}
+	e.runs = append(e.runs, run)
+	e.buffer = nil
+	return true, nil
+}
+
+//Runs returns the number of sorted runs that have been spilled to disk.
+func (e *externalSort) Runs() int {
+	return len(e.runs)
+}
+
+//Sort calls emit for each row that has been added, in order.
+func (e *externalSort) Sort(emit func([]interface{})) error {
+	if len(e.runs) == 0 {
+		sortRows(e.buffer, e.compare)
+		for _, row := range e.buffer {
+			emit(row)
+		}
+		e.buffer = nil
+		return nil
+	}
+	if len(e.buffer) > 0 {
+		run, err := spillRun(e.buffer, e.compare)
+		if err != nil {
+			return err
+		}
+		e.runs = append(e.runs, run)
+		e.buffer = nil
+	}
+	return mergeRuns(e.runs, e.compare, emit)
+}
+
+//Close removes any spill files.
+func (e *externalSort) Close() {
+	for _, run := range e.runs {
+		run.Close()
+	}
+	e.runs = nil
+}
+
+//sortRows sorts the rows in-place. The sort is stable.
+func sortRows(rows [][]interface{}, compare func(a, b []interface{}) int) {
+	if compare == nil {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compare(rows[i], rows[j]) < 0
+	})
+}
+
+//spillRun sorts the rows and writes them to a new spill file, ready to be read back.
+func spillRun(rows [][]interface{}, compare func(a, b []interface{}) int) (*spillFile, error) {
+	sortRows(rows, compare)
+	run, err := newSpillFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if err := run.Write(row); err != nil {
+			run.Close()
+			return nil, err
+		}
+	}
+	if err := run.Rewind(); err != nil {
+		run.Close()
+		return nil, err
+	}
+	return run, nil
+}
+
+type mergeItem struct {
+	row []interface{}
+	run int
+}
+
+//mergeHeap is a min-heap of the current head of each sorted run. Ties are
+//broken by run index so that the merge is stable.
+type mergeHeap struct {
+	items   []mergeItem
+	compare func(a, b []interface{}) int
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	c := h.compare(h.items[i].row, h.items[j].row)
+	if c != 0 {
+		return c < 0
+	}
+	return h.items[i].run < h.items[j].run
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(mergeItem)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+//mergeRuns performs a k-way merge of the sorted runs, calling emit for each row in order.
+func mergeRuns(runs []*spillFile, compare func(a, b []interface{}) int, emit func([]interface{})) error {
+	h := &mergeHeap{compare: compare}
+	for i, run := range runs {
+		row, err := run.Read()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h.items = append(h.items, mergeItem{row, i})
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		item := heap.Pop(h).(mergeItem)
+		emit(item.row)
+		row, err := runs[item.run].Read()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		heap.Push(h, mergeItem{row, item.run})
+	}
+	return nil
+}
+
+func newSorter(s *Sort) (*sorter, error) {
+	if len(s.Terms) == 0 {
+		return nil, fmt.Errorf("SORT expects at least one column to sort by")
+	}
+	return &sorter{terms: s.Terms}, nil
+}
+
+func NewSort(aqlBody string) (*sorter, error) {
+	p, err := participle.Build(&Sort{}, sortLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var s Sort
+	err = p.ParseString(aqlBody, &s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newSorter(&s)
+}
+
+func sortInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewSort(aqlBody)
+}
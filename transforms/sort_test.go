@@ -0,0 +1,77 @@
+package transforms
+
+import (
+	"github.com/alecthomas/participle"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestSortParsing(t *testing.T) {
+	parser, err := participle.Build(&Sort{}, sortLexer)
+	if err != nil {
+		panic(err)
+	}
+	Convey("Given a valid sort", t, func() {
+		s1 := `SORT BY A DESC, b, c asc`
+		s := Sort{}
+		err = parser.ParseString(s1, &s)
+		So(err, ShouldBeNil)
+		So(s.Terms, ShouldHaveLength, 3)
+		So(s.Terms[0].Column, ShouldEqual, "A")
+		So(s.Terms[0].Desc, ShouldBeTrue)
+		So(s.Terms[1].Desc, ShouldBeFalse)
+		So(s.Terms[1].Asc, ShouldBeFalse)
+		So(s.Terms[2].Asc, ShouldBeTrue)
+	})
+}
+
+func TestSort(t *testing.T) {
+	cols := []string{"Name", "Value"}
+	rows := [][]interface{}{
+		[]interface{}{"a", 3},
+		[]interface{}{"b", 1.5},
+		[]interface{}{"c", nil},
+		[]interface{}{"d", 3},
+		[]interface{}{"e", 10},
+	}
+	expected := [][]interface{}{
+		[]interface{}{"e", 10},
+		[]interface{}{"a", 3},
+		[]interface{}{"d", 3},
+		[]interface{}{"b", 1.5},
+		[]interface{}{"c", nil},
+	}
+	Convey("Given a valid SORT transform", t, func() {
+		s, err := NewSort(`SORT BY Value DESC`)
+		So(err, ShouldBeNil)
+		Convey("It should sort rows in memory", func() {
+			_, res := runTransform(s, cols, rows)
+			So(res, ShouldResemble, expected)
+		})
+		Convey("It should sort rows that spill to disk", func() {
+			s.BufferRows = 2
+			_, res := runTransform(s, cols, rows)
+			So(res, ShouldResemble, expected)
+		})
+	})
+	Convey("Given a SORT transform referencing an unknown column", t, func() {
+		s, err := NewSort(`SORT BY Unknown`)
+		So(err, ShouldBeNil)
+		Convey("It should not output any rows", func() {
+			_, res := runTransform(s, cols, rows)
+			So(res, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestCompareValues(t *testing.T) {
+	Convey("Given values of different types", t, func() {
+		Convey("It should compare them consistently", func() {
+			So(compareValues(nil, 1), ShouldEqual, -1)
+			So(compareValues(1, 1.0), ShouldEqual, 0)
+			So(compareValues(int64(2), 1.5), ShouldEqual, 1)
+			So(compareValues("a", "b"), ShouldEqual, -1)
+			So(compareValues(false, true), ShouldEqual, -1)
+		})
+	})
+}
@@ -0,0 +1,103 @@
+package transforms
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+//spillFilePrefix is the prefix of the temporary files used by transforms that
+//spill rows to disk when they exceed their memory threshold.
+const spillFilePrefix = "analyst-spill-"
+
+func init() {
+	//rows are slices of interface, so the concrete types need to be known to gob
+	gob.Register(time.Time{})
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+//spillFile is a temporary file that holds rows that did not fit in memory. Rows are
+//written sequentially, after which the file can be rewound and read back in the same order.
+//Times are read back as time.Time even if they were written as *time.Time.
+type spillFile struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *gob.Encoder
+	dec *gob.Decoder
+}
+
+func newSpillFile() (*spillFile, error) {
+	f, err := ioutil.TempFile("", spillFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &spillFile{
+		f:   f,
+		w:   w,
+		enc: gob.NewEncoder(w),
+	}, nil
+}
+
+//Write appends a row to the file.
+func (s *spillFile) Write(row []interface{}) error {
+	return s.enc.Encode(normalizeRow(row))
+}
+
+//Rewind flushes any buffered rows and positions the file so that rows can be read
+//back from the start.
+func (s *spillFile) Rewind() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.dec = gob.NewDecoder(bufio.NewReader(s.f))
+	return nil
+}
+
+//Read returns the next row, or io.EOF once all rows have been read.
+func (s *spillFile) Read() ([]interface{}, error) {
+	var row []interface{}
+	if err := s.dec.Decode(&row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+//Close closes and removes the file.
+func (s *spillFile) Close() error {
+	err := s.f.Close()
+	if errR := os.Remove(s.f.Name()); err == nil {
+		err = errR
+	}
+	return err
+}
+
+//normalizeRow replaces values that gob cannot encode inside an interface by
+//equivalent ones that it can.
+func normalizeRow(row []interface{}) []interface{} {
+	var ret []interface{}
+	for i := range row {
+		if t, ok := row[i].(*time.Time); ok {
+			if ret == nil {
+				ret = make([]interface{}, len(row))
+				copy(ret, row)
+			}
+			if t == nil {
+				ret[i] = nil
+			} else {
+				ret[i] = *t
+			}
+		}
+	}
+	if ret == nil {
+		return row
+	}
+	return ret
+}
@@ -0,0 +1,50 @@
+package transforms
+
+import (
+	"fmt"
+	"strings"
+)
+
+//stringAgg concatenates the non-NULL values of its first argument, in the order that they
+//are received, separated by its second argument.
+type stringAgg struct {
+	values    []string
+	separator string
+	am        ArgumentMap
+}
+
+func (s *stringAgg) ParameterLen() int {
+	return 2
+}
+
+func (s *stringAgg) SetArgumentMap(am ArgumentMap) {
+	s.am = am
+}
+
+func (s *stringAgg) Reduce(arg []interface{}) error {
+	args := s.am(arg)
+	if len(args) != 2 {
+		return fmt.Errorf("STRING_AGG takes exactly 2 arguments but %v were provided", len(args))
+	}
+	sep, ok := args[1].(string)
+	if !ok {
+		return fmt.Errorf("STRING_AGG expects its second argument to be a string separator but %v was provided", args[1])
+	}
+	s.separator = sep
+	if args[0] == nil {
+		return nil
+	}
+	s.values = append(s.values, fmt.Sprintf("%v", args[0]))
+	return nil
+}
+
+func (s *stringAgg) Return() interface{} {
+	if s.values == nil {
+		return nil
+	}
+	return strings.Join(s.values, s.separator)
+}
+
+func (s *stringAgg) Copy() Reducer {
+	return &stringAgg{am: s.am}
+}
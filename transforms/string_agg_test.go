@@ -0,0 +1,25 @@
+package transforms
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestStringAgg(t *testing.T) {
+	Convey("Given a string_agg aggregate", t, func() {
+		agg := stringAgg{}
+		agg.SetArgumentMap(DefaultArgMap)
+		Convey("It should reduce to nil if there are no messages", func() {
+			So(agg.Return(), ShouldBeNil)
+		})
+		Convey("It should reduce multiple message correctly", func() {
+			for _, v := range []interface{}{"a", nil, 1, "b"} {
+				So(agg.Reduce([]interface{}{v, ", "}), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, "a, 1, b")
+		})
+		Convey("It should reject a non-string separator", func() {
+			So(agg.Reduce([]interface{}{"a", 1.0}), ShouldNotBeNil)
+		})
+	})
+}
@@ -41,11 +41,11 @@ func (s *sum) Reduce(arg []interface{}) error {
 	return nil
 }
 
-func (s *sum) Return() *float64 {
+func (s *sum) Return() interface{} {
 	if !s.notNull {
 		return nil
 	}
-	return &s.result
+	return s.result
 }
 
 func (s *sum) Copy() Reducer {
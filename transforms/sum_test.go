@@ -24,7 +24,7 @@ func TestSum(t *testing.T) {
 			}
 			f := agg.Return()
 			So(f, ShouldNotBeNil)
-			So(*f, ShouldEqual, 1.0)
+			So(f, ShouldEqual, 1.0)
 		})
 	})
 }
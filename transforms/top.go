@@ -0,0 +1,168 @@
+package transforms
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/michaelbironneau/analyst/engine"
+	"sort"
+	"time"
+)
+
+type Top struct {
+	N     int         `"TOP" @Number "BY"`
+	Terms []OrderTerm `@@ { "," @@ }`
+}
+
+type topItem struct {
+	row []interface{}
+	seq int
+}
+
+//topHeap is a bounded heap holding the best N rows seen so far. The root is the
+//worst of these, so that it can be evicted when a better row comes along. Ties
+//are broken by arrival order so that the output is stable.
+type topHeap struct {
+	items   []topItem
+	compare func(a, b []interface{}) int
+}
+
+func (h *topHeap) less(a, b topItem) bool {
+	c := h.compare(a.row, b.row)
+	if c != 0 {
+		return c < 0
+	}
+	return a.seq < b.seq
+}
+
+func (h *topHeap) Len() int { return len(h.items) }
+
+func (h *topHeap) Less(i, j int) bool { return h.less(h.items[j], h.items[i]) }
+
+func (h *topHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *topHeap) Push(x interface{}) { h.items = append(h.items, x.(topItem)) }
+
+func (h *topHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+type top struct {
+	name      string
+	n         int
+	terms     []OrderTerm
+	sourceSeq []string
+}
+
+func (t *top) SetName(name string) {
+	t.name = name
+}
+
+func (t *top) Sequence(seq []string) {
+	t.sourceSeq = seq
+}
+
+func (t *top) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  t.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(t.name))
+}
+
+func (t *top) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		h            = &topHeap{}
+		seq          int
+		firstMessage = true
+		err          error
+	)
+
+	if t.sourceSeq != nil {
+		sq := engine.NewSequencedStream(s, t.sourceSeq)
+		inChan = sq.Chan(t.name)
+	} else {
+		inChan = s.Chan(t.name)
+	}
+	outChan = dest.Chan(t.name)
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			cols := s.Columns()
+			if err := dest.SetColumns(t.name, cols); err != nil {
+				t.fatalerr(err, dest, l, st)
+				return
+			}
+			h.compare, err = rowComparator(t.terms, cols)
+			if err != nil {
+				t.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		item := topItem{msg.Data, seq}
+		seq++
+		if h.Len() < t.n {
+			heap.Push(h, item)
+			continue
+		}
+		if h.less(item, h.items[0]) {
+			h.items[0] = item
+			heap.Fix(h, 0)
+		}
+	}
+
+	sort.Slice(h.items, func(i, j int) bool {
+		return h.less(h.items[i], h.items[j])
+	})
+
+	for _, item := range h.items {
+		outChan <- engine.Message{
+			Source:      t.name,
+			Destination: engine.DestinationWildcard,
+			Data:        item.row,
+		}
+	}
+	close(outChan)
+}
+
+func newTop(t *Top) (*top, error) {
+	if t.N <= 0 {
+		return nil, fmt.Errorf("TOP expects a positive number of rows but got %v", t.N)
+	}
+	if len(t.Terms) == 0 {
+		return nil, fmt.Errorf("TOP expects at least one column to order by")
+	}
+	return &top{n: t.N, terms: t.Terms}, nil
+}
+
+func NewTop(aqlBody string) (*top, error) {
+	p, err := participle.Build(&Top{}, sortLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var t Top
+	err = p.ParseString(aqlBody, &t)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newTop(&t)
+}
+
+func topInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewTop(aqlBody)
+}
@@ -0,0 +1,64 @@
+package transforms
+
+import (
+	"github.com/alecthomas/participle"
+	"github.com/michaelbironneau/analyst/engine"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestTopParsing(t *testing.T) {
+	parser, err := participle.Build(&Top{}, sortLexer)
+	if err != nil {
+		panic(err)
+	}
+	Convey("Given a valid top", t, func() {
+		s1 := `TOP 3 BY A DESC, B`
+		tt := Top{}
+		err = parser.ParseString(s1, &tt)
+		So(err, ShouldBeNil)
+		So(tt.N, ShouldEqual, 3)
+		So(tt.Terms, ShouldHaveLength, 2)
+		So(tt.Terms[0].Desc, ShouldBeTrue)
+		So(tt.Terms[1].Column, ShouldEqual, "B")
+	})
+	Convey("Given a top with no rows", t, func() {
+		_, err := NewTop(`TOP 0 BY A`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestTop(t *testing.T) {
+	Convey("Given a valid TOP transform", t, func() {
+		tt, err := NewTop(`TOP 2 BY Value DESC`)
+		So(err, ShouldBeNil)
+		tt.SetName("Top")
+		Convey("It should output the top rows in order", func() {
+			in := engine.NewStream([]string{"Name", "Value"}, 100)
+			out := engine.NewStream(nil, 100)
+			l := engine.NewConsoleLogger(engine.Trace)
+			st := engine.NewStopper()
+			rows := [][]interface{}{
+				[]interface{}{"a", 3},
+				[]interface{}{"b", 1},
+				[]interface{}{"c", 7},
+				[]interface{}{"d", 3},
+				[]interface{}{"e", 2},
+			}
+			for i := range rows {
+				in.Chan("Top") <- engine.Message{Source: "Source", Destination: "Top", Data: rows[i]}
+			}
+			close(in.Chan("Top"))
+			tt.Open(in, out, l, st)
+			var res [][]interface{}
+			for msg := range out.Chan(engine.DestinationWildcard) {
+				res = append(res, msg.Data)
+			}
+			So(out.Columns(), ShouldResemble, []string{"Name", "Value"})
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{"c", 7},
+				[]interface{}{"a", 3},
+			})
+		})
+	})
+}
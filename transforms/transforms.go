@@ -12,10 +12,28 @@ var (
 	types map[string]initializer = map[string]initializer{
 		"aggregate": aggregateInitializer,
 		"lookup":    lookupInitializer,
-		"apply":   applyInitializer,
+		"apply":     applyInitializer,
+		"sort":      sortInitializer,
+		"top":       topInitializer,
+		"distinct":  distinctInitializer,
+		"union":     unionInitializer,
+		"pivot":     pivotInitializer,
+		"unpivot":   unpivotInitializer,
+		"window":    windowInitializer,
+		"resample":  resampleInitializer,
+		"explode":   explodeInitializer,
+		"flatten":   flattenInitializer,
+		"mask":      maskInitializer,
+		"profile":   profileInitializer,
 	}
 )
 
+//Configurables returns a zero value of each built-in transform that reads options from its "aql" struct tags,
+//such as SORT_BUFFER_ROWS.
+func Configurables() []interface{} {
+	return []interface{}{&apply{}, &distinct{}, &flatten{}, &lookup{}, &mask{}, &sorter{}, &window{}}
+}
+
 //Parse parses and initializes a transform given its body and input sequence.
 func Parse(s string) (engine.SequenceableTransform, error) {
 	words := strings.SplitN(strings.TrimSpace(s), " ", 2)
@@ -1,10 +1,42 @@
 package transforms
 
 import (
+	"fmt"
+	"github.com/michaelbironneau/analyst/engine"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 )
 
+//runTransform runs the transform on the rows of a single source, and returns its output columns and rows.
+func runTransform(tr engine.Transform, cols []string, rows [][]interface{}) ([]string, [][]interface{}) {
+	return runTransformSources(tr, [][]string{cols}, [][][]interface{}{rows})
+}
+
+//runTransformSources runs the transform on the rows of several sources, named SourceA, SourceB and so on, and
+//returns its output columns and rows. The transform is opened once for each source, as the coordinator does.
+func runTransformSources(tr engine.Transform, cols [][]string, rows [][][]interface{}) ([]string, [][]interface{}) {
+	out := engine.NewStream(nil, 100)
+	l := engine.NewConsoleLogger(engine.Trace)
+	st := engine.NewStopper()
+	tr.SetName("Transform")
+	if m, ok := tr.(engine.MultiSourceTransform); ok {
+		m.SetSourceCount(len(cols))
+	}
+	for i := range cols {
+		in := engine.NewStream(cols[i], 100)
+		for j := range rows[i] {
+			in.Chan("Transform") <- engine.Message{Source: fmt.Sprintf("Source%c", 'A'+i), Destination: "Transform", Data: rows[i][j]}
+		}
+		close(in.Chan("Transform"))
+		go tr.Open(in, out, l, st)
+	}
+	var ret [][]interface{}
+	for msg := range out.Chan(engine.DestinationWildcard) {
+		ret = append(ret, msg.Data)
+	}
+	return out.Columns(), ret
+}
+
 func TestParse(t *testing.T) {
 	Convey("Given a valid transform body", t, func() {
 		s := `AGGREGATE SUM(A) As Val`
@@ -0,0 +1,286 @@
+package transforms
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/michaelbironneau/analyst/engine"
+	"sync"
+	"time"
+)
+
+var (
+	unionLexer = lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Keyword>(?i)(?:UNION|ALL)\b)`+
+		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
+		`|(?P<Operators>[,()])`,
+	)), "Keyword")
+)
+
+type Union struct {
+	Union bool `@"UNION"`
+	All   bool `[ @"ALL" ]`
+}
+
+//unionInput is a source of the union, as registered when its first message arrives.
+type unionInput struct {
+	source string
+	cols   []string
+}
+
+type union struct {
+	sync.Mutex
+	name        string
+	all         bool
+	sourceCount int
+	sourceSeq   []string
+	sequencer   engine.Sequencer
+	inputs      []unionInput
+	ready       chan bool
+	outputCols  []string
+	getKey      func([]interface{}) string
+	seen        map[string]bool
+	finished    int
+	closeOnce   sync.Once
+}
+
+func (u *union) SetName(name string) {
+	u.name = name
+}
+
+//  Sequence makes the union output all rows of each source in turn, in the given order,
+//  rather than interleaving them.
+func (u *union) Sequence(seq []string) {
+	u.sourceSeq = seq
+	u.sequencer = engine.NewSequencer(seq)
+}
+
+func (u *union) SetSourceCount(n int) {
+	u.sourceCount = n
+}
+
+func (u *union) log(l engine.Logger, level engine.LogLevel, msg string, args ...interface{}) {
+	l.Chan() <- engine.Event{
+		Source:  u.name,
+		Level:   level,
+		Time:    time.Now(),
+		Message: fmt.Sprintf(msg, args...),
+	}
+}
+
+func (u *union) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  u.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	u.closeOnce.Do(func() { close(s.Chan(u.name)) })
+}
+
+//register records the columns of an input. Once all inputs are registered, the output
+//columns are computed and the inputs are released to start emitting rows.
+func (u *union) register(source string, cols []string, dest engine.Stream) error {
+	u.Lock()
+	defer u.Unlock()
+	u.inputs = append(u.inputs, unionInput{source, cols})
+	if len(u.inputs) < u.expectedInputs() {
+		return nil
+	}
+	defer close(u.ready)
+	u.outputCols = u.alignColumns()
+	u.getKey, _ = groupBy(u.outputCols, u.outputCols)
+	if u.sequencer != nil {
+		u.releaseEmptySources()
+	}
+	return dest.SetColumns(u.name, u.outputCols)
+}
+
+func (u *union) expectedInputs() int {
+	if u.sourceCount > 0 {
+		return u.sourceCount
+	}
+	if len(u.sourceSeq) > 0 {
+		return len(u.sourceSeq)
+	}
+	return 1
+}
+
+//alignColumns returns the union of the input columns, matched by name (case-insensitive).
+//Columns are ordered by source, following the source sequence if there is one.
+func (u *union) alignColumns() []string {
+	var (
+		ret    []string
+		inputs = u.inputs
+	)
+	if u.sourceSeq != nil {
+		inputs = nil
+		for _, source := range u.sourceSeq {
+			for _, input := range u.inputs {
+				if _, ok := find([]string{source}, input.source); ok {
+					inputs = append(inputs, input)
+				}
+			}
+		}
+	}
+	for _, input := range inputs {
+		for _, col := range input.cols {
+			if _, ok := find(ret, col); !ok {
+				ret = append(ret, col)
+			}
+		}
+	}
+	return ret
+}
+
+//releaseEmptySources marks sources that did not send any rows as done, in turn,
+//so that they don't block the sources that are sequenced after them.
+func (u *union) releaseEmptySources() {
+	for _, source := range u.sourceSeq {
+		var found bool
+		for _, input := range u.inputs {
+			if _, ok := find([]string{source}, input.source); ok {
+				found = true
+			}
+		}
+		if !found {
+			go func(task string) {
+				u.sequencer.Wait(task)
+				u.sequencer.Done(task)
+			}(source)
+		}
+	}
+}
+
+//alignment returns, for each output column, the index of the column in the input (or -1 if
+//the input does not have the column).
+func alignment(outputCols []string, inputCols []string) []int {
+	ret := make([]int, len(outputCols))
+	for i, col := range outputCols {
+		ix, _ := find(inputCols, col)
+		ret[i] = ix
+	}
+	return ret
+}
+
+//isDuplicate returns whether the row has already been output. It is only used by UNION without ALL.
+func (u *union) isDuplicate(row []interface{}) bool {
+	key := u.getKey(row)
+	u.Lock()
+	defer u.Unlock()
+	if u.seen[key] {
+		return true
+	}
+	u.seen[key] = true
+	return false
+}
+
+//Open is called once for each input. The output columns depend on the columns of all of the inputs, which are
+//only known once each input has sent its first row or ended, so rows are output once all of the inputs have.
+//The inputs share the sequencer, rather than each wrapping its stream with engine.NewSequencedStream, as
+//sequenced streams have a sequencer each. Inputs that end without any rows have no source name, so the
+//sources that no input has named are released by register instead.
+func (u *union) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan  = s.Chan(u.name)
+		outChan = dest.Chan(u.name)
+		source  string
+		task    string
+	)
+
+	u.Lock()
+	if u.ready == nil {
+		u.ready = make(chan bool)
+		u.seen = make(map[string]bool)
+	}
+	u.Unlock()
+
+	msg, ok := <-inChan
+	if ok {
+		source = msg.Source
+	}
+
+	if err := u.register(source, s.Columns(), dest); err != nil {
+		u.fatalerr(err, dest, l, st)
+		return
+	}
+
+	<-u.ready
+
+	if ok {
+		mapping := alignment(u.outputCols, s.Columns())
+
+		if u.sequencer != nil {
+			ix, found := find(u.sourceSeq, source)
+			if !found {
+				u.fatalerr(fmt.Errorf("source %s not found in source sequence %v", source, u.sourceSeq), dest, l, st)
+				return
+			}
+			task = u.sourceSeq[ix]
+			u.sequencer.Wait(task)
+		}
+
+		u.log(l, engine.Info, "Started processing messages for source %s", source)
+
+		for {
+			if st.Stopped() {
+				return
+			}
+			row := make([]interface{}, len(mapping))
+			for i, ix := range mapping {
+				if ix >= 0 {
+					row[i] = msg.Data[ix]
+				}
+			}
+			if u.all || !u.isDuplicate(row) {
+				outChan <- engine.Message{
+					Source:      u.name,
+					Destination: engine.DestinationWildcard,
+					Data:        row,
+				}
+			}
+			if msg, ok = <-inChan; !ok {
+				break
+			}
+		}
+
+		if u.sequencer != nil {
+			u.sequencer.Done(task)
+		}
+		u.log(l, engine.Info, "Finished processing messages for source %s", source)
+	}
+
+	u.Lock()
+	u.finished++
+	done := u.finished == u.expectedInputs()
+	u.Unlock()
+
+	if done {
+		u.closeOnce.Do(func() { close(outChan) })
+	}
+}
+
+func newUnion(u *Union) (*union, error) {
+	return &union{all: u.All}, nil
+}
+
+func NewUnion(aqlBody string) (*union, error) {
+	p, err := participle.Build(&Union{}, unionLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var u Union
+	err = p.ParseString(aqlBody, &u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newUnion(&u)
+}
+
+func unionInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewUnion(aqlBody)
+}
@@ -0,0 +1,77 @@
+package transforms
+
+import (
+	"github.com/alecthomas/participle"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestUnionParsing(t *testing.T) {
+	parser, err := participle.Build(&Union{}, unionLexer)
+	if err != nil {
+		panic(err)
+	}
+	Convey("Given a valid union", t, func() {
+		u := Union{}
+		err = parser.ParseString(`UNION ALL`, &u)
+		So(err, ShouldBeNil)
+		So(u.All, ShouldBeTrue)
+		err = parser.ParseString(`union`, &u)
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestUnion(t *testing.T) {
+	cols := [][]string{
+		[]string{"Id", "Value"},
+		[]string{"value", "Extra"},
+	}
+	rows := [][][]interface{}{
+		[][]interface{}{
+			[]interface{}{1, "a"},
+			[]interface{}{2, "b"},
+		},
+		[][]interface{}{
+			[]interface{}{"a", true},
+			[]interface{}{"a", true},
+		},
+	}
+	Convey("Given a UNION ALL transform", t, func() {
+		u, err := NewUnion(`UNION ALL`)
+		So(err, ShouldBeNil)
+		Convey("It should align columns by name and fill missing ones with NULL", func() {
+			u.Sequence([]string{"SourceA", "SourceB"})
+			_, res := runTransformSources(u, cols, rows)
+			So(u.outputCols, ShouldResemble, []string{"Id", "Value", "Extra"})
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, "a", nil},
+				[]interface{}{2, "b", nil},
+				[]interface{}{nil, "a", true},
+				[]interface{}{nil, "a", true},
+			})
+		})
+	})
+	Convey("Given a sequenced UNION ALL transform with an empty source", t, func() {
+		u, err := NewUnion(`UNION ALL`)
+		So(err, ShouldBeNil)
+		u.Sequence([]string{"SourceA", "SourceB", "SourceC"})
+		Convey("It should output the other sources in turn", func() {
+			_, res := runTransformSources(u, [][]string{cols[0], []string{"Id"}, cols[1]}, [][][]interface{}{rows[0], nil, rows[1]})
+			So(res, ShouldResemble, [][]interface{}{
+				[]interface{}{1, "a", nil},
+				[]interface{}{2, "b", nil},
+				[]interface{}{nil, "a", true},
+				[]interface{}{nil, "a", true},
+			})
+		})
+	})
+	Convey("Given a UNION transform", t, func() {
+		u, err := NewUnion(`UNION`)
+		So(err, ShouldBeNil)
+		Convey("It should remove duplicate rows", func() {
+			_, res := runTransformSources(u, cols, rows)
+			So(res, ShouldHaveLength, 3)
+			So(res, ShouldContain, []interface{}{nil, "a", true})
+		})
+	})
+}
@@ -0,0 +1,144 @@
+package transforms
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/michaelbironneau/analyst/engine"
+	"time"
+)
+
+type Unpivot struct {
+	Columns []string `"UNPIVOT" "(" @Ident { "," @Ident } ")"`
+	Name    string   `"INTO" @Ident`
+	Value   string   `"," @Ident`
+}
+
+type unpivot struct {
+	name      string
+	columns   []string
+	nameCol   string
+	valueCol  string
+	sourceSeq []string
+}
+
+func (u *unpivot) SetName(name string) {
+	u.name = name
+}
+
+func (u *unpivot) Sequence(seq []string) {
+	u.sourceSeq = seq
+}
+
+func (u *unpivot) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  u.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(u.name))
+}
+
+//  Open turns columns into rows. Each input row results in one output row per unpivoted
+//  column, made up of the remaining columns, the column name and its value. As in SQL,
+//  NULL values do not result in an output row.
+func (u *unpivot) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		keepIxs      []int
+		unpivotIxs   []int
+		firstMessage = true
+	)
+
+	if u.sourceSeq != nil {
+		seq := engine.NewSequencedStream(s, u.sourceSeq)
+		inChan = seq.Chan(u.name)
+	} else {
+		inChan = s.Chan(u.name)
+	}
+	outChan = dest.Chan(u.name)
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			cols := s.Columns()
+			var outCols []string
+			for _, col := range u.columns {
+				ix, ok := find(cols, col)
+				if !ok {
+					u.fatalerr(fmt.Errorf("could not find column %s", col), dest, l, st)
+					return
+				}
+				unpivotIxs = append(unpivotIxs, ix)
+			}
+			for i, col := range cols {
+				if _, ok := find(u.columns, col); !ok {
+					keepIxs = append(keepIxs, i)
+					outCols = append(outCols, col)
+				}
+			}
+			outCols = append(outCols, u.nameCol, u.valueCol)
+			if _, ok := find(outCols[:len(outCols)-2], u.nameCol); ok {
+				u.fatalerr(fmt.Errorf("column %s already exists", u.nameCol), dest, l, st)
+				return
+			}
+			if _, ok := find(outCols[:len(outCols)-1], u.valueCol); ok {
+				u.fatalerr(fmt.Errorf("column %s already exists", u.valueCol), dest, l, st)
+				return
+			}
+			if err := dest.SetColumns(u.name, outCols); err != nil {
+				u.fatalerr(err, dest, l, st)
+				return
+			}
+		}
+		for i, ix := range unpivotIxs {
+			if msg.Data[ix] == nil {
+				continue
+			}
+			row := make([]interface{}, 0, len(keepIxs)+2)
+			for _, kix := range keepIxs {
+				row = append(row, msg.Data[kix])
+			}
+			row = append(row, u.columns[i], msg.Data[ix])
+			outChan <- engine.Message{
+				Source:      u.name,
+				Destination: engine.DestinationWildcard,
+				Data:        row,
+			}
+		}
+	}
+	close(outChan)
+}
+
+func newUnpivot(up *Unpivot) (*unpivot, error) {
+	return &unpivot{
+		columns:  up.Columns,
+		nameCol:  up.Name,
+		valueCol: up.Value,
+	}, nil
+}
+
+func NewUnpivot(aqlBody string) (*unpivot, error) {
+	p, err := participle.Build(&Unpivot{}, pivotLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var up Unpivot
+	err = p.ParseString(aqlBody, &up)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newUnpivot(&up)
+}
+
+func unpivotInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewUnpivot(aqlBody)
+}
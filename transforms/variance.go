@@ -0,0 +1,65 @@
+package transforms
+
+import (
+	"fmt"
+	"math"
+)
+
+//variance computes the sample variance, or the sample standard deviation, using Welford's algorithm.
+type variance struct {
+	stddev bool
+	count  float64
+	mean   float64
+	m2     float64
+	am     ArgumentMap
+}
+
+func (s *variance) ParameterLen() int {
+	return 1
+}
+
+func (s *variance) SetArgumentMap(am ArgumentMap) {
+	s.am = am
+}
+
+func (s *variance) functionName() string {
+	if s.stddev {
+		return "STDDEV"
+	}
+	return "VARIANCE"
+}
+
+func (s *variance) Reduce(arg []interface{}) error {
+	args := s.am(arg)
+	if len(args) != 1 {
+		return fmt.Errorf("%s takes exactly 1 argument but %v were provided", s.functionName(), len(args))
+	}
+	if args[0] == nil {
+		return nil
+	}
+	v, ok := toFloat(args[0])
+	if !ok {
+		return fmt.Errorf("%s takes a single numerical argument, but %v was provided", s.functionName(), args[0])
+	}
+	s.count += 1
+	delta := v - s.mean
+	s.mean += delta / s.count
+	s.m2 += delta * (v - s.mean)
+	return nil
+}
+
+//Return returns nil unless there are at least two values, as the sample variance is undefined otherwise.
+func (s *variance) Return() interface{} {
+	if s.count < 2 {
+		return nil
+	}
+	v := s.m2 / (s.count - 1)
+	if s.stddev {
+		return math.Sqrt(v)
+	}
+	return v
+}
+
+func (s *variance) Copy() Reducer {
+	return &variance{stddev: s.stddev, am: s.am}
+}
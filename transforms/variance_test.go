@@ -0,0 +1,42 @@
+package transforms
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestVariance(t *testing.T) {
+	Convey("Given a variance aggregate", t, func() {
+		agg := variance{}
+		agg.SetArgumentMap(DefaultArgMap)
+		Convey("It should reduce to nil if there are fewer than two messages", func() {
+			So(agg.Return(), ShouldBeNil)
+			So(agg.Reduce([]interface{}{1.0}), ShouldBeNil)
+			So(agg.Return(), ShouldBeNil)
+		})
+		Convey("It should reduce multiple message correctly", func() {
+			msgs := [][]interface{}{
+				[]interface{}{2.0},
+				[]interface{}{4},
+				[]interface{}{nil},
+				[]interface{}{6.0},
+			}
+			for _, msg := range msgs {
+				err := agg.Reduce(msg)
+				So(err, ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, 4.0)
+		})
+		Convey("It should compute the standard deviation", func() {
+			agg := variance{stddev: true}
+			agg.SetArgumentMap(DefaultArgMap)
+			for _, v := range []float64{2, 4, 6} {
+				So(agg.Reduce([]interface{}{v}), ShouldBeNil)
+			}
+			So(agg.Return(), ShouldEqual, 2.0)
+		})
+		Convey("It should reject non-numerical values", func() {
+			So(agg.Reduce([]interface{}{"a"}), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,474 @@
+package transforms
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/michaelbironneau/analyst/engine"
+	"strings"
+	"time"
+)
+
+const (
+	windowRowNumber = "row_number("
+	windowRank      = "rank("
+	windowDenseRank = "dense_rank("
+	windowLag       = "lag("
+	windowLead      = "lead("
+)
+
+//As for AGGREGATE, functions include the opening ( so that they are not parsed as Ident.
+var (
+	windowLexer = lexer.Unquote(lexer.Upper(lexer.Must(lexer.Regexp(`(\s+)`+
+		`|(?P<Keyword>(?i)(?:WINDOW|AS|PARTITION|ORDER|BY|ASC|DESC)\b)`+
+		`|(?P<Function>[a-zA-Z0-9_]+\()`+
+		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_]*)`+
+		`|(?P<Number>[-+]?\d*\.?\d+([eE][-+]?\d+)?)`+
+		`|(?P<String>'[^']*'|"[^"]*")`+
+		`|(?P<Operators><>|!=|<=|>=|[-+*/%,.()=<>])`,
+	)), "Keyword"), "String")
+)
+
+type WindowTerm struct {
+	Function string             `@Function`
+	Args     []FunctionArgument `[ @@ { "," @@ } ] ")"`
+	Alias    string             `"AS" @Ident`
+}
+
+type Window struct {
+	Terms       []WindowTerm `"WINDOW" @@ { "," @@ }`
+	PartitionBy []string     `[ "PARTITION" "BY" @Ident { "," @Ident } ]`
+	OrderBy     []OrderTerm  `[ "ORDER" "BY" @@ { "," @@ } ]`
+}
+
+//windowFunction is a compiled window term. Exactly one of the ranking functions,
+//lag/lead or a cumulative reducer applies.
+type windowFunction struct {
+	function string
+	alias    string
+	args     []FunctionArgument
+	column   string
+	offset   int
+	def      interface{}
+	reducer  Reducer
+}
+
+type window struct {
+	name        string
+	functions   []windowFunction
+	partitionBy []string
+	orderBy     []OrderTerm
+	sourceSeq   []string
+	maxLag      int
+	maxLead     int
+	BufferRows  int `aql:"WINDOW_BUFFER_ROWS, optional"`
+}
+
+func (w *window) SetName(name string) {
+	w.name = name
+}
+
+func (w *window) Sequence(seq []string) {
+	w.sourceSeq = seq
+}
+
+func (w *window) fatalerr(err error, s engine.Stream, l engine.Logger, st engine.Stopper) {
+	l.Chan() <- engine.Event{
+		Level:   engine.Error,
+		Source:  w.name,
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+	st.Stop()
+	close(s.Chan(w.name))
+}
+
+func (w *window) log(l engine.Logger, level engine.LogLevel, msg string, args ...interface{}) {
+	l.Chan() <- engine.Event{
+		Source:  w.name,
+		Level:   level,
+		Time:    time.Now(),
+		Message: fmt.Sprintf(msg, args...),
+	}
+}
+
+//  Open adds the window function columns to each row. If there is a PARTITION BY or ORDER BY
+//  clause then the rows are first sorted by partition and order, spilling to disk if there
+//  are more than WINDOW_BUFFER_ROWS of them. Once sorted, rows are streamed through and
+//  only as many as are needed for LAG and LEAD are held in memory.
+func (w *window) Open(s engine.Stream, dest engine.Stream, l engine.Logger, st engine.Stopper) {
+	var (
+		inChan       chan engine.Message
+		outChan      chan engine.Message
+		sorted       *externalSort
+		state        *windowState
+		firstMessage = true
+	)
+
+	if w.sourceSeq != nil {
+		seq := engine.NewSequencedStream(s, w.sourceSeq)
+		inChan = seq.Chan(w.name)
+	} else {
+		inChan = s.Chan(w.name)
+	}
+	outChan = dest.Chan(w.name)
+
+	defer func() {
+		if sorted != nil {
+			sorted.Close()
+		}
+	}()
+
+	emit := func(row []interface{}) {
+		outChan <- engine.Message{
+			Source:      w.name,
+			Destination: engine.DestinationWildcard,
+			Data:        row,
+		}
+	}
+
+	for msg := range inChan {
+		if st.Stopped() {
+			return
+		}
+		if firstMessage {
+			firstMessage = false
+			cols := s.Columns()
+			var err error
+			state, err = w.newState(cols, emit)
+			if err != nil {
+				w.fatalerr(err, dest, l, st)
+				return
+			}
+			if err := dest.SetColumns(w.name, w.columns(cols)); err != nil {
+				w.fatalerr(err, dest, l, st)
+				return
+			}
+			if len(w.partitionBy)+len(w.orderBy) > 0 {
+				compare, err := rowComparator(append(partitionTerms(w.partitionBy), w.orderBy...), cols)
+				if err != nil {
+					w.fatalerr(err, dest, l, st)
+					return
+				}
+				sorted = newExternalSort(compare, w.BufferRows)
+			}
+		}
+		if sorted == nil {
+			if err := state.Push(msg.Data); err != nil {
+				w.fatalerr(err, dest, l, st)
+				return
+			}
+			continue
+		}
+		spilled, err := sorted.Add(msg.Data)
+		if err != nil {
+			w.fatalerr(err, dest, l, st)
+			return
+		}
+		if spilled {
+			w.log(l, engine.Trace, "Spilled sorted run of %v rows to disk", sorted.bufferRows)
+		}
+	}
+
+	if state == nil {
+		close(outChan)
+		return
+	}
+
+	if sorted != nil {
+		var pushErr error
+		err := sorted.Sort(func(row []interface{}) {
+			if pushErr == nil {
+				pushErr = state.Push(row)
+			}
+		})
+		if err == nil {
+			err = pushErr
+		}
+		if err != nil {
+			w.fatalerr(err, dest, l, st)
+			return
+		}
+	}
+
+	if err := state.Flush(); err != nil {
+		w.fatalerr(err, dest, l, st)
+		return
+	}
+
+	close(outChan)
+}
+
+//columns returns the output columns: the input columns followed by the window function aliases.
+func (w *window) columns(cols []string) []string {
+	var ret []string
+	ret = append(ret, cols...)
+	for _, f := range w.functions {
+		ret = append(ret, f.alias)
+	}
+	return ret
+}
+
+//partitionTerms returns order terms that sort rows by partition.
+func partitionTerms(cols []string) []OrderTerm {
+	var ret []OrderTerm
+	for _, col := range cols {
+		ret = append(ret, OrderTerm{Column: col})
+	}
+	return ret
+}
+
+func (w *window) newState(cols []string, emit func([]interface{})) (*windowState, error) {
+	var (
+		ws  = windowState{w: w, emit: emit}
+		err error
+	)
+	for _, f := range w.functions {
+		if _, ok := find(cols, f.alias); ok {
+			return nil, fmt.Errorf("column %s already exists", f.alias)
+		}
+		switch f.function {
+		case windowLag, windowLead:
+			ix, ok := find(cols, f.column)
+			if !ok {
+				return nil, fmt.Errorf("could not find column %s", f.column)
+			}
+			ws.columnIxs = append(ws.columnIxs, ix)
+			ws.argMaps = append(ws.argMaps, nil)
+		case windowRowNumber, windowRank, windowDenseRank:
+			ws.columnIxs = append(ws.columnIxs, -1)
+			ws.argMaps = append(ws.argMaps, nil)
+		default:
+			am, err := bindFunctionArgs(f.args, cols)
+			if err != nil {
+				return nil, err
+			}
+			ws.columnIxs = append(ws.columnIxs, -1)
+			ws.argMaps = append(ws.argMaps, am)
+		}
+	}
+	if w.partitionBy != nil {
+		ws.partitionCompare, err = rowComparator(partitionTerms(w.partitionBy), cols)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if w.orderBy != nil {
+		ws.orderCompare, err = rowComparator(w.orderBy, cols)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ws.reset()
+	return &ws, nil
+}
+
+//windowState evaluates the window functions over rows that arrive in partition order.
+//It holds the rows of the current partition from maxLag rows before the next row to
+//be output, up to the most recent row, which is at most maxLead rows after it.
+type windowState struct {
+	w                *window
+	emit             func([]interface{})
+	partitionCompare func(a, b []interface{}) int
+	orderCompare     func(a, b []interface{}) int
+	columnIxs        []int
+	argMaps          []ArgumentMap
+	rows             [][]interface{}
+	last             []interface{}
+	next             int
+	previous         []interface{}
+	rowNumber        int64
+	rank             int64
+	denseRank        int64
+	reducers         []Reducer
+}
+
+//reset clears the state at the start of a new partition.
+func (ws *windowState) reset() {
+	ws.rows = nil
+	ws.next = 0
+	ws.previous = nil
+	ws.rowNumber = 0
+	ws.rank = 0
+	ws.denseRank = 0
+	ws.reducers = make([]Reducer, len(ws.w.functions))
+	for i, f := range ws.w.functions {
+		if f.reducer != nil {
+			ws.reducers[i] = f.reducer.Copy()
+			ws.reducers[i].SetArgumentMap(ws.argMaps[i])
+		}
+	}
+}
+
+//Push adds a row, outputting any rows that no longer need to wait for LEAD values.
+func (ws *windowState) Push(row []interface{}) error {
+	if ws.last != nil && ws.partitionCompare != nil && ws.partitionCompare(ws.last, row) != 0 {
+		if err := ws.Flush(); err != nil {
+			return err
+		}
+		ws.reset()
+	}
+	ws.last = row
+	ws.rows = append(ws.rows, row)
+	for ws.next+ws.w.maxLead < len(ws.rows) {
+		if err := ws.output(ws.next); err != nil {
+			return err
+		}
+		ws.next++
+	}
+	if ws.next > ws.w.maxLag {
+		drop := ws.next - ws.w.maxLag
+		ws.rows = ws.rows[drop:]
+		ws.next -= drop
+	}
+	return nil
+}
+
+//Flush outputs the remaining rows of the current partition.
+func (ws *windowState) Flush() error {
+	for ; ws.next < len(ws.rows); ws.next++ {
+		if err := ws.output(ws.next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//output computes the window function values for the i-th row and emits it.
+func (ws *windowState) output(i int) error {
+	row := ws.rows[i]
+	ws.rowNumber++
+	//without ORDER BY, all the rows of a partition are peers
+	peer := ws.previous != nil && (ws.orderCompare == nil || ws.orderCompare(ws.previous, row) == 0)
+	if !peer {
+		ws.rank = ws.rowNumber
+		ws.denseRank++
+	}
+	ws.previous = row
+
+	out := make([]interface{}, 0, len(row)+len(ws.w.functions))
+	out = append(out, row...)
+	for j, f := range ws.w.functions {
+		switch f.function {
+		case windowRowNumber:
+			out = append(out, ws.rowNumber)
+		case windowRank:
+			out = append(out, ws.rank)
+		case windowDenseRank:
+			out = append(out, ws.denseRank)
+		case windowLag:
+			if k := i - f.offset; k >= 0 {
+				out = append(out, ws.rows[k][ws.columnIxs[j]])
+			} else {
+				out = append(out, f.def)
+			}
+		case windowLead:
+			if k := i + f.offset; k < len(ws.rows) {
+				out = append(out, ws.rows[k][ws.columnIxs[j]])
+			} else {
+				out = append(out, f.def)
+			}
+		default:
+			if err := ws.reducers[j].Reduce(row); err != nil {
+				return err
+			}
+			out = append(out, ws.reducers[j].Return())
+		}
+	}
+	ws.emit(out)
+	return nil
+}
+
+//newOffsetFunction compiles LAG(column [, offset [, default]]) or LEAD(column [, offset [, default]]).
+func newOffsetFunction(t *WindowTerm) (windowFunction, error) {
+	f := windowFunction{function: strings.ToLower(t.Function), alias: t.Alias, offset: 1}
+	if len(t.Args) == 0 || len(t.Args) > 3 {
+		return f, fmt.Errorf("%s) expects between 1 and 3 arguments but %v were provided", t.Function, len(t.Args))
+	}
+	if t.Args[0].Column == "" {
+		return f, fmt.Errorf("the first argument of %s) should be a column", t.Function)
+	}
+	f.column = t.Args[0].Column
+	if len(t.Args) > 1 {
+		n := t.Args[1].Number
+		if n == nil || *n < 0 || *n != float64(int(*n)) {
+			return f, fmt.Errorf("the offset of %s) should be a non-negative integer", t.Function)
+		}
+		f.offset = int(*n)
+	}
+	if len(t.Args) > 2 {
+		switch {
+		case t.Args[2].Number != nil:
+			f.def = *t.Args[2].Number
+		case t.Args[2].String != nil:
+			f.def = *t.Args[2].String
+		default:
+			return f, fmt.Errorf("the default value of %s) should be a number or a string", t.Function)
+		}
+	}
+	return f, nil
+}
+
+func newWindow(wd *Window) (*window, error) {
+	var w = window{partitionBy: wd.PartitionBy, orderBy: wd.OrderBy}
+	for i := range wd.Terms {
+		var (
+			t = &wd.Terms[i]
+			f windowFunction
+		)
+		switch fn := strings.ToLower(t.Function); fn {
+		case windowRowNumber, windowRank, windowDenseRank:
+			if len(t.Args) != 0 {
+				return nil, fmt.Errorf("%s) does not take any arguments", t.Function)
+			}
+			f = windowFunction{function: fn, alias: t.Alias}
+		case windowLag, windowLead:
+			var err error
+			if f, err = newOffsetFunction(t); err != nil {
+				return nil, err
+			}
+			if fn == windowLag && f.offset > w.maxLag {
+				w.maxLag = f.offset
+			}
+			if fn == windowLead && f.offset > w.maxLead {
+				w.maxLead = f.offset
+			}
+		default:
+			r, ok := Reducers[fn]
+			if !ok {
+				return nil, fmt.Errorf("unknown window function %s", t.Function)
+			}
+			if r.ParameterLen() != len(t.Args) {
+				return nil, fmt.Errorf("the reducer %s expects %v parameters but %v were provided", t.Function, r.ParameterLen(), len(t.Args))
+			}
+			f = windowFunction{function: fn, alias: t.Alias, args: t.Args, reducer: r.Copy()}
+		}
+		for _, other := range w.functions {
+			if strings.ToLower(other.alias) == strings.ToLower(f.alias) {
+				return nil, fmt.Errorf("alias %s is repeated in WINDOW", f.alias)
+			}
+		}
+		w.functions = append(w.functions, f)
+	}
+	return &w, nil
+}
+
+func NewWindow(aqlBody string) (*window, error) {
+	p, err := participle.Build(&Window{}, windowLexer)
+
+	if err != nil {
+		panic(err)
+	}
+	var w Window
+	err = p.ParseString(aqlBody, &w)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newWindow(&w)
+}
+
+func windowInitializer(aqlBody string) (engine.SequenceableTransform, error) {
+	return NewWindow(aqlBody)
+}
@@ -0,0 +1,99 @@
+package transforms
+
+import (
+	"github.com/alecthomas/participle"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestWindowParsing(t *testing.T) {
+	parser, err := participle.Build(&Window{}, windowLexer)
+	if err != nil {
+		panic(err)
+	}
+	Convey("Given a valid window", t, func() {
+		w := Window{}
+		err = parser.ParseString(`WINDOW row_number() AS rn, lag(Value, 1) AS prev, sum(Value) AS running PARTITION BY Meter ORDER BY Time DESC`, &w)
+		So(err, ShouldBeNil)
+		So(w.Terms, ShouldHaveLength, 3)
+		So(w.Terms[0].Function, ShouldEqual, "row_number(")
+		So(w.Terms[0].Args, ShouldBeEmpty)
+		So(w.Terms[1].Args, ShouldHaveLength, 2)
+		So(w.Terms[2].Alias, ShouldEqual, "running")
+		So(w.PartitionBy, ShouldResemble, []string{"Meter"})
+		So(w.OrderBy, ShouldHaveLength, 1)
+		So(w.OrderBy[0].Desc, ShouldBeTrue)
+	})
+	Convey("Given invalid window functions", t, func() {
+		_, err := NewWindow(`WINDOW unknown(Value) AS u`)
+		So(err, ShouldNotBeNil)
+		_, err = NewWindow(`WINDOW row_number(Value) AS rn`)
+		So(err, ShouldNotBeNil)
+		_, err = NewWindow(`WINDOW lag(Value, -1) AS prev`)
+		So(err, ShouldNotBeNil)
+		_, err = NewWindow(`WINDOW lag(Value) AS prev, lead(Value) AS prev`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestWindow(t *testing.T) {
+	cols := []string{"Meter", "Time", "Value"}
+	rows := [][]interface{}{
+		[]interface{}{"b", 2, 20.0},
+		[]interface{}{"a", 2, 2.0},
+		[]interface{}{"a", 1, 1.0},
+		[]interface{}{"b", 1, 10.0},
+		[]interface{}{"a", 3, 3.0},
+	}
+	expected := [][]interface{}{
+		[]interface{}{"a", 1, 1.0, int64(1), nil, 2.0, 1.0},
+		[]interface{}{"a", 2, 2.0, int64(2), 1.0, 3.0, 3.0},
+		[]interface{}{"a", 3, 3.0, int64(3), 2.0, -1.0, 6.0},
+		[]interface{}{"b", 1, 10.0, int64(1), nil, 20.0, 10.0},
+		[]interface{}{"b", 2, 20.0, int64(2), 10.0, -1.0, 30.0},
+	}
+	Convey("Given a WINDOW transform with a partition and order", t, func() {
+		w, err := NewWindow(`WINDOW row_number() AS rn, lag(Value) AS prev, lead(Value, 1, -1) AS next, sum(Value) AS running PARTITION BY Meter ORDER BY Time`)
+		So(err, ShouldBeNil)
+		Convey("It should compute the window functions in memory", func() {
+			outCols, res := runTransform(w, cols, rows)
+			So(outCols, ShouldResemble, []string{"Meter", "Time", "Value", "rn", "prev", "next", "running"})
+			So(res, ShouldResemble, expected)
+		})
+		Convey("It should compute the window functions when spilling to disk", func() {
+			w.BufferRows = 2
+			_, res := runTransform(w, cols, rows)
+			So(res, ShouldResemble, expected)
+		})
+	})
+	Convey("Given a WINDOW transform with ranking functions", t, func() {
+		w, err := NewWindow(`WINDOW rank() AS r, dense_rank() AS dr, count(Value) AS c ORDER BY Value`)
+		So(err, ShouldBeNil)
+		_, res := runTransform(w, []string{"Value"}, [][]interface{}{
+			[]interface{}{5},
+			[]interface{}{3},
+			[]interface{}{5},
+			[]interface{}{7},
+		})
+		So(res, ShouldResemble, [][]interface{}{
+			[]interface{}{3, int64(1), int64(1), 1.0},
+			[]interface{}{5, int64(2), int64(2), 2.0},
+			[]interface{}{5, int64(2), int64(2), 3.0},
+			[]interface{}{7, int64(4), int64(3), 4.0},
+		})
+	})
+	Convey("Given a WINDOW transform without a partition or order", t, func() {
+		w, err := NewWindow(`WINDOW lag(Value, 2) AS prev2`)
+		So(err, ShouldBeNil)
+		_, res := runTransform(w, []string{"Value"}, [][]interface{}{
+			[]interface{}{1},
+			[]interface{}{2},
+			[]interface{}{3},
+		})
+		So(res, ShouldResemble, [][]interface{}{
+			[]interface{}{1, nil},
+			[]interface{}{2, nil},
+			[]interface{}{3, 1},
+		})
+	})
+}
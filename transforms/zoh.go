@@ -91,12 +91,15 @@ func (s *zoh) Reduce(arg []interface{}) error {
 	return nil
 }
 
-func (s *zoh) Return() *float64 {
+func (s *zoh) Return() interface{} {
 	if s.start == nil || s.finish == nil {
 		return nil
 	}
 	sort.Sort(s.items)
-	return s.items.Mean(*s.start, *s.finish)
+	if f := s.items.Mean(*s.start, *s.finish); f != nil {
+		return *f
+	}
+	return nil
 }
 
 func (s *zoh) Copy() Reducer {
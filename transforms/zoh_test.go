@@ -23,7 +23,7 @@ func TestZoh(t *testing.T) {
 			}
 			f := agg.Return()
 			So(f, ShouldNotBeNil)
-			So(*f, ShouldEqual, 1.0)
+			So(f, ShouldEqual, 1.0)
 		})
 	})
 }